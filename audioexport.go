@@ -13,8 +13,14 @@ import (
 // If pcm16 is set to true, the samples in the WAV-file will be 16-bit signed
 // integers; otherwise the samples will be 32-bit floats
 func Wav(buffer []float32, pcm16 bool) ([]byte, error) {
+	return WavWithMetadata(buffer, pcm16, Metadata{})
+}
+
+// WavWithMetadata is like Wav, but the fields of the metadata that are set are
+// written into a LIST/INFO chunk of the WAV-file.
+func WavWithMetadata(buffer []float32, pcm16 bool, metadata Metadata) ([]byte, error) {
 	buf := new(bytes.Buffer)
-	wavHeader(len(buffer), pcm16, buf)
+	wavHeader(len(buffer), pcm16, infoChunk(metadata), buf)
 	err := rawToBuffer(buffer, pcm16, buf)
 	if err != nil {
 		return nil, fmt.Errorf("Wav failed: %v", err)
@@ -57,8 +63,9 @@ func rawToBuffer(data []float32, pcm16 bool, buf *bytes.Buffer) error {
 // bytes.buffer. It needs to know the length of the buffer and assumes stereo
 // sound, so the length in stereo samples (L + R) is bufferlength / 2. If pcm16
 // = true, then the header is for int16 audio; pcm16 = false means the header is
-// for float32 audio. Assumes 44100 Hz sample rate.
-func wavHeader(bufferLength int, pcm16 bool, buf *bytes.Buffer) {
+// for float32 audio. Assumes 44100 Hz sample rate. The info chunk, if any, is
+// written before the data chunk.
+func wavHeader(bufferLength int, pcm16 bool, info []byte, buf *bytes.Buffer) {
 	// Refer to: http://www-mmsp.ece.mcgill.ca/Documents/AudioFormats/WAVE/WAVE.html
 	numChannels := 2
 	sampleRate := 44100
@@ -77,6 +84,7 @@ func wavHeader(bufferLength int, pcm16 bool, buf *bytes.Buffer) {
 		waveFormat = 3 // IEEE float
 		factChunk = true
 	}
+	chunkSize += len(info)
 	buf.Write([]byte("RIFF"))
 	binary.Write(buf, binary.LittleEndian, uint32(chunkSize))
 	buf.Write([]byte("WAVE"))
@@ -96,10 +104,41 @@ func wavHeader(bufferLength int, pcm16 bool, buf *bytes.Buffer) {
 		binary.Write(buf, binary.LittleEndian, uint32(4))            // fact chunk size
 		binary.Write(buf, binary.LittleEndian, uint32(bufferLength)) // sample length
 	}
+	buf.Write(info)
 	buf.Write([]byte("data"))
 	binary.Write(buf, binary.LittleEndian, uint32(bytesPerSample*bufferLength))
 }
 
+// infoChunk returns a LIST chunk of type INFO with the metadata that is set, or
+// nil if none is. The strings are null terminated and the subchunks padded to
+// even lengths.
+func infoChunk(metadata Metadata) []byte {
+	var list bytes.Buffer
+	for _, field := range []struct{ id, value string }{
+		{"INAM", metadata.Title}, {"IART", metadata.Author}, {"ICMT", metadata.Comment}, {"ICOP", metadata.License},
+	} {
+		if field.value == "" {
+			continue
+		}
+		list.Write([]byte(field.id))
+		binary.Write(&list, binary.LittleEndian, uint32(len(field.value)+1))
+		list.WriteString(field.value)
+		list.WriteByte(0)
+		if len(field.value)%2 == 0 {
+			list.WriteByte(0) // pad byte
+		}
+	}
+	if list.Len() == 0 {
+		return nil
+	}
+	var chunk bytes.Buffer
+	chunk.Write([]byte("LIST"))
+	binary.Write(&chunk, binary.LittleEndian, uint32(4+list.Len()))
+	chunk.Write([]byte("INFO"))
+	chunk.Write(list.Bytes())
+	return chunk.Bytes()
+}
+
 func clamp(value, min, max int) int {
 	if value < min {
 		return min
@@ -0,0 +1,47 @@
+package sointu
+
+import (
+	"testing"
+
+	"github.com/vsariola/sointu/riff"
+)
+
+func TestWavMetadata(t *testing.T) {
+	buffer := []float32{0, 0.5, -0.5, 1}
+	metadata := Metadata{Title: "Song", Author: "Someone", Comment: "First line\nSecond line", License: "CC BY 4.0"}
+	for _, pcm16 := range []bool{false, true} {
+		data, err := WavWithMetadata(buffer, pcm16, metadata)
+		if err != nil {
+			t.Fatalf("Wav failed: %v", err)
+		}
+		root, err := riff.Parse(data)
+		if err != nil {
+			t.Fatalf("the WAV file could not be parsed: %v", err)
+		}
+		if root.Size != len(data)-8 {
+			t.Errorf("the RIFF chunk size is %v, expected %v", root.Size, len(data)-8)
+		}
+		info := root.Find("LIST INFO")
+		if info == nil {
+			t.Fatalf("no LIST/INFO chunk")
+		}
+		got := Metadata{Title: info.Info("INAM"), Author: info.Info("IART"), Comment: info.Info("ICMT"), License: info.Info("ICOP")}
+		if got != metadata {
+			t.Errorf("wrong metadata: %#v", got)
+		}
+		bytesPerSample := 4
+		if pcm16 {
+			bytesPerSample = 2
+		}
+		if d := root.Find("data"); d == nil || d.Size != len(buffer)*bytesPerSample {
+			t.Errorf("missing or wrong data chunk: %v", d)
+		}
+	}
+	data, err := Wav(buffer, true)
+	if err != nil {
+		t.Fatalf("Wav failed: %v", err)
+	}
+	if len(data) != 44+len(buffer)*2 {
+		t.Errorf("expected a plain 44-byte header without metadata, got %v bytes", len(data)-len(buffer)*2)
+	}
+}
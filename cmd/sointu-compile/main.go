@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -14,6 +15,8 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/smf"
+	"github.com/vsariola/sointu/vm"
 	"github.com/vsariola/sointu/vm/compiler"
 )
 
@@ -35,23 +38,35 @@ func main() {
 	help := flag.Bool("h", false, "Show help.")
 	rowsync := flag.Bool("r", false, "Write the current fractional row as sync #0")
 	library := flag.Bool("a", false, "Compile Sointu into a library. Input files are not needed.")
+	schemaOut := flag.Bool("schema", false, "Output the JSON schemas of the .yml song and instrument files, as song.schema.json and instrument.schema.json, e.g. for validating and autocompleting them in editors. Input files are not needed.")
 	jsonOut := flag.Bool("j", false, "Output the song as .json file instead of compiling.")
 	yamlOut := flag.Bool("y", false, "Output the song as .yml file instead of compiling.")
+	disasmOut := flag.Bool("disasm", false, "Output a disassembly of the bytecode of the song as .disasm file instead of compiling.")
+	patchOut := flag.Bool("patch", false, "Output the patch of the song encoded for the library as .patch.json file instead of compiling, e.g. to load it in the wasm library.")
+	midiOut := flag.Bool("midi", false, "Output the score of the song as a Standard MIDI File (.mid) instead of compiling.")
+	sizeOut := flag.Bool("size", false, "Print an estimate of the compressed size of the song data, per table, instrument and opcode, instead of compiling. The code of the VM is estimated only roughly, per opcode.")
 	tmplDir := flag.String("t", "", "When compiling, use the templates in this directory instead of the standard templates.")
 	outPath := flag.String("o", "", "Directory or filename where to write compiled code. Extension is ignored. Directory and its parents are created if needed. By default, everything is placed in the same directory where the original song file is.")
 	extensionsOut := flag.String("e", "", "Output only the compiled files with these comma separated extensions. For example: h,asm")
-	targetArch := flag.String("arch", runtime.GOARCH, "Target architecture. Defaults to OS architecture. Possible values: 386, amd64, wasm")
+	targetArch := flag.String("arch", runtime.GOARCH, "Target architecture. Defaults to OS architecture. Possible values: 386, amd64, wasm, c, go")
 	output16bit := flag.Bool("i", false, "Compiled song should output 16-bit integers, instead of floats.")
+	sampleDownsample := flag.Int("sd", 1, "Downsample the samples embedded in the instruments by this integer factor.")
+	sample8bit := flag.Bool("s8", false, "Store the samples embedded in the instruments as 8-bit instead of 16-bit.")
+	multi := flag.Bool("m", false, "Compile all the input songs into one 386/amd64 player sharing one synth, with su_render_song(buffer, N) rendering the Nth song. The output files are named after the first song.")
+	chunked := flag.Bool("chunk", false, "Add su_render_chunk and su_seek to the compiled 386/amd64 player, for rendering the song in pieces, e.g. in a sound callback.")
+	events := flag.Bool("events", false, "Add the event tables of the tracks to the compiled player: the rows and notes of the note-ons and the steps of the effect tracks, e.g. for syncing visuals.")
+	featuresPath := flag.String("features", "", "Compile the library (-a) with only the features listed in this .json/.yml file, or the features needed by the songs in this directory. Patches output with -patch are encoded for the same features.")
+	goPackage := flag.String("package", "player", "Package name of the compiled .go player, when targeting go.")
 	targetOs := flag.String("os", runtime.GOOS, "Target OS. Defaults to current OS. Possible values: windows, darwin, linux. Anything else is assumed linuxy. Ignored when targeting wasm.")
 	flag.Usage = printUsage
 	flag.Parse()
-	if (flag.NArg() == 0 && !*library) || *help {
+	if (flag.NArg() == 0 && !*library && !*schemaOut) || *help {
 		flag.Usage()
 		os.Exit(0)
 	}
-	compile := !*jsonOut && !*yamlOut // if the user gives nothing to output, then the default behaviour is to compile the file
+	compile := !*jsonOut && !*yamlOut && !*disasmOut && !*patchOut && !*sizeOut && !*midiOut // if the user gives nothing to output, then the default behaviour is to compile the file
 	var comp *compiler.Compiler
-	if compile || *library {
+	if compile || *library || *sizeOut {
 		var err error
 		if *tmplDir != "" {
 			comp, err = compiler.NewFromTemplates(*targetOs, *targetArch, *output16bit, *rowsync, *tmplDir)
@@ -62,6 +77,10 @@ func main() {
 			fmt.Fprintf(os.Stderr, `error creating compiler: %v`, err)
 			os.Exit(1)
 		}
+		comp.SampleFormat = vm.SampleFormat{Downsample: *sampleDownsample, EightBit: *sample8bit}
+		comp.GoPackage = *goPackage
+		comp.Chunked = *chunked
+		comp.Events = *events
 	}
 	output := func(filename string, extension string, contents []byte) error {
 		if *stdout {
@@ -117,27 +136,51 @@ func main() {
 		}
 		return nil
 	}
-	process := func(filename string) error {
-		inputBytes, err := ioutil.ReadFile(filename)
+	readSong := func(filename string) (*sointu.Song, error) {
+		file, err := os.Open(filename)
 		if err != nil {
-			return fmt.Errorf("could not read file %v: %v", filename, err)
+			return nil, fmt.Errorf("could not open file %v: %v", filename, err)
 		}
-		var song sointu.Song
-		if errJSON := json.Unmarshal(inputBytes, &song); errJSON != nil {
-			if errYaml := yaml.Unmarshal(inputBytes, &song); errYaml != nil {
-				return fmt.Errorf("song could not be unmarshaled as a .json (%v) or .yml (%v)", errJSON, errYaml)
-			}
+		defer file.Close()
+		song, err := sointu.LoadSong(file)
+		if err != nil {
+			return nil, err
 		}
-		if song.RowsPerBeat == 0 {
-			song.RowsPerBeat = 4
+		return &song, nil
+	}
+	var features vm.FeatureSet = vm.AllFeatures{}
+	if *featuresPath != "" {
+		f, err := readFeatures(*featuresPath, readSong)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not read the features: %v\n", err)
+			os.Exit(1)
 		}
-		if song.Score.Length == 0 {
-			song.Score.Length = len(song.Score.Tracks[0].Patterns)
+		features = f
+		if comp != nil {
+			comp.Features = f
+		}
+	}
+	validate := func(filename string, song *sointu.Song) error {
+		diagnostics := vm.Validate(song)
+		for _, d := range diagnostics {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", filename, d)
+		}
+		if vm.HasErrors(diagnostics) {
+			return errors.New("song has errors, not compiling")
+		}
+		return nil
+	}
+	process := func(filename string) error {
+		song, err := readSong(filename)
+		if err != nil {
+			return err
 		}
 		var compiledPlayer map[string]string
 		if compile {
-			var err error
-			compiledPlayer, err = comp.Song(&song)
+			if err := validate(filename, song); err != nil {
+				return err
+			}
+			compiledPlayer, err = comp.Song(song)
 			if err != nil {
 				return fmt.Errorf("compiling player failed: %v", err)
 			}
@@ -151,23 +194,101 @@ func main() {
 			}
 		}
 		if *jsonOut {
-			jsonSong, err := json.Marshal(song)
-			if err != nil {
+			var jsonSong bytes.Buffer
+			if err := sointu.SaveSong(&jsonSong, *song, sointu.JSONFormat); err != nil {
 				return fmt.Errorf("could not marshal the song as json file: %v", err)
 			}
-			if err := output(filename, ".json", jsonSong); err != nil {
+			if err := output(filename, ".json", jsonSong.Bytes()); err != nil {
 				return fmt.Errorf("error outputting json file: %v", err)
 			}
 		}
 		if *yamlOut {
-			yamlSong, err := yaml.Marshal(song)
-			if err != nil {
+			var yamlSong bytes.Buffer
+			if err := sointu.SaveSong(&yamlSong, *song, sointu.YAMLFormat); err != nil {
 				return fmt.Errorf("could not marshal the song as yaml file: %v", err)
 			}
-			if err := output(filename, ".yml", yamlSong); err != nil {
+			if err := output(filename, ".yml", yamlSong.Bytes()); err != nil {
 				return fmt.Errorf("error outputting yaml file: %v", err)
 			}
 		}
+		if *disasmOut {
+			features := vm.NecessaryFeaturesFor(song.Patch)
+			patch, err := vm.Encode(song.Patch, features)
+			if err != nil {
+				return fmt.Errorf("could not encode the patch: %v", err)
+			}
+			listing, err := vm.DisassembleFeatures(patch, features)
+			if err != nil {
+				return fmt.Errorf("could not disassemble the patch: %v", err)
+			}
+			if err := output(filename, ".disasm", []byte(listing)); err != nil {
+				return fmt.Errorf("error outputting disassembly: %v", err)
+			}
+		}
+		if *patchOut {
+			if err := vm.CheckPatch(song.Patch, features); err != nil {
+				return fmt.Errorf("the patch cannot be encoded for the features: %v", err)
+			}
+			patch, err := vm.Encode(song.Patch, features)
+			if err != nil {
+				return fmt.Errorf("could not encode the patch: %v", err)
+			}
+			jsonPatch, err := json.Marshal(patch)
+			if err != nil {
+				return fmt.Errorf("could not marshal the patch as json file: %v", err)
+			}
+			if err := output(filename, ".patch.json", jsonPatch); err != nil {
+				return fmt.Errorf("error outputting patch: %v", err)
+			}
+		}
+		if *midiOut {
+			midi, err := smf.Export(song)
+			if err != nil {
+				return fmt.Errorf("could not convert the score to midi: %v", err)
+			}
+			if err := output(filename, ".mid", midi); err != nil {
+				return fmt.Errorf("error outputting midi file: %v", err)
+			}
+		}
+		if *sizeOut {
+			report, err := comp.EstimateSize(song)
+			if err != nil {
+				return fmt.Errorf("could not estimate the size of the song: %v", err)
+			}
+			fmt.Printf("%v: ", filename)
+			if err := report.Write(os.Stdout); err != nil {
+				return fmt.Errorf("could not write the size report: %v", err)
+			}
+		}
+		return nil
+	}
+	processSongs := func(filenames []string) error {
+		if len(filenames) == 0 {
+			return errors.New("no songs given")
+		}
+		var songs []*sointu.Song
+		for _, filename := range filenames {
+			song, err := readSong(filename)
+			if err != nil {
+				return err
+			}
+			if err := validate(filename, song); err != nil {
+				return fmt.Errorf("%v: %v", filename, err)
+			}
+			songs = append(songs, song)
+		}
+		compiledPlayer, err := comp.Songs(songs)
+		if err != nil {
+			return fmt.Errorf("compiling player failed: %v", err)
+		}
+		if len(*extensionsOut) > 0 {
+			compiledPlayer = filterExtensions(compiledPlayer, strings.Split(*extensionsOut, ","))
+		}
+		for extension, code := range compiledPlayer {
+			if err := output(filenames[0], extension, []byte(code)); err != nil {
+				return fmt.Errorf("error outputting %v file: %v", extension, err)
+			}
+		}
 		return nil
 	}
 	retval := 0
@@ -188,6 +309,21 @@ func main() {
 			}
 		}
 	}
+	if *schemaOut {
+		for name, generate := range map[string]func() ([]byte, error){"song": sointu.SongSchema, "instrument": sointu.InstrumentSchema} {
+			schema, err := generate()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "could not generate the %v schema: %v\n", name, err)
+				retval = 1
+				continue
+			}
+			if err := output(name, ".schema.json", schema); err != nil {
+				fmt.Fprintf(os.Stderr, "error outputting the %v schema: %v\n", name, err)
+				retval = 1
+			}
+		}
+	}
+	var files []string
 	for _, param := range flag.Args() {
 		if info, err := os.Stat(param); err == nil && info.IsDir() {
 			jsonfiles, err := filepath.Glob(filepath.Join(param, "*.json"))
@@ -202,18 +338,21 @@ func main() {
 				retval = 1
 				continue
 			}
-			files := append(ymlfiles, jsonfiles...)
-			for _, file := range files {
-				err := process(file)
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "could not process file %v: %v\n", file, err)
-					retval = 1
-				}
-			}
+			files = append(files, ymlfiles...)
+			files = append(files, jsonfiles...)
 		} else {
-			err := process(param)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "could not process file %v: %v\n", param, err)
+			files = append(files, param)
+		}
+	}
+	if *multi && compile {
+		if err := processSongs(files); err != nil {
+			fmt.Fprintf(os.Stderr, "could not compile the songs: %v\n", err)
+			retval = 1
+		}
+	} else {
+		for _, file := range files {
+			if err := process(file); err != nil {
+				fmt.Fprintf(os.Stderr, "could not process file %v: %v\n", file, err)
 				retval = 1
 			}
 		}
@@ -221,6 +360,45 @@ func main() {
 	os.Exit(retval)
 }
 
+// readFeatures reads the feature list from a .json/.yml file or, if path is a
+// directory, computes the features needed by all the songs in it.
+func readFeatures(path string, readSong func(string) (*sointu.Song, error)) (vm.FeatureList, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		var patches []sointu.Patch
+		for _, pattern := range []string{"*.yml", "*.json"} {
+			files, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return vm.FeatureList{}, fmt.Errorf("could not glob the path %v: %v", path, err)
+			}
+			for _, file := range files {
+				song, err := readSong(file)
+				if err != nil {
+					return vm.FeatureList{}, err
+				}
+				patches = append(patches, song.Patch)
+			}
+		}
+		if len(patches) == 0 {
+			return vm.FeatureList{}, fmt.Errorf("no songs found in %v", path)
+		}
+		return vm.FeatureListFor(vm.NecessaryFeaturesForAll(patches)), nil
+	}
+	inputBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return vm.FeatureList{}, fmt.Errorf("could not read file %v: %v", path, err)
+	}
+	var list vm.FeatureList
+	if errJSON := json.Unmarshal(inputBytes, &list); errJSON != nil {
+		if errYaml := yaml.Unmarshal(inputBytes, &list); errYaml != nil {
+			return vm.FeatureList{}, fmt.Errorf("features could not be unmarshaled as a .json (%v) or .yml (%v)", errJSON, errYaml)
+		}
+	}
+	if err := list.Check(); err != nil {
+		return vm.FeatureList{}, err
+	}
+	return list, nil
+}
+
 func printUsage() {
 	fmt.Fprintf(os.Stderr, "Sointu compiler. Input .yml or .json songs, outputs compiled songs (e.g. .asm and .h files).\nUsage: %s [flags] [path ...]\n", os.Args[0])
 	flag.PrintDefaults()
@@ -19,5 +19,5 @@ func main() {
 	synthService := bridge.BridgeService{}
 	// TODO: native track does not support syncing at the moment (which is why
 	// we pass nil), as the native bridge does not support sync data
-	gioui.Main(audioContext, synthService, nil)
+	gioui.Main(audioContext, synthService, nil, nil)
 }
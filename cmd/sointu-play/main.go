@@ -1,7 +1,7 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -9,10 +9,9 @@ import (
 	"path/filepath"
 	"strings"
 
-	"gopkg.in/yaml.v3"
-
 	"github.com/vsariola/sointu"
 	"github.com/vsariola/sointu/oto"
+	"github.com/vsariola/sointu/vm"
 	"github.com/vsariola/sointu/vm/compiler/bridge"
 )
 
@@ -28,12 +27,30 @@ func main() {
 	rawOut := flag.Bool("r", false, "Output the rendered song as .raw file. By default, saves stereo float32 buffer to disk.")
 	wavOut := flag.Bool("w", false, "Output the rendered song as .wav file. By default, saves stereo float32 buffer to disk.")
 	pcm := flag.Bool("c", false, "Convert audio to 16-bit signed PCM when outputting.")
+	samplesPath := flag.String("samples", "", "DLS (e.g. gm.dls) or SoundFont 2 file providing the samples for the sample-based oscillators. The sample table of the synth has the size of gm.dls, so the file can be at most 3440660 bytes.")
 	flag.Usage = printUsage
 	flag.Parse()
 	if flag.NArg() == 0 || *help {
 		flag.Usage()
 		os.Exit(0)
 	}
+	if *samplesPath != "" {
+		file, err := os.Open(*samplesPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not open sample bank: %v\n", err)
+			os.Exit(1)
+		}
+		table, err := vm.LoadSampleTable(file)
+		file.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not load sample bank: %v\n", err)
+			os.Exit(1)
+		}
+		if err := bridge.LoadSampleTable(table); err != nil {
+			fmt.Fprintf(os.Stderr, "could not load sample bank: %v\n", err)
+			os.Exit(1)
+		}
+	}
 	if !*rawOut && !*wavOut {
 		*play = true // if the user gives nothing to output, then the default behaviour is just to play the file
 	}
@@ -78,15 +95,28 @@ func main() {
 			}
 			return nil
 		}
-		inputBytes, err := ioutil.ReadFile(filename)
+		file, err := os.Open(filename)
 		if err != nil {
-			return fmt.Errorf("could not read file %v: %v", filename, err)
+			return fmt.Errorf("could not open file %v: %v", filename, err)
 		}
-		var song sointu.Song
-		if errJSON := json.Unmarshal(inputBytes, &song); errJSON != nil {
-			if errYaml := yaml.Unmarshal(inputBytes, &song); errYaml != nil {
-				return fmt.Errorf("the song could not be parsed as .json (%v) or .yml (%v)", errJSON, errYaml)
-			}
+		song, err := sointu.LoadSong(file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		info := os.Stdout
+		if *stdout {
+			info = os.Stderr // the standard output is for the audio
+		}
+		for _, line := range song.Metadata.Lines() {
+			fmt.Fprintf(info, "%v: %v\n", filename, line)
+		}
+		diagnostics := vm.Validate(&song)
+		for _, d := range diagnostics {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", filename, d)
+		}
+		if vm.HasErrors(diagnostics) {
+			return errors.New("song has errors, not rendering")
 		}
 		buffer, _, err := sointu.Play(bridge.BridgeService{}, song, !*unreleased) // render the song to calculate its length
 		if err != nil {
@@ -109,7 +139,7 @@ func main() {
 			}
 		}
 		if *wavOut {
-			wav, err := sointu.Wav(buffer, *pcm)
+			wav, err := sointu.WavWithMetadata(buffer, *pcm, song.Metadata)
 			if err != nil {
 				return fmt.Errorf("could not generate .wav file: %v", err)
 			}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 
+	"github.com/vsariola/sointu/dls"
 	"github.com/vsariola/sointu/oto"
 	"github.com/vsariola/sointu/rpc"
+	"github.com/vsariola/sointu/sf2"
+	"github.com/vsariola/sointu/tracker"
 	"github.com/vsariola/sointu/tracker/gioui"
 	"github.com/vsariola/sointu/vm"
 )
 
 func main() {
 	syncAddress := flag.String("address", "", "remote RPC server where to send sync data")
+	samplesPath := flag.String("samples", "", "DLS (e.g. gm.dls) or SoundFont 2 file providing the samples for the sample-based oscillators, listed by the sample parameter")
+	sampleDownsample := flag.Int("sd", 1, "preview the samples embedded in the instruments downsampled by this integer factor, as with sointu-compile -sd")
+	sample8bit := flag.Bool("s8", false, "preview the samples embedded in the instruments as 8-bit, as with sointu-compile -s8")
 	flag.Parse()
 	audioContext, err := oto.NewContext()
 	if err != nil {
@@ -28,6 +36,24 @@ func main() {
 			os.Exit(1)
 		}
 	}
-	synthService := vm.SynthService{}
-	gioui.Main(audioContext, synthService, syncChannel)
+	synthService := vm.SynthService{SampleFormat: vm.SampleFormat{Downsample: *sampleDownsample, EightBit: *sample8bit}}
+	var sampleEntries []tracker.GmDlsEntry
+	if *samplesPath != "" {
+		data, err := ioutil.ReadFile(*samplesPath)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		synthService.SampleBank, err = vm.LoadSampleTable(bytes.NewReader(data))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if sf, err := sf2.Parse(data); err == nil {
+			sampleEntries = tracker.SoundFontEntries(sf)
+		} else if c, err := dls.Parse(data); err == nil {
+			sampleEntries = tracker.DlsEntries(c)
+		}
+	}
+	gioui.Main(audioContext, synthService, sampleEntries, syncChannel)
 }
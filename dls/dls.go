@@ -0,0 +1,320 @@
+// Package dls parses Downloadable Sounds (DLS) collections, such as the gm.dls
+// that ships with Windows, into Go structs.
+//
+// Only the parts of the format relevant for Sointu are parsed: the wave pool
+// with the sample data, loop points (wsmp) and names (INAM), and the
+// instruments with their key/velocity regions. The positions of the wave data
+// within the file are retained, as the sample oscillators of Sointu address
+// the DLS file as a flat table of int16s.
+package dls
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/vsariola/sointu/riff"
+)
+
+// Collection is a parsed DLS collection.
+type Collection struct {
+	Name        string
+	Waves       []Wave
+	Instruments []Instrument
+}
+
+// Wave is a single wave in the wave pool of the collection.
+//
+// DataOffset is the position of the sample data in the file, in bytes.
+// WaveSample is nil if the wave had no wsmp chunk.
+type Wave struct {
+	Name          string
+	FormatTag     uint16
+	Channels      uint16
+	SamplesPerSec uint32
+	BitsPerSample uint16
+	DataOffset    int
+	Data          []byte
+	WaveSample    *WaveSample
+}
+
+// WaveSample contains the contents of a wsmp chunk: the unity note, tuning,
+// attenuation and the loops of a wave or a region.
+type WaveSample struct {
+	UnityNote   uint16
+	FineTune    int16
+	Attenuation int32
+	Options     uint32
+	Loops       []Loop
+}
+
+// Loop is a loop in a WaveSample. Start and Length are in samples.
+type Loop struct {
+	Type   uint32
+	Start  uint32
+	Length uint32
+}
+
+// Instrument is a DLS instrument, consisting of regions that map key and
+// velocity ranges to waves.
+type Instrument struct {
+	Name    string
+	Bank    uint32
+	Program uint32
+	Regions []Region
+}
+
+// Region maps a key and velocity range of an instrument to a wave. Wave is an
+// index to Collection.Waves, or -1 if the wave link could not be resolved.
+// WaveSample is nil if the region did not override the wsmp of the wave.
+type Region struct {
+	KeyLow, KeyHigh           uint16
+	VelocityLow, VelocityHigh uint16
+	Options                   uint16
+	KeyGroup                  uint16
+	Wave                      int
+	WaveSample                *WaveSample
+}
+
+// Read reads and parses a DLS collection.
+func Read(r io.Reader) (*Collection, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read DLS: %v", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses a DLS collection from the contents of a DLS file.
+func Parse(data []byte) (*Collection, error) {
+	root, err := riff.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.ID != "RIFF" || root.ListType != "DLS " {
+		return nil, errors.New("not a DLS file: missing RIFF DLS header")
+	}
+	c := &Collection{}
+	var cues []uint32
+	var wvpl *riff.Chunk
+	for i := range root.Children {
+		ch := &root.Children[i]
+		switch ch.Key() {
+		case "ptbl":
+			if cues, err = parsePoolTable(ch.Data); err != nil {
+				return nil, err
+			}
+		case "LIST wvpl":
+			wvpl = ch
+		case "LIST lins":
+			for _, ins := range ch.Children {
+				if ins.Key() != "LIST ins " {
+					continue
+				}
+				instr, err := parseInstrument(&ins)
+				if err != nil {
+					return nil, err
+				}
+				c.Instruments = append(c.Instruments, instr)
+			}
+		case "LIST INFO":
+			c.Name = ch.Info("INAM")
+		}
+	}
+	waveIndices := map[uint32]int{}
+	if wvpl != nil {
+		for _, w := range wvpl.Children {
+			if w.Key() != "LIST wave" {
+				continue
+			}
+			wave, err := parseWave(&w)
+			if err != nil {
+				return nil, fmt.Errorf("wave %v: %v", len(c.Waves), err)
+			}
+			// pool table cues are offsets of the wave chunk headers, relative
+			// to the first byte after the "wvpl" list type
+			waveIndices[uint32(w.Pos-8-(wvpl.Pos+4))] = len(c.Waves)
+			c.Waves = append(c.Waves, wave)
+		}
+	}
+	for i := range c.Instruments {
+		for j := range c.Instruments[i].Regions {
+			r := &c.Instruments[i].Regions[j]
+			if r.Wave < 0 || r.Wave >= len(cues) {
+				r.Wave = -1
+				continue
+			}
+			if index, ok := waveIndices[cues[r.Wave]]; ok {
+				r.Wave = index
+			} else {
+				r.Wave = -1
+			}
+		}
+	}
+	return c, nil
+}
+
+// NumSamples returns the number of sample frames in the wave.
+func (w *Wave) NumSamples() int {
+	frameSize := int(w.BitsPerSample+7) / 8 * int(w.Channels)
+	if frameSize == 0 {
+		return 0
+	}
+	return len(w.Data) / frameSize
+}
+
+// UnityNote returns the MIDI note at which the wave plays at its original
+// pitch, defaulting to middle C (60) if the wave has no wsmp chunk.
+func (w *Wave) UnityNote() int {
+	if w.WaveSample == nil {
+		return 60
+	}
+	return int(w.WaveSample.UnityNote)
+}
+
+// Loop returns the loop start and length of the wave, in samples. If the wave
+// has no loops, the last sample of the wave is looped, so that a sample
+// oscillator playing it just holds the last value.
+func (w *Wave) Loop() (start, length int) {
+	if w.WaveSample != nil && len(w.WaveSample.Loops) > 0 {
+		l := w.WaveSample.Loops[0]
+		return int(l.Start), int(l.Length)
+	}
+	return w.NumSamples() - 1, 1
+}
+
+func parseWave(c *riff.Chunk) (Wave, error) {
+	var w Wave
+	for i := range c.Children {
+		ch := &c.Children[i]
+		switch ch.Key() {
+		case "fmt ":
+			if len(ch.Data) < 16 {
+				return w, errors.New("fmt chunk too short")
+			}
+			w.FormatTag = binary.LittleEndian.Uint16(ch.Data[0:2])
+			w.Channels = binary.LittleEndian.Uint16(ch.Data[2:4])
+			w.SamplesPerSec = binary.LittleEndian.Uint32(ch.Data[4:8])
+			w.BitsPerSample = binary.LittleEndian.Uint16(ch.Data[14:16])
+		case "wsmp":
+			ws, err := parseWaveSample(ch.Data)
+			if err != nil {
+				return w, err
+			}
+			w.WaveSample = ws
+		case "data":
+			w.DataOffset = ch.Pos
+			w.Data = ch.Data
+		case "LIST INFO":
+			w.Name = ch.Info("INAM")
+		}
+	}
+	return w, nil
+}
+
+func parseInstrument(c *riff.Chunk) (Instrument, error) {
+	var instr Instrument
+	for i := range c.Children {
+		ch := &c.Children[i]
+		switch ch.Key() {
+		case "insh":
+			if len(ch.Data) < 12 {
+				return instr, errors.New("insh chunk too short")
+			}
+			instr.Bank = binary.LittleEndian.Uint32(ch.Data[4:8])
+			instr.Program = binary.LittleEndian.Uint32(ch.Data[8:12])
+		case "LIST lrgn":
+			for j := range ch.Children {
+				rgn := &ch.Children[j]
+				if k := rgn.Key(); k != "LIST rgn " && k != "LIST rgn2" {
+					continue
+				}
+				region, err := parseRegion(rgn)
+				if err != nil {
+					return instr, err
+				}
+				instr.Regions = append(instr.Regions, region)
+			}
+		case "LIST INFO":
+			instr.Name = ch.Info("INAM")
+		}
+	}
+	return instr, nil
+}
+
+func parseRegion(c *riff.Chunk) (Region, error) {
+	r := Region{Wave: -1}
+	for i := range c.Children {
+		ch := &c.Children[i]
+		switch ch.ID {
+		case "rgnh":
+			if len(ch.Data) < 12 {
+				return r, errors.New("rgnh chunk too short")
+			}
+			r.KeyLow = binary.LittleEndian.Uint16(ch.Data[0:2])
+			r.KeyHigh = binary.LittleEndian.Uint16(ch.Data[2:4])
+			r.VelocityLow = binary.LittleEndian.Uint16(ch.Data[4:6])
+			r.VelocityHigh = binary.LittleEndian.Uint16(ch.Data[6:8])
+			r.Options = binary.LittleEndian.Uint16(ch.Data[8:10])
+			r.KeyGroup = binary.LittleEndian.Uint16(ch.Data[10:12])
+		case "wsmp":
+			ws, err := parseWaveSample(ch.Data)
+			if err != nil {
+				return r, err
+			}
+			r.WaveSample = ws
+		case "wlnk":
+			if len(ch.Data) < 12 {
+				return r, errors.New("wlnk chunk too short")
+			}
+			// the pool table index is resolved to wave index once the whole
+			// file is parsed
+			r.Wave = int(binary.LittleEndian.Uint32(ch.Data[8:12]))
+		}
+	}
+	return r, nil
+}
+
+func parseWaveSample(data []byte) (*WaveSample, error) {
+	if len(data) < 20 {
+		return nil, errors.New("wsmp chunk too short")
+	}
+	cbSize := int(binary.LittleEndian.Uint32(data[0:4]))
+	ws := &WaveSample{
+		UnityNote:   binary.LittleEndian.Uint16(data[4:6]),
+		FineTune:    int16(binary.LittleEndian.Uint16(data[6:8])),
+		Attenuation: int32(binary.LittleEndian.Uint32(data[8:12])),
+		Options:     binary.LittleEndian.Uint32(data[12:16]),
+	}
+	numLoops := int(binary.LittleEndian.Uint32(data[16:20]))
+	for i := 0; i < numLoops; i++ {
+		pos := cbSize + i*16
+		if pos+16 > len(data) {
+			return nil, errors.New("wsmp chunk too short for its loops")
+		}
+		ws.Loops = append(ws.Loops, Loop{
+			Type:   binary.LittleEndian.Uint32(data[pos+4 : pos+8]),
+			Start:  binary.LittleEndian.Uint32(data[pos+8 : pos+12]),
+			Length: binary.LittleEndian.Uint32(data[pos+12 : pos+16]),
+		})
+	}
+	return ws, nil
+}
+
+func parsePoolTable(data []byte) ([]uint32, error) {
+	if len(data) < 8 {
+		return nil, errors.New("ptbl chunk too short")
+	}
+	cbSize := int(binary.LittleEndian.Uint32(data[0:4]))
+	numCues := int(binary.LittleEndian.Uint32(data[4:8]))
+	if cbSize+numCues*4 > len(data) {
+		return nil, errors.New("ptbl chunk too short for its cues")
+	}
+	cues := make([]uint32, numCues)
+	for i := range cues {
+		cues[i] = binary.LittleEndian.Uint32(data[cbSize+i*4:])
+	}
+	return cues, nil
+}
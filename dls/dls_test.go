@@ -0,0 +1,108 @@
+package dls_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/vsariola/sointu/dls"
+	"github.com/vsariola/sointu/riff/rifftest"
+)
+
+func le(values ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, v := range values {
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+	return b.Bytes()
+}
+
+func info(name string) []byte {
+	return rifftest.List("LIST", "INFO", rifftest.Chunk("INAM", append([]byte(name), 0)))
+}
+
+func wave(name string, samples []int16, loops ...dls.Loop) []byte {
+	fmtChunk := rifftest.Chunk("fmt ", le(uint16(1), uint16(1), uint32(22050), uint32(44100), uint16(2), uint16(16)))
+	wsmp := le(uint32(20), uint16(60), int16(0), int32(0), uint32(0), uint32(len(loops)))
+	for _, l := range loops {
+		wsmp = append(wsmp, le(uint32(16), l.Type, l.Start, l.Length)...)
+	}
+	return rifftest.List("LIST", "wave", fmtChunk, rifftest.Chunk("wsmp", wsmp), rifftest.Chunk("data", le(samples)), info(name))
+}
+
+func syntheticDLS() []byte {
+	wave1 := wave("Sine", []int16{0, 1000, 0, -1000, 0}, dls.Loop{Start: 1, Length: 4})
+	wave2 := wave("", []int16{7, 8, 9})
+	ptbl := rifftest.Chunk("ptbl", le(uint32(8), uint32(2), uint32(0), uint32(len(wave1))))
+	rgn := rifftest.List("LIST", "rgn ",
+		rifftest.Chunk("rgnh", le(uint16(36), uint16(72), uint16(0), uint16(127), uint16(1), uint16(0))),
+		rifftest.Chunk("wsmp", le(uint32(20), uint16(64), int16(0), int32(0), uint32(0), uint32(0))),
+		rifftest.Chunk("wlnk", le(uint16(0), uint16(0), uint32(1), uint32(1))),
+	)
+	ins := rifftest.List("LIST", "ins ",
+		rifftest.Chunk("insh", le(uint32(1), uint32(0x80000000), uint32(5))),
+		rifftest.List("LIST", "lrgn", rgn),
+		info("Piano"),
+	)
+	return rifftest.List("RIFF", "DLS ",
+		rifftest.Chunk("colh", le(uint32(1))),
+		rifftest.List("LIST", "lins", ins),
+		ptbl,
+		rifftest.List("LIST", "wvpl", wave1, wave2),
+		info("Test collection"),
+	)
+}
+
+func TestParse(t *testing.T) {
+	data := syntheticDLS()
+	c, err := dls.Parse(data)
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if c.Name != "Test collection" {
+		t.Errorf("expected collection name %q, got %q", "Test collection", c.Name)
+	}
+	if len(c.Waves) != 2 {
+		t.Fatalf("expected 2 waves, got %v", len(c.Waves))
+	}
+	w := c.Waves[0]
+	if w.Name != "Sine" || w.SamplesPerSec != 22050 || w.BitsPerSample != 16 || w.NumSamples() != 5 {
+		t.Errorf("wave 0 parsed incorrectly: %+v", w)
+	}
+	if got := int16(binary.LittleEndian.Uint16(data[w.DataOffset+2:])); got != 1000 {
+		t.Errorf("DataOffset does not point to the wave data: expected second sample 1000, got %v", got)
+	}
+	if start, length := w.Loop(); start != 1 || length != 4 {
+		t.Errorf("expected loop 1, 4, got %v, %v", start, length)
+	}
+	if start, length := c.Waves[1].Loop(); start != 2 || length != 1 {
+		t.Errorf("expected an unlooped wave to loop its last sample, got %v, %v", start, length)
+	}
+	if len(c.Instruments) != 1 {
+		t.Fatalf("expected 1 instrument, got %v", len(c.Instruments))
+	}
+	instr := c.Instruments[0]
+	if instr.Name != "Piano" || instr.Bank != 0x80000000 || instr.Program != 5 || len(instr.Regions) != 1 {
+		t.Fatalf("instrument parsed incorrectly: %+v", instr)
+	}
+	r := instr.Regions[0]
+	if r.KeyLow != 36 || r.KeyHigh != 72 || r.VelocityHigh != 127 {
+		t.Errorf("region ranges parsed incorrectly: %+v", r)
+	}
+	if r.Wave != 1 {
+		t.Errorf("expected region to link to wave 1, got %v", r.Wave)
+	}
+	if r.WaveSample == nil || r.WaveSample.UnityNote != 64 {
+		t.Errorf("expected region wsmp with unity note 64, got %+v", r.WaveSample)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := dls.Parse(rifftest.List("RIFF", "WAVE")); err == nil {
+		t.Errorf("parsing a non-DLS RIFF file should fail")
+	}
+	data := syntheticDLS()
+	if _, err := dls.Parse(data[:len(data)-10]); err == nil {
+		t.Errorf("parsing a truncated DLS file should fail")
+	}
+}
@@ -0,0 +1,461 @@
+// Package fourklang converts the binary patch (.4kp) and instrument (.4ki)
+// files of 4klang, the synth Sointu was forked from, into Sointu patches and
+// instruments.
+//
+// The files are the raw unit values of the 4klang VSTi: each unit is 16 bytes,
+// the first byte being the type of the unit and the rest its values. A patch
+// file has a four byte version tag ("4k10" to "4k14"), the polyphony as an
+// uint32, the names of the 16 instruments (64 bytes each), the units of the 16
+// instruments (64 units each) and finally the 64 global units. An instrument
+// file has the version tag, the name of the instrument and its 64 units.
+//
+// Most 4klang units have a direct Sointu equivalent; see the doc comments of
+// the unit type constants. The stores (FST) become sends, targeting the port
+// corresponding to the modulated slot of the target unit; the target units are
+// given IDs as needed. Units or features without a Sointu equivalent are
+// dropped with a Warning.
+package fourklang
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/vsariola/sointu"
+)
+
+const (
+	maxInstruments = 16
+	maxUnits       = 64
+	unitSize       = 16
+	nameLength     = 64
+	// globalStack is the number of the global units in the dest_stack of the
+	// stores and in the Instrument of the Warnings.
+	globalStack = maxInstruments
+	// localStack in the dest_stack of a store means the instrument of the
+	// store itself.
+	localStack = 0xFF
+)
+
+// The bits of the type of a FST, i.e. its second value. Without fstAdd or
+// fstMul, the store sets the modulated value, overriding the other stores to
+// the same slot.
+const (
+	fstAdd = 0x10
+	fstMul = 0x20
+	fstPop = 0x40
+)
+
+// The types of the 4klang units, i.e. the first byte of each unit.
+const (
+	none   = iota
+	env    // envelope
+	vco    // oscillator or noise
+	vcf    // filter
+	dst    // distort, followed by hold if the sample & hold is used
+	dll    // delay
+	fop    // pop, addp, mulp, push, xch, add, mul, loadnote
+	fst    // send
+	pan    // pan
+	out    // outaux
+	acc    // in, reading the outputs or the aux outputs of the instruments
+	fld    // loadval
+	glitch // no equivalent
+)
+
+var unitNames = []string{"", "ENV", "VCO", "VCF", "DST", "DLL", "FOP", "FST", "PAN", "OUT", "ACC", "FLD", "GLITCH"}
+
+// Warning tells about a unit in a 4klang file that could not be converted
+// exactly, e.g. a unit that has no Sointu equivalent and was dropped.
+type Warning struct {
+	Instrument int // the index of the instrument in the 4klang file; 16 for the global units
+	Unit       int // the index of the unit in the 4klang instrument
+	Message    string
+}
+
+func (w Warning) String() string {
+	if w.Instrument == globalStack {
+		return fmt.Sprintf("global unit %v: %v", w.Unit, w.Message)
+	}
+	return fmt.Sprintf("instrument %v, unit %v: %v", w.Instrument, w.Unit, w.Message)
+}
+
+// ReadPatch reads and converts a 4klang patch file.
+func ReadPatch(r io.Reader) (sointu.Patch, []Warning, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read 4klang patch: %v", err)
+	}
+	return ParsePatch(data)
+}
+
+// ParsePatch converts a 4klang patch file from the contents of the file. The
+// instruments without any units are left out; the global units become the last
+// instrument, named "Global", with one voice. All the other instruments get the
+// polyphony of the patch as their number of voices.
+func ParsePatch(data []byte) (sointu.Patch, []Warning, error) {
+	version, err := parseVersion(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	size := 8 + maxInstruments*nameLength + (maxInstruments+1)*maxUnits*unitSize
+	if len(data) < size {
+		return nil, nil, fmt.Errorf("4klang patch should be at least %v bytes, got %v", size, len(data))
+	}
+	polyphony := int(binary.LittleEndian.Uint32(data[4:8]))
+	if polyphony < 1 {
+		polyphony = 1
+	}
+	c := converter{version: version}
+	unitData := data[8+maxInstruments*nameLength:]
+	for i := 0; i <= maxInstruments; i++ {
+		name, numVoices := "Global", 1
+		if i < maxInstruments {
+			name, numVoices = zeroTerminated(data[8+i*nameLength:8+(i+1)*nameLength]), polyphony
+		}
+		c.addStack(i, name, numVoices, unitData[i*maxUnits*unitSize:(i+1)*maxUnits*unitSize])
+	}
+	c.resolveSends()
+	var patch sointu.Patch
+	for _, s := range c.stacks {
+		if s != nil && len(s.instr.Units) > 0 {
+			patch = append(patch, s.instr)
+		}
+	}
+	return patch, c.warnings, nil
+}
+
+// ReadInstrument reads and converts a 4klang instrument file.
+func ReadInstrument(r io.Reader) (sointu.Instrument, []Warning, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return sointu.Instrument{}, nil, fmt.Errorf("could not read 4klang instrument: %v", err)
+	}
+	return ParseInstrument(data)
+}
+
+// ParseInstrument converts a 4klang instrument file from the contents of the
+// file. The stores targeting other instruments are dropped, as the instrument
+// file has no other instruments. The instrument has one voice.
+func ParseInstrument(data []byte) (sointu.Instrument, []Warning, error) {
+	version, err := parseVersion(data)
+	if err != nil {
+		return sointu.Instrument{}, nil, err
+	}
+	size := 4 + nameLength + maxUnits*unitSize
+	if len(data) < size {
+		return sointu.Instrument{}, nil, fmt.Errorf("4klang instrument should be at least %v bytes, got %v", size, len(data))
+	}
+	c := converter{version: version}
+	c.addStack(0, zeroTerminated(data[4:4+nameLength]), 1, data[4+nameLength:size])
+	c.resolveSends()
+	return c.stacks[0].instr, c.warnings, nil
+}
+
+func parseVersion(data []byte) (int, error) {
+	if len(data) < 4 || string(data[:3]) != "4k1" || data[3] < '0' || data[3] > '4' {
+		return 0, fmt.Errorf("not a 4klang file: unknown version tag %q", data[:min(len(data), 4)])
+	}
+	return 10 + int(data[3]-'0'), nil
+}
+
+// converter keeps track of the converted instruments, so that the stores can be
+// resolved after all the units have been converted.
+type converter struct {
+	version  int
+	stacks   []*stack
+	sends    []send
+	maxID    int
+	warnings []Warning
+}
+
+// stack is a converted 4klang instrument. The units of the i:th 4klang unit
+// are instr.Units[first[i]:first[i]+count[i]].
+type stack struct {
+	instr        sointu.Instrument
+	types        [maxUnits]byte
+	first, count [maxUnits]int
+}
+
+// send is a converted store, waiting for its target to be resolved.
+type send struct {
+	stack, unit                   int // the location of the store in the 4klang file
+	index                         int // the index of the send unit in the Sointu instrument
+	destStack, destUnit, destSlot int
+	typ                           byte
+}
+
+func (c *converter) warn(stack, unit int, format string, args ...interface{}) {
+	c.warnings = append(c.warnings, Warning{Instrument: stack, Unit: unit, Message: fmt.Sprintf(format, args...)})
+}
+
+func (c *converter) addStack(index int, name string, numVoices int, data []byte) {
+	for len(c.stacks) <= index {
+		c.stacks = append(c.stacks, nil)
+	}
+	s := &stack{instr: sointu.Instrument{Name: name, NumVoices: numVoices, Units: []sointu.Unit{}}}
+	c.stacks[index] = s
+	for i := 0; i < maxUnits; i++ {
+		v := data[i*unitSize : (i+1)*unitSize]
+		s.types[i] = v[0]
+		s.first[i] = len(s.instr.Units)
+		if v[0] == fst {
+			destStack := int(v[3])
+			if destStack == localStack {
+				destStack = index
+			}
+			c.sends = append(c.sends, send{stack: index, unit: i, index: len(s.instr.Units), destStack: destStack, destUnit: int(v[4]), destSlot: int(v[5]), typ: v[2]})
+		}
+		s.instr.Units = append(s.instr.Units, c.convert(index, i, v)...)
+		s.count[i] = len(s.instr.Units) - s.first[i]
+	}
+}
+
+// convert converts a 4klang unit, given as its 16 bytes, into Sointu units.
+func (c *converter) convert(stack, unit int, v []byte) []sointu.Unit {
+	p := func(i int) int { return int(v[i]) }
+	switch v[0] {
+	case none:
+		return nil
+	case env:
+		return []sointu.Unit{{Type: "envelope", Parameters: map[string]int{"stereo": 0, "attack": p(1), "decay": p(2), "sustain": p(3), "release": p(4), "gain": p(5)}}}
+	case vco:
+		return c.convertVCO(stack, unit, v)
+	case vcf:
+		t := p(3)
+		params := map[string]int{"stereo": btoi(t&0x10 != 0), "frequency": p(1), "resonance": p(2), "lowpass": btoi(t&1 != 0), "bandpass": btoi(t&4 != 0), "highpass": btoi(t&2 != 0), "negbandpass": 0, "neghighpass": 0}
+		if t&8 != 0 { // peak: lowpass minus highpass
+			params["lowpass"], params["highpass"], params["neghighpass"] = 1, 0, 1
+		}
+		return []sointu.Unit{{Type: "filter", Parameters: params}}
+	case dst:
+		stereo := btoi(v[3]&0x40 != 0)
+		ret := []sointu.Unit{{Type: "distort", Parameters: map[string]int{"stereo": stereo, "drive": p(1)}}}
+		if p(2) < 128 { // 128 holds every sample i.e. does nothing
+			ret = append(ret, sointu.Unit{Type: "hold", Parameters: map[string]int{"stereo": stereo, "holdfreq": p(2)}})
+		}
+		return ret
+	case dll:
+		return []sointu.Unit{c.convertDLL(stack, unit, v)}
+	case fop:
+		ops := map[byte]string{1: "pop", 2: "addp", 3: "mulp", 4: "push", 5: "xch", 6: "add", 7: "mul", 8: "addp", 9: "loadnote", 10: "mulp"}
+		t, ok := ops[v[1]]
+		if !ok {
+			c.warn(stack, unit, "unknown FOP operation %v, dropped", v[1])
+			return nil
+		}
+		return []sointu.Unit{{Type: t, Parameters: map[string]int{"stereo": btoi(v[1] == 8 || v[1] == 10)}}}
+	case fst:
+		// the target and the port are filled in by resolveSends
+		return []sointu.Unit{{Type: "send", Parameters: map[string]int{"stereo": 0, "amount": p(1), "voice": 0, "target": 0, "port": 0, "sendpop": btoi(v[2]&fstPop != 0)}}}
+	case pan:
+		return []sointu.Unit{{Type: "pan", Parameters: map[string]int{"stereo": 0, "panning": p(1)}}}
+	case out:
+		return []sointu.Unit{{Type: "outaux", Parameters: map[string]int{"stereo": 1, "outgain": p(1), "auxgain": p(2)}}}
+	case acc:
+		channel := 0
+		if v[1]&8 != 0 {
+			channel = 2
+		}
+		return []sointu.Unit{{Type: "in", Parameters: map[string]int{"stereo": 1, "channel": channel}}}
+	case fld:
+		return []sointu.Unit{{Type: "loadval", Parameters: map[string]int{"stereo": 0, "value": p(1)}}}
+	case glitch:
+		c.warn(stack, unit, "GLITCH has no equivalent in Sointu, dropped")
+		return nil
+	}
+	c.warn(stack, unit, "unknown unit type %v, dropped", v[0])
+	return nil
+}
+
+func (c *converter) convertVCO(stack, unit int, v []byte) []sointu.Unit {
+	// versions before 4k12 did not have the gates of the gate oscillator
+	transpose, detune, phase, gates, color, shape, gain, flags := v[1], v[2], v[3], v[4], v[5], v[6], v[7], v[8]
+	if c.version < 12 {
+		gates, color, shape, gain, flags = 0, v[4], v[5], v[6], v[7]
+	}
+	stereo := btoi(flags&0x40 != 0)
+	if flags&0x08 != 0 {
+		return []sointu.Unit{{Type: "noise", Parameters: map[string]int{"stereo": stereo, "shape": int(shape), "gain": int(gain)}}}
+	}
+	params := map[string]int{"stereo": stereo, "transpose": int(transpose), "detune": int(detune), "phase": int(phase), "color": int(color), "shape": int(shape), "gain": int(gain), "type": sointu.Sine, "lfo": btoi(flags&0x10 != 0), "unison": 0}
+	switch {
+	case flags&0x01 != 0:
+	case flags&0x02 != 0:
+		params["type"] = sointu.Trisaw
+	case flags&0x04 != 0:
+		params["type"] = sointu.Pulse
+	case flags&0x20 != 0:
+		// the 16 gate bits of 4klang are gates (low byte) and color (high
+		// byte), or color and shape before 4k12; in Sointu, they are color and
+		// shape
+		params["type"] = sointu.Gate
+		if c.version >= 12 {
+			params["color"], params["shape"] = int(gates), int(color)
+		}
+	default:
+		c.warn(stack, unit, "VCO without a waveform, converted to a sine")
+	}
+	return []sointu.Unit{{Type: "oscillator", Parameters: params}}
+}
+
+// The delay times of the reverbs of 4klang, in samples, for the left and the
+// right channel.
+var (
+	leftReverb  = []int{1116, 1188, 1276, 1356, 1422, 1492, 1556, 1618}
+	rightReverb = []int{1140, 1212, 1300, 1380, 1446, 1516, 1580, 1642}
+)
+
+// convertDLL converts a DLL, whose values are pregain, dry, feedback, damp,
+// freq, depth, delay, count, guidelay, synctype, leftreverb and reverb, as in
+// the DLL_val of 4klang. delay and count are the index and the number of the
+// delay times in the delay time table of an exported song; the delay time
+// itself is computed from guidelay and synctype.
+func (c *converter) convertDLL(stack, unit int, v []byte) sointu.Unit {
+	pregain, dry, feedback, damp, depth, guidelay, synctype, leftreverb, reverb := v[1], v[2], v[3], v[4], v[6], v[9], v[10], v[11], v[12]
+	ret := sointu.Unit{Type: "delay", Parameters: map[string]int{"stereo": 0, "pregain": int(pregain), "dry": int(dry), "feedback": int(feedback), "damp": int(damp), "notetracking": 0}}
+	if depth > 0 {
+		c.warn(stack, unit, "the delay time modulation of DLL has no equivalent in Sointu, dropped")
+	}
+	switch {
+	case reverb != 0 && leftreverb != 0:
+		ret.VarArgs = append([]int{}, leftReverb...)
+	case reverb != 0:
+		ret.VarArgs = append([]int{}, rightReverb...)
+	case synctype == 2: // note sync
+		ret.Parameters["notetracking"] = 1
+		ret.VarArgs = []int{10787}
+	default:
+		if synctype == 1 {
+			c.warn(stack, unit, "the tempo synced delay time of DLL is not converted; set the delaytime by hand")
+		}
+		ret.VarArgs = []int{int(guidelay) * 16}
+	}
+	return ret
+}
+
+// slotPorts maps the value slots of the 4klang units (1 being the first value
+// after the type) to the ports of the Sointu units. Empty string means that
+// the slot has no Sointu equivalent.
+var slotPorts = map[byte][]string{
+	env: {"", "attack", "decay", "sustain", "release", "gain"},
+	vco: {"", "transpose", "detune", "phase", "", "color", "shape", "gain"},
+	vcf: {"", "frequency", "resonance"},
+	dst: {"", "drive", "holdfreq"},
+	dll: {"", "pregain", "dry", "feedback", "damp"},
+	fst: {"", "amount"},
+	pan: {"", "panning"},
+	out: {"", "outgain", "auxgain"},
+	fld: {"", "value"},
+}
+
+// resolveSends fills in the targets and the ports of the converted stores. The
+// target units get IDs; the sends whose target cannot be resolved are dropped,
+// or replaced with pops if they popped the signal. The sends of Sointu always
+// add to the modulated port, so multiplying stores, and setting stores to slots
+// that other stores modulate too, are converted with a warning.
+func (c *converter) resolveSends() {
+	type slot struct{ stack, unit, slot int }
+	stores := map[slot]int{}
+	for _, s := range c.sends {
+		stores[slot{s.destStack, s.destUnit, s.destSlot}]++
+	}
+	for _, s := range c.sends {
+		sendUnit := &c.stacks[s.stack].instr.Units[s.index]
+		target, port, err := c.findTarget(s)
+		if err != nil {
+			c.warn(s.stack, s.unit, "FST %v, dropped", err)
+			if sendUnit.Parameters["sendpop"] == 1 {
+				*sendUnit = sointu.Unit{Type: "pop", Parameters: map[string]int{"stereo": 0}}
+			} else {
+				sendUnit.Type = "" // removed below
+			}
+			continue
+		}
+		if s.typ&fstMul != 0 {
+			c.warn(s.stack, s.unit, "FST multiplies the modulated value, which has no equivalent in Sointu; converted to a send adding to it")
+		} else if s.typ&fstAdd == 0 && stores[slot{s.destStack, s.destUnit, s.destSlot}] > 1 {
+			c.warn(s.stack, s.unit, "FST sets a value that other stores modulate too, which has no equivalent in Sointu; converted to a send adding to it")
+		}
+		if target.ID == 0 {
+			c.maxID++
+			target.ID = c.maxID
+		}
+		sendUnit.Parameters["target"] = target.ID
+		sendUnit.Parameters["port"] = port
+	}
+	for _, s := range c.stacks {
+		if s == nil {
+			continue
+		}
+		units := s.instr.Units[:0]
+		for _, u := range s.instr.Units {
+			if u.Type != "" {
+				units = append(units, u)
+			}
+		}
+		s.instr.Units = units
+	}
+}
+
+func (c *converter) findTarget(s send) (*sointu.Unit, int, error) {
+	if s.destStack >= len(c.stacks) || c.stacks[s.destStack] == nil {
+		return nil, 0, fmt.Errorf("targets instrument %v, which is not in the file", s.destStack)
+	}
+	if s.destUnit >= maxUnits {
+		return nil, 0, fmt.Errorf("targets unit %v, which does not exist", s.destUnit)
+	}
+	dest := c.stacks[s.destStack]
+	t := dest.types[s.destUnit]
+	if t == none {
+		return nil, 0, fmt.Errorf("targets unit %v, which is empty", s.destUnit)
+	}
+	if t == vco && c.version < 12 && s.destSlot >= 4 {
+		s.destSlot++ // no gates before 4k12
+	}
+	ports := slotPorts[t]
+	if s.destSlot >= len(ports) || ports[s.destSlot] == "" {
+		return nil, 0, fmt.Errorf("targets slot %v of a %v unit, which has no equivalent", s.destSlot, unitName(t))
+	}
+	name := ports[s.destSlot]
+	for i := dest.first[s.destUnit]; i < dest.first[s.destUnit]+dest.count[s.destUnit]; i++ {
+		u := &dest.instr.Units[i]
+		for p, n := range sointu.Ports[u.Type] {
+			if n == name {
+				return u, p, nil
+			}
+		}
+	}
+	return nil, 0, fmt.Errorf("targets %v of a %v unit, which has no equivalent", name, unitName(t))
+}
+
+func unitName(t byte) string {
+	if int(t) < len(unitNames) {
+		return unitNames[t]
+	}
+	return fmt.Sprintf("type %v", t)
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func zeroTerminated(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
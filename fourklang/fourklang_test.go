@@ -0,0 +1,217 @@
+package fourklang_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/fourklang"
+	"github.com/vsariola/sointu/vm"
+)
+
+// instrumentFile builds a 4klang instrument file with the given units; the
+// units are padded with zeros to 16 bytes.
+func instrumentFile(version, name string, units ...[]byte) []byte {
+	data := []byte(version)
+	data = append(data, make([]byte, 64)...)
+	copy(data[4:], name)
+	return append(data, unitData(units...)...)
+}
+
+// dllVal and fstVal mirror the DLL_val and FST_val structs of 4klang, with the
+// unit type in front, as the units are stored in the files.
+type dllVal struct {
+	Type                                byte
+	Pregain, Dry, Feedback, Damp        byte
+	Freq, Depth, Delay, Count, Guidelay byte
+	Synctype, Leftreverb, Reverb        byte
+}
+
+type fstVal struct {
+	Type                                  byte
+	Amount, FstType                       byte
+	DestStack, DestUnit, DestSlot, DestID byte
+}
+
+func unitBytes(v interface{}) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, v)
+	return b.Bytes()
+}
+
+func unitData(units ...[]byte) []byte {
+	data := make([]byte, 64*16)
+	for i, u := range units {
+		copy(data[i*16:], u)
+	}
+	return data
+}
+
+func TestParseInstrument(t *testing.T) {
+	data := instrumentFile("4k14", "Bass",
+		[]byte{1, 10, 70, 64, 40, 128},          // ENV
+		[]byte{2, 52, 64, 0, 0, 64, 32, 100, 2}, // VCO, trisaw
+		[]byte{3, 30, 60, 1},                    // VCF, lowpass
+		[]byte{4, 80, 50, 0},                    // DST, with sample & hold
+		[]byte{6, 3},                            // FOP, mulp
+		[]byte{12},                              // GLITCH
+		[]byte{7, 96, 0x40, 0xFF, 1, 1},         // FST to the transpose of the VCO, popping
+		[]byte{8, 64},                           // PAN
+		[]byte{9, 100, 20},                      // OUT
+	)
+	instr, warnings, err := fourklang.ParseInstrument(data)
+	if err != nil {
+		t.Fatalf("ParseInstrument failed: %v", err)
+	}
+	expected := sointu.Instrument{Name: "Bass", NumVoices: 1, Units: []sointu.Unit{
+		{Type: "envelope", Parameters: map[string]int{"stereo": 0, "attack": 10, "decay": 70, "sustain": 64, "release": 40, "gain": 128}},
+		{Type: "oscillator", ID: 1, Parameters: map[string]int{"stereo": 0, "transpose": 52, "detune": 64, "phase": 0, "color": 64, "shape": 32, "gain": 100, "type": sointu.Trisaw, "lfo": 0, "unison": 0}},
+		{Type: "filter", Parameters: map[string]int{"stereo": 0, "frequency": 30, "resonance": 60, "lowpass": 1, "bandpass": 0, "highpass": 0, "negbandpass": 0, "neghighpass": 0}},
+		{Type: "distort", Parameters: map[string]int{"stereo": 0, "drive": 80}},
+		{Type: "hold", Parameters: map[string]int{"stereo": 0, "holdfreq": 50}},
+		{Type: "mulp", Parameters: map[string]int{"stereo": 0}},
+		{Type: "send", Parameters: map[string]int{"stereo": 0, "amount": 96, "voice": 0, "target": 1, "port": 0, "sendpop": 1}},
+		{Type: "pan", Parameters: map[string]int{"stereo": 0, "panning": 64}},
+		{Type: "outaux", Parameters: map[string]int{"stereo": 1, "outgain": 100, "auxgain": 20}},
+	}}
+	if !reflect.DeepEqual(instr, expected) {
+		t.Fatalf("wrong instrument\nexpected: %#v\ngot: %#v", expected, instr)
+	}
+	if len(warnings) != 1 || warnings[0].Unit != 5 || !strings.Contains(warnings[0].Message, "GLITCH") {
+		t.Fatalf("expected a warning about the GLITCH, got %v", warnings)
+	}
+}
+
+func TestParsePatch(t *testing.T) {
+	data := append([]byte("4k11"), 2, 0, 0, 0)
+	names := make([]byte, 16*64)
+	copy(names, "Lead")
+	copy(names[2*64:], "Pad")
+	data = append(data, names...)
+	data = append(data, unitData(
+		[]byte{1, 0, 64, 64, 64, 128},          // ENV
+		[]byte{2, 64, 64, 0, 0, 64, 128, 0x20}, // VCO, gate, no gates value in 4k11
+		[]byte{6, 7},                           // FOP, mul
+		[]byte{9, 128, 0},                      // OUT
+	)...)
+	data = append(data, unitData()...) // an empty instrument, left out
+	data = append(data, unitData(
+		[]byte{2, 64, 64, 0, 0, 64, 128, 0x08}, // VCO, noise
+		[]byte{7, 0, 0, 16, 1, 1},              // FST to the drive of the global DST
+		[]byte{7, 0, 0, 0, 1, 6},               // FST to the gain of the first VCO, which is slot 6 in 4k11
+		[]byte{7, 0, 0x40, 0, 0, 6},            // FST to a slot of ENV that does not exist
+		[]byte{9, 128, 64},                     // OUT
+	)...)
+	for i := 3; i < 16; i++ {
+		data = append(data, unitData()...)
+	}
+	data = append(data, unitData(
+		[]byte{10, 0},            // ACC, outputs
+		[]byte{4, 64, 128, 0x40}, // DST, stereo, no sample & hold
+		[]byte{10, 8},            // ACC, aux outputs
+		unitBytes(dllVal{Type: 5, Pregain: 128, Feedback: 64, Damp: 64, Count: 8, Guidelay: 64, Leftreverb: 1, Reverb: 1}), // DLL, left reverb
+		unitBytes(dllVal{Type: 5, Pregain: 128, Feedback: 64, Damp: 64, Count: 8, Guidelay: 64, Reverb: 1}),                // DLL, right reverb
+		[]byte{6, 8},      // FOP, stereo addp
+		[]byte{9, 128, 0}, // OUT
+	)...)
+	patch, warnings, err := fourklang.ParsePatch(data)
+	if err != nil {
+		t.Fatalf("ParsePatch failed: %v", err)
+	}
+	if len(patch) != 3 {
+		t.Fatalf("expected 3 instruments, got %v", len(patch))
+	}
+	if patch[0].Name != "Lead" || patch[1].Name != "Pad" || patch[2].Name != "Global" || patch[0].NumVoices != 2 || patch[2].NumVoices != 1 {
+		t.Fatalf("wrong names or voices: %v/%v, %v/%v, %v/%v", patch[0].Name, patch[0].NumVoices, patch[1].Name, patch[1].NumVoices, patch[2].Name, patch[2].NumVoices)
+	}
+	osc := patch[0].Units[1]
+	if osc.Parameters["type"] != sointu.Gate || osc.Parameters["color"] != 0 || osc.Parameters["shape"] != 64 || osc.Parameters["gain"] != 128 || osc.ID == 0 {
+		t.Fatalf("wrong gate oscillator: %v", osc)
+	}
+	dist := patch[2].Units[1]
+	if dist.Type != "distort" || dist.Parameters["stereo"] != 1 || dist.ID == 0 {
+		t.Fatalf("wrong distort: %v", dist)
+	}
+	sends := patch[1].Units[1:4]
+	if sends[0].Type != "send" || sends[0].Parameters["target"] != dist.ID || sends[0].Parameters["port"] != 0 {
+		t.Fatalf("wrong send to the global distort: %v", sends[0])
+	}
+	if sends[1].Type != "send" || sends[1].Parameters["target"] != osc.ID || sends[1].Parameters["port"] != 5 {
+		t.Fatalf("wrong send to the gain of the oscillator: %v", sends[1])
+	}
+	if sends[2].Type != "pop" {
+		t.Fatalf("the unresolved popping send should become a pop, got %v", sends[2])
+	}
+	if len(warnings) != 1 || warnings[0].Instrument != 2 || warnings[0].Unit != 3 {
+		t.Fatalf("expected a warning about the unresolved send, got %v", warnings)
+	}
+	global := patch[2].Units
+	if global[0].Type != "in" || global[0].Parameters["channel"] != 0 || global[2].Parameters["channel"] != 2 {
+		t.Fatalf("wrong accumulators: %v, %v", global[0], global[2])
+	}
+	if global[3].VarArgs[0] != 1116 || global[4].VarArgs[0] != 1140 {
+		t.Fatalf("wrong reverbs: %v, %v", global[3].VarArgs, global[4].VarArgs)
+	}
+	if global[5].Type != "addp" || global[5].Parameters["stereo"] != 1 {
+		t.Fatalf("wrong stereo addp: %v", global[5])
+	}
+	if _, err := vm.Encode(patch, vm.AllFeatures{}); err != nil {
+		t.Fatalf("the converted patch could not be encoded: %v", err)
+	}
+}
+
+func TestParseDelaysAndStores(t *testing.T) {
+	data := instrumentFile("4k14", "Echo",
+		unitBytes(dllVal{Type: 5, Pregain: 64, Dry: 128, Feedback: 96, Damp: 32, Delay: 3, Count: 1, Guidelay: 20}),              // DLL, free delay time
+		unitBytes(dllVal{Type: 5, Pregain: 64, Dry: 128, Feedback: 96, Damp: 32, Delay: 4, Count: 1, Guidelay: 20, Synctype: 2}), // DLL, note sync
+		unitBytes(dllVal{Type: 5, Pregain: 64, Dry: 128, Feedback: 96, Damp: 32, Delay: 5, Count: 1, Guidelay: 20, Synctype: 1}), // DLL, tempo sync
+		unitBytes(dllVal{Type: 5, Pregain: 64, Dry: 128, Feedback: 96, Damp: 32, Freq: 10, Depth: 20, Count: 1, Guidelay: 20}),   // DLL, modulated delay time
+		unitBytes(fstVal{Type: 7, Amount: 64, FstType: 0x20, DestStack: 0xFF, DestUnit: 0, DestSlot: 1}),                         // FST multiplying the pregain
+		unitBytes(fstVal{Type: 7, Amount: 64, FstType: 0x00, DestStack: 0xFF, DestUnit: 1, DestSlot: 2}),                         // FST setting the dry
+		unitBytes(fstVal{Type: 7, Amount: 64, FstType: 0x10, DestStack: 0xFF, DestUnit: 1, DestSlot: 2}),                         // FST adding to the dry
+		unitBytes(fstVal{Type: 7, Amount: 64, FstType: 0x40, DestStack: 0xFF, DestUnit: 2, DestSlot: 3}),                         // FST setting the feedback, popping
+	)
+	instr, warnings, err := fourklang.ParseInstrument(data)
+	if err != nil {
+		t.Fatalf("ParseInstrument failed: %v", err)
+	}
+	units := instr.Units
+	if !reflect.DeepEqual(units[0].VarArgs, []int{320}) || units[0].Parameters["pregain"] != 64 || units[0].Parameters["dry"] != 128 || units[0].Parameters["feedback"] != 96 || units[0].Parameters["damp"] != 32 {
+		t.Errorf("wrong free delay: %v", units[0])
+	}
+	if !reflect.DeepEqual(units[1].VarArgs, []int{10787}) || units[1].Parameters["notetracking"] != 1 {
+		t.Errorf("wrong note synced delay: %v", units[1])
+	}
+	if !reflect.DeepEqual(units[2].VarArgs, []int{320}) || !reflect.DeepEqual(units[3].VarArgs, []int{320}) {
+		t.Errorf("wrong tempo synced or modulated delays: %v, %v", units[2], units[3])
+	}
+	for i, u := range units[4:] {
+		if u.Type != "send" || u.Parameters["target"] != units[[]int{0, 1, 1, 2}[i]].ID {
+			t.Errorf("wrong send %v: %v", i, u)
+		}
+	}
+	var warned []int
+	for _, w := range warnings {
+		warned = append(warned, w.Unit)
+	}
+	if !reflect.DeepEqual(warned, []int{2, 3, 4, 5}) {
+		t.Errorf("expected warnings about the tempo sync, the modulation, the multiplying store and the overridden store, got %v", warnings)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	if _, _, err := fourklang.ParseInstrument([]byte("RIFF")); err == nil {
+		t.Error("expected an error for an unknown version tag")
+	}
+	if _, _, err := fourklang.ParsePatch(instrumentFile("4k14", "Too short")); err == nil {
+		t.Error("expected an error for a truncated patch")
+	}
+	data := append([]byte("4k14"), make([]byte, 10)...)
+	binary.LittleEndian.PutUint32(data[4:], 1)
+	if _, _, err := fourklang.ParseInstrument(data); err == nil {
+		t.Error("expected an error for a truncated instrument")
+	}
+}
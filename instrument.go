@@ -6,6 +6,7 @@ type Instrument struct {
 	Comment   string `yaml:",omitempty"`
 	NumVoices int
 	Units     []Unit
+	Samples   []InstrumentSample `yaml:",omitempty"`
 }
 
 // Copy makes a deep copy of an Instrument
@@ -14,5 +15,54 @@ func (instr *Instrument) Copy() Instrument {
 	for i, u := range instr.Units {
 		units[i] = u.Copy()
 	}
-	return Instrument{Name: instr.Name, Comment: instr.Comment, NumVoices: instr.NumVoices, Units: units}
+	var samples []InstrumentSample
+	if len(instr.Samples) > 0 {
+		samples = make([]InstrumentSample, len(instr.Samples))
+		for i, s := range instr.Samples {
+			samples[i] = s.Copy()
+		}
+	}
+	return Instrument{Name: instr.Name, Comment: instr.Comment, NumVoices: instr.NumVoices, Units: units, Samples: samples}
+}
+
+// X87StackSize is the number of signals that fit on the stack of the native
+// and compiled VM, which uses the 8-level x87 FPU stack for the signals. The Go
+// interpreter has no such limit, so a patch that plays fine in the tracker can
+// still overflow when compiled.
+const X87StackSize = 8
+
+// StackUse is the result of a static analysis of how the units of an
+// instrument use the signal stack, based on StackChange and StackNeed of the
+// units. Depths[i] is the number of signals on the stack after the i:th unit.
+// Underflows lists the indices of the units that need more signals than there
+// are on the stack, Peak is the maximum depth and Leftover the number of
+// signals left on the stack after the last unit, which should be 0.
+type StackUse struct {
+	Depths     []int
+	Underflows []int
+	Peak       int
+	Leftover   int
+}
+
+// StackUse analyzes the stack use of the instrument; see StackUse.
+func (instr *Instrument) StackUse() StackUse {
+	ret := StackUse{Depths: make([]int, len(instr.Units))}
+	depth := 0
+	for i, u := range instr.Units {
+		if u.StackNeed() > depth {
+			ret.Underflows = append(ret.Underflows, i)
+		}
+		depth += u.StackChange()
+		ret.Depths[i] = depth
+		if depth > ret.Peak {
+			ret.Peak = depth
+		}
+	}
+	ret.Leftover = depth
+	return ret
+}
+
+// Overflows returns true if the peak depth exceeds the x87 stack.
+func (s StackUse) Overflows() bool {
+	return s.Peak > X87StackSize
 }
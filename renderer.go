@@ -0,0 +1,179 @@
+package sointu
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// SongRenderer renders a Song with a Synth in blocks of the size chosen by the
+// caller, instead of the whole song at once like Play. The notes are triggered
+// and released and the sync outputs are timed the same way as in Play.
+// SongRenderer also implements io.Reader, so that the audio can be piped into
+// e.g. an encoder or an audio output without buffering the whole song.
+type SongRenderer struct {
+	// PCM16 makes Read output 16-bit signed integers instead of 32-bit floats
+	PCM16 bool
+
+	synth         Synth
+	song          Song
+	curVoices     []int
+	numSyncs      int
+	row           int  // the row being rendered
+	rowTime       int  // time advanced within the row
+	rowSamples    int  // samples rendered within the row
+	rowTriggered  bool // if the notes of the row have been triggered already
+	syncRowBuffer []float32
+	readBuffer    []float32
+	out           bytes.Buffer // rendered bytes not read yet
+	err           error        // error to return from Read when out is empty
+}
+
+// NewSongRenderer returns a SongRenderer that renders the song from the
+// beginning, using a synth compiled from the patch of the song. Like Play, the
+// renderer does not release the voices before the first notes; call
+// synth.Release for that.
+func NewSongRenderer(synth Synth, song Song) (*SongRenderer, error) {
+	if err := song.Validate(); err != nil {
+		return nil, err
+	}
+	r := &SongRenderer{synth: synth, song: song, numSyncs: song.Patch.NumSyncs()}
+	r.curVoices = make([]int, len(song.Score.Tracks))
+	for i := range r.curVoices {
+		r.curVoices[i] = song.Score.FirstVoiceForTrack(i)
+	}
+	r.syncRowBuffer = make([]float32, r.SyncBlockLength())
+	return r, nil
+}
+
+// SyncBlockLength returns how many values the sync outputs of one row take at
+// most: each sync has the time and the values of all the sync units. The
+// syncBuffer given to Render should have at least this many values.
+func (r *SongRenderer) SyncBlockLength() int {
+	return ((r.song.SamplesPerRow() + 255) / 256) * (1 + r.numSyncs)
+}
+
+// Row returns the row being rendered. It is the length of the song in rows
+// once the song has ended.
+func (r *SongRenderer) Row() int {
+	return r.row
+}
+
+// Render fills a stereo buffer with the next samples of the song, until either
+// the buffer is full or the song ends. The sync outputs are written into
+// syncBuffer, the time of each sync converted into rows, as in Play; the
+// syncBuffer can be nil if the syncs are not needed. Render returns early if
+// the syncBuffer might not fit the syncs of the next row. Returns the number of
+// samples (in stereo samples) and syncs rendered, and io.EOF when the song has
+// already ended.
+func (r *SongRenderer) Render(buffer []float32, syncBuffer []float32) (samples int, syncs int, err error) {
+	length := r.song.Score.LengthInRows()
+	samplesPerRow := r.song.SamplesPerRow()
+	stride := 1 + r.numSyncs
+	if r.row >= length {
+		return 0, 0, io.EOF
+	}
+	for r.row < length && len(buffer)-samples*2 > 1 {
+		if syncBuffer != nil && len(syncBuffer)-syncs*stride < len(r.syncRowBuffer) {
+			if samples == 0 {
+				return 0, 0, fmt.Errorf("the sync buffer should have room for at least %v values", len(r.syncRowBuffer))
+			}
+			return samples, syncs, nil
+		}
+		if !r.rowTriggered {
+			r.trigger()
+			r.rowTriggered = true
+		}
+		block := buffer[samples*2:]
+		if len(block) > samplesPerRow*2 {
+			block = block[:samplesPerRow*2] // syncRowBuffer has room for the syncs of one row
+		}
+		s, n, time, err := r.synth.Render(block, r.syncRowBuffer, samplesPerRow-r.rowTime)
+		for i := 0; i < n; i++ {
+			r.syncRowBuffer[i*stride] = (r.syncRowBuffer[i*stride]+float32(r.rowTime))/float32(samplesPerRow) + float32(r.row)
+		}
+		if syncBuffer != nil {
+			copy(syncBuffer[syncs*stride:], r.syncRowBuffer[:n*stride])
+			syncs += n
+		}
+		samples += s
+		if err != nil {
+			return samples, syncs, fmt.Errorf("render failed: %v", err)
+		}
+		r.rowTime += time
+		r.rowSamples += s
+		if r.rowTime >= samplesPerRow {
+			r.row++
+			r.rowTime, r.rowSamples, r.rowTriggered = 0, 0, false
+		} else if r.rowSamples > 100*samplesPerRow {
+			return samples, syncs, fmt.Errorf("Song speed modulation likely so slow that row never advances; error at pattern %v, row %v", r.row/r.song.Score.RowsPerPattern, r.row%r.song.Score.RowsPerPattern)
+		}
+	}
+	return samples, syncs, nil
+}
+
+// Read implements io.Reader, reading the audio as little-endian, interleaved
+// stereo samples: 32-bit floats, or 16-bit signed integers if PCM16 is set. The
+// sync outputs are discarded. Returns io.EOF once the song has ended.
+func (r *SongRenderer) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if r.out.Len() == 0 && r.err == nil {
+		frameSize := 8
+		if r.PCM16 {
+			frameSize = 4
+		}
+		frames := len(p) / frameSize
+		if frames == 0 {
+			frames = 1 // p is smaller than one frame; the rest is read later
+		}
+		if cap(r.readBuffer) < frames*2 {
+			r.readBuffer = make([]float32, frames*2)
+		}
+		var samples int
+		samples, _, r.err = r.Render(r.readBuffer[:frames*2], nil)
+		if err := rawToBuffer(r.readBuffer[:samples*2], r.PCM16, &r.out); err != nil && r.err == nil {
+			r.err = err
+		}
+	}
+	if r.out.Len() == 0 {
+		return 0, r.err
+	}
+	return r.out.Read(p)
+}
+
+// trigger releases and triggers the voices for the notes on the current row.
+func (r *SongRenderer) trigger() {
+	score := r.song.Score
+	patternRow := r.row % score.RowsPerPattern
+	pattern := r.row / score.RowsPerPattern
+	for t := range score.Tracks {
+		order := score.Tracks[t].Order
+		if pattern < 0 || pattern >= len(order) {
+			continue
+		}
+		patternIndex := order[pattern]
+		patterns := score.Tracks[t].Patterns
+		if patternIndex < 0 || int(patternIndex) >= len(patterns) {
+			continue
+		}
+		pattern := patterns[patternIndex]
+		if patternRow < 0 || patternRow >= len(pattern) {
+			continue
+		}
+		note := pattern[patternRow]
+		if note > 0 && note <= 1 { // anything but hold causes an action.
+			continue
+		}
+		r.synth.Release(r.curVoices[t])
+		if note > 1 {
+			r.curVoices[t]++
+			first := score.FirstVoiceForTrack(t)
+			if r.curVoices[t] >= first+score.Tracks[t].NumVoices {
+				r.curVoices[t] = first
+			}
+			r.synth.Trigger(r.curVoices[t], note)
+		}
+	}
+}
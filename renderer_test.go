@@ -0,0 +1,129 @@
+package sointu_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
+)
+
+func loadTestSong(t *testing.T, name string) sointu.Song {
+	t.Helper()
+	f, err := os.Open(filepath.Join("tests", name))
+	if err != nil {
+		t.Fatalf("cannot open the song: %v", err)
+	}
+	defer f.Close()
+	song, err := sointu.LoadSong(f)
+	if err != nil {
+		t.Fatalf("cannot load the song: %v", err)
+	}
+	return song
+}
+
+func newRenderer(t *testing.T, song sointu.Song) *sointu.SongRenderer {
+	t.Helper()
+	synth, err := vm.SynthService{}.Compile(song.Patch)
+	if err != nil {
+		t.Fatalf("cannot compile the patch: %v", err)
+	}
+	r, err := sointu.NewSongRenderer(synth, song)
+	if err != nil {
+		t.Fatalf("NewSongRenderer failed: %v", err)
+	}
+	return r
+}
+
+func TestSongRendererRender(t *testing.T) {
+	song := loadTestSong(t, "test_sync.yml")
+	expected, expectedSyncs, err := sointu.Play(vm.SynthService{}, song, false)
+	if err != nil {
+		t.Fatalf("Play failed: %v", err)
+	}
+	for _, blockSize := range []int{1, 999, 44100} {
+		r := newRenderer(t, song)
+		block := make([]float32, blockSize*2)
+		syncBlock := make([]float32, r.SyncBlockLength())
+		stride := 1 + song.Patch.NumSyncs()
+		var buffer, syncBuffer []float32
+		for {
+			samples, syncs, err := r.Render(block, syncBlock)
+			buffer = append(buffer, block[:samples*2]...)
+			syncBuffer = append(syncBuffer, syncBlock[:syncs*stride]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Render failed: %v", err)
+			}
+		}
+		if !reflect.DeepEqual(buffer, expected) {
+			t.Errorf("the audio rendered in blocks of %v differs from Play", blockSize)
+		}
+		if !reflect.DeepEqual(syncBuffer, expectedSyncs) {
+			t.Errorf("the syncs rendered in blocks of %v differ from Play", blockSize)
+		}
+		if r.Row() != song.Score.LengthInRows() {
+			t.Errorf("expected the renderer to end at row %v, got %v", song.Score.LengthInRows(), r.Row())
+		}
+	}
+	if _, _, err := newRenderer(t, song).Render(make([]float32, 2), make([]float32, 1)); err == nil {
+		t.Errorf("expected an error for a too small sync buffer")
+	}
+}
+
+// chunkReader reads at most n bytes at a time, to test reading parts of frames.
+type chunkReader struct {
+	r io.Reader
+	n int
+}
+
+func (c chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.n {
+		p = p[:c.n]
+	}
+	return c.r.Read(p)
+}
+
+func TestSongRendererRead(t *testing.T) {
+	song := loadTestSong(t, "test_chords.yml")
+	expected, _, err := sointu.Play(vm.SynthService{}, song, false)
+	if err != nil {
+		t.Fatalf("Play failed: %v", err)
+	}
+	for _, pcm16 := range []bool{false, true} {
+		raw, err := sointu.Raw(expected, pcm16)
+		if err != nil {
+			t.Fatalf("Raw failed: %v", err)
+		}
+		for _, chunk := range []int{3, 4096} {
+			r := newRenderer(t, song)
+			r.PCM16 = pcm16
+			data, err := ioutil.ReadAll(chunkReader{r, chunk})
+			if err != nil {
+				t.Fatalf("reading the song failed: %v", err)
+			}
+			if !bytes.Equal(data, raw) {
+				t.Errorf("the audio read in chunks of %v bytes (pcm16 %v) differs from Play", chunk, pcm16)
+			}
+		}
+	}
+}
+
+func TestSongRendererErrors(t *testing.T) {
+	song := loadTestSong(t, "test_chords.yml")
+	synth, err := vm.SynthService{}.Compile(song.Patch)
+	if err != nil {
+		t.Fatalf("cannot compile the patch: %v", err)
+	}
+	song.BPM = 0
+	if _, err := sointu.NewSongRenderer(synth, song); err == nil {
+		t.Errorf("expected an error for an invalid song")
+	}
+}
@@ -0,0 +1,91 @@
+// Package riff implements a minimal reader for RIFF files, such as .wav, .dls
+// and .sf2 files.
+package riff
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Chunk is a RIFF chunk. For RIFF and LIST chunks, ListType is the type of the
+// list and Children the subchunks; for other chunks, Data is the contents of
+// the chunk. Pos is the position of Data (or the list type) in the file and
+// Size the size of the chunk, as given in its header.
+type Chunk struct {
+	ID       string
+	ListType string
+	Pos      int
+	Size     int
+	Data     []byte
+	Children []Chunk
+}
+
+// Parse parses the RIFF chunk at the beginning of data, along with all its
+// subchunks. The Data of the chunks are slices of data, so modifying data
+// modifies the chunks.
+func Parse(data []byte) (Chunk, error) {
+	return readChunk(data, 0)
+}
+
+// Key returns the chunk id, followed by the list type for lists e.g. "LIST
+// wave", which is convenient for switching on chunk types.
+func (c *Chunk) Key() string {
+	if c.ListType != "" {
+		return c.ID + " " + c.ListType
+	}
+	return c.ID
+}
+
+// Find returns the first subchunk with the given key (see Key), or nil if
+// there is no such subchunk.
+func (c *Chunk) Find(key string) *Chunk {
+	for i := range c.Children {
+		if c.Children[i].Key() == key {
+			return &c.Children[i]
+		}
+	}
+	return nil
+}
+
+// Info returns the value of a subchunk of an INFO list e.g. INAM, with the
+// terminating zeros removed.
+func (c *Chunk) Info(id string) string {
+	ch := c.Find(id)
+	if ch == nil {
+		return ""
+	}
+	s := ch.Data
+	for len(s) > 0 && s[len(s)-1] == 0 {
+		s = s[:len(s)-1]
+	}
+	return string(s)
+}
+
+func readChunk(data []byte, pos int) (Chunk, error) {
+	if pos+8 > len(data) {
+		return Chunk{}, fmt.Errorf("truncated chunk header at %v", pos)
+	}
+	c := Chunk{ID: string(data[pos : pos+4]), Pos: pos + 8}
+	c.Size = int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+	end := c.Pos + c.Size
+	if end > len(data) || end < c.Pos {
+		return Chunk{}, fmt.Errorf("chunk %q at %v is %v bytes, but only %v bytes remain", c.ID, pos, c.Size, len(data)-c.Pos)
+	}
+	if c.ID != "RIFF" && c.ID != "LIST" {
+		c.Data = data[c.Pos:end]
+		return c, nil
+	}
+	if c.Size < 4 {
+		return Chunk{}, fmt.Errorf("list chunk at %v is missing its type", pos)
+	}
+	c.ListType = string(data[c.Pos : c.Pos+4])
+	for p := c.Pos + 4; p+8 <= end; {
+		child, err := readChunk(data[:end], p)
+		if err != nil {
+			return Chunk{}, err
+		}
+		c.Children = append(c.Children, child)
+		p = child.Pos + child.Size + child.Size&1 // chunks are padded to even size
+	}
+	return c, nil
+}
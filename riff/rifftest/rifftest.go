@@ -0,0 +1,31 @@
+// Package rifftest builds RIFF files for the tests of the packages reading
+// them.
+package rifftest
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Chunk returns a chunk with the given id and contents, padded to an even
+// length.
+func Chunk(id string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(id)
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	if len(data)&1 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// List returns a RIFF or LIST chunk of the given list type with the children
+// as its subchunks.
+func List(id, listType string, children ...[]byte) []byte {
+	data := []byte(listType)
+	for _, c := range children {
+		data = append(data, c...)
+	}
+	return Chunk(id, data)
+}
@@ -0,0 +1,92 @@
+package sointu
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/vsariola/sointu/riff"
+)
+
+// InstrumentSample is a mono 16-bit sample embedded in an instrument. If any
+// instrument of a patch has embedded samples, the Sample oscillators play the
+// embedded samples instead of gm.dls: the samplestart parameter of an
+// oscillator is then the offset within the concatenated samples of its
+// instrument.
+type InstrumentSample struct {
+	Name string  `yaml:",omitempty"`
+	Data []int16 `yaml:",flow"`
+}
+
+// Copy makes a deep copy of an InstrumentSample
+func (s *InstrumentSample) Copy() InstrumentSample {
+	data := make([]int16, len(s.Data))
+	copy(data, s.Data)
+	return InstrumentSample{Name: s.Name, Data: data}
+}
+
+// ReadWavSample reads a WAV file into an InstrumentSample. The WAV file can
+// have 8, 16, 24 or 32-bit integer or 32-bit float samples; multichannel files
+// are mixed down to mono. The sample rate is not converted.
+func ReadWavSample(r io.Reader, name string) (InstrumentSample, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return InstrumentSample{}, fmt.Errorf("could not read WAV: %v", err)
+	}
+	root, err := riff.Parse(data)
+	if err != nil {
+		return InstrumentSample{}, fmt.Errorf("could not parse WAV: %v", err)
+	}
+	if root.ID != "RIFF" || root.ListType != "WAVE" {
+		return InstrumentSample{}, errors.New("not a WAV file: missing RIFF WAVE header")
+	}
+	fmtChunk, dataChunk := root.Find("fmt "), root.Find("data")
+	if fmtChunk == nil || dataChunk == nil {
+		return InstrumentSample{}, errors.New("WAV file is missing the fmt or data chunk")
+	}
+	if len(fmtChunk.Data) < 16 {
+		return InstrumentSample{}, errors.New("WAV fmt chunk is too short")
+	}
+	formatTag := binary.LittleEndian.Uint16(fmtChunk.Data[0:2])
+	channels := int(binary.LittleEndian.Uint16(fmtChunk.Data[2:4]))
+	bits := int(binary.LittleEndian.Uint16(fmtChunk.Data[14:16]))
+	if formatTag == 0xFFFE && len(fmtChunk.Data) >= 26 { // WAVE_FORMAT_EXTENSIBLE, the actual format is in the subformat GUID
+		formatTag = binary.LittleEndian.Uint16(fmtChunk.Data[24:26])
+	}
+	var decode func(b []byte) float64
+	switch {
+	case formatTag == 1 && bits == 8:
+		decode = func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case formatTag == 1 && bits == 16:
+		decode = func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / 32768 }
+	case formatTag == 1 && bits == 24:
+		decode = func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)) / 2147483648
+		}
+	case formatTag == 1 && bits == 32:
+		decode = func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / 2147483648 }
+	case formatTag == 3 && bits == 32:
+		decode = func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }
+	default:
+		return InstrumentSample{}, fmt.Errorf("unsupported WAV format %v with %v bits per sample", formatTag, bits)
+	}
+	if channels < 1 {
+		return InstrumentSample{}, errors.New("WAV file has no channels")
+	}
+	bytesPerSample := bits / 8
+	frameSize := bytesPerSample * channels
+	ret := InstrumentSample{Name: name, Data: make([]int16, len(dataChunk.Data)/frameSize)}
+	for i := range ret.Data {
+		var sum float64
+		for c := 0; c < channels; c++ {
+			pos := i*frameSize + c*bytesPerSample
+			sum += decode(dataChunk.Data[pos : pos+bytesPerSample])
+		}
+		v := math.Round(sum / float64(channels) * 32767)
+		ret.Data[i] = int16(math.Max(math.Min(v, 32767), -32768))
+	}
+	return ret, nil
+}
@@ -0,0 +1,166 @@
+package sointu
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// schemaDraft is the JSON Schema draft of the generated schemas; draft-07 is
+// the one best supported by the YAML language servers of the editors.
+const schemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// SongSchema returns a JSON Schema of the .yml song files, generated from
+// UnitTypes: the allowed parameters of each unit type, with their ranges, the
+// delay times of the delay units and the structure of the score. Editors with
+// YAML language servers can use it to validate and autocomplete song files.
+// The JSON song files use the same structure, but their keys are the field
+// names of the structs, so the schema does not apply to them.
+func SongSchema() ([]byte, error) {
+	return marshalSchema("Sointu song", "song")
+}
+
+// InstrumentSchema returns a JSON Schema of the .yml instrument files; see
+// SongSchema.
+func InstrumentSchema() ([]byte, error) {
+	return marshalSchema("Sointu instrument", "instrument")
+}
+
+func marshalSchema(title, root string) ([]byte, error) {
+	definitions := schemaDefinitions()
+	// in draft-07, the keywords next to a $ref are ignored, so the root
+	// definition is copied to the root of the schema instead of referred to
+	s := schemaObject{"$schema": schemaDraft, "title": title, "definitions": definitions}
+	for k, v := range definitions[root].(schemaObject) {
+		s[k] = v
+	}
+	return json.MarshalIndent(s, "", "  ")
+}
+
+type schemaObject = map[string]interface{}
+
+// legacyParameters are the parameters that the unit types no longer have, but
+// that older versions of Sointu wrote into the files, e.g. the example
+// instruments. They are ignored when encoding, so the schema allows them.
+var legacyParameters = map[string][]string{
+	"delay": {"count", "delay"},
+	"send":  {"unit"},
+}
+
+func schemaDefinitions() schemaObject {
+	intRange := func(min, max int) schemaObject {
+		return schemaObject{"type": "integer", "minimum": min, "maximum": max}
+	}
+	arrayOf := func(items schemaObject) schemaObject {
+		return schemaObject{"type": "array", "items": items}
+	}
+	// the structs are decoded with unknown fields as errors, so no additional
+	// properties are allowed in any of the objects
+	object := func(properties schemaObject, required ...string) schemaObject {
+		o := schemaObject{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(required) > 0 {
+			o["required"] = required
+		}
+		return o
+	}
+	ref := func(name string) schemaObject {
+		return schemaObject{"$ref": "#/definitions/" + name}
+	}
+	isType := func(name string) schemaObject {
+		return schemaObject{"properties": schemaObject{"type": schemaObject{"const": name}}, "required": []string{"type"}}
+	}
+	unitTypes := make([]string, 0, len(UnitTypes))
+	for name := range UnitTypes {
+		unitTypes = append(unitTypes, name)
+	}
+	sort.Strings(unitTypes)
+	var rules []interface{}
+	gateParams := schemaObject{} // the ranges of the gate bits when not a gate
+	for _, name := range unitTypes {
+		params := schemaObject{}
+		for _, p := range UnitTypes[name] {
+			if !p.CanSet {
+				continue
+			}
+			params[p.Name] = intRange(p.MinValue, p.MaxValue)
+			if name == "oscillator" && (p.Name == "color" || p.Name == "shape") {
+				gateParams[p.Name] = params[p.Name]
+				params[p.Name] = intRange(p.MinValue, 255) // the gate bits are stored in color and shape
+			}
+		}
+		for _, p := range legacyParameters[name] {
+			params[p] = schemaObject{"type": "integer", "description": "ignored; written by older versions of Sointu"}
+		}
+		then := schemaObject{"properties": schemaObject{"parameters": object(params)}}
+		if name == "delay" {
+			// the delay times of the delay lines, in samples
+			then["properties"].(schemaObject)["varargs"] = schemaObject{"type": "array", "items": intRange(0, 65535), "minItems": 1}
+			then["required"] = []string{"varargs"}
+		} else {
+			then["properties"].(schemaObject)["varargs"] = schemaObject{"type": "array", "maxItems": 0}
+		}
+		rules = append(rules, schemaObject{"if": isType(name), "then": then})
+	}
+	var notGate []int
+	for _, p := range UnitTypes["oscillator"] {
+		if p.Name == "type" {
+			for v := p.MinValue; v <= p.MaxValue; v++ {
+				if v != Gate {
+					notGate = append(notGate, v)
+				}
+			}
+		}
+	}
+	rules = append(rules, schemaObject{
+		"if": schemaObject{"allOf": []interface{}{isType("oscillator"), schemaObject{"properties": schemaObject{
+			"parameters": schemaObject{"properties": schemaObject{"type": schemaObject{"enum": notGate}}},
+		}}}},
+		"then": schemaObject{"properties": schemaObject{
+			"parameters": schemaObject{"properties": gateParams},
+		}},
+	})
+	unit := object(schemaObject{
+		"type":       schemaObject{"type": "string", "enum": unitTypes},
+		"id":         schemaObject{"type": "integer", "minimum": 0},
+		"parameters": schemaObject{"type": "object", "additionalProperties": schemaObject{"type": "integer"}},
+		"varargs":    arrayOf(schemaObject{"type": "integer"}),
+	})
+	unit["allOf"] = rules
+	return schemaObject{
+		"song": object(schemaObject{
+			"version":     intRange(0, SongVersion),
+			"title":       schemaObject{"type": "string"},
+			"author":      schemaObject{"type": "string"},
+			"comment":     schemaObject{"type": "string"},
+			"license":     schemaObject{"type": "string"},
+			"bpm":         schemaObject{"type": "integer", "minimum": 1},
+			"rowsperbeat": schemaObject{"type": "integer", "minimum": 1},
+			"score":       ref("score"),
+			"patch":       arrayOf(ref("instrument")),
+		}, "bpm"),
+		"score": object(schemaObject{
+			"tracks":         arrayOf(ref("track")),
+			"rowsperpattern": schemaObject{"type": "integer", "minimum": 1},
+			"length":         schemaObject{"type": "integer", "minimum": 0},
+		}),
+		"track": object(schemaObject{
+			"numvoices": schemaObject{"type": "integer", "minimum": 1},
+			"effect":    schemaObject{"type": "boolean"},
+			// -1 is an empty slot in the order list
+			"order": arrayOf(schemaObject{"type": "integer", "minimum": -1}),
+			// 0 releases and 1 holds the previous note
+			"patterns": arrayOf(arrayOf(intRange(0, 255))),
+		}),
+		"instrument": object(schemaObject{
+			"name":      schemaObject{"type": "string"},
+			"comment":   schemaObject{"type": "string"},
+			"numvoices": schemaObject{"type": "integer", "minimum": 1},
+			"units":     arrayOf(ref("unit")),
+			"samples":   arrayOf(ref("sample")),
+		}),
+		"unit": unit,
+		"sample": object(schemaObject{
+			"name": schemaObject{"type": "string"},
+			"data": arrayOf(intRange(-32768, 32767)),
+		}),
+	}
+}
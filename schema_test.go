@@ -0,0 +1,200 @@
+package sointu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validate checks a decoded YAML document against a schema; only the keywords
+// used by the generated schemas are supported.
+func validate(doc interface{}, schema, root map[string]interface{}, path string) error {
+	if r, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(r, "#/definitions/")
+		return validate(doc, root["definitions"].(map[string]interface{})[name].(map[string]interface{}), root, path)
+	}
+	if c, ok := schema["const"]; ok && !sameValue(doc, c) {
+		return fmt.Errorf("%v: expected %v, got %v", path, c, doc)
+	}
+	if e, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, v := range e {
+			found = found || sameValue(v, doc)
+		}
+		if !found {
+			return fmt.Errorf("%v: %v is not one of %v", path, doc, e)
+		}
+	}
+	switch x := doc.(type) {
+	case int:
+		if schema["type"] != nil && schema["type"] != "integer" {
+			return fmt.Errorf("%v: did not expect an integer", path)
+		}
+		if min, ok := schema["minimum"].(float64); ok && float64(x) < min {
+			return fmt.Errorf("%v: %v is less than %v", path, x, min)
+		}
+		if max, ok := schema["maximum"].(float64); ok && float64(x) > max {
+			return fmt.Errorf("%v: %v is more than %v", path, x, max)
+		}
+	case float64:
+		if schema["type"] != nil && schema["type"] != "number" {
+			return fmt.Errorf("%v: did not expect a number", path)
+		}
+	case []interface{}:
+		if schema["type"] != nil && schema["type"] != "array" {
+			return fmt.Errorf("%v: did not expect an array", path)
+		}
+		if max, ok := schema["maxItems"].(float64); ok && float64(len(x)) > max {
+			return fmt.Errorf("%v: more than %v items", path, max)
+		}
+		if min, ok := schema["minItems"].(float64); ok && float64(len(x)) < min {
+			return fmt.Errorf("%v: less than %v items", path, min)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, v := range x {
+				if err := validate(v, items, root, fmt.Sprintf("%v[%v]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]interface{}:
+		if schema["type"] != nil && schema["type"] != "object" {
+			return fmt.Errorf("%v: did not expect an object", path)
+		}
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				if _, ok := x[r.(string)]; !ok {
+					return fmt.Errorf("%v: %v is required", path, r)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for k, v := range x {
+			s, ok := properties[k].(map[string]interface{})
+			if !ok {
+				if s, ok = schema["additionalProperties"].(map[string]interface{}); !ok {
+					if schema["additionalProperties"] == false {
+						return fmt.Errorf("%v: unknown property %v", path, k)
+					}
+					continue
+				}
+			}
+			if err := validate(v, s, root, path+"."+k); err != nil {
+				return err
+			}
+		}
+	}
+	allOf, _ := schema["allOf"].([]interface{})
+	for _, rule := range allOf {
+		rule := rule.(map[string]interface{})
+		cond, ok := rule["if"].(map[string]interface{})
+		if !ok {
+			if err := validate(doc, rule, root, path); err != nil {
+				return err
+			}
+			continue
+		}
+		if validate(doc, cond, root, path) == nil {
+			if err := validate(doc, rule["then"].(map[string]interface{}), root, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sameValue compares the values of a YAML document and a JSON schema, where
+// the numbers are float64s.
+func sameValue(a, b interface{}) bool {
+	if x, ok := a.(int); ok {
+		a = float64(x)
+	}
+	if x, ok := b.(int); ok {
+		b = float64(x)
+	}
+	return a == b
+}
+
+func loadSchema(t *testing.T, generate func() ([]byte, error)) map[string]interface{} {
+	t.Helper()
+	data, err := generate()
+	if err != nil {
+		t.Fatalf("generating the schema failed: %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("the schema is not valid JSON: %v", err)
+	}
+	return schema
+}
+
+func TestSongSchema(t *testing.T) {
+	schema := loadSchema(t, SongSchema)
+	files, err := filepath.Glob(filepath.Join("tests", "*.yml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("cannot glob the test songs: %v", err)
+	}
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("cannot read the song: %v", err)
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("cannot unmarshal the song: %v", err)
+		}
+		if err := validate(doc, schema, schema, filepath.Base(filename)); err != nil {
+			t.Errorf("the song does not match the schema: %v", err)
+		}
+	}
+}
+
+func TestInstrumentSchema(t *testing.T) {
+	schema := loadSchema(t, InstrumentSchema)
+	files, err := filepath.Glob(filepath.Join("examples", "instruments", "*.yml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("cannot glob the example instruments: %v", err)
+	}
+	for _, filename := range files {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("cannot read the instrument: %v", err)
+		}
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			t.Fatalf("cannot unmarshal the instrument: %v", err)
+		}
+		if err := validate(doc, schema, schema, filepath.Base(filename)); err != nil {
+			t.Errorf("the instrument does not match the schema: %v", err)
+		}
+	}
+}
+
+func TestSchemaErrors(t *testing.T) {
+	schema := loadSchema(t, InstrumentSchema)
+	for _, data := range []string{
+		"numvoices: 1\nunits:\n  - type: oscilator\n",
+		"numvoices: 1\nunits:\n  - type: oscillator\n    parameters: {timbre: 64}\n",
+		"numvoices: 1\nunits:\n  - type: oscillator\n    parameters: {transpose: 1.5}\n",
+		"numvoices: 1\nunits:\n  - type: send\n    parameters: {unit: 1.5}\n",
+		"numvoices: 1\nunits:\n  - type: envelope\n    parameters: {attack: 129}\n",
+		"numvoices: 1\nunits:\n  - type: oscillator\n    parameters: {type: 1, color: 170}\n",
+		"numvoices: 1\nunits:\n  - type: delay\n    parameters: {delaytime: 64}\n    varargs: [1000]\n",
+		"numvoices: 1\nunits:\n  - type: delay\n    parameters: {stereo: 0}\n",
+		"numvoices: 1\nunits:\n  - type: filter\n    parameters: {stereo: 0}\n    varargs: [1000]\n",
+		"numvoices: 1\nunits: []\nvoices: 1\n",
+	} {
+		var doc interface{}
+		if err := yaml.Unmarshal([]byte(data), &doc); err != nil {
+			t.Fatalf("cannot unmarshal %q: %v", data, err)
+		}
+		if validate(doc, schema, schema, "") == nil {
+			t.Errorf("expected %q not to match the schema", data)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Package sf2 reads the sample headers of SoundFont 2 (.sf2) files, so that
+// the samples in them can be used by the Sample oscillators of Sointu.
+//
+// Like gm.dls, a SoundFont is used by loading the whole file into the sample
+// table of the synth as int16s: the sample data chunk (smpl) is always at an
+// even position in the file, so the sample offsets are simply the positions of
+// the samples in the file, in int16s.
+package sf2
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/vsariola/sointu/riff"
+	"github.com/vsariola/sointu/vm"
+)
+
+// SoundFont is a parsed SoundFont 2 file.
+//
+// DataOffset is the position of the sample data (the contents of the smpl
+// chunk) in the file, in bytes. The positions in the sample headers are
+// relative to it and in samples.
+type SoundFont struct {
+	Name       string
+	DataOffset int
+	Samples    []SampleHeader
+	data       []byte
+}
+
+// SampleHeader is a sample header (shdr) record of a SoundFont. Start, End,
+// StartLoop and EndLoop are in samples, relative to the beginning of the
+// sample data.
+type SampleHeader struct {
+	Name            string
+	Start           uint32
+	End             uint32
+	StartLoop       uint32
+	EndLoop         uint32
+	SampleRate      uint32
+	OriginalPitch   uint8
+	PitchCorrection int8
+	SampleLink      uint16
+	SampleType      uint16
+}
+
+// Entry is a sample of the SoundFont mapped to a SampleOffset of the Sointu
+// VM, with a SuggestedTranspose to play the sample at its original pitch,
+// similar to tracker.GmDlsEntry.
+type Entry struct {
+	Name               string
+	SampleOffset       vm.SampleOffset
+	SuggestedTranspose int
+}
+
+const shdrSize = 46
+
+// Read reads and parses a SoundFont 2 file.
+func Read(r io.Reader) (*SoundFont, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read SoundFont: %v", err)
+	}
+	return Parse(data)
+}
+
+// Parse parses a SoundFont 2 file from the contents of the file.
+func Parse(data []byte) (*SoundFont, error) {
+	root, err := riff.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.ID != "RIFF" || root.ListType != "sfbk" {
+		return nil, errors.New("not a SoundFont 2 file: missing RIFF sfbk header")
+	}
+	sf := &SoundFont{data: data}
+	if info := root.Find("LIST INFO"); info != nil {
+		sf.Name = info.Info("INAM")
+	}
+	sdta := root.Find("LIST sdta")
+	if sdta == nil {
+		return nil, errors.New("SoundFont has no sample data (sdta) list")
+	}
+	smpl := sdta.Find("smpl")
+	if smpl == nil {
+		return nil, errors.New("SoundFont has no smpl chunk")
+	}
+	sf.DataOffset = smpl.Pos
+	numSamples := uint32(len(smpl.Data) / 2)
+	pdta := root.Find("LIST pdta")
+	if pdta == nil {
+		return nil, errors.New("SoundFont has no preset data (pdta) list")
+	}
+	shdr := pdta.Find("shdr")
+	if shdr == nil {
+		return nil, errors.New("SoundFont has no sample headers (shdr)")
+	}
+	if len(shdr.Data)%shdrSize != 0 {
+		return nil, fmt.Errorf("shdr chunk size %v is not a multiple of %v", len(shdr.Data), shdrSize)
+	}
+	// the last record is the terminal "EOS" record, which is not a sample
+	for p := 0; p+2*shdrSize <= len(shdr.Data); p += shdrSize {
+		d := shdr.Data[p : p+shdrSize]
+		h := SampleHeader{
+			Name:            zeroTerminated(d[0:20]),
+			Start:           binary.LittleEndian.Uint32(d[20:24]),
+			End:             binary.LittleEndian.Uint32(d[24:28]),
+			StartLoop:       binary.LittleEndian.Uint32(d[28:32]),
+			EndLoop:         binary.LittleEndian.Uint32(d[32:36]),
+			SampleRate:      binary.LittleEndian.Uint32(d[36:40]),
+			OriginalPitch:   d[40],
+			PitchCorrection: int8(d[41]),
+			SampleLink:      binary.LittleEndian.Uint16(d[42:44]),
+			SampleType:      binary.LittleEndian.Uint16(d[44:46]),
+		}
+		if h.Start > h.End || h.End > numSamples {
+			return nil, fmt.Errorf("sample %q spans %v-%v, outside the %v samples of the smpl chunk", h.Name, h.Start, h.End, numSamples)
+		}
+		sf.Samples = append(sf.Samples, h)
+	}
+	return sf, nil
+}
+
+// SampleTable returns the whole SoundFont file as int16s, to be used as the
+// sample table of the synth, just like gm.dls; see vm.LoadSampleTable.
+func (sf *SoundFont) SampleTable() (vm.SampleTable, error) {
+	return vm.LoadSampleTable(bytes.NewReader(sf.data))
+}
+
+// Entry maps the i:th sample header to a SampleOffset, addressing the
+// SoundFont file as a flat table of int16s. If the sample has no valid loop,
+// its last sample is looped. An error is returned if the loop points do not
+// fit in the 16-bit LoopStart and LoopLength of a SampleOffset.
+func (sf *SoundFont) Entry(i int) (Entry, error) {
+	if i < 0 || i >= len(sf.Samples) {
+		return Entry{}, fmt.Errorf("sample index %v out of range", i)
+	}
+	h := sf.Samples[i]
+	loopStart := int(h.StartLoop) - int(h.Start)
+	loopLength := int(h.EndLoop) - int(h.StartLoop)
+	if loopStart < 0 || loopLength <= 0 || h.EndLoop > h.End {
+		loopStart, loopLength = int(h.End-h.Start)-1, 1
+	}
+	if loopStart < 0 {
+		loopStart = 0
+	}
+	if loopStart > math.MaxUint16 || loopLength > math.MaxUint16 {
+		return Entry{}, fmt.Errorf("the loop of sample %q (start %v, length %v) does not fit in 16 bits", h.Name, loopStart, loopLength)
+	}
+	pitch := int(h.OriginalPitch)
+	if pitch > 127 { // 255 means unpitched; the spec says to assume 60 for invalid values
+		pitch = 60
+	}
+	// the VM plays the samples as if they were 22050 Hz, like the ones of
+	// gm.dls, so e.g. 44100 Hz samples need to be transposed an octave up;
+	// the pitch correction is in cents
+	transpose := float64(60-pitch) + float64(h.PitchCorrection)/100
+	if h.SampleRate > 0 {
+		transpose += 12 * math.Log2(float64(h.SampleRate)/22050)
+	}
+	return Entry{
+		Name: h.Name,
+		SampleOffset: vm.SampleOffset{
+			Start:      uint32(sf.DataOffset/2) + h.Start,
+			LoopStart:  uint16(loopStart),
+			LoopLength: uint16(loopLength),
+		},
+		SuggestedTranspose: int(math.Round(transpose)),
+	}, nil
+}
+
+// Entries returns the entries of all the samples in the SoundFont that can be
+// mapped to SampleOffsets; see Entry.
+func (sf *SoundFont) Entries() []Entry {
+	ret := make([]Entry, 0, len(sf.Samples))
+	for i := range sf.Samples {
+		if e, err := sf.Entry(i); err == nil {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
+
+func zeroTerminated(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,89 @@
+package sf2_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/vsariola/sointu/riff/rifftest"
+	"github.com/vsariola/sointu/sf2"
+)
+
+func shdr(name string, start, end, startLoop, endLoop, sampleRate uint32, pitch uint8, correction int8) []byte {
+	var b bytes.Buffer
+	var n [20]byte
+	copy(n[:], name)
+	b.Write(n[:])
+	binary.Write(&b, binary.LittleEndian, []uint32{start, end, startLoop, endLoop, sampleRate})
+	b.Write([]byte{pitch, byte(correction), 0, 0, 1, 0})
+	return b.Bytes()
+}
+
+func syntheticSF2() []byte {
+	samples := make([]int16, 200)
+	for i := range samples {
+		samples[i] = int16(i * 10)
+	}
+	var smpl bytes.Buffer
+	binary.Write(&smpl, binary.LittleEndian, samples)
+	headers := append(shdr("Looped", 10, 100, 20, 80, 44100, 72, 0), shdr("Oneshot", 120, 150, 0, 0, 11025, 255, 40)...)
+	headers = append(headers, shdr("EOS", 0, 0, 0, 0, 0, 0, 0)...)
+	return rifftest.List("RIFF", "sfbk",
+		rifftest.List("LIST", "INFO", rifftest.Chunk("ifil", []byte{2, 0, 1, 0}), rifftest.Chunk("INAM", []byte("Test font\x00"))),
+		rifftest.List("LIST", "sdta", rifftest.Chunk("smpl", smpl.Bytes())),
+		rifftest.List("LIST", "pdta", rifftest.Chunk("shdr", headers)),
+	)
+}
+
+func TestParse(t *testing.T) {
+	sf, err := sf2.Parse(syntheticSF2())
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if sf.Name != "Test font" {
+		t.Errorf("expected name %q, got %q", "Test font", sf.Name)
+	}
+	if len(sf.Samples) != 2 {
+		t.Fatalf("expected 2 samples (EOS excluded), got %v", len(sf.Samples))
+	}
+	if sf.DataOffset%2 != 0 {
+		t.Fatalf("sample data should be at an even position, got %v", sf.DataOffset)
+	}
+	e, err := sf.Entry(0)
+	if err != nil {
+		t.Fatalf("entry failed: %v", err)
+	}
+	// 72 is an octave above 60, but a 44100 Hz sample is played an octave too
+	// low by the VM, which plays the samples as 22050 Hz
+	if e.Name != "Looped" || e.SampleOffset.LoopStart != 10 || e.SampleOffset.LoopLength != 60 || e.SuggestedTranspose != 0 {
+		t.Errorf("looped sample mapped incorrectly: %+v", e)
+	}
+	table, err := sf.SampleTable()
+	if err != nil {
+		t.Fatalf("sample table failed: %v", err)
+	}
+	if v := table.Sample(int(e.SampleOffset.Start)); v != 100 {
+		t.Errorf("entry start should point to the first sample of the sample (100), got %v", v)
+	}
+	e, err = sf.Entry(1)
+	if err != nil {
+		t.Fatalf("entry failed: %v", err)
+	}
+	// unpitched, so 60; the 11025 Hz sample is played an octave too high and
+	// the +40 cents correction is rounded away
+	if e.SampleOffset.LoopStart != 29 || e.SampleOffset.LoopLength != 1 || e.SuggestedTranspose != -12 {
+		t.Errorf("unlooped, unpitched sample mapped incorrectly: %+v", e)
+	}
+	if len(sf.Entries()) != 2 {
+		t.Errorf("expected 2 entries, got %v", len(sf.Entries()))
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := sf2.Parse(rifftest.List("RIFF", "DLS ")); err == nil {
+		t.Errorf("parsing a non-sf2 RIFF file should fail")
+	}
+	if _, err := sf2.Parse(rifftest.List("RIFF", "sfbk", rifftest.List("LIST", "sdta", rifftest.Chunk("smpl", make([]byte, 10))), rifftest.List("LIST", "pdta", rifftest.Chunk("shdr", append(shdr("Bad", 0, 100, 0, 0, 22050, 60, 0), shdr("EOS", 0, 0, 0, 0, 0, 0, 0)...))))); err == nil {
+		t.Errorf("parsing a sample extending beyond the sample data should fail")
+	}
+}
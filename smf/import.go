@@ -0,0 +1,436 @@
+package smf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/vsariola/sointu"
+)
+
+// Warning tells about something in a MIDI file that could not be imported
+// exactly, e.g. a note that was dropped or shifted to the nearest row.
+type Warning struct {
+	Track   int // the index of the track in the MIDI file
+	Tick    int // the time in the MIDI file, in ticks
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("track %v, tick %v: %v", w.Track, w.Tick, w.Message)
+}
+
+// midiEvent is a channel message of a MIDI file, at an absolute time in ticks.
+type midiEvent struct {
+	tick   int
+	status byte
+	data1  byte
+	data2  byte
+}
+
+// note is a note of a MIDI file, quantized to rows.
+type note struct {
+	tick       int
+	start, end int // rows
+	pitch      byte
+}
+
+// part is the notes and the control changes of one channel of one MIDI track,
+// which become the tracks of the score.
+type part struct {
+	track    int
+	channel  byte
+	notes    []note
+	controls []midiEvent
+}
+
+// Import reads a Standard MIDI File (type 0 or 1) into the song, replacing its
+// score and patch. The notes are quantized to the rows of the song, using its
+// RowsPerBeat and RowsPerPattern (16 if not set), and the BPM of the song is
+// taken from the first tempo of the file. Each channel of each MIDI track
+// becomes as many tracks as it plays notes at the same time, with
+// voicesPerTrack voices each, and gets a placeholder instrument with the voices
+// of its tracks. The control
+// changes of the given controllers become effect tracks, with the values as in
+// Export, each with its own placeholder instrument. The instruments are in the
+// order of the tracks, so that the voices of the tracks match the voices of
+// the instruments. The notes that had to be dropped or shifted to the nearest
+// row are returned as warnings.
+func Import(data []byte, song *sointu.Song, controllers []int) ([]Warning, error) {
+	if song.RowsPerBeat <= 0 {
+		return nil, fmt.Errorf("the song should have positive RowsPerBeat, got %v", song.RowsPerBeat)
+	}
+	rowsPerPattern := song.Score.RowsPerPattern
+	if rowsPerPattern <= 0 {
+		rowsPerPattern = 16
+	}
+	division, tracks, tempo, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []Warning
+	warn := func(track, tick int, format string, args ...interface{}) {
+		warnings = append(warnings, Warning{track, tick, fmt.Sprintf(format, args...)})
+	}
+	toRow := func(tick int) int {
+		return (tick*song.RowsPerBeat + division/2) / division
+	}
+	isController := map[int]bool{}
+	for _, c := range controllers {
+		isController[c] = true
+		if c < 32 {
+			isController[c+32] = true // the least significant bits
+		}
+	}
+	var parts []*part
+	numRows := 0
+	for trackIndex, events := range tracks {
+		channelParts := map[byte]*part{}
+		getPart := func(channel byte) *part {
+			if p, ok := channelParts[channel]; ok {
+				return p
+			}
+			p := &part{track: trackIndex, channel: channel}
+			channelParts[channel] = p
+			parts = append(parts, p)
+			return p
+		}
+		playing := map[[2]byte][]int{} // channel & pitch -> the ticks of the notes playing
+		end := func(key [2]byte, startTick, endTick int) {
+			p := getPart(key[0])
+			start, end := toRow(startTick), toRow(endTick)
+			if end <= start {
+				end = start + 1
+			}
+			if end > numRows {
+				numRows = end
+			}
+			p.notes = append(p.notes, note{tick: startTick, start: start, end: end, pitch: key[1]})
+		}
+		lastTick := 0
+		for _, e := range events {
+			lastTick = e.tick
+			channel := e.status & 0x0F
+			key := [2]byte{channel, e.data1}
+			switch e.status & 0xF0 {
+			case 0x90:
+				if e.data2 > 0 {
+					playing[key] = append(playing[key], e.tick)
+					continue
+				}
+				fallthrough // note-on with zero velocity is a note-off
+			case 0x80:
+				if ticks := playing[key]; len(ticks) > 0 {
+					end(key, ticks[0], e.tick)
+					playing[key] = ticks[1:]
+				}
+			case 0xB0:
+				if isController[int(e.data1)] {
+					getPart(channel).controls = append(getPart(channel).controls, e)
+					if r := toRow(e.tick) + 1; r > numRows {
+						numRows = r
+					}
+				}
+			}
+		}
+		var keys [][2]byte
+		for key, ticks := range playing {
+			if len(ticks) > 0 {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+		})
+		for _, key := range keys {
+			for _, t := range playing[key] {
+				warn(trackIndex, t, "note %v was not released; it ends at the end of the track", key[1])
+				end(key, t, lastTick)
+			}
+		}
+	}
+	sort.SliceStable(parts, func(i, j int) bool {
+		return parts[i].track < parts[j].track || (parts[i].track == parts[j].track && parts[i].channel < parts[j].channel)
+	})
+	numRows = (numRows + rowsPerPattern - 1) / rowsPerPattern * rowsPerPattern
+	if numRows == 0 {
+		numRows = rowsPerPattern
+	}
+	score := sointu.Score{RowsPerPattern: rowsPerPattern, Length: numRows / rowsPerPattern}
+	var patch sointu.Patch
+	for _, p := range parts {
+		lanes := noteLanes(p, song.RowsPerBeat, division, numRows, warn)
+		if len(lanes) > 0 {
+			patch = append(patch, placeholder(fmt.Sprintf("Track %v channel %v", p.track, p.channel+1), len(lanes)*voicesPerTrack))
+		}
+		for _, lane := range lanes {
+			score.Tracks = append(score.Tracks, splitPatterns(lane, rowsPerPattern, voicesPerTrack, false))
+		}
+		for _, c := range controllers {
+			if lane := controlLane(p, c, toRow, numRows, warn); lane != nil {
+				patch = append(patch, sointu.Instrument{Name: fmt.Sprintf("Track %v channel %v CC %v", p.track, p.channel+1, c), NumVoices: 1, Units: []sointu.Unit{
+					{Type: "loadnote", Parameters: map[string]int{"stereo": 0}},
+					{Type: "pop", Parameters: map[string]int{"stereo": 0}},
+				}})
+				score.Tracks = append(score.Tracks, splitPatterns(lane, rowsPerPattern, 1, true))
+			}
+		}
+	}
+	song.Score = score
+	song.Patch = patch
+	if tempo > 0 {
+		song.BPM = (60000000 + tempo/2) / tempo
+	}
+	return warnings, nil
+}
+
+// noteLanes divides the notes of a part into lanes of non-overlapping notes,
+// returned as the notes of each row.
+func noteLanes(p *part, rowsPerBeat, division, numRows int, warn func(int, int, string, ...interface{})) [][]byte {
+	sort.SliceStable(p.notes, func(i, j int) bool {
+		a, b := p.notes[i], p.notes[j]
+		return a.start < b.start || (a.start == b.start && a.pitch < b.pitch)
+	})
+	var lanes [][]byte
+	var free []int // the row after the last note of each lane
+	for i, n := range p.notes {
+		if i > 0 && p.notes[i-1].start == n.start && p.notes[i-1].pitch == n.pitch {
+			warn(p.track, n.tick, "note %v on channel %v is already playing at row %v; dropped", n.pitch, p.channel, n.start)
+			continue
+		}
+		if n.pitch < 2 {
+			warn(p.track, n.tick, "note %v cannot be played by Sointu, as 0 and 1 mean release and hold; dropped", n.pitch)
+			continue
+		}
+		if n.tick*rowsPerBeat%division != 0 {
+			warn(p.track, n.tick, "note %v on channel %v shifted to row %v", n.pitch, p.channel, n.start)
+		}
+		l := 0
+		for l < len(lanes) && free[l] > n.start {
+			l++
+		}
+		if l == len(lanes) {
+			lane := make([]byte, numRows)
+			for r := range lane {
+				lane[r] = 1
+			}
+			lanes = append(lanes, lane)
+			free = append(free, 0)
+		}
+		lanes[l][n.start] = n.pitch
+		if n.end < numRows {
+			lanes[l][n.end] = 0
+		}
+		free[l] = n.end
+	}
+	return lanes
+}
+
+// controlLane returns the values of a controller of a part as an effect track,
+// or nil if the part has no changes of the controller. The values are 8-bit, as
+// in Export: the controller has the upper 7 bits and, for controllers below 32,
+// controller+32 the lowest bit.
+func controlLane(p *part, controller int, toRow func(int) int, numRows int, warn func(int, int, string, ...interface{})) []byte {
+	lane := make([]byte, numRows)
+	for r := range lane {
+		lane[r] = 1
+	}
+	found := false
+	var msb, lsb byte
+	for _, e := range p.controls {
+		switch {
+		case int(e.data1) == controller:
+			msb, lsb = e.data2, 0 // a new MSB resets the LSB
+		case controller < 32 && int(e.data1) == controller+32:
+			lsb = e.data2
+		default:
+			continue
+		}
+		found = true
+		value := msb<<1 | lsb>>6
+		if value == 1 {
+			warn(p.track, e.tick, "value 1 of controller %v means hold in an effect track; changed to 0", controller)
+			value = 0
+		}
+		lane[toRow(e.tick)] = value
+	}
+	if !found {
+		return nil
+	}
+	value := byte(0)
+	for r, v := range lane {
+		if v == 1 {
+			continue
+		}
+		if v == value {
+			lane[r] = 1 // no change
+		}
+		value = v
+	}
+	return lane
+}
+
+// voicesPerTrack is the number of voices of the imported note tracks. The
+// notes of a track do not overlap, but the tracks take turns in using their
+// voices, so that the release of a note is not cut by the next note.
+const voicesPerTrack = 2
+
+// placeholder returns a simple synth instrument with the given number of
+// voices, to play the notes of a channel until it is replaced with a real one.
+func placeholder(name string, numVoices int) sointu.Instrument {
+	return sointu.Instrument{Name: name, NumVoices: numVoices, Units: []sointu.Unit{
+		{Type: "envelope", Parameters: map[string]int{"stereo": 0, "attack": 32, "decay": 64, "sustain": 64, "release": 64, "gain": 64}},
+		{Type: "oscillator", Parameters: map[string]int{"stereo": 0, "transpose": 64, "detune": 64, "phase": 0, "color": 64, "shape": 64, "gain": 64, "type": sointu.Trisaw}},
+		{Type: "mulp", Parameters: map[string]int{"stereo": 0}},
+		{Type: "pan", Parameters: map[string]int{"stereo": 0, "panning": 64}},
+		{Type: "outaux", Parameters: map[string]int{"stereo": 1, "outgain": 64, "auxgain": 0}},
+	}}
+}
+
+// splitPatterns splits the notes of a track into patterns, reusing identical
+// patterns.
+func splitPatterns(lane []byte, rowsPerPattern, numVoices int, effect bool) sointu.Track {
+	track := sointu.Track{NumVoices: numVoices, Effect: effect}
+	for i := 0; i < len(lane); i += rowsPerPattern {
+		pat := sointu.Pattern(lane[i : i+rowsPerPattern])
+		index := -1
+		for j, p := range track.Patterns {
+			if string(p) == string(pat) {
+				index = j
+				break
+			}
+		}
+		if index == -1 {
+			index = len(track.Patterns)
+			track.Patterns = append(track.Patterns, append(sointu.Pattern{}, pat...))
+		}
+		track.Order = append(track.Order, index)
+	}
+	return track
+}
+
+// parse reads the division (ticks per quarter note), the channel messages of
+// each track and the first tempo (microseconds per quarter note, 0 if none) of
+// a Standard MIDI File.
+func parse(data []byte) (division int, tracks [][]midiEvent, tempo int, err error) {
+	if len(data) < 14 || string(data[:4]) != "MThd" {
+		return 0, nil, 0, errors.New("not a Standard MIDI File")
+	}
+	headerLength := int(binary.BigEndian.Uint32(data[4:8]))
+	if headerLength < 6 || 8+headerLength > len(data) {
+		return 0, nil, 0, errors.New("invalid MIDI file header")
+	}
+	format := binary.BigEndian.Uint16(data[8:10])
+	if format > 1 {
+		return 0, nil, 0, fmt.Errorf("MIDI files of type %v are not supported, only types 0 and 1", format)
+	}
+	division = int(binary.BigEndian.Uint16(data[12:14]))
+	if division&0x8000 != 0 || division == 0 {
+		return 0, nil, 0, errors.New("MIDI files with SMPTE time division are not supported")
+	}
+	data = data[8+headerLength:]
+	for len(data) >= 8 {
+		length := int(binary.BigEndian.Uint32(data[4:8]))
+		if 8+length > len(data) {
+			return 0, nil, 0, errors.New("truncated MIDI file")
+		}
+		chunk := data[8 : 8+length]
+		isTrack := string(data[:4]) == "MTrk"
+		data = data[8+length:]
+		if !isTrack {
+			continue // unknown chunks should be ignored
+		}
+		events, t, err := parseTrack(chunk)
+		if err != nil {
+			return 0, nil, 0, fmt.Errorf("track %v: %v", len(tracks), err)
+		}
+		if tempo == 0 {
+			tempo = t
+		}
+		tracks = append(tracks, events)
+	}
+	return division, tracks, tempo, nil
+}
+
+func parseTrack(data []byte) (events []midiEvent, tempo int, err error) {
+	pos, tick := 0, 0
+	var status byte
+	readVarLen := func() (int, error) {
+		value := 0
+		for i := 0; i < 4; i++ {
+			if pos >= len(data) {
+				return 0, errors.New("truncated variable-length quantity")
+			}
+			b := data[pos]
+			pos++
+			value = value<<7 | int(b&0x7F)
+			if b&0x80 == 0 {
+				return value, nil
+			}
+		}
+		return 0, errors.New("too long variable-length quantity")
+	}
+	for pos < len(data) {
+		delta, err := readVarLen()
+		if err != nil {
+			return nil, 0, err
+		}
+		tick += delta
+		if pos >= len(data) {
+			return nil, 0, errors.New("truncated event")
+		}
+		b := data[pos]
+		switch {
+		case b == 0xFF: // meta event
+			if pos+2 > len(data) {
+				return nil, 0, errors.New("truncated meta event")
+			}
+			metaType := data[pos+1]
+			pos += 2
+			length, err := readVarLen()
+			if err != nil {
+				return nil, 0, err
+			}
+			if pos+length > len(data) {
+				return nil, 0, errors.New("truncated meta event")
+			}
+			if metaType == 0x51 && length == 3 && tempo == 0 {
+				tempo = int(data[pos])<<16 | int(data[pos+1])<<8 | int(data[pos+2])
+			}
+			pos += length
+			if metaType == 0x2F {
+				return events, tempo, nil
+			}
+		case b == 0xF0 || b == 0xF7: // sysex
+			pos++
+			length, err := readVarLen()
+			if err != nil {
+				return nil, 0, err
+			}
+			pos += length
+		default:
+			if b&0x80 != 0 {
+				status = b
+				pos++
+			} else if status == 0 {
+				return nil, 0, errors.New("running status without a previous status")
+			}
+			e := midiEvent{tick: tick, status: status}
+			numData := 2
+			if status&0xF0 == 0xC0 || status&0xF0 == 0xD0 {
+				numData = 1
+			}
+			if pos+numData > len(data) {
+				return nil, 0, errors.New("truncated channel message")
+			}
+			e.data1 = data[pos]
+			if numData == 2 {
+				e.data2 = data[pos+1]
+			}
+			pos += numData
+			events = append(events, e)
+		}
+	}
+	return events, tempo, nil
+}
@@ -0,0 +1,169 @@
+// Package smf converts the scores of Sointu songs to Standard MIDI Files and
+// back, e.g. for moving the arrangement of a song into a DAW or sketching the
+// melodies in a DAW.
+//
+// Each track of the score becomes a MIDI track of a type 1 file, on channel
+// (track index mod 16). Note tracks become notes, which last until the next
+// note or release of the track, just like the voices triggered by the track
+// are released. Effect tracks become 14-bit control change lanes: the upper 7
+// bits of the value are sent with EffectController and the lowest bit as the
+// most significant bit of EffectController+32.
+package smf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/vsariola/sointu"
+)
+
+const (
+	// TicksPerRow is the resolution of the exported files: the MIDI ticks per
+	// quarter note are TicksPerRow * RowsPerBeat.
+	TicksPerRow = 24
+	// EffectController is the controller number of the control changes of the
+	// effect tracks (General Purpose Controller 1 by default).
+	EffectController = 16
+	// Velocity is the velocity of the exported notes.
+	Velocity = 100
+)
+
+// event is a MIDI event in a track, at an absolute time in ticks.
+type event struct {
+	tick int
+	data []byte
+}
+
+// Export converts the score of a song into a type 1 Standard MIDI File. The
+// first MIDI track has the tempo of the song and each track of the score is
+// exported as its own MIDI track.
+func Export(song *sointu.Song) ([]byte, error) {
+	if song.BPM <= 0 || song.RowsPerBeat <= 0 {
+		return nil, fmt.Errorf("the song should have positive BPM and RowsPerBeat, got %v and %v", song.BPM, song.RowsPerBeat)
+	}
+	division := TicksPerRow * song.RowsPerBeat
+	if division > 0x7FFF {
+		return nil, fmt.Errorf("too many rows per beat for a MIDI file: %v", song.RowsPerBeat)
+	}
+	if song.BPM < 4 {
+		return nil, fmt.Errorf("too slow tempo for a MIDI file: %v BPM", song.BPM)
+	}
+	if len(song.Score.Tracks) > 0xFFFE {
+		return nil, fmt.Errorf("too many tracks for a MIDI file: %v", len(song.Score.Tracks))
+	}
+	var buf bytes.Buffer
+	buf.WriteString("MThd")
+	binary.Write(&buf, binary.BigEndian, uint32(6))
+	binary.Write(&buf, binary.BigEndian, []uint16{1, uint16(len(song.Score.Tracks) + 1), uint16(division)}) // format, number of tracks, division
+	// the tempo is given in microseconds per quarter note, in three bytes
+	tempo := 60000000 / song.BPM
+	writeTrack(&buf, []event{
+		{0, metaEvent(0x03, []byte("Sointu"))},
+		{0, metaEvent(0x51, []byte{byte(tempo >> 16), byte(tempo >> 8), byte(tempo)})},
+		{0, metaEvent(0x58, []byte{4, 2, 24, 8})}, // 4/4
+	}, song.Score.LengthInRows()*TicksPerRow)
+	for i := range song.Score.Tracks {
+		events, err := trackEvents(song, i)
+		if err != nil {
+			return nil, fmt.Errorf("track %v: %v", i, err)
+		}
+		writeTrack(&buf, events, song.Score.LengthInRows()*TicksPerRow)
+	}
+	return buf.Bytes(), nil
+}
+
+// trackEvents converts the notes of a track into MIDI events.
+func trackEvents(song *sointu.Song, trackIndex int) ([]event, error) {
+	t := song.Score.Tracks[trackIndex]
+	channel := byte(trackIndex % 16)
+	name := fmt.Sprintf("Track %v", trackIndex)
+	if instr, err := song.Patch.InstrumentForVoice(song.Score.FirstVoiceForTrack(trackIndex)); err == nil && song.Patch[instr].Name != "" {
+		name = song.Patch[instr].Name
+	}
+	events := []event{{0, metaEvent(0x03, []byte(name))}}
+	playing := -1 // the note playing, -1 if none; for effect tracks, the value of the track
+	if t.Effect {
+		playing = 0
+	}
+	row := 0
+	for i := 0; i < song.Score.Length; i++ {
+		patIndex := t.Order.Get(i)
+		var pattern sointu.Pattern
+		if patIndex >= 0 && patIndex < len(t.Patterns) {
+			pattern = t.Patterns[patIndex]
+		}
+		for j := 0; j < song.Score.RowsPerPattern; j++ {
+			note := int(pattern.Get(j))
+			tick := row * TicksPerRow
+			row++
+			if note == 1 {
+				continue // hold
+			}
+			if t.Effect {
+				if note != playing {
+					events = append(events,
+						event{tick, []byte{0xB0 | channel, EffectController, byte(note >> 1)}},
+						event{tick, []byte{0xB0 | channel, EffectController + 32, byte(note&1) << 6}})
+					playing = note
+				}
+				continue
+			}
+			if playing >= 0 {
+				events = append(events, event{tick, []byte{0x80 | channel, byte(playing), 0}})
+				playing = -1
+			}
+			if note == 0 {
+				continue // release
+			}
+			if note > 127 {
+				return nil, fmt.Errorf("note %v at row %v does not fit in a MIDI file", note, row-1)
+			}
+			events = append(events, event{tick, []byte{0x90 | channel, byte(note), Velocity}})
+			playing = note
+		}
+	}
+	if !t.Effect && playing >= 0 {
+		events = append(events, event{row * TicksPerRow, []byte{0x80 | channel, byte(playing), 0}})
+	}
+	return events, nil
+}
+
+func metaEvent(metaType byte, data []byte) []byte {
+	ret := []byte{0xFF, metaType}
+	ret = appendVarLen(ret, len(data))
+	return append(ret, data...)
+}
+
+// writeTrack writes an MTrk chunk with the events, which should be in the order
+// of their ticks, and the end of track at endTick.
+func writeTrack(buf *bytes.Buffer, events []event, endTick int) {
+	var data []byte
+	tick := 0
+	for _, e := range events {
+		data = appendVarLen(data, e.tick-tick)
+		data = append(data, e.data...)
+		tick = e.tick
+	}
+	if endTick < tick {
+		endTick = tick
+	}
+	data = appendVarLen(data, endTick-tick)
+	data = append(data, metaEvent(0x2F, nil)...)
+	buf.WriteString("MTrk")
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+// appendVarLen appends a variable-length quantity: 7 bits per byte, most
+// significant first, with the high bit set on all but the last byte.
+func appendVarLen(data []byte, value int) []byte {
+	var tmp [5]byte
+	i := len(tmp) - 1
+	tmp[i] = byte(value & 0x7F)
+	for value >>= 7; value > 0; value >>= 7 {
+		i--
+		tmp[i] = byte(value&0x7F) | 0x80
+	}
+	return append(data, tmp[i:]...)
+}
@@ -0,0 +1,194 @@
+package smf_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/smf"
+)
+
+// chunks splits a MIDI file into the contents of its chunks.
+func chunks(t *testing.T, data []byte) (ids []string, contents [][]byte) {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatalf("truncated chunk header")
+		}
+		length := int(binary.BigEndian.Uint32(data[4:8]))
+		if len(data) < 8+length {
+			t.Fatalf("truncated chunk %v", string(data[:4]))
+		}
+		ids = append(ids, string(data[:4]))
+		contents = append(contents, data[8:8+length])
+		data = data[8+length:]
+	}
+	return
+}
+
+func TestExport(t *testing.T) {
+	song := sointu.Song{BPM: 125, RowsPerBeat: 4, Score: sointu.Score{
+		RowsPerPattern: 4,
+		Length:         2,
+		Tracks: []sointu.Track{{
+			NumVoices: 2,
+			Order:     sointu.Order{0, 1},
+			Patterns:  []sointu.Pattern{{64, 1, 66, 0}, {1, 68}},
+		}, {
+			NumVoices: 1,
+			Effect:    true,
+			Order:     sointu.Order{0, 0},
+			Patterns:  []sointu.Pattern{{1, 255, 1, 0}},
+		}},
+	}, Patch: sointu.Patch{
+		sointu.Instrument{Name: "Lead", NumVoices: 2},
+		sointu.Instrument{NumVoices: 1},
+	}}
+	data, err := smf.Export(&song)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	ids, contents := chunks(t, data)
+	if !reflect.DeepEqual(ids, []string{"MThd", "MTrk", "MTrk", "MTrk"}) {
+		t.Fatalf("expected a header and three tracks, got %v", ids)
+	}
+	if expected := []byte{0, 1, 0, 3, 0, 96}; !bytes.Equal(contents[0], expected) {
+		t.Errorf("wrong header: got %v, expected %v", contents[0], expected)
+	}
+	if !bytes.Contains(contents[1], []byte{0xFF, 0x51, 3, 0x07, 0x53, 0x00}) { // 480000 microseconds per beat
+		t.Errorf("the tempo track does not set the tempo of 125 BPM: %v", contents[1])
+	}
+	notes := append([]byte{0, 0xFF, 0x03, 4}, "Lead"...)
+	notes = append(notes,
+		0, 0x90, 64, smf.Velocity,
+		48, 0x80, 64, 0, // the next note releases the previous
+		0, 0x90, 66, smf.Velocity,
+		24, 0x80, 66, 0, // explicit release
+		48, 0x90, 68, smf.Velocity, // the hold at the start of the pattern is not a note
+		72, 0x80, 68, 0, // the note is released at the end of the song
+		0, 0xFF, 0x2F, 0,
+	)
+	if !bytes.Equal(contents[2], notes) {
+		t.Errorf("wrong note track: got %v, expected %v", contents[2], notes)
+	}
+	effects := append([]byte{0, 0xFF, 0x03, 7}, "Track 1"...)
+	effects = append(effects,
+		24, 0xB1, smf.EffectController, 127,
+		0, 0xB1, smf.EffectController+32, 64,
+		48, 0xB1, smf.EffectController, 0,
+		0, 0xB1, smf.EffectController+32, 0,
+		48, 0xB1, smf.EffectController, 127, // the value changes again in the second pattern
+		0, 0xB1, smf.EffectController+32, 64,
+		48, 0xB1, smf.EffectController, 0,
+		0, 0xB1, smf.EffectController+32, 0,
+		24, 0xFF, 0x2F, 0,
+	)
+	if !bytes.Equal(contents[3], effects) {
+		t.Errorf("wrong effect track: got %v, expected %v", contents[3], effects)
+	}
+}
+
+func TestExportLongDeltas(t *testing.T) {
+	song := sointu.Song{BPM: 100, RowsPerBeat: 4, Score: sointu.Score{
+		RowsPerPattern: 64,
+		Length:         1,
+		Tracks:         []sointu.Track{{NumVoices: 1, Order: sointu.Order{0}, Patterns: []sointu.Pattern{{64}}}},
+	}}
+	data, err := smf.Export(&song)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	_, contents := chunks(t, data)
+	// 64 rows * 24 ticks = 1536 ticks = 0x600, i.e. 0x8C 0x00 as a variable-length quantity
+	if !bytes.Contains(contents[2], []byte{0x8C, 0x00, 0x80, 64, 0}) {
+		t.Errorf("the note-off at the end of the song is not 1536 ticks after the note-on: %v", contents[2])
+	}
+}
+
+func TestImportExported(t *testing.T) {
+	song := sointu.Song{BPM: 125, RowsPerBeat: 4, Score: sointu.Score{
+		RowsPerPattern: 4,
+		Length:         2,
+		Tracks: []sointu.Track{{
+			NumVoices: 2, // the note tracks are imported with 2 voices
+			Order:     sointu.Order{0, 1},
+			Patterns:  []sointu.Pattern{{64, 1, 66, 0}, {1, 68, 1, 1}},
+		}, {
+			NumVoices: 1,
+			Effect:    true,
+			Order:     sointu.Order{0, 0},
+			Patterns:  []sointu.Pattern{{1, 255, 1, 0}},
+		}},
+	}}
+	data, err := smf.Export(&song)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	imported := sointu.Song{BPM: 100, RowsPerBeat: 4, Score: sointu.Score{RowsPerPattern: 4}}
+	warnings, err := smf.Import(data, &imported, []int{smf.EffectController})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if len(warnings) > 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+	if !reflect.DeepEqual(imported.Score, song.Score) || imported.BPM != song.BPM {
+		t.Fatalf("the imported song differs from the exported one. got: %v expected: %v", imported, song)
+	}
+	if err := imported.Validate(); err != nil {
+		t.Errorf("the imported song is not valid: %v", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	// format 0, one track, 96 ticks per quarter note i.e. 24 ticks per row
+	track := []byte{
+		0, 0x90, 60, 100, // C-E-G chord at row 0
+		0, 0x90, 64, 100,
+		0, 0x90, 67, 100,
+		96, 0x80, 60, 0, // running status is not used here, but below
+		0, 64, 0,
+		0, 67, 0,
+		4, 0x90, 72, 100, // off the grid: tick 100 is shifted to row 4
+		0, 72, 100, // the same note again at the same row is dropped
+		44, 72, 0, // velocity 0 means note off
+		0, 72, 0,
+		0, 0x90, 1, 100, // note 1 means hold in Sointu
+		24, 1, 0,
+		0, 0xB1, 7, 64, // controller 7 on channel 1
+		0, 0xFF, 0x2F, 0,
+	}
+	var data []byte
+	data = append(data, "MThd"...)
+	data = append(data, 0, 0, 0, 6, 0, 0, 0, 1, 0, 96)
+	data = append(data, "MTrk"...)
+	data = append(data, 0, 0, 0, byte(len(track)))
+	data = append(data, track...)
+	song := sointu.Song{BPM: 100, RowsPerBeat: 4, Score: sointu.Score{RowsPerPattern: 4}}
+	warnings, err := smf.Import(data, &song, []int{7})
+	if err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	expected := sointu.Score{RowsPerPattern: 4, Length: 2, Tracks: []sointu.Track{
+		{NumVoices: 2, Order: sointu.Order{0, 1}, Patterns: []sointu.Pattern{{60, 1, 1, 1}, {72, 1, 0, 1}}}, // the C is released when 72 starts
+		{NumVoices: 2, Order: sointu.Order{0, 1}, Patterns: []sointu.Pattern{{64, 1, 1, 1}, {0, 1, 1, 1}}},
+		{NumVoices: 2, Order: sointu.Order{0, 1}, Patterns: []sointu.Pattern{{67, 1, 1, 1}, {0, 1, 1, 1}}},
+		{NumVoices: 1, Effect: true, Order: sointu.Order{0, 1}, Patterns: []sointu.Pattern{{1, 1, 1, 1}, {1, 1, 1, 128}}}, // channel 1 is a part of its own
+	}}
+	if !reflect.DeepEqual(song.Score, expected) {
+		t.Errorf("got different score than expected. got: %v expected: %v", song.Score, expected)
+	}
+	if err := song.Validate(); err != nil {
+		t.Errorf("the imported song is not valid: %v", err)
+	}
+	if len(song.Patch) != 2 || song.Patch[0].NumVoices != 6 || song.Patch[1].NumVoices != 1 {
+		t.Errorf("expected an instrument with 2 voices for each of the 3 tracks of the chord and one with 1 voice for the controller, got %v", song.Patch)
+	}
+	if song.BPM != 100 {
+		t.Errorf("the BPM should not change when the file has no tempo, got %v", song.BPM)
+	}
+	if len(warnings) != 3 {
+		t.Errorf("expected warnings about the shifted, the duplicate and the hold note, got %v", warnings)
+	}
+}
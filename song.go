@@ -2,6 +2,7 @@ package sointu
 
 import (
 	"errors"
+	"strings"
 )
 
 // Song includes a Score(the arrangement of notes in the song in one or more
@@ -9,17 +10,53 @@ import (
 // and RowsPerBeat fields set how fast the song should be played. Currently, BPM
 // is an integer as it offers already quite much granularity for controlling the
 // playback speed, but this could be changed to a floating point in future if
-// finer adjustments are necessary.
+// finer adjustments are necessary. Version is the version of the file format
+// the song was loaded from; see LoadSong and SaveSong. The optional Metadata
+// tells e.g. the title and the author of the song.
 type Song struct {
+	Version     int
+	Metadata    `yaml:",inline"`
 	BPM         int
 	RowsPerBeat int
 	Score       Score
 	Patch       Patch
 }
 
+// Metadata is the optional information about a song, written e.g. into the
+// exported WAV files and the compiled players. Comment can span several lines.
+type Metadata struct {
+	Title   string `yaml:",omitempty" json:",omitempty"`
+	Author  string `yaml:",omitempty" json:",omitempty"`
+	Comment string `yaml:",omitempty" json:",omitempty"`
+	License string `yaml:",omitempty" json:",omitempty"`
+}
+
 // Copy makes a deep copy of a Score.
 func (s *Song) Copy() Song {
-	return Song{BPM: s.BPM, RowsPerBeat: s.RowsPerBeat, Score: s.Score.Copy(), Patch: s.Patch.Copy()}
+	return Song{Version: s.Version, Metadata: s.Metadata, BPM: s.BPM, RowsPerBeat: s.RowsPerBeat, Score: s.Score.Copy(), Patch: s.Patch.Copy()}
+}
+
+// Lines returns the metadata that is set as "Title: ..." lines, e.g. for
+// printing it or for writing it into comments. The lines of a multi-line
+// Comment after the first one are indented.
+func (m Metadata) Lines() []string {
+	var ret []string
+	for _, field := range []struct{ name, value string }{
+		{"Title", m.Title}, {"Author", m.Author}, {"Comment", m.Comment}, {"License", m.License},
+	} {
+		value := strings.TrimSpace(strings.ReplaceAll(field.value, "\r\n", "\n"))
+		if value == "" {
+			continue
+		}
+		for i, line := range strings.Split(value, "\n") {
+			if i == 0 {
+				ret = append(ret, field.name+": "+line)
+			} else {
+				ret = append(ret, strings.TrimRight("    "+line, " \t"))
+			}
+		}
+	}
+	return ret
 }
 
 // Assuming 44100 Hz playback speed, return the number of samples of each row of
@@ -29,8 +66,9 @@ func (s *Song) SamplesPerRow() int {
 }
 
 // Validate checks if the Song looks like a valid song: BPM > 0, one or more
-// tracks, score uses less than or equal number of voices than patch. Not used
-// much so we could probably get rid of this function.
+// tracks, score uses less than or equal number of voices than patch. This is
+// only a quick sanity check done before playing; vm.Validate reports all the
+// problems of a song as diagnostics.
 func (s *Song) Validate() error {
 	if s.BPM < 1 {
 		return errors.New("BPM should be > 0")
@@ -0,0 +1,218 @@
+package sointu
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SongFormat is the format of a song file: YAML (.yml) or JSON (.json).
+type SongFormat int
+
+const (
+	YAMLFormat SongFormat = iota
+	JSONFormat
+)
+
+// songMigration migrates a song file from one version to the next. The
+// migrations work on the decoded document, with all the keys in lowercase, so
+// that also the fields that were renamed or removed from the structs can be
+// migrated. Note that in JSON documents, the patterns are base64 strings.
+type songMigration struct {
+	// renames are the unit parameters that were renamed in the next version:
+	// unit type -> old name -> new name
+	renames map[string]map[string]string
+	migrate func(doc map[string]interface{})
+}
+
+// songMigrations[i] migrates a song file from version i to version i+1. The
+// files without a version are version 0.
+var songMigrations = []songMigration{
+	{migrate: migrateSong0},
+	{}, // version 2 added the optional metadata; nothing to migrate
+}
+
+// SongVersion is the version of the song files written by SaveSong. Files of
+// older versions are migrated by LoadSong.
+var SongVersion = len(songMigrations)
+
+// LoadSong reads a song file, detecting whether it is JSON or YAML, and
+// migrates it from older versions of the file format. Unknown fields are
+// errors, as they are probably typos or fields of a newer version.
+func LoadSong(r io.Reader) (Song, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return Song{}, fmt.Errorf("could not read the song: %v", err)
+	}
+	format := DetectSongFormat(data)
+	var doc map[string]interface{}
+	if err := unmarshal(data, &doc, format, false); err != nil {
+		return Song{}, fmt.Errorf("could not parse the song: %v", err)
+	}
+	doc, _ = lowercaseKeys(doc).(map[string]interface{})
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	version := 0
+	if v, ok := doc["version"]; ok {
+		var isInt bool
+		if version, isInt = intValue(v); !isInt || version < 0 {
+			return Song{}, fmt.Errorf("invalid song file version %v; the version should be a non-negative integer", v)
+		}
+	}
+	if version > SongVersion {
+		return Song{}, fmt.Errorf("the song file is version %v, but only versions up to %v are supported; update Sointu", version, SongVersion)
+	}
+	if version < SongVersion {
+		for _, m := range songMigrations[version:] {
+			if m.migrate != nil {
+				m.migrate(doc)
+			}
+			renameParameters(doc, m.renames)
+		}
+		doc["version"] = SongVersion
+		if data, err = marshal(doc, format); err != nil {
+			return Song{}, fmt.Errorf("could not migrate the song: %v", err)
+		}
+	}
+	var song Song
+	if err := unmarshal(data, &song, format, true); err != nil {
+		return Song{}, fmt.Errorf("could not parse the song: %v", err)
+	}
+	return song, nil
+}
+
+// SaveSong writes a song file of the current SongVersion.
+func SaveSong(w io.Writer, song Song, format SongFormat) error {
+	song.Version = SongVersion
+	data, err := marshal(song, format)
+	if err != nil {
+		return fmt.Errorf("could not marshal the song: %v", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// DetectSongFormat guesses the format of a song file from its contents: JSON
+// files start with a '{', everything else is considered YAML.
+func DetectSongFormat(data []byte) SongFormat {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return JSONFormat
+	}
+	return YAMLFormat
+}
+
+func unmarshal(data []byte, v interface{}, format SongFormat, strict bool) error {
+	if format == JSONFormat {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if strict {
+			dec.DisallowUnknownFields()
+		}
+		return dec.Decode(v)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(strict)
+	if err := dec.Decode(v); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+func marshal(v interface{}, format SongFormat) ([]byte, error) {
+	if format == JSONFormat {
+		return json.Marshal(v)
+	}
+	return yaml.Marshal(v)
+}
+
+// lowercaseKeys converts the keys of all the maps in a decoded document into
+// lowercase, as the JSON field names are matched case-insensitively.
+func lowercaseKeys(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		ret := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			ret[strings.ToLower(k)] = lowercaseKeys(e)
+		}
+		return ret
+	case []interface{}:
+		for i, e := range x {
+			x[i] = lowercaseKeys(e)
+		}
+	}
+	return v
+}
+
+// renameParameters renames the unit parameters of all the instruments of a
+// song document.
+func renameParameters(doc map[string]interface{}, renames map[string]map[string]string) {
+	if len(renames) == 0 {
+		return
+	}
+	patch, _ := doc["patch"].([]interface{})
+	for _, instr := range patch {
+		instr, _ := instr.(map[string]interface{})
+		units, _ := instr["units"].([]interface{})
+		for _, unit := range units {
+			unit, _ := unit.(map[string]interface{})
+			t, _ := unit["type"].(string)
+			params, _ := unit["parameters"].(map[string]interface{})
+			for oldName, newName := range renames[t] {
+				if v, ok := params[oldName]; ok {
+					delete(params, oldName)
+					params[newName] = v
+				}
+			}
+		}
+	}
+}
+
+// migrateSong0 fills in the fields that were optional before the files had
+// versions: RowsPerBeat defaults to 4 and the Length of the score to the
+// length of the longest order list.
+func migrateSong0(doc map[string]interface{}) {
+	if toInt(doc["rowsperbeat"]) <= 0 {
+		doc["rowsperbeat"] = 4
+	}
+	score, _ := doc["score"].(map[string]interface{})
+	if score == nil || toInt(score["length"]) > 0 {
+		return
+	}
+	length := 0
+	tracks, _ := score["tracks"].([]interface{})
+	for _, t := range tracks {
+		t, _ := t.(map[string]interface{})
+		if order, _ := t["order"].([]interface{}); len(order) > length {
+			length = len(order)
+		}
+	}
+	score["length"] = length
+}
+
+// toInt converts a number of a decoded document to an int; 0 if it is not an
+// integer.
+func toInt(v interface{}) int {
+	i, _ := intValue(v)
+	return i
+}
+
+// intValue converts a number of a decoded document to an int, reporting
+// whether it was an integer: YAML decodes integers as ints, but JSON decodes
+// all numbers as float64s.
+func intValue(v interface{}) (int, bool) {
+	switch x := v.(type) {
+	case int:
+		return x, true
+	case float64:
+		if x == math.Trunc(x) && math.Abs(x) < 1<<31 {
+			return int(x), true
+		}
+	}
+	return 0, false
+}
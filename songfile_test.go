@@ -0,0 +1,146 @@
+package sointu
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sameSong compares songs as marshaled, as e.g. nil and empty Parameters are
+// marshaled the same.
+func sameSong(a, b Song) bool {
+	x, errA := yaml.Marshal(a)
+	y, errB := yaml.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(x, y)
+}
+
+func TestLoadSongRegressionTests(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("tests", "*.yml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("cannot glob the test songs: %v", err)
+	}
+	for _, filename := range files {
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("cannot read the song: %v", err)
+			}
+			song, err := LoadSong(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("LoadSong failed: %v", err)
+			}
+			var expected Song
+			if err := yaml.Unmarshal(data, &expected); err != nil {
+				t.Fatalf("cannot unmarshal the song: %v", err)
+			}
+			expected.Version = SongVersion
+			if !sameSong(song, expected) {
+				t.Fatalf("the loaded song differs from the file")
+			}
+			for _, format := range []SongFormat{YAMLFormat, JSONFormat} {
+				var buf bytes.Buffer
+				if err := SaveSong(&buf, song, format); err != nil {
+					t.Fatalf("SaveSong failed: %v", err)
+				}
+				if DetectSongFormat(buf.Bytes()) != format {
+					t.Fatalf("the format of the saved song was not detected as %v", format)
+				}
+				saved, err := LoadSong(&buf)
+				if err != nil {
+					t.Fatalf("LoadSong failed for the saved song: %v", err)
+				}
+				if !sameSong(saved, song) {
+					t.Fatalf("the song changed when saved in format %v and loaded", format)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSongMigration(t *testing.T) {
+	for _, data := range []string{
+		"bpm: 100\nscore:\n  rowsperpattern: 4\n  tracks:\n    - numvoices: 1\n      order: [0, 0, 0]\n      patterns: [[64, 0]]\n",
+		`{"BPM": 100, "Score": {"RowsPerPattern": 4, "Tracks": [{"NumVoices": 1, "Order": [0, 0, 0], "Patterns": ["QAA="]}]}}`,
+	} {
+		song, err := LoadSong(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("LoadSong failed: %v", err)
+		}
+		if song.Version != SongVersion || song.RowsPerBeat != 4 || song.Score.Length != 3 {
+			t.Errorf("expected version %v, RowsPerBeat 4 and Length 3, got %v, %v and %v", SongVersion, song.Version, song.RowsPerBeat, song.Score.Length)
+		}
+		if !reflect.DeepEqual(song.Score.Tracks[0].Patterns, []Pattern{{64, 0}}) {
+			t.Errorf("wrong patterns: %v", song.Score.Tracks[0].Patterns)
+		}
+	}
+}
+
+func TestLoadSongRenames(t *testing.T) {
+	defer func(m []songMigration, v int) { songMigrations, SongVersion = m, v }(songMigrations, SongVersion)
+	songMigrations = append(songMigrations[:len(songMigrations):len(songMigrations)], songMigration{
+		renames: map[string]map[string]string{"oscillator": {"timbre": "color"}},
+	})
+	SongVersion = len(songMigrations)
+	data := "version: 1\nbpm: 100\nrowsperbeat: 4\npatch:\n  - numvoices: 1\n    units:\n      - type: oscillator\n        parameters: {timbre: 32, shape: 64}\n"
+	song, err := LoadSong(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("LoadSong failed: %v", err)
+	}
+	if p := song.Patch[0].Units[0].Parameters; !reflect.DeepEqual(p, map[string]int{"color": 32, "shape": 64}) {
+		t.Errorf("the parameter was not renamed: %v", p)
+	}
+}
+
+func TestLoadSongErrors(t *testing.T) {
+	for _, data := range []string{
+		"version: 1\nbpm: 100\nrowsperbeet: 4\n",
+		"bpm: 100\nscore:\n  tracks:\n    - numvoices: 1\n      sequence: [0]\n",
+		`{"Version": 1, "BPM": 100, "Tempo": 4}`,
+		"version: 1000\nbpm: 100\n",
+		"version: -1\nbpm: 100\n",
+		`{"Version": -3, "BPM": 100}`,
+		"version: 1.5\nbpm: 100\n",
+		`{"Version": 1.5, "BPM": 100}`,
+		"version: one\nbpm: 100\n",
+		"bpm: [",
+	} {
+		if _, err := LoadSong(strings.NewReader(data)); err == nil {
+			t.Errorf("expected an error for %q", data)
+		}
+	}
+}
+
+func TestLoadSongMetadata(t *testing.T) {
+	data := "version: 2\ntitle: Song\nauthor: Someone\ncomment: |\n  First line\n  Second line\nlicense: CC BY 4.0\nbpm: 100\nrowsperbeat: 4\n"
+	song, err := LoadSong(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("LoadSong failed: %v", err)
+	}
+	expected := Metadata{Title: "Song", Author: "Someone", Comment: "First line\nSecond line\n", License: "CC BY 4.0"}
+	if song.Metadata != expected {
+		t.Fatalf("wrong metadata: %#v", song.Metadata)
+	}
+	var buf bytes.Buffer
+	if err := SaveSong(&buf, song, JSONFormat); err != nil {
+		t.Fatalf("SaveSong failed: %v", err)
+	}
+	if saved, err := LoadSong(&buf); err != nil || saved.Metadata != expected {
+		t.Fatalf("the metadata was not saved as JSON: %v, %#v", err, saved.Metadata)
+	}
+	buf.Reset()
+	if err := SaveSong(&buf, Song{BPM: 100, RowsPerBeat: 4}, JSONFormat); err != nil {
+		t.Fatalf("SaveSong failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "Title") {
+		t.Errorf("the empty metadata should be omitted from the JSON: %v", buf.String())
+	}
+	lines := []string{"Title: Song", "Author: Someone", "Comment: First line", "    Second line", "License: CC BY 4.0"}
+	if !reflect.DeepEqual(song.Metadata.Lines(), lines) {
+		t.Errorf("wrong lines: %q", song.Metadata.Lines())
+	}
+}
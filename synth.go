@@ -3,6 +3,7 @@ package sointu
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math"
 )
 
@@ -57,7 +58,8 @@ func Render(synth Synth, buffer []float32) error {
 // 'release' as true means that all the notes are released when the synth is
 // created. The default behaviour during runtime rendering is to leave them
 // playing, meaning that envelopes start attacking right away unless an explicit
-// note release is put to every track.
+// note release is put to every track. Play renders the whole song at once; use
+// a SongRenderer to render it in smaller blocks.
 func Play(synthService SynthService, song Song, release bool) ([]float32, []float32, error) {
 	err := song.Validate()
 	if err != nil {
@@ -72,65 +74,25 @@ func Play(synthService SynthService, song Song, release bool) ([]float32, []floa
 			synth.Release(i)
 		}
 	}
-	curVoices := make([]int, len(song.Score.Tracks))
-	for i := range curVoices {
-		curVoices[i] = song.Score.FirstVoiceForTrack(i)
+	renderer, err := NewSongRenderer(synth, song)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sointu.Play failed: %v", err)
 	}
 	initialCapacity := song.Score.LengthInRows() * song.SamplesPerRow() * 2
 	buffer := make([]float32, 0, initialCapacity)
 	rowbuffer := make([]float32, song.SamplesPerRow()*2)
 	numSyncs := song.Patch.NumSyncs()
 	syncBuffer := make([]float32, 0, (song.Score.LengthInRows()*song.SamplesPerRow()+255)/256*(1+numSyncs))
-	syncRowBuffer := make([]float32, ((song.SamplesPerRow()+255)/256)*(1+numSyncs))
-	for row := 0; row < song.Score.LengthInRows(); row++ {
-		patternRow := row % song.Score.RowsPerPattern
-		pattern := row / song.Score.RowsPerPattern
-		for t := range song.Score.Tracks {
-			order := song.Score.Tracks[t].Order
-			if pattern < 0 || pattern >= len(order) {
-				continue
-			}
-			patternIndex := song.Score.Tracks[t].Order[pattern]
-			patterns := song.Score.Tracks[t].Patterns
-			if patternIndex < 0 || int(patternIndex) >= len(patterns) {
-				continue
-			}
-			pattern := patterns[patternIndex]
-			if patternRow < 0 || patternRow >= len(pattern) {
-				continue
-			}
-			note := pattern[patternRow]
-			if note > 0 && note <= 1 { // anything but hold causes an action.
-				continue
-			}
-			synth.Release(curVoices[t])
-			if note > 1 {
-				curVoices[t]++
-				first := song.Score.FirstVoiceForTrack(t)
-				if curVoices[t] >= first+song.Score.Tracks[t].NumVoices {
-					curVoices[t] = first
-				}
-				synth.Trigger(curVoices[t], note)
-			}
+	syncRowBuffer := make([]float32, renderer.SyncBlockLength())
+	for {
+		samples, syncs, err := renderer.Render(rowbuffer, syncRowBuffer)
+		buffer = append(buffer, rowbuffer[:samples*2]...)
+		syncBuffer = append(syncBuffer, syncRowBuffer[:syncs*(1+numSyncs)]...)
+		if err == io.EOF {
+			return buffer, syncBuffer, nil
 		}
-		tries := 0
-		for rowtime := 0; rowtime < song.SamplesPerRow(); {
-			samples, syncs, time, err := synth.Render(rowbuffer, syncRowBuffer, song.SamplesPerRow()-rowtime)
-			for i := 0; i < syncs; i++ {
-				t := syncRowBuffer[i*(1+numSyncs)]
-				t = (t+float32(rowtime))/(float32(song.SamplesPerRow())) + float32(row)
-				syncRowBuffer[i*(1+numSyncs)] = t
-			}
-			if err != nil {
-				return buffer, syncBuffer, fmt.Errorf("render failed: %v", err)
-			}
-			rowtime += time
-			buffer = append(buffer, rowbuffer[:samples*2]...)
-			syncBuffer = append(syncBuffer, syncRowBuffer[:syncs*(1+numSyncs)]...)
-			if tries > 100 {
-				return nil, nil, fmt.Errorf("Song speed modulation likely so slow that row never advances; error at pattern %v, row %v", pattern, patternRow)
-			}
+		if err != nil {
+			return buffer, syncBuffer, err
 		}
 	}
-	return buffer, syncBuffer, nil
 }
@@ -1,4 +1,15 @@
 {{- if .SupportsParamValue "oscillator" "type" .Sample}}
+{{- $embedded := false}}
+{{- if not .Library}}{{$embedded = gt (len .SampleData) 0}}{{end}}
+
+{{- if $embedded}}
+;-------------------------------------------------------------------------------
+;    Samples embedded in the instruments
+;-------------------------------------------------------------------------------
+{{.Data "su_sample_table"}}
+{{- range $i, $b := .EncodedSamples}}{{if eq (mod $i 32) 0}}
+    db {{else}},{{end}}{{$b}}{{end}}
+{{- else}}
 
 {{- if eq .OS "windows"}}
 {{.ExportFunc "su_load_gmdls"}}
@@ -45,6 +56,11 @@ extern __imp__ReadFile@20
 {{end}}
 
 {{.SectBss "susamtable"}}
-su_sample_table:
+{{.ExportData "su_sample_table"}}
+{{- if .Library}}
     resb    3440660    ; size of gmdls.
+{{- else}}
+    resb    {{max 3440660 (mul 2 .SampleTableLength)}}    ; size of gmdls, or more if the sample offsets reach further e.g. in a .sf2
+{{- end}}
+{{- end}}
 {{end}}
@@ -60,6 +60,11 @@ typedef struct Synth {
 #define CALLCONV  // the asm will use honor honor correct x64 ABI on all 64-bit platforms
 #endif
 
+// The sample table should contain the sample bank file (gm.dls or a .sf2) as
+// int16s. su_load_gmdls loads gm.dls into it on Windows; on other platforms,
+// read at most SU_SAMPLE_TABLE_SIZE bytes of the file into it.
+#define SU_SAMPLE_TABLE_SIZE 3440660
+extern short su_sample_table[SU_SAMPLE_TABLE_SIZE / 2];
 void CALLCONV su_load_gmdls(void);
 
 // int su_render(Synth* synth, float* buffer, int* samples, int* time):
@@ -92,9 +97,34 @@ void CALLCONV su_load_gmdls(void);
 //    bits 11-13    The top pointer of the fpu stack. Any other value than 0 indicates that some values were left on the stack.
 int CALLCONV su_render(Synth* synth, float* buffer, int* samples, int* time);
 
+// The opcodes of the unit types the library supports. Patches with other unit
+// types cannot be played.
 #define SU_ADVANCE_ID       0
 {{- range $index, $element := .Instructions}}
 #define {{printf "su_%v_id" $element | upper | printf "%-20v"}}{{add1 $index | mul 2}}
 {{- end}}
+{{- if .FeatureList.ParamValues}}
+
+// The parameters that support only some values:
+{{- range $key, $values := .FeatureList.ParamValues}}
+//      {{printf "%-24v" $key}}{{$values | toStrings | join ", "}}
+{{- end}}
+{{- end}}
+
+// The ports that sends can modulate:
+{{- range .FeatureList.Modulations}}
+//      {{.}}
+{{- end}}
+{{- if not .SupportsPolyphony}}
+// The library does not support polyphony: all instruments should have one voice.
+{{- end}}
+{{- if not .SupportsGlobalSend}}
+// The library does not support global sends: sends can only target the units of
+// the same voice.
+{{- end}}
+
+// SU_FEATURES is the vm.FeatureList of the library as JSON, which the patches
+// should be encoded with.
+#define SU_FEATURES {{.FeatureList | toJson | quote}}
 
 #endif // _SOINTU_H
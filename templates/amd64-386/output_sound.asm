@@ -27,7 +27,7 @@
             mov     {{.SI}}, [{{.Stack "OutputBufPtr"}}] ; esi points to the output buffer
             mov     {{.DI}}, {{.PTRWORD}} su_synth_obj+su_synthworkspace.left
             mov     ecx, 2
-            output_sound16bit_loop: ; loop over two channels, left & right
+            .output_sound16bit_loop: ; loop over two channels, left & right
                     fld     dword [{{.DI}}]
                     {{.Call "su_clip"}}
             {{- .Float 32767.0 | .Prepare | indent 16}}
@@ -39,6 +39,6 @@
                     xor     eax,eax
                     stosd
                     add     {{.SI}},2
-                    loop    output_sound16bit_loop
+                    loop    .output_sound16bit_loop
             mov     [{{.Stack "OutputBufPtr"}}], {{.SI}} ; save esi back to stack
 {{- end }}
\ No newline at end of file
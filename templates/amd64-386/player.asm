@@ -1,3 +1,4 @@
+{{.MetadataComments "; " -}}
 {{template "structs.asm" .}}
 ;-------------------------------------------------------------------------------
 ;   Uninitialized data: The synth object
@@ -15,6 +16,27 @@ extern syncBuf
 {{- end}}
 {{- end}}
 
+{{- $syncBuf := "syncBuf"}}
+{{- if or (and (eq .OS "windows") (not .Amd64)) (eq .OS "darwin")}}
+{{- $syncBuf = "_syncBuf"}}
+{{- end}}
+{{- if .Chunked}}
+
+;-------------------------------------------------------------------------------
+;   player state struct: where su_render_chunk continues from
+;-------------------------------------------------------------------------------
+struc su_player_state
+    .row        resd    1
+    .sample     resd    1
+    .globaltick resd    1
+    .randseed   resd    1
+    {{- if or .RowSync (.HasOp "sync")}}
+    .syncbufptr resb    {{.PTRSIZE}}
+    {{- end}}
+    .size:
+endstruc
+{{- end}}
+
 
 ;-------------------------------------------------------------------------------
 ;   su_render_song function: the entry point for the synth
@@ -79,7 +101,7 @@ su_render_sampleloop:                   ; loop through every sample in the row
             jl      su_render_sampleloop
         {{.Pop .AX}}                  ; Stack: pushad ptr
         inc     eax
-        cmp     eax, {{mul .PatternLength .SequenceLength}}
+        cmp     eax, {{.Song.Score.LengthInRows}}
         jl      su_render_rowloop
     ; rewind the stack the entropy of multiple pop {{.AX}} is probably lower than add
     {{- range slice .Stacklocs $prologsize}}
@@ -99,6 +121,187 @@ su_render_sampleloop:                   ; loop through every sample in the row
     ret     4
     {{- end}}
 
+{{- if .Chunked}}
+;-------------------------------------------------------------------------------
+;   su_render_chunk function: renders the song in pieces
+;-------------------------------------------------------------------------------
+;   Has the signature su_render_chunk(void *ptr, int samples), where ptr is a
+;   pointer to the output buffer. Renders the next samples of the song to the
+;   buffer, continuing from su_player. After the last row, the song loops back
+;   to the first row. The stack frame has the same layout as in su_render_song,
+;   so su_update_voices and su_run_vm work for both.
+;   Stack:  output_ptr samples
+;-------------------------------------------------------------------------------
+{{.ExportFunc "su_render_chunk" "OutputBufPtr" "SamplesLeft"}}
+    {{-  if .Amd64}}
+    {{- if eq .OS "windows"}}
+    {{- .PushRegs "rcx" "OutputBufPtr" "rdx" "SamplesLeft" "rdi" "NonVolatileRsi" "rsi" "NonVolatile" "rbx" "NonVolatileRbx" "rbp" "NonVolatileRbp" | indent 4}} ; rcx = ptr to buf, rdx = samples. rdi,rsi,rbx,rbp  nonvolatile
+    {{- else}} ; SystemV amd64 ABI, linux mac or hopefully something similar
+    {{- .PushRegs "rdi" "OutputBufPtr" "rsi" "SamplesLeft" "rbx" "NonVolatileRbx" "rbp" "NonVolatileRbp" | indent 4}}
+    {{- end}}
+    {{- else}}
+    {{- .PushRegs | indent 4}}
+    {{- end}}
+    {{- $prologsize := len .Stacklocs}}
+    mov     {{.SI}}, {{.PTRWORD}} su_player                     ; {{.SI}} points to the player state
+    {{- if or .RowSync (.HasOp "sync")}}
+    {{.Push (printf "%v [%v + su_player_state.syncbufptr]" .PTRWORD .SI) "SyncBufPtr"}}
+    {{- end}}
+    {{- if ne .VoiceTrackBitmask 0}}
+    {{.Push (.VoiceTrackBitmask | printf "%v") "VoiceTrackBitmask"}}
+    {{- end}}
+    mov     eax, [{{.SI}} + su_player_state.randseed]
+    {{.Push .AX "RandSeed"}}
+    mov     eax, [{{.SI}} + su_player_state.globaltick]
+    {{.Push .AX "GlobalTick"}}
+    mov     eax, [{{.SI}} + su_player_state.row]
+    {{.Push .AX "Row"}}
+    mov     eax, [{{.SI}} + su_player_state.sample]                  ; eax is the current sample within row
+su_render_chunk_loop:                   ; loop through the samples of the chunk
+        dec     dword [{{.Stack "SamplesLeft"}}]
+        js      su_render_chunk_done
+        cmp     eax, {{.Song.SamplesPerRow}}
+        jl      su_render_chunk_sample  ; if the row ended, advance to the next row
+        mov     eax, [{{.Stack "Row"}}]
+        inc     eax
+        cmp     eax, {{.Song.Score.LengthInRows}}
+        jl      su_render_chunk_nowrap
+        xor     eax, eax                ; loop back to the first row
+        {{- if or .RowSync (.HasOp "sync")}}
+        mov     {{.CX}}, {{.PTRWORD}} {{$syncBuf}}
+        mov     [{{.Stack "SyncBufPtr"}}], {{.CX}} ; write the syncs again from the start of the buffer
+        {{- end}}
+su_render_chunk_nowrap:
+        mov     [{{.Stack "Row"}}], eax
+        {{.Call "su_update_voices"}}   ; update instruments for the new row
+        xor     eax, eax
+su_render_chunk_sample:
+        {{.Push .AX "Sample"}}
+        {{- if .SupportsPolyphony}}
+        {{.Push (.PolyphonyBitmask | printf "%v") "PolyphonyBitmask"}} ; does the next voice reuse the current opcodes?
+        {{- end}}
+        {{.Push (.Song.Patch.NumVoices | printf "%v") "VoicesRemain"}}
+        mov     {{.DX}}, {{.PTRWORD}} su_synth_obj                       ; {{.DX}} points to the synth object
+        mov     {{.COM}}, {{.PTRWORD}} su_patch_code           ; COM points to vm code
+        mov     {{.VAL}}, {{.PTRWORD}} su_patch_parameters             ; VAL points to unit params
+        {{- if .HasOp "delay"}}
+        mov     {{.CX}}, {{.PTRWORD}} su_synth_obj + su_synthworkspace.size - su_delayline_wrk.filtstate
+        {{- end}}
+        lea     {{.WRK}}, [{{.DX}} + su_synthworkspace.voices]            ; WRK points to the first voice
+        {{.Call "su_run_vm"}} ; run through the VM code
+        {{.Pop .AX}}
+        {{- if .SupportsPolyphony}}
+        {{.Pop .AX}}
+        {{- end}}
+        {{- template "output_sound.asm" .}}                ; *ptr++ = left, *ptr++ = right
+        {{.Pop .AX}}
+        inc     dword [{{.Stack "GlobalTick"}}] ; increment global time, used by delays
+        inc     eax
+        jmp     su_render_chunk_loop
+su_render_chunk_done:                   ; save the state for the next call
+    mov     {{.SI}}, {{.PTRWORD}} su_player
+    mov     [{{.SI}} + su_player_state.sample], eax
+    {{.Pop .AX}}
+    mov     [{{.SI}} + su_player_state.row], eax
+    {{.Pop .AX}}
+    mov     [{{.SI}} + su_player_state.globaltick], eax
+    {{.Pop .AX}}
+    mov     [{{.SI}} + su_player_state.randseed], eax
+    {{- if ne .VoiceTrackBitmask 0}}
+    {{.Pop .AX}}
+    {{- end}}
+    {{- if or .RowSync (.HasOp "sync")}}
+    {{.Pop .AX}}
+    mov     [{{.SI}} + su_player_state.syncbufptr], {{.AX}}
+    {{- end}}
+    {{-  if .Amd64}}
+    {{- if eq .OS "windows"}}
+    ; Windows64 ABI, rdi rsi rbx rbp non-volatile
+    {{- .PopRegs "rcx" "rdx" "rdi" "rsi" "rbx" "rbp" | indent 4}}
+    {{- else}}
+    ; SystemV64 ABI (linux mac or hopefully something similar), rbx rbp non-volatile
+    {{- .PopRegs "rdi" "rsi" "rbx" "rbp" | indent 4}}
+    {{- end}}
+    ret
+    {{- else}}
+    {{- .PopRegs | indent 4}}
+    ret     8
+    {{- end}}
+
+;-------------------------------------------------------------------------------
+;   su_seek function: moves su_render_chunk to the start of a row
+;-------------------------------------------------------------------------------
+;   Has the signature su_seek(int row). Silences all voices and clears the delay
+;   lines, then runs su_update_voices for all the rows before, so that the
+;   tracks rotate their voices as if the song was played from the start. The
+;   notes still held at the row start from the beginning of their envelopes.
+;   Stack:  row
+;-------------------------------------------------------------------------------
+{{.ExportFunc "su_seek" "RowParam"}}
+    {{-  if .Amd64}}
+    {{- if eq .OS "windows"}}
+    {{- .PushRegs "rcx" "RowParam" "rdi" "NonVolatileRsi" "rsi" "NonVolatile" "rbx" "NonVolatileRbx" "rbp" "NonVolatileRbp" | indent 4}} ; rcx = row. rdi,rsi,rbx,rbp  nonvolatile
+    {{- else}} ; SystemV amd64 ABI, linux mac or hopefully something similar
+    {{- .PushRegs "rdi" "RowParam" "rbx" "NonVolatileRbx" "rbp" "NonVolatileRbp" | indent 4}}
+    {{- end}}
+    {{- else}}
+    {{- .PushRegs | indent 4}}
+    {{- end}}
+    mov     {{.DI}}, {{.PTRWORD}} su_synth_obj
+    mov     ecx, (su_synthworkspace.size + {{.Song.Patch.NumDelayLines}}*su_delayline_wrk.size)/4
+    xor     eax, eax
+    rep stosd                           ; clear the synth object, including the voices of the tracks
+    ; the same stack frame as in su_render_song, for su_update_voices
+    {{- if ne .VoiceTrackBitmask 0}}
+    {{.Push (.VoiceTrackBitmask | printf "%v") "VoiceTrackBitmask"}}
+    {{- end}}
+    {{.Push "1" "RandSeed"}}
+    {{.Push .AX "GlobalTick"}}
+su_seek_rowloop:                        ; replay the rows before the seeked row
+        cmp     eax, [{{.Stack "RowParam"}}]
+        jge     su_seek_done
+        {{.Push .AX "Row"}}
+        {{.Call "su_update_voices"}}
+        {{.Pop .AX}}
+        inc     eax
+        jmp     su_seek_rowloop
+su_seek_done:                           ; su_render_chunk advances to the row and triggers its notes
+    mov     {{.SI}}, {{.PTRWORD}} su_player
+    dec     eax
+    mov     [{{.SI}} + su_player_state.row], eax
+    mov     dword [{{.SI}} + su_player_state.sample], {{.Song.SamplesPerRow}}
+    inc     eax
+    imul    eax, eax, {{.Song.SamplesPerRow}}
+    mov     [{{.SI}} + su_player_state.globaltick], eax
+    mov     dword [{{.SI}} + su_player_state.randseed], 1
+    {{- if or .RowSync (.HasOp "sync")}}
+    add     eax, 255                    ; the first sync is written when the globaltick is the next multiple of 256
+    shr     eax, 8
+    imul    eax, eax, {{if .RowSync}}{{add1 .Song.Patch.NumSyncs}}{{else}}{{.Song.Patch.NumSyncs}}{{end}}*4
+    {{- .Prepare $syncBuf .AX | indent 4}}
+    lea     {{.AX}}, [{{.Use $syncBuf .AX}}]
+    mov     [{{.SI}} + su_player_state.syncbufptr], {{.AX}}
+    {{- end}}
+    {{.Pop .AX}}
+    {{.Pop .AX}}
+    {{- if ne .VoiceTrackBitmask 0}}
+    {{.Pop .AX}}
+    {{- end}}
+    {{-  if .Amd64}}
+    {{- if eq .OS "windows"}}
+    ; Windows64 ABI, rdi rsi rbx rbp non-volatile
+    {{- .PopRegs "rcx" "rdi" "rsi" "rbx" "rbp" | indent 4}}
+    {{- else}}
+    ; SystemV64 ABI (linux mac or hopefully something similar), rbx rbp non-volatile
+    {{- .PopRegs "rdi" "rbx" "rbp" | indent 4}}
+    {{- end}}
+    ret
+    {{- else}}
+    {{- .PopRegs | indent 4}}
+    ret     4
+    {{- end}}
+
+{{end}}
 ;-------------------------------------------------------------------------------
 ;   su_update_voices function: polyphonic & chord implementation
 ;-------------------------------------------------------------------------------
@@ -247,6 +450,35 @@ su_update_voices_skipadd:
 {{.Data "su_patch_parameters"}}
     db {{.Values | toStrings | join ","}}
 
+{{- if .Events}}
+;-------------------------------------------------------------------------------
+;    Event tables of the tracks: the rows and the values of the events
+;-------------------------------------------------------------------------------
+{{- range $i, $e := .Events}}
+{{$.SectData (printf "su_events_%v" $i)}}
+{{$.ExportData (printf "su_event_rows_%v" $i)}}
+{{- if $e.Rows}}
+    dw {{$e.Rows | toStrings | join ","}}
+{{- end}}
+{{$.ExportData (printf "su_event_values_%v" $i)}}
+{{- if $e.Values}}
+    db {{$e.Values | toStrings | join ","}}
+{{- end}}
+{{- end}}
+{{end}}
+
+{{- if .Chunked}}
+;-------------------------------------------------------------------------------
+;    The state of su_render_chunk, starting before the first row
+;-------------------------------------------------------------------------------
+{{.SectData "su_player"}}
+{{.ExportData "su_player"}}
+    dd  -1,{{.Song.SamplesPerRow}},0,1 ; row, sample, globaltick, randseed
+    {{- if or .RowSync (.HasOp "sync")}}
+    {{.DPTR}}  {{$syncBuf}}                  ; syncbufptr
+    {{- end}}
+
+{{end}}
 ;-------------------------------------------------------------------------------
 ;    Constants
 ;-------------------------------------------------------------------------------
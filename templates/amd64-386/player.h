@@ -1,4 +1,5 @@
 // auto-generated by Sointu, editing not recommended
+{{.MetadataComments "// " -}}
 #ifndef SU_RENDER_H
 #define SU_RENDER_H
 
@@ -10,8 +11,20 @@
 #define SU_ROWS_PER_BEAT        {{.Song.RowsPerBeat}}
 #define SU_ROWS_PER_PATTERN     {{.Song.Score.RowsPerPattern}}
 #define SU_LENGTH_IN_PATTERNS   {{.Song.Score.Length}}
-#define SU_LENGTH_IN_ROWS       (SU_LENGTH_IN_PATTERNS*SU_PATTERN_SIZE)
+#define SU_LENGTH_IN_ROWS       (SU_LENGTH_IN_PATTERNS*SU_ROWS_PER_PATTERN)
 #define SU_SAMPLES_PER_ROW      (SU_SAMPLE_RATE*60/(SU_BPM*SU_ROWS_PER_BEAT))
+{{- with .Song.Title}}
+#define SU_TITLE                {{$.CString .}}
+{{- end}}
+{{- with .Song.Author}}
+#define SU_AUTHOR               {{$.CString .}}
+{{- end}}
+{{- with .Song.Comment}}
+#define SU_COMMENT              {{$.CString .}}
+{{- end}}
+{{- with .Song.License}}
+#define SU_LICENSE              {{$.CString .}}
+{{- end}}
 
 {{- if or .RowSync (.HasOp "sync")}}
 {{- if .RowSync}}
@@ -53,7 +66,58 @@ extern "C" {
 {{- end}}
 void SU_CALLCONV su_render_song(SUsample *buffer);
 
-{{- if gt (.SampleOffsets | len) 0}}
+{{- if .Chunked}}
+
+// The state of su_render_chunk. It starts before the first row, so the first
+// chunk triggers the notes of the first row.
+typedef struct SUplayer {
+    int row;                    // the row being played
+    int sample;                 // time ticks since the start of the row
+    unsigned int globaltick;
+    unsigned int randseed;
+#ifdef SU_SYNC
+    float *syncbuf;             // where the next syncs are written
+#endif
+} SUplayer;
+extern SUplayer su_player;
+
+// su_render_chunk renders the next samples stereo samples (samples*2 SUsamples)
+// of the song to the buffer, e.g. in a sound callback. After the last row, the
+// song loops back to the first row.
+void SU_CALLCONV su_render_chunk(SUsample *buffer, int samples);
+
+// su_seek moves su_render_chunk to the start of the row (0 <= row <
+// SU_LENGTH_IN_ROWS). The voices are silenced and the delays cleared; the notes
+// held at the row start again from the beginning of their envelopes.
+void SU_CALLCONV su_seek(int row);
+#define SU_RENDER_CHUNK
+{{- end}}
+
+{{- if .Events}}
+
+// The event tables of the tracks, e.g. for syncing visuals to the music. Track
+// T has SU_EVENTS_T events: su_event_rows_T[i] is the row of the ith event and
+// su_event_values_T[i] its value: the note of a note-on or, in an effect track
+// (SU_EVENTS_T_EFFECT), the value of the track from that row on. Multiply the
+// rows by SU_SAMPLES_PER_ROW to get the times in samples, unless speed units
+// change the tempo.
+#define SU_EVENTS
+{{- range $i, $e := .Events}}
+#define SU_EVENTS_{{$i}} {{len $e.Rows}}
+{{- if $e.Effect}}
+#define SU_EVENTS_{{$i}}_EFFECT
+{{- end}}
+extern const unsigned short su_event_rows_{{$i}}[];
+extern const unsigned char su_event_values_{{$i}}[];
+{{- end}}
+{{- end}}
+
+{{- if and (gt (.SampleOffsets | len) 0) (eq (.SampleData | len) 0)}}
+// The sample table should contain the sample bank file (gm.dls or a .sf2) as
+// int16s before rendering. su_load_gmdls loads gm.dls into it on Windows; on
+// other platforms, read at most SU_SAMPLE_TABLE_SIZE bytes of the file into it.
+#define SU_SAMPLE_TABLE_SIZE    {{max 3440660 (mul 2 .SampleTableLength)}}
+extern short su_sample_table[];
 void SU_CALLCONV su_load_gmdls();
 #define SU_LOAD_GMDLS
 {{- end}}
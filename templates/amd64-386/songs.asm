@@ -0,0 +1,323 @@
+{{template "structs.asm" .}}
+;-------------------------------------------------------------------------------
+;   Uninitialized data: The synth object, shared by all the songs
+;-------------------------------------------------------------------------------
+{{.SectBss "synth_object"}}
+su_synth_obj:
+    resb    su_synthworkspace.size
+    resb    {{.NumDelayLines}}*su_delayline_wrk.size
+
+{{- if or .RowSync (.HasOp "sync")}}
+{{- if or (and (eq .OS "windows") (not .Amd64)) (eq .OS "darwin")}}
+extern _syncBuf
+{{- else}}
+extern syncBuf
+{{- end}}
+{{- end}}
+
+;-------------------------------------------------------------------------------
+;   song struct: the tables and the constants of a song, see su_songs
+;-------------------------------------------------------------------------------
+struc su_song
+    .patch_code         resb    {{.PTRSIZE}}
+    .patch_parameters   resb    {{.PTRSIZE}}
+    .tracks             resb    {{.PTRSIZE}}
+    .patterns           resb    {{.PTRSIZE}}
+    .voicetrack_bitmask resb    {{.PTRSIZE}}
+    .polyphony_bitmask  resb    {{.PTRSIZE}}
+    .num_voices         resb    {{.PTRSIZE}}
+    .samples_per_row    resb    {{.PTRSIZE}}
+    .length_in_rows     resb    {{.PTRSIZE}}
+    .pattern_length     resb    {{.PTRSIZE}}
+    .sequence_length    resb    {{.PTRSIZE}}
+    .num_tracks         resb    {{.PTRSIZE}}
+    .size:
+endstruc
+
+;-------------------------------------------------------------------------------
+;   su_render_song function: the entry point for all the songs
+;-------------------------------------------------------------------------------
+;   Has the signature su_render_song(void *ptr, int song), where ptr is a
+;   pointer to the output buffer and song the index of the song. Clears the
+;   synth object and renders the song to the buffer. The tables and the
+;   constants of the song are read from its entry in su_songs, kept in the stack
+;   frame for su_update_voices.
+;   Stack:  output_ptr song
+;-------------------------------------------------------------------------------
+{{.ExportFunc "su_render_song" "OutputBufPtr" "SongIndex"}}
+    {{-  if .Amd64}}
+    {{- if eq .OS "windows"}}
+    {{- .PushRegs "rcx" "OutputBufPtr" "rdx" "SongIndex" "rdi" "NonVolatileRsi" "rsi" "NonVolatile" "rbx" "NonVolatileRbx" "rbp" "NonVolatileRbp" | indent 4}} ; rcx = ptr to buf, rdx = song. rdi,rsi,rbx,rbp  nonvolatile
+    {{- else}} ; SystemV amd64 ABI, linux mac or hopefully something similar
+    {{- .PushRegs "rdi" "OutputBufPtr" "rsi" "SongIndex" "rbx" "NonVolatileRbx" "rbp" "NonVolatileRbp" | indent 4}}
+    {{- end}}
+    {{- else}}
+    {{- .PushRegs | indent 4}}
+    {{- end}}
+    {{- $prologsize := len .Stacklocs}}
+    mov     eax, [{{.Stack "SongIndex"}}]
+    imul    eax, eax, su_song.size
+    {{- .Prepare "su_songs" .AX | indent 4}}
+    lea     {{.AX}}, [{{.Use "su_songs" .AX}}]
+    {{.Push .AX "Song"}}
+    mov     {{.DI}}, {{.PTRWORD}} su_synth_obj
+    mov     ecx, (su_synthworkspace.size + {{.NumDelayLines}}*su_delayline_wrk.size)/4
+    xor     eax, eax
+    rep stosd                           ; clear the synth object, the previous song may have left voices playing
+    {{- if or .RowSync (.HasOp "sync")}}
+    {{- if or (and (eq .OS "windows") (not .Amd64)) (eq .OS "darwin")}}
+    {{- .Prepare "_syncBuf"}}
+    {{.Push (.Use "_syncBuf") "SyncBufPtr"}}
+    {{- else}}
+    {{- .Prepare "syncBuf"}}
+    {{.Push (.Use "syncBuf") "SyncBufPtr"}}
+    {{- end}}
+    {{- end}}
+    {{- if .VoiceTracks}}
+    mov     {{.DI}}, [{{.Stack "Song"}}]
+    mov     eax, [{{.DI}} + su_song.voicetrack_bitmask]
+    {{.Push .AX "VoiceTrackBitmask"}}
+    xor     eax, eax
+    {{- end}}
+    {{.Push "1" "RandSeed"}}
+    {{.Push .AX "GlobalTick"}}
+su_render_rowloop:                      ; loop through every row in the song
+        {{.Push .AX "Row"}}
+        {{.Call "su_update_voices"}}   ; update instruments for the new row
+        xor     eax, eax                ; ecx is the current sample within row
+su_render_sampleloop:                   ; loop through every sample in the row
+            {{.Push .AX "Sample"}}
+            mov     {{.DI}}, [{{.Stack "Song"}}]                   ; {{.DI}} points to the song
+            {{- if .SupportsPolyphony}}
+            mov     eax, [{{.DI}} + su_song.polyphony_bitmask]
+            {{.Push .AX "PolyphonyBitmask"}} ; does the next voice reuse the current opcodes?
+            {{- end}}
+            mov     eax, [{{.DI}} + su_song.num_voices]
+            {{.Push .AX "VoicesRemain"}}
+            mov     {{.DX}}, {{.PTRWORD}} su_synth_obj                       ; {{.DX}} points to the synth object
+            mov     {{.COM}}, [{{.DI}} + su_song.patch_code]           ; COM points to vm code
+            mov     {{.VAL}}, [{{.DI}} + su_song.patch_parameters]     ; VAL points to unit params
+            {{- if .HasOp "delay"}}
+            mov     {{.CX}}, {{.PTRWORD}} su_synth_obj + su_synthworkspace.size - su_delayline_wrk.filtstate
+            {{- end}}
+            lea     {{.WRK}}, [{{.DX}} + su_synthworkspace.voices]            ; WRK points to the first voice
+            {{.Call "su_run_vm"}} ; run through the VM code
+            {{.Pop .AX}}
+            {{- if .SupportsPolyphony}}
+            {{.Pop .AX}}
+            {{- end}}
+            {{- template "output_sound.asm" .}}                ; *ptr++ = left, *ptr++ = right
+            {{.Pop .AX}}
+            inc     dword [{{.Stack "GlobalTick"}}] ; increment global time, used by delays
+            inc     eax
+            mov     {{.DI}}, [{{.Stack "Song"}}]
+            cmp     eax, [{{.DI}} + su_song.samples_per_row]
+            jl      su_render_sampleloop
+        {{.Pop .AX}}                  ; Stack: pushad ptr
+        inc     eax
+        cmp     eax, [{{.DI}} + su_song.length_in_rows]
+        jl      su_render_rowloop
+    ; rewind the stack the entropy of multiple pop {{.AX}} is probably lower than add
+    {{- range slice .Stacklocs $prologsize}}
+    {{$.Pop $.AX}}
+    {{- end}}
+    {{-  if .Amd64}}
+    {{- if eq .OS "windows"}}
+    ; Windows64 ABI, rdi rsi rbx rbp non-volatile
+    {{- .PopRegs "rcx" "rdx" "rdi" "rsi" "rbx" "rbp" | indent 4}}
+    {{- else}}
+    ; SystemV64 ABI (linux mac or hopefully something similar), rbx rbp non-volatile
+    {{- .PopRegs "rdi" "rsi" "rbx" "rbp" | indent 4}}
+    {{- end}}
+    ret
+    {{- else}}
+    {{- .PopRegs | indent 4}}
+    ret     8
+    {{- end}}
+
+;-------------------------------------------------------------------------------
+;   su_update_voices function: polyphonic & chord implementation for all songs
+;-------------------------------------------------------------------------------
+;   Input:      eax     :   current row within song
+;               Song    :   pointer to the su_song of the song, in the stack
+;   Dirty:      pretty much everything
+;-------------------------------------------------------------------------------
+{{.Func "su_update_voices"}}
+{{- if .VoiceTracks}}
+; The more complicated implementation: one track can trigger multiple voices
+    mov     {{.DI}}, [{{.Stack "Song"}}]                   ; {{.DI}} points to the song
+    xor     edx, edx
+    div     dword [{{.DI}} + su_song.pattern_length]    ; eax = current pattern, edx = current row in pattern
+    mov     {{.SI}}, [{{.DI}} + su_song.tracks]
+    add     {{.SI}}, {{.AX}}                          ; esi points to the pattern data for current track
+    xor     eax, eax                            ; eax is the first voice of next track
+    xor     ebx, ebx                            ; ebx is the first voice of current track
+    mov     {{.BP}}, {{.PTRWORD}} su_synth_obj           ; ebp points to the current_voiceno array
+su_update_voices_trackloop:
+        mov     {{.DI}}, [{{.Stack "Song"}}]
+        movzx   eax, byte [{{.SI}}]                     ; eax = current pattern
+        imul    eax, [{{.DI}} + su_song.pattern_length] ; eax = offset to current pattern data
+        add     {{.AX}}, [{{.DI}} + su_song.patterns]
+        movzx   eax, byte [{{.AX}} + {{.DX}}]           ; eax = note
+        push    {{.DX}}                                 ; Stack: ptrnrow
+        xor     edx, edx                            ; edx=0
+        mov     ecx, ebx                            ; ecx=first voice of the track to be done
+su_calculate_voices_loop:                           ; do {
+        bt      dword [{{.Stack "VoiceTrackBitmask"}} + {{.PTRSIZE}}],ecx ; test voicetrack_bitmask// notice that the incs don't set carry
+        inc     edx                                 ;   edx++   // edx=numvoices
+        inc     ecx                                 ;   ecx++   // ecx=the first voice of next track
+        jc      su_calculate_voices_loop          ; } while bit ecx-1 of bitmask is on
+        push    {{.CX}}                                 ; Stack: next_instr ptrnrow
+        cmp     al, {{.Hold}}                    ; anything but hold causes action
+        je      short su_update_voices_nexttrack
+        mov     cl, byte [{{.BP}}]
+        mov     edi, ecx
+        add     edi, ebx
+        shl     edi, 12           ; each unit = 64 bytes and there are 1<<MAX_UNITS_SHIFT units + small header
+{{- .Prepare "su_synth_obj" | indent 4}}
+        inc     dword [{{.Use "su_synth_obj"}} + su_synthworkspace.voices + su_voice.release + {{.DI}}] ; set the voice currently active to release; notice that it could increment any number of times
+        cmp     al, {{.Hold}}                    ; if cl < HLD (no new note triggered)
+        jl      su_update_voices_nexttrack        ;   goto nexttrack
+        inc     ecx                                 ; curvoice++
+        cmp     ecx, edx                            ; if (curvoice >= num_voices)
+        jl      su_update_voices_skipreset
+        xor     ecx,ecx                             ;   curvoice = 0
+su_update_voices_skipreset:
+        mov     byte [{{.BP}}],cl
+        add     ecx, ebx
+        shl     ecx, 12                           ; each unit = 64 bytes and there are 1<<6 units + small header
+        lea     {{.DI}},[{{.Use "su_synth_obj"}} + su_synthworkspace.voices + {{.CX}}]
+        stosd                                       ; save note
+        mov     ecx, (su_voice.size - su_voice.release)/4
+        xor     eax, eax
+        rep stosd                                   ; clear the workspace of the new voice, retriggering oscillators
+su_update_voices_nexttrack:
+        pop     {{.BX}}                                 ; ebx=first voice of next instrument, Stack: ptrnrow
+        pop     {{.DX}}                                 ; edx=patrnrow
+        mov     {{.DI}}, [{{.Stack "Song"}}]
+        add     {{.SI}}, [{{.DI}} + su_song.sequence_length]
+        inc     {{.BP}}
+        mov     eax, [{{.DI}} + su_song.num_tracks]
+{{- .Prepare "su_synth_obj" .AX | indent 8}}
+        lea     {{.AX}}, [{{.Use "su_synth_obj" .AX}}]
+        cmp     {{.BP}}, {{.AX}}
+        jl      su_update_voices_trackloop
+    ret
+{{- else}}
+; The simple implementation: each track triggers always the same voice
+    mov     {{.BP}}, [{{.Stack "Song"}}]                   ; {{.BP}} points to the song
+    xor     edx, edx
+    div     dword [{{.BP}} + su_song.pattern_length]    ; eax = current pattern, edx = current row in pattern
+    mov     {{.SI}}, [{{.BP}} + su_song.tracks]
+    add     {{.SI}}, {{.AX}}                          ; esi points to the pattern data for current track
+    mov     {{.DI}}, {{.PTRWORD}} su_synth_obj+su_synthworkspace.voices
+    mov     ebx, [{{.BP}} + su_song.num_tracks]
+su_update_voices_trackloop:
+        movzx   eax, byte [{{.SI}}]                     ; eax = current pattern
+        imul    eax, [{{.BP}} + su_song.pattern_length] ; multiply by rows per pattern, eax = offset to current pattern data
+        add     {{.AX}}, [{{.BP}} + su_song.patterns]
+        movzx   eax, byte [{{.AX}} + {{.DX}}]           ; eax = note
+        cmp     al, {{.Hold}}                   ; anything but hold causes action
+        je      short su_update_voices_nexttrack
+        inc     dword [{{.DI}}+su_voice.release]        ; set the voice currently active to release; notice that it could increment any number of times
+        jb      su_update_voices_nexttrack        ; if cl < HLD (no new note triggered)  goto nexttrack
+su_update_voices_retrigger:
+        stosd                                       ; save note
+        mov     ecx, (su_voice.size - su_voice.release)/4  ; could be xor ecx, ecx; mov ch,...>>8, but will it actually be smaller after compression?
+        xor     eax, eax
+        rep stosd                                   ; clear the workspace of the new voice, retriggering oscillators
+        jmp     short su_update_voices_skipadd
+su_update_voices_nexttrack:
+        add     {{.DI}}, su_voice.size
+su_update_voices_skipadd:
+        add     {{.SI}}, [{{.BP}} + su_song.sequence_length]
+        dec     ebx
+        jnz     short su_update_voices_trackloop
+    ret
+{{- end}}
+
+{{template "patch.asm" .}}
+
+{{- range $i, $s := .Songs}}
+
+;-------------------------------------------------------------------------------
+;    Patterns of song #{{$i}}
+;-------------------------------------------------------------------------------
+{{$.Data (printf "su_patterns_%v" $i)}}
+{{- range $s.Patterns}}
+    db {{. | toStrings | join ","}}
+{{- end}}
+
+;-------------------------------------------------------------------------------
+;    Tracks of song #{{$i}}
+;-------------------------------------------------------------------------------
+{{$.Data (printf "su_tracks_%v" $i)}}
+{{- range $s.Sequences}}
+    db {{. | toStrings | join ","}}
+{{- end}}
+
+;-------------------------------------------------------------------------------
+;    The code for the patch of song #{{$i}}, basically indices to vm jump table
+;-------------------------------------------------------------------------------
+{{$.Data (printf "su_patch_code_%v" $i)}}
+    db {{$s.Commands | toStrings | join ","}}
+
+;-------------------------------------------------------------------------------
+;    The parameters / inputs to each opcode of song #{{$i}}
+;-------------------------------------------------------------------------------
+{{$.Data (printf "su_patch_parameters_%v" $i)}}
+    db {{$s.Values | toStrings | join ","}}
+
+{{- if $s.Events}}
+
+;-------------------------------------------------------------------------------
+;    Event tables of the tracks of song #{{$i}}: the rows and the values
+;-------------------------------------------------------------------------------
+{{- range $t, $e := $s.Events}}
+{{$.SectData (printf "su_events_%v_%v" $i $t)}}
+{{$.ExportData (printf "su_event_rows_%v_%v" $i $t)}}
+{{- if $e.Rows}}
+    dw {{$e.Rows | toStrings | join ","}}
+{{- end}}
+{{$.ExportData (printf "su_event_values_%v_%v" $i $t)}}
+{{- if $e.Values}}
+    db {{$e.Values | toStrings | join ","}}
+{{- end}}
+{{- end}}
+{{- end}}
+{{- end}}
+
+;-------------------------------------------------------------------------------
+;    The su_song of each song, indexed by su_render_song
+;-------------------------------------------------------------------------------
+{{.Data "su_songs"}}
+{{- range $i, $s := .Songs}}
+    {{$.DPTR}} su_patch_code_{{$i}}, su_patch_parameters_{{$i}}, su_tracks_{{$i}}, su_patterns_{{$i}}
+    {{$.DPTR}} {{$s.VoiceTrackBitmask}}, {{$s.PolyphonyBitmask}}, {{$s.Song.Patch.NumVoices}}, {{$s.Song.SamplesPerRow}}, {{$s.Song.Score.LengthInRows}}, {{$s.PatternLength}}, {{$s.SequenceLength}}, {{len $s.Song.Score.Tracks}}
+{{- end}}
+
+{{- if gt (.SampleOffsets | len) 0}}
+;-------------------------------------------------------------------------------
+;    Sample offsets, shared by all the songs
+;-------------------------------------------------------------------------------
+{{.Data "su_sample_offsets"}}
+{{- range .SampleOffsets}}
+    dd {{.Start}}
+    dw {{.LoopStart}}
+    dw {{.LoopLength}}
+{{- end}}
+{{end}}
+
+{{- if gt (.DelayTimes | len ) 0}}
+;-------------------------------------------------------------------------------
+;    Delay times, shared by all the songs
+;-------------------------------------------------------------------------------
+{{.Data "su_delay_times"}}
+    dw {{.DelayTimes | toStrings | join ","}}
+{{end}}
+
+;-------------------------------------------------------------------------------
+;    Constants
+;-------------------------------------------------------------------------------
+{{.SectData "constants"}}
+{{.Constants}}
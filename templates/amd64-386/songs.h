@@ -0,0 +1,101 @@
+// auto-generated by Sointu, editing not recommended
+#ifndef SU_RENDER_H
+#define SU_RENDER_H
+
+#define SU_NUM_SONGS            {{len .Songs}}
+#define SU_SAMPLE_RATE          44100
+{{- range $i, $s := .Songs}}
+
+// song #{{$i}}
+#define SU_LENGTH_IN_SAMPLES_{{$i}}  {{$s.MaxSamples}}
+#define SU_BUFFER_LENGTH_{{$i}}      (SU_LENGTH_IN_SAMPLES_{{$i}}*2)
+#define SU_BPM_{{$i}}                {{$s.Song.BPM}}
+#define SU_ROWS_PER_BEAT_{{$i}}      {{$s.Song.RowsPerBeat}}
+#define SU_ROWS_PER_PATTERN_{{$i}}   {{$s.Song.Score.RowsPerPattern}}
+#define SU_LENGTH_IN_PATTERNS_{{$i}} {{$s.Song.Score.Length}}
+#define SU_LENGTH_IN_ROWS_{{$i}}     (SU_LENGTH_IN_PATTERNS_{{$i}}*SU_ROWS_PER_PATTERN_{{$i}})
+#define SU_SAMPLES_PER_ROW_{{$i}}    (SU_SAMPLE_RATE*60/(SU_BPM_{{$i}}*SU_ROWS_PER_BEAT_{{$i}}))
+{{- if or $.RowSync ($.HasOp "sync")}}
+{{- if $.RowSync}}
+#define SU_NUMSYNCS_{{$i}}           {{add1 $s.Song.Patch.NumSyncs}}
+{{- else}}
+#define SU_NUMSYNCS_{{$i}}           {{$s.Song.Patch.NumSyncs}}
+{{- end}}
+#define SU_SYNCBUFFER_LENGTH_{{$i}}  ((SU_LENGTH_IN_SAMPLES_{{$i}}+255)>>8)*SU_NUMSYNCS_{{$i}}
+{{- end}}
+{{- end}}
+
+// the longest of the songs, for allocating one buffer for all of them
+#define SU_MAX_LENGTH_IN_SAMPLES {{.MaxSamples}}
+#define SU_MAX_BUFFER_LENGTH     (SU_MAX_LENGTH_IN_SAMPLES*2)
+
+#include <stdint.h>
+#if UINTPTR_MAX == 0xffffffff
+    #if defined(__clang__) || defined(__GNUC__)
+        #define SU_CALLCONV __attribute__ ((stdcall))
+    #elif defined(_WIN32)
+        #define SU_CALLCONV __stdcall
+    #endif
+#else
+    #define SU_CALLCONV
+#endif
+
+{{- if .Output16Bit}}
+typedef short SUsample;
+#define SU_SAMPLE_RANGE 32767.0
+#define SU_SAMPLE_PCM16
+{{- else}}
+typedef float SUsample;
+#define SU_SAMPLE_RANGE 1.0
+#define SU_SAMPLE_FLOAT
+{{- end}}
+
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+{{- if or .RowSync (.HasOp "sync")}}
+#define SU_SYNC
+{{- end}}
+// su_render_song renders the song #song, 0 to SU_NUM_SONGS-1, to the buffer.
+// The songs share the synth, so only one of them can be rendered at a time.
+void SU_CALLCONV su_render_song(SUsample *buffer, int song);
+
+{{- if .Events}}
+
+// The event tables of the tracks, e.g. for syncing visuals to the music. Track
+// T of song N has SU_EVENTS_N_T events: su_event_rows_N_T[i] is the row of the
+// ith event and su_event_values_N_T[i] its value: the note of a note-on or, in
+// an effect track (SU_EVENTS_N_T_EFFECT), the value of the track from that row
+// on. Multiply the rows by SU_SAMPLES_PER_ROW_N to get the times in samples,
+// unless speed units change the tempo.
+#define SU_EVENTS
+{{- range $i, $s := .Songs}}
+{{- range $t, $e := $s.Events}}
+#define SU_EVENTS_{{$i}}_{{$t}} {{len $e.Rows}}
+{{- if $e.Effect}}
+#define SU_EVENTS_{{$i}}_{{$t}}_EFFECT
+{{- end}}
+extern const unsigned short su_event_rows_{{$i}}_{{$t}}[];
+extern const unsigned char su_event_values_{{$i}}_{{$t}}[];
+{{- end}}
+{{- end}}
+{{- end}}
+
+{{- if and (gt (.SampleOffsets | len) 0) (eq (.SampleData | len) 0)}}
+// The sample table should contain the sample bank file (gm.dls or a .sf2) as
+// int16s before rendering. su_load_gmdls loads gm.dls into it on Windows; on
+// other platforms, read at most SU_SAMPLE_TABLE_SIZE bytes of the file into it.
+#define SU_SAMPLE_TABLE_SIZE    {{max 3440660 (mul 2 .SampleTableLength)}}
+extern short su_sample_table[];
+void SU_CALLCONV su_load_gmdls();
+#define SU_LOAD_GMDLS
+{{- end}}
+
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
@@ -322,8 +322,15 @@ su_oscillat_gate_bit:                           ; stack: 0/1, let's call it x
 {{- .Prepare "su_sample_offsets" | indent 4}}
     lea     {{.DI}}, [{{.Use "su_sample_offsets"}} + {{.AX}}*8]; edi points now to the sample table entry
 {{- end}}
+{{- $eightBit := false}}
+{{- if .Library}}
 {{- .Float 84.28074964676522 | .Prepare | indent 4}}
     fmul    dword [{{.Float 84.28074964676522 | .Use}}]                  ; p*r
+{{- else}}
+{{- $eightBit = .SampleFormat.EightBit}}
+{{- .Float .SamplePhaseScale | .Prepare | indent 4}}
+    fmul    dword [{{.Float .SamplePhaseScale | .Use}}]                  ; p*r, r is smaller if the embedded samples are downsampled
+{{- end}}
     fistp   dword [{{.SP}}]
     pop     {{.DX}}                                             ; edx is now the sample number
     movzx   ebx, word [{{.DI}} + 4]    ; ecx = loopstart
@@ -337,9 +344,18 @@ su_oscillat_sample_not_looping:
     add     edx, ebx                                        ; sampleno += loopstart
     add     edx, dword [{{.DI}}]
 {{- .Prepare "su_sample_table" | indent 4}}
+{{- if $eightBit}}
+    movsx   edx, byte [{{.Use "su_sample_table"}} + {{.DX}}]      ; 8-bit samples
+    push    {{.DX}}
+    fild    dword [{{.SP}}]
+    pop     {{.DX}}
+{{- .Float 127.99609375 | .Prepare | indent 4}}
+    fdiv    dword [{{.Float 127.99609375 | .Use}}]           ; 32767/256, so 8-bit samples have the same scale as 16-bit samples
+{{- else}}
     fild    word [{{.Use "su_sample_table"}} + {{.DX}}*2]
 {{- .Float 32767.0 | .Prepare | indent 4}}
     fdiv    dword [{{.Float 32767.0 | .Use}}]
+{{- end}}
     {{- .PopRegs .AX .DX .CX .BX .DI | indent 4}}
     ret
 {{end}}
@@ -0,0 +1,728 @@
+// auto-generated by Sointu, editing not recommended
+{{- $gmdls := and (gt (.SampleOffsets | len) 0) (eq (.SampleData | len) 0)}}
+{{- $sync := or .RowSync (.HasOp "sync")}}
+#include <math.h>
+#include <stdint.h>
+#include <string.h>
+{{- if $gmdls}}
+#include <stdio.h>
+#include <stdlib.h>
+{{- end}}
+
+{{- if .Output16Bit}}
+typedef short SUsample;
+{{- else}}
+typedef float SUsample;
+{{- end}}
+
+//-------------------------------------------------------------------------------
+//   Opcodes: 0 advances to the next voice, the rest are the units in the order
+//   of the instructions of the feature set
+//-------------------------------------------------------------------------------
+enum {
+    su_op_advance,
+{{- range .Instructions}}
+    su_op_{{.}},
+{{- end}}
+};
+
+//-------------------------------------------------------------------------------
+//   Structs: the memory layout is the same as in the assembly players, so that
+//   the send addresses can be used as such
+//-------------------------------------------------------------------------------
+typedef struct su_unit {
+    float state[8];
+    float ports[8];
+} su_unit;
+
+typedef struct su_voice {
+    int note;
+    int release;
+    float inputs[8];
+    float reserved[6];
+    su_unit units[63];
+} su_voice;
+
+typedef struct su_synth {
+    unsigned char curvoices[32]; // which voice is playing on which track
+    float outputs[8];            // left, right and 3 auxiliary signals
+    su_voice voices[32];
+} su_synth;
+
+{{- if .HasOp "delay"}}
+
+typedef struct su_delayline {
+    float dcin;
+    float dcout;
+    float filtstate;
+    float buffer[65536];
+} su_delayline;
+{{- end}}
+
+{{- if gt (.SampleOffsets | len) 0}}
+
+typedef struct su_sample_offset {
+    uint32_t start;
+    uint16_t loopstart;
+    uint16_t looplength;
+} su_sample_offset;
+{{- end}}
+
+//-------------------------------------------------------------------------------
+//   Patterns
+//-------------------------------------------------------------------------------
+static const unsigned char su_patterns[] = {
+{{- range .Patterns}}
+    {{. | toStrings | join ","}},
+{{- end}}
+};
+
+//-------------------------------------------------------------------------------
+//   Tracks
+//-------------------------------------------------------------------------------
+static const unsigned char su_tracks[] = {
+{{- range .Sequences}}
+    {{. | toStrings | join ","}},
+{{- end}}
+};
+
+{{- if gt (.SampleOffsets | len) 0}}
+
+//-------------------------------------------------------------------------------
+//   Sample offsets
+//-------------------------------------------------------------------------------
+static const su_sample_offset su_sample_offsets[] = {
+{{- range .SampleOffsets}}
+    {{"{"}}{{.Start}},{{.LoopStart}},{{.LoopLength}}{{"}"}},
+{{- end}}
+};
+{{- end}}
+
+{{- if gt (.DelayTimes | len) 0}}
+
+//-------------------------------------------------------------------------------
+//   Delay times
+//-------------------------------------------------------------------------------
+static const uint16_t su_delay_times[] = {
+    {{.DelayTimes | toStrings | join ","}}
+};
+{{- end}}
+
+//-------------------------------------------------------------------------------
+//   The code for this patch, basically indices to the opcodes
+//-------------------------------------------------------------------------------
+static const unsigned char su_patch_code[] = {
+    {{.Commands | toStrings | join ","}}
+};
+
+//-------------------------------------------------------------------------------
+//   The parameters / inputs to each opcode
+//-------------------------------------------------------------------------------
+static const unsigned char su_patch_parameters[] = {
+    {{if .Values}}{{.Values | toStrings | join ","}}{{else}}0{{end}}
+};
+
+//-------------------------------------------------------------------------------
+//   The number of transformed parameters each opcode takes
+//-------------------------------------------------------------------------------
+static const unsigned char su_vm_transformcounts[] = {
+    0{{range .Instructions}},{{$.TransformCount .}}{{end}}
+};
+
+{{- if .Events}}
+
+//-------------------------------------------------------------------------------
+//   Event tables of the tracks: the rows and the values of the events
+//-------------------------------------------------------------------------------
+{{- range $i, $e := .Events}}
+const unsigned short su_event_rows_{{$i}}[] = {
+    {{if $e.Rows}}{{$e.Rows | toStrings | join ","}}{{else}}0{{end}}
+};
+const unsigned char su_event_values_{{$i}}[] = {
+    {{if $e.Values}}{{$e.Values | toStrings | join ","}}{{else}}0{{end}}
+};
+{{- end}}
+{{- end}}
+
+{{- if gt (.SampleOffsets | len) 0}}
+{{- if $gmdls}}
+
+//-------------------------------------------------------------------------------
+//   Sample table, loaded from gm.dls (or a .sf2) by the user
+//-------------------------------------------------------------------------------
+short su_sample_table[{{div (max 3440660 (mul 2 .SampleTableLength)) 2}}];
+
+void su_load_gmdls(void) {
+    char path[1024];
+    const char *root = getenv("SystemRoot");
+    FILE *f;
+    snprintf(path, sizeof(path), "%s/System32/drivers/gm.dls", root != NULL ? root : "C:/Windows");
+    if ((f = fopen(path, "rb")) != NULL) {
+        if (fread(su_sample_table, 1, sizeof(su_sample_table), f) == 0)
+            su_sample_table[0] = 0;
+        fclose(f);
+    }
+}
+{{- else}}
+
+//-------------------------------------------------------------------------------
+//   Samples embedded in the instruments
+//-------------------------------------------------------------------------------
+{{- if .SampleFormat.EightBit}}
+static const signed char su_sample_table[] = {
+{{- range $i, $v := .SampleData}}{{if $i}},{{end}}{{if eq (mod $i 32) 0}}
+    {{end}}{{div $v 256}}{{end}}
+};
+{{- else}}
+static const short su_sample_table[] = {
+{{- range $i, $v := .SampleData}}{{if $i}},{{end}}{{if eq (mod $i 32) 0}}
+    {{end}}{{$v}}{{end}}
+};
+{{- end}}
+{{- end}}
+{{- end}}
+
+//-------------------------------------------------------------------------------
+//   Uninitialized data: the synth object and the state of the player
+//-------------------------------------------------------------------------------
+static su_synth su_synth_obj;
+{{- if .HasOp "delay"}}
+static su_delayline su_delaylines[{{max 1 .Song.Patch.NumDelayLines}}];
+{{- end}}
+{{- if .HasOp "noise"}}
+static uint32_t su_randseed;
+{{- end}}
+static uint32_t su_globaltick;
+static int su_row;
+static int su_sample;
+{{- if $sync}}
+extern float syncBuf[];
+static float *su_syncbuf;
+{{- end}}
+
+{{- if or (.HasOp "envelope") (.HasOp "compressor")}}
+
+// su_nonlinear_map returns 2^(-24*x), where x is the parameter in the range 0-1
+static float su_nonlinear_map(float x) {
+    return (float)exp2(-24.0 * x);
+}
+{{- end}}
+
+{{- if or (.HasOp "distort") (.HasOp "noise") (.HasOp "oscillator")}}
+
+// su_waveshaper "distorts" signal x by amount a: x*a/(1-a+(2*a-1)*abs(x))
+static float su_waveshaper(float x, float a) {
+    return x * a / (1 - a + (2 * a - 1) * fabsf(x));
+}
+{{- end}}
+
+{{- if or (.HasOp "clip") .Output16Bit .Clip}}
+
+// su_clip clips the signal into [-1,1] range
+static float su_clip(float x) {
+    return x < -1 ? -1 : (x > 1 ? 1 : x);
+}
+{{- end}}
+
+{{- if .SupportsParamValue "oscillator" "type" .Sample}}
+
+// su_oscillator_sample returns the value of the sample at the given phase;
+// the sample number is the "color" parameter of the oscillator
+static float su_oscillator_sample(int sample, long double phase) {
+    const su_sample_offset *offset = &su_sample_offsets[sample];
+    int index = (int)lrintl(phase * (float){{printf "%.9g" .SamplePhaseScale}});
+    if (index >= offset->loopstart && offset->looplength > 0)
+        index = (index - offset->loopstart) % offset->looplength + offset->loopstart;
+{{- if and (not $gmdls) .SampleFormat.EightBit}}
+    return su_sample_table[offset->start + index] / 127.99609375f; // 32767/256, so 8-bit samples have the same scale as 16-bit samples
+{{- else}}
+    return su_sample_table[offset->start + index] / 32767.0f;
+{{- end}}
+}
+{{- end}}
+
+//-------------------------------------------------------------------------------
+//   su_update_voices: triggers and releases the voices for a new row
+//-------------------------------------------------------------------------------
+static void su_update_voices(int row) {
+    int pattern = row / {{.PatternLength}}, patternrow = row % {{.PatternLength}};
+    int track, note, numvoices, firstvoice = 0;
+    su_voice *voice;
+    for (track = 0; track < {{len .Sequences}}; track++) {
+        // the bits of the voices of a track are set, except for the last voice
+        for (numvoices = 1; ({{.VoiceTrackBitmask}}u >> (firstvoice + numvoices - 1)) & 1; numvoices++)
+            ;
+        note = su_patterns[su_tracks[track * {{.SequenceLength}} + pattern] * {{.PatternLength}} + patternrow];
+        if (note != {{.Hold}}) { // anything but hold causes action
+            su_synth_obj.voices[firstvoice + su_synth_obj.curvoices[track]].release++;
+            if (note > {{.Hold}}) { // retrigger the next voice of the track
+                su_synth_obj.curvoices[track] = (su_synth_obj.curvoices[track] + 1) % numvoices;
+                voice = &su_synth_obj.voices[firstvoice + su_synth_obj.curvoices[track]];
+                memset(voice, 0, sizeof(*voice));
+                voice->note = note;
+            }
+        }
+        firstvoice += numvoices;
+    }
+}
+
+//-------------------------------------------------------------------------------
+//   su_run_vm: runs the entire virtual machine once, creating 1 sample
+//-------------------------------------------------------------------------------
+static void su_run_vm(void) {
+    const unsigned char *com = su_patch_code, *val = su_patch_parameters;
+{{- if .SupportsPolyphony}}
+    const unsigned char *com_instr = com, *val_instr = val;
+{{- end}}
+    su_voice *voice = su_synth_obj.voices;
+    su_unit *unit = voice->units;
+{{- if .HasOp "delay"}}
+    su_delayline *delayline = su_delaylines;
+{{- end}}
+    float *outputs = su_synth_obj.outputs;
+    float stack[64], *sp = stack, params[8];
+    int voices_remain = {{.Song.Patch.NumVoices}}, stereo, i;
+    unsigned char op;
+{{- if .RowSync}}
+    if ((su_globaltick & 255) == 0) // write the current fractional row as sync #0
+        *su_syncbuf++ = su_row + (float)su_sample / {{.Song.SamplesPerRow}};
+{{- end}}
+    for (;;) {
+        op = *com++;
+        stereo = op & 1;
+        if ((op >> 1) == su_op_advance) {
+            voice++;
+            unit = voice->units;
+            if (--voices_remain == 0)
+                return;
+{{- if .SupportsPolyphony}}
+            if (({{.PolyphonyBitmask}}u >> voices_remain) & 1) { // the next voice uses the same instrument
+                com = com_instr;
+                val = val_instr;
+            } else {
+                com_instr = com;
+                val_instr = val;
+            }
+{{- end}}
+            continue;
+        }
+        for (i = 0; i < su_vm_transformcounts[op >> 1]; i++) {
+            params[i] = *val++ * 0.0078125f + unit->ports[i]; // scale to 0-1 and add the modulations
+            unit->ports[i] = 0;
+        }
+        switch (op >> 1) {
+{{- if .HasOp "add"}}
+        case su_op_add: // a b -> a+b b, stereo: a b c d -> a+c b+d c d
+            sp[-1] += sp[-2 - stereo];
+            if (stereo)
+                sp[-2] += sp[-4];
+            break;
+{{- end}}
+{{- if .HasOp "addp"}}
+        case su_op_addp: // a b -> a+b, stereo: a b c d -> a+c b+d
+            sp[-2 - stereo] += sp[-1];
+            if (stereo)
+                sp[-4] += sp[-2];
+            sp -= 1 + stereo;
+            break;
+{{- end}}
+{{- if .HasOp "mul"}}
+        case su_op_mul: // a b -> a*b b, stereo: a b c d -> a*c b*d c d
+            sp[-1] *= sp[-2 - stereo];
+            if (stereo)
+                sp[-2] *= sp[-4];
+            break;
+{{- end}}
+{{- if .HasOp "mulp"}}
+        case su_op_mulp: // a b -> a*b, stereo: a b c d -> a*c b*d
+            sp[-2 - stereo] *= sp[-1];
+            if (stereo)
+                sp[-4] *= sp[-2];
+            sp -= 1 + stereo;
+            break;
+{{- end}}
+{{- if .HasOp "xch"}}
+        case su_op_xch: { // a b -> b a, stereo: a b c d -> c d a b
+            float t;
+            for (i = 1; i <= 1 + stereo; i++) {
+                t = sp[-i];
+                sp[-i] = sp[-i - 1 - stereo];
+                sp[-i - 1 - stereo] = t;
+            }
+            break;
+        }
+{{- end}}
+{{- if .HasOp "push"}}
+        case su_op_push: // a -> a a, stereo: a b -> a b a b
+            for (i = 0; i <= stereo; i++, sp++)
+                sp[0] = sp[-1 - stereo];
+            break;
+{{- end}}
+{{- if .HasOp "pop"}}
+        case su_op_pop:
+            sp -= 1 + stereo;
+            break;
+{{- end}}
+{{- if .HasOp "loadval"}}
+        case su_op_loadval:
+            for (i = 0; i <= stereo; i++)
+                *sp++ = params[0] * 2 - 1;
+            break;
+{{- end}}
+{{- if .HasOp "loadnote"}}
+        case su_op_loadnote:
+            for (i = 0; i <= stereo; i++)
+                *sp++ = voice->note / 64.0f - 1;
+            break;
+{{- end}}
+{{- if .HasOp "receive"}}
+        case su_op_receive:
+            for (i = stereo; i >= 0; i--) {
+                *sp++ = unit->ports[i];
+                unit->ports[i] = 0;
+            }
+            break;
+{{- end}}
+{{- if .HasOp "in"}}
+        case su_op_in: { // push and clear a global port (out or aux)
+            int channel = *val++;
+            for (i = stereo; i >= 0; i--) {
+                *sp++ = outputs[channel + i];
+                outputs[channel + i] = 0;
+            }
+            break;
+        }
+{{- end}}
+{{- if .HasOp "envelope"}}
+        case su_op_envelope: // state 0 = attack, 1 = decay, 3 = release
+            if (voice->release)
+                unit->state[0] = 3;
+            if (unit->state[0] == 0) {
+                unit->state[1] += su_nonlinear_map(params[0]);
+                if (unit->state[1] >= 1) {
+                    unit->state[1] = 1;
+                    unit->state[0] = 1;
+                }
+            } else if (unit->state[0] == 1) {
+                unit->state[1] -= su_nonlinear_map(params[1]);
+                if (unit->state[1] <= params[2])
+                    unit->state[1] = params[2];
+            } else {
+                unit->state[1] -= su_nonlinear_map(params[3]);
+                if (unit->state[1] <= 0)
+                    unit->state[1] = 0;
+            }
+            for (i = 0; i <= stereo; i++)
+                *sp++ = unit->state[1] * params[4];
+            break;
+{{- end}}
+{{- if .HasOp "noise"}}
+        case su_op_noise:
+            for (i = 0; i <= stereo; i++) {
+                su_randseed *= 16007;
+                *sp++ = su_waveshaper((int32_t)su_randseed / -2147483648.0f, params[0]) * params[1];
+            }
+            break;
+{{- end}}
+{{- if .HasOp "oscillator"}}
+        case su_op_oscillator: {
+            unsigned char flags = *val++; // the color and shape values are at val[-4] and val[-3]
+            float detune_stereo = params[1] * 2 - 1, detune, output, amplitude, *statevar;
+            // the phase is computed in extended precision, like the x87 FPU
+            // does, so that the waveforms flip at exactly the same samples
+            long double pitch, phase;
+            int j, unison = flags & 3;
+            for (i = 0; i <= stereo; i++) {
+                detune = detune_stereo;
+                output = 0;
+                for (j = 0; j <= unison; j++) {
+                    statevar = &unit->state[i + j * 2];
+                    pitch = (long double)(params[0] - 0.5f) / 0.0078125f + detune;
+                    if (!(flags & 0x08)) // if lfo is disabled, add note to oscillator transpose
+                        pitch += voice->note;
+                    // from semitones to octaves; the constant scales middle-C where it should be or LFOs to a reasonable range
+                    phase = exp2l(pitch * 0.08333333f) * ((flags & 0x08) ? 0.000038f : 0.000092696138f) + *statevar;
+                    amplitude = 0;
+{{- if .SupportsParamValue "oscillator" "type" .Sample}}
+                    if (flags & 0x80) { // for samples, the phase is not wrapped, as it is the position in the sample
+                        *statevar = (float)phase;
+                        amplitude = su_oscillator_sample(val[-4], phase + params[2]);
+                    } else
+{{- end}}
+                    {
+                        // mod(p+1,1) instead of mod(p,1), to keep the phase positive; without the modulo, the
+                        // pitch of the oscillator would drift as the precision of the phase decreases
+                        phase = fmodl(phase + 1, 1);
+                        *statevar = (float)phase;
+{{- if or (.SupportsParamValueOtherThan "oscillator" "phase" 0) (.SupportsModulation "oscillator" "phase")}}
+                        phase = fmodl(phase + params[2] + 1, 1);
+{{- end}}
+{{- if or (.SupportsParamValue "oscillator" "type" .Sine) (.SupportsParamValue "oscillator" "type" .Trisaw) (.SupportsParamValue "oscillator" "type" .Pulse)}}
+                        long double color = params[3];
+{{- end}}
+{{- if .SupportsParamValue "oscillator" "type" .Sine}}
+                        if ((flags & 0x40) && color >= phase)
+                            amplitude = (float)sinl(6.283185307179586477L * (phase / color));
+{{- end}}
+{{- if .SupportsParamValue "oscillator" "type" .Trisaw}}
+                        if (flags & 0x20) {
+                            if (color < phase) {
+                                phase = 1 - phase;
+                                color = 1 - color;
+                            }
+                            amplitude = (float)(phase / color * 2 - 1);
+                        }
+{{- end}}
+{{- if .SupportsParamValue "oscillator" "type" .Pulse}}
+                        if (flags & 0x10)
+                            amplitude = color >= phase ? 1.0f : -1.0f;
+{{- end}}
+{{- if .SupportsParamValue "oscillator" "type" .Gate}}
+                        if (flags & 0x04) { // the gate bits are stored in color and shape; low pass the transitions
+                            amplitude = (float)(((val[-4] | val[-3] << 8) >> ((int)lrintl(phase * 16) & 15)) & 1);
+                            amplitude += 0.99609375f * (unit->state[4 + i] - amplitude);
+                            unit->state[4 + i] = amplitude;
+                        }
+{{- end}}
+                    }
+{{- if .SupportsParamValue "oscillator" "type" .Gate}}
+                    if (flags & 0x04) // wave shaping is skipped with gate
+                        output += amplitude * params[5];
+                    else
+{{- end}}
+                    output += su_waveshaper(amplitude, params[4]) * params[5];
+                    if (j < unison)
+                        params[2] += 0.08333333f; // 1/12, add small phase shift so all oscillators don't start in phase
+                    detune = -detune * 0.5f;
+                }
+                *sp++ = output;
+                detune_stereo = -detune_stereo;
+            }
+            break;
+        }
+{{- end}}
+{{- if .HasOp "distort"}}
+        case su_op_distort:
+            for (i = 1; i <= 1 + stereo; i++)
+                sp[-i] = su_waveshaper(sp[-i], params[0]);
+            break;
+{{- end}}
+{{- if .HasOp "hold"}}
+        case su_op_hold: { // sample and hold the signal, reducing sample rate
+            float phase;
+            for (i = 0; i <= stereo; i++) {
+                phase = unit->state[i] - params[0] * params[0];
+                if (phase <= 0) {
+                    unit->state[2 + i] = sp[-1 - i];
+                    phase += 1;
+                }
+                sp[-1 - i] = unit->state[2 + i];
+                unit->state[i] = phase;
+            }
+            break;
+        }
+{{- end}}
+{{- if .HasOp "crush"}}
+        case su_op_crush:
+            for (i = 1; i <= 1 + stereo; i++)
+                sp[-i] = (float)(rint((double)sp[-i] / params[0]) * params[0]);
+            break;
+{{- end}}
+{{- if .HasOp "gain"}}
+        case su_op_gain:
+            for (i = 1; i <= 1 + stereo; i++)
+                sp[-i] *= params[0];
+            break;
+{{- end}}
+{{- if .HasOp "invgain"}}
+        case su_op_invgain:
+            for (i = 1; i <= 1 + stereo; i++)
+                sp[-i] /= params[0];
+            break;
+{{- end}}
+{{- if .HasOp "clip"}}
+        case su_op_clip:
+            for (i = 1; i <= 1 + stereo; i++)
+                sp[-i] = su_clip(sp[-i]);
+            break;
+{{- end}}
+{{- if .HasOp "filter"}}
+        case su_op_filter: {
+            unsigned char flags = *val++;
+            float freq2 = params[0] * params[0], low, band, high, output;
+            for (i = 0; i <= stereo; i++) {
+                low = unit->state[i] + freq2 * unit->state[2 + i];
+                high = sp[-1 - i] - low - params[1] * unit->state[2 + i];
+                band = unit->state[2 + i] + freq2 * high;
+                unit->state[i] = low;
+                unit->state[2 + i] = band;
+                output = 0;
+                if (flags & 0x40)
+                    output += low;
+                if (flags & 0x20)
+                    output += band;
+                if (flags & 0x10)
+                    output += high;
+                if (flags & 0x08)
+                    output -= band;
+                if (flags & 0x04)
+                    output -= high;
+                sp[-1 - i] = output;
+            }
+            break;
+        }
+{{- end}}
+{{- if .HasOp "pan"}}
+        case su_op_pan: // s -> s*(1-p) s*p, stereo: l r -> l*(1-p) r*p
+            if (!stereo) {
+                sp[0] = sp[-1];
+                sp++;
+            }
+            sp[-2] *= params[0];
+            sp[-1] *= 1 - params[0];
+            break;
+{{- end}}
+{{- if .HasOp "delay"}}
+        case su_op_delay: { // the right channel uses the first count delay lines and the left channel the rest
+            int index = *val++, count = *val++, j;
+            uint16_t t = (uint16_t)su_globaltick;
+            float pregain2 = params[0] * params[0], signal, output, delay, s;
+            for (i = stereo; i >= 0; i--) {
+                signal = sp[-1 - i];
+                output = params[1] * signal; // dry output
+                for (j = 0; j < count; j += 2, index++, delayline++) {
+                    delay = su_delay_times[index] + unit->ports[4] * 32767;
+{{- if .SupportsParamValue "delay" "notetracking" 1}}
+                    if (!(count & 1)) // note tracking
+                        delay /= (float)exp2l(voice->note * 0.08333333f);
+{{- end}}
+                    s = delayline->buffer[(uint16_t)(t - lrintf(delay))];
+                    output += s;
+                    delayline->filtstate = params[3] * delayline->filtstate + (1 - params[3]) * s; // damping
+                    delayline->buffer[t] = params[2] * delayline->filtstate + pregain2 * signal;
+                }
+                // dc filter, using the state of the last delay line of the channel
+                delayline[-1].dcout = output + (0.99609375f * delayline[-1].dcout - delayline[-1].dcin);
+                delayline[-1].dcin = output;
+                sp[-1 - i] = delayline[-1].dcout;
+            }
+            unit->ports[4] = 0;
+            break;
+        }
+{{- end}}
+{{- if .HasOp "compressor"}}
+        case su_op_compressor: { // push the gain of the compressor on the stack
+            float level = sp[-1] * sp[-1], threshold2 = params[3] * params[3], gain = 1;
+            if (stereo)
+                level += sp[-2] * sp[-2];
+            // attack if the signal is above the current level, release otherwise
+            unit->state[0] += (level - unit->state[0]) * su_nonlinear_map(level < unit->state[0] ? params[1] : params[0]);
+            if (unit->state[0] > threshold2)
+                gain = (float)pow(threshold2 / unit->state[0], params[4] / 2);
+            gain /= params[2]; // apply inverse gain
+            for (i = 0; i <= stereo; i++)
+                *sp++ = gain;
+            break;
+        }
+{{- end}}
+{{- if .HasOp "out"}}
+        case su_op_out:
+            outputs[0] += params[0] * sp[-1];
+            if (stereo)
+                outputs[1] += params[0] * sp[-2];
+            sp -= 1 + stereo;
+            break;
+{{- end}}
+{{- if .HasOp "outaux"}}
+        case su_op_outaux:
+            for (i = 0; i <= stereo; i++) {
+                outputs[i] += params[0] * sp[-1 - i];
+                outputs[2 + i] += params[1] * sp[-1 - i];
+            }
+            sp -= 1 + stereo;
+            break;
+{{- end}}
+{{- if .HasOp "aux"}}
+        case su_op_aux: {
+            int channel = *val++;
+            for (i = 0; i <= stereo; i++)
+                outputs[channel + i] += params[0] * sp[-1 - i];
+            sp -= 1 + stereo;
+            break;
+        }
+{{- end}}
+{{- if .HasOp "send"}}
+        case su_op_send: { // add the signal to a port, addressed as in the assembly players
+            unsigned int addr = val[0] | val[1] << 8;
+            su_voice *target = voice;
+            su_unit *targetunit;
+            val += 2;
+{{- if .SupportsGlobalSend}}
+            if (addr & 0x8000) {
+                addr -= 0x8010;
+                target = &su_synth_obj.voices[addr >> 10];
+            }
+{{- end}}
+            targetunit = &target->units[((addr & 0x3F0) >> 4) - 1];
+            for (i = 0; i <= stereo; i++)
+                targetunit->ports[(addr & 7) + i] += sp[-1 - i] * (params[0] * 2 - 1);
+            if (addr & 8) // pop
+                sp -= 1 + stereo;
+            break;
+        }
+{{- end}}
+{{- if .HasOp "speed"}}
+        case su_op_speed: { // add or subtract whole ticks to the row, a value of 0.5 is neutral
+            float r = unit->state[0] + (float)exp2(*--sp * 2.206896551724138) - 1;
+            int w = (int)lrintf(r); // round to nearest, like the x87 players
+            unit->state[0] = r - w;
+            su_sample += w;
+            break;
+        }
+{{- end}}
+{{- if .HasOp "sync"}}
+        case su_op_sync: // save the stack top to the sync buffer every 256 samples
+            if ((su_globaltick & 255) == 0)
+                *su_syncbuf++ = sp[-1];
+            break;
+{{- end}}
+        }
+        unit++;
+    }
+}
+
+//-------------------------------------------------------------------------------
+//   su_render_song: the entry point for the synth. Renders the compile time
+//   hard-coded song to the buffer.
+//-------------------------------------------------------------------------------
+void su_render_song(SUsample *buffer) {
+    int i;
+    memset(&su_synth_obj, 0, sizeof(su_synth_obj));
+{{- if .HasOp "delay"}}
+    memset(su_delaylines, 0, sizeof(su_delaylines));
+{{- end}}
+{{- if .HasOp "noise"}}
+    su_randseed = 1;
+{{- end}}
+    su_globaltick = 0;
+{{- if $sync}}
+    su_syncbuf = syncBuf;
+{{- end}}
+    for (su_row = 0; su_row < {{.Song.Score.LengthInRows}}; su_row++) {
+        su_update_voices(su_row);
+        for (su_sample = 0; su_sample < {{.Song.SamplesPerRow}}; su_sample++) {
+            su_run_vm();
+            for (i = 0; i < 2; i++) { // left & right
+{{- if .Output16Bit}}
+                *buffer++ = (SUsample)lrintf(su_clip(su_synth_obj.outputs[i]) * 32767.0f);
+{{- else if .Clip}}
+                *buffer++ = su_clip(su_synth_obj.outputs[i]);
+{{- else}}
+                *buffer++ = su_synth_obj.outputs[i];
+{{- end}}
+                su_synth_obj.outputs[i] = 0; // clear the sample so the VM is ready to write it
+            }
+            su_globaltick++;
+        }
+    }
+}
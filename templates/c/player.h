@@ -0,0 +1,100 @@
+// auto-generated by Sointu, editing not recommended
+{{.MetadataComments "// " -}}
+#ifndef SU_RENDER_H
+#define SU_RENDER_H
+
+#define SU_LENGTH_IN_SAMPLES    {{.MaxSamples}}
+#define SU_BUFFER_LENGTH        (SU_LENGTH_IN_SAMPLES*2)
+
+#define SU_SAMPLE_RATE          44100
+#define SU_BPM                  {{.Song.BPM}}
+#define SU_ROWS_PER_BEAT        {{.Song.RowsPerBeat}}
+#define SU_ROWS_PER_PATTERN     {{.Song.Score.RowsPerPattern}}
+#define SU_LENGTH_IN_PATTERNS   {{.Song.Score.Length}}
+#define SU_LENGTH_IN_ROWS       (SU_LENGTH_IN_PATTERNS*SU_ROWS_PER_PATTERN)
+#define SU_SAMPLES_PER_ROW      (SU_SAMPLE_RATE*60/(SU_BPM*SU_ROWS_PER_BEAT))
+{{- with .Song.Title}}
+#define SU_TITLE                {{$.CString .}}
+{{- end}}
+{{- with .Song.Author}}
+#define SU_AUTHOR               {{$.CString .}}
+{{- end}}
+{{- with .Song.Comment}}
+#define SU_COMMENT              {{$.CString .}}
+{{- end}}
+{{- with .Song.License}}
+#define SU_LICENSE              {{$.CString .}}
+{{- end}}
+
+{{- if or .RowSync (.HasOp "sync")}}
+{{- if .RowSync}}
+#define SU_NUMSYNCS             {{add1 .Song.Patch.NumSyncs}}
+{{- else}}
+#define SU_NUMSYNCS             {{.Song.Patch.NumSyncs}}
+{{- end}}
+#define SU_SYNCBUFFER_LENGTH    ((SU_LENGTH_IN_SAMPLES+255)>>8)*SU_NUMSYNCS
+{{- end}}
+
+// The C player uses the default calling convention of the compiler; the macro
+// is defined only for compatibility with the headers of the assembly players.
+#define SU_CALLCONV
+
+{{- if .Output16Bit}}
+typedef short SUsample;
+#define SU_SAMPLE_RANGE 32767.0
+#define SU_SAMPLE_PCM16
+{{- else}}
+typedef float SUsample;
+#define SU_SAMPLE_RANGE 1.0
+#define SU_SAMPLE_FLOAT
+{{- end}}
+
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+{{- if or .RowSync (.HasOp "sync")}}
+#define SU_SYNC
+// The syncs are written to syncBuf, which should be defined by the user and
+// hold at least SU_SYNCBUFFER_LENGTH floats.
+extern float syncBuf[];
+{{- end}}
+void SU_CALLCONV su_render_song(SUsample *buffer);
+
+{{- if .Events}}
+
+// The event tables of the tracks, e.g. for syncing visuals to the music. Track
+// T has SU_EVENTS_T events: su_event_rows_T[i] is the row of the ith event and
+// su_event_values_T[i] its value: the note of a note-on or, in an effect track
+// (SU_EVENTS_T_EFFECT), the value of the track from that row on. Multiply the
+// rows by SU_SAMPLES_PER_ROW to get the times in samples, unless speed units
+// change the tempo.
+#define SU_EVENTS
+{{- range $i, $e := .Events}}
+#define SU_EVENTS_{{$i}} {{len $e.Rows}}
+{{- if $e.Effect}}
+#define SU_EVENTS_{{$i}}_EFFECT
+{{- end}}
+extern const unsigned short su_event_rows_{{$i}}[];
+extern const unsigned char su_event_values_{{$i}}[];
+{{- end}}
+{{- end}}
+
+{{- if and (gt (.SampleOffsets | len) 0) (eq (.SampleData | len) 0)}}
+// The sample table should contain the sample bank file (gm.dls or a .sf2) as
+// int16s before rendering. su_load_gmdls loads gm.dls into it from the Windows
+// system directory; on other platforms, read at most SU_SAMPLE_TABLE_SIZE bytes
+// of the file into it.
+#define SU_SAMPLE_TABLE_SIZE    {{max 3440660 (mul 2 .SampleTableLength)}}
+extern short su_sample_table[];
+void SU_CALLCONV su_load_gmdls(void);
+#define SU_LOAD_GMDLS
+{{- end}}
+
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
@@ -210,7 +210,7 @@
     call $pop
     call $pop
 {{- if .StereoAndMono "xch"}}
-    (if (local.get $stereo) (then
+    (if (param f32 f32) (result f32 f32) (local.get $stereo) (then ;; the popped values are passed to the branches
 {{- end}}
 {{- if .Stereo "xch"}}
         call $pop  ;; F: d       P: c b a
@@ -0,0 +1,231 @@
+// auto-generated by Sointu, editing not recommended
+//
+// Javascript glue for the WebAssembly library of Sointu. Assemble sointu.wat
+// into sointu.wasm first, e.g. with: wat2wasm --enable-bulk-memory sointu.wat
+//
+// The same module works on the main thread and as the AudioWorklet module: in
+// the AudioWorkletGlobalScope, it registers the "sointu" processor, and on the
+// main thread, SointuNode.create adds the module to the AudioWorklet of an
+// AudioContext and creates a node playing the processor. Sointu can also be
+// used directly, e.g. to render offline.
+//
+// The patches are vm.BytePatches of Sointu, encoded as JSON. sointu-compile
+// outputs them with the -patch flag. The library supports only the features it
+// was compiled with: all the features of the VM, or the ones given with
+// sointu-compile -features. The patches should be encoded for the same
+// features, i.e. output with the same -features flag as the library.
+
+export const SAMPLE_RATE = 44100;
+export const MAX_VOICES = 32;
+
+const VOICE_SIZE = 4096; // bytes
+const MAX_COMMANDS = 2048;
+const MAX_VALUES = 16384;
+const MAX_DELAY_TIMES = 768;
+const MAX_SAMPLE_OFFSETS = 256;
+
+// the math functions wasm does not have; imported by the .wasm as m.pow etc.
+const imports = { m: { pow: Math.pow, log2: Math.log2, sin: Math.sin } };
+
+// bytes converts a []byte of a BytePatch to Uint8Array. Go encodes []byte as
+// base64; atob is not available in the AudioWorkletGlobalScope, so decode it
+// here.
+function bytes(value) {
+    if (typeof value !== 'string') {
+        return Uint8Array.from(value || []);
+    }
+    const alphabet = 'ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/';
+    const digits = value.replace(/=+$/, '');
+    const ret = new Uint8Array(Math.floor(digits.length * 3 / 4));
+    let acc = 0, bits = 0, j = 0;
+    for (const c of digits) {
+        acc = ((acc << 6) | alphabet.indexOf(c)) & 0xFFFFFF;
+        bits += 6;
+        if (bits >= 8) {
+            bits -= 8;
+            ret[j++] = (acc >> bits) & 255;
+        }
+    }
+    return ret;
+}
+
+// Sointu is an instance of the wasm library: a synth with up to 32 voices,
+// playing a patch that can be changed at runtime.
+export class Sointu {
+    // module is the compiled sointu.wasm, a WebAssembly.Module
+    constructor(module) {
+        this.exports = new WebAssembly.Instance(module, imports).exports;
+        this.memory = this.exports.m.buffer;
+        this.maxBlockLength = this.exports.su_max_block_length.value;
+        this.output = new Float32Array(this.memory, this.exports.su_outputbuffer.value, this.maxBlockLength * 2);
+        this.voices = new Int32Array(this.memory, this.exports.su_voices.value, MAX_VOICES * VOICE_SIZE / 4);
+        this.instruments = [];
+    }
+
+    static async load(url) {
+        const response = await fetch(url);
+        return new Sointu(await WebAssembly.compile(await response.arrayBuffer()));
+    }
+
+    // setPatch copies the patch to the memory of the synth and resets the
+    // synth
+    setPatch(patch) {
+        const e = this.exports;
+        const commands = bytes(patch.Commands);
+        const values = bytes(patch.Values);
+        const delayTimes = patch.DelayTimes || [];
+        const sampleOffsets = patch.SampleOffsets || [];
+        const sampleData = patch.SampleData || [];
+        const numVoices = patch.NumVoices || 0;
+        if (commands.length > MAX_COMMANDS || values.length > MAX_VALUES ||
+            delayTimes.length > MAX_DELAY_TIMES || sampleOffsets.length > MAX_SAMPLE_OFFSETS ||
+            sampleData.length * 2 > e.su_sample_table_size.value || numVoices > MAX_VOICES) {
+            throw new Error('the patch is too large for the Sointu library');
+        }
+        e.su_reset();
+        new Uint8Array(this.memory, e.su_patch_code.value, MAX_COMMANDS).fill(0).set(commands);
+        new Uint8Array(this.memory, e.su_patch_parameters.value, MAX_VALUES).fill(0).set(values);
+        new Uint16Array(this.memory, e.su_delay_times.value, MAX_DELAY_TIMES).fill(0).set(delayTimes);
+        const offsets = new DataView(this.memory, e.su_sample_offsets.value, MAX_SAMPLE_OFFSETS * 8);
+        sampleOffsets.forEach((s, i) => {
+            offsets.setUint32(i * 8, s.Start, true);
+            offsets.setUint16(i * 8 + 4, s.LoopStart, true);
+            offsets.setUint16(i * 8 + 6, s.LoopLength, true);
+        });
+        if (sampleData.length > 0) {
+            new Int16Array(this.memory, e.su_sample_table.value, sampleData.length).set(sampleData);
+        }
+        const polyphony = patch.PolyphonyBitmask >>> 0;
+        new DataView(this.memory).setUint32(e.su_polyphony.value, polyphony, true);
+        new DataView(this.memory).setUint32(e.su_numvoices.value, numVoices, true);
+        // find out the voices of each instrument: bit numVoices-v of the
+        // polyphony bitmask tells if voice v uses the same instrument as v-1
+        this.instruments = [];
+        for (let v = 0; v < numVoices; v++) {
+            if (v === 0 || !((polyphony >>> (numVoices - v)) & 1)) {
+                this.instruments.push({ first: v, count: 0, next: 0 });
+            }
+            this.instruments[this.instruments.length - 1].count++;
+        }
+    }
+
+    // loadSampleTable copies a sample bank file, e.g. gm.dls, to the sample
+    // table, for patches using samples that are not embedded in the patch
+    loadSampleTable(buffer) {
+        const size = Math.min(buffer.byteLength, this.exports.su_sample_table_size.value);
+        new Uint8Array(this.memory, this.exports.su_sample_table.value, size).set(new Uint8Array(buffer, 0, size));
+    }
+
+    reset() {
+        this.exports.su_reset();
+    }
+
+    // trigger starts playing the note with the voice
+    trigger(voice, note) {
+        this.exports.su_trigger(voice, note);
+    }
+
+    // release releases the note played by the voice
+    release(voice) {
+        this.exports.su_release(voice);
+    }
+
+    // noteOn plays the note with the next voice of the instrument, cycling
+    // through its voices like the tracker does
+    noteOn(instrument, note) {
+        const instr = this.instruments[instrument];
+        if (!instr) {
+            return;
+        }
+        this.trigger(instr.first + instr.next, note);
+        instr.next = (instr.next + 1) % instr.count;
+    }
+
+    // noteOff releases the voices of the instrument playing the note
+    noteOff(instrument, note) {
+        const instr = this.instruments[instrument];
+        if (!instr) {
+            return;
+        }
+        for (let v = instr.first; v < instr.first + instr.count; v++) {
+            const i = v * VOICE_SIZE / 4;
+            if (this.voices[i] === note && this.voices[i + 1] === 0) {
+                this.release(v);
+            }
+        }
+    }
+
+    // render renders samples stereo samples and returns them interleaved (L R L
+    // R ...) as a view to the memory of the synth, valid until the next call.
+    // At most maxBlockLength samples are rendered at once.
+    render(samples) {
+        samples = Math.min(samples, this.maxBlockLength);
+        this.exports.su_render(samples);
+        return this.output.subarray(0, samples * 2);
+    }
+}
+
+// the methods of Sointu that SointuNode can call in the processor
+const methods = ['setPatch', 'loadSampleTable', 'reset', 'trigger', 'release', 'noteOn', 'noteOff'];
+
+if (typeof AudioWorkletProcessor !== 'undefined') {
+    class SointuProcessor extends AudioWorkletProcessor {
+        constructor(options) {
+            super();
+            this.synth = new Sointu(options.processorOptions.module);
+            this.port.onmessage = (event) => {
+                const [method, ...args] = event.data;
+                if (methods.includes(method)) {
+                    this.synth[method](...args);
+                }
+            };
+        }
+
+        process(inputs, outputs) {
+            const [left, right] = outputs[0];
+            const buffer = this.synth.render(left.length);
+            for (let i = 0; i < left.length; i++) {
+                left[i] = buffer[i * 2];
+                right[i] = buffer[i * 2 + 1];
+            }
+            return true;
+        }
+    }
+    registerProcessor('sointu', SointuProcessor);
+}
+
+// SointuNode controls a Sointu playing in an AudioWorklet. The methods are the
+// same as of Sointu, except render: the audio is rendered by the node.
+export class SointuNode {
+    constructor(node) {
+        this.node = node;
+        for (const method of methods) {
+            this[method] = (...args) => node.port.postMessage([method, ...args]);
+        }
+    }
+
+    // create returns a new SointuNode in the context. The context should run at
+    // 44100 Hz, e.g. new AudioContext({ sampleRate: SAMPLE_RATE }).
+    static async create(context, wasmUrl = new URL('sointu.wasm', import.meta.url)) {
+        if (context.sampleRate !== SAMPLE_RATE) {
+            throw new Error('the sample rate of the AudioContext should be ' + SAMPLE_RATE);
+        }
+        const response = await fetch(wasmUrl);
+        const module = await WebAssembly.compile(await response.arrayBuffer());
+        await context.audioWorklet.addModule(import.meta.url);
+        const node = new AudioWorkletNode(context, 'sointu', {
+            numberOfInputs: 0,
+            outputChannelCount: [2],
+            processorOptions: { module },
+        });
+        return new SointuNode(node);
+    }
+
+    connect(...args) {
+        return this.node.connect(...args);
+    }
+
+    disconnect(...args) {
+        return this.node.disconnect(...args);
+    }
+}
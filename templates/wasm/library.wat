@@ -0,0 +1,234 @@
+(module
+{{- $blockLength := 4096}}
+
+{{- /*
+;-------------------------------------------------------------------------------
+; The number of transformed parameters each opcode takes. The table is indexed
+; with the opcode, starting from 1, so the first byte is for the advance opcode
+; and keeps the offsets to the table positive.
+;-------------------------------------------------------------------------------
+*/}}
+{{- .DataB 0}}
+{{- .SetDataLabel "su_vm_transformcounts"}}
+{{- range .Instructions}}
+{{- $.TransformCount . | $.ToByte | $.DataB}}
+{{- end}}
+
+{{- /*
+;-------------------------------------------------------------------------------
+; Allocate memory for stack.
+; Note: as the stack grows _downwards_ the label is _after_ stack
+;-------------------------------------------------------------------------------
+*/}}
+{{- .Align}}
+{{- .Block 256}}
+{{- .SetBlockLabel "su_stack"}}
+
+{{- /*
+;-------------------------------------------------------------------------------
+; Allocate memory for transformed values.
+;-------------------------------------------------------------------------------
+*/}}
+{{- .Align}}
+{{- .SetBlockLabel "su_transformedvalues"}}
+{{- .Block 32}}
+
+{{- /*
+;-------------------------------------------------------------------------------
+; The patch is not known at compile time, so the tables are uninitialized memory
+; that is filled from javascript before rendering. The sizes are the same as in
+; the Synth struct of the x86 library.
+;-------------------------------------------------------------------------------
+*/}}
+{{- .Align}}
+{{- .SetBlockLabel "su_patch_code"}}
+{{- .Block 2048}}
+{{- .SetBlockLabel "su_patch_parameters"}}
+{{- .Block 16384}}
+{{- .SetBlockLabel "su_delay_times"}}
+{{- .Block 1536}}
+{{- .SetBlockLabel "su_sample_offsets"}}
+{{- .Block 2048}}
+{{- .SetBlockLabel "su_polyphony"}}
+{{- .Block 4}}
+{{- .SetBlockLabel "su_numvoices"}}
+{{- .Block 4}}
+
+{{- /*
+;-------------------------------------------------------------------------------
+; Uninitialized memory for synth, delaylines, outputbuffer & sample table
+;-------------------------------------------------------------------------------
+*/}}
+{{- .Align}}
+{{- .SetBlockLabel "su_synth"}}
+{{- .Block 32}}
+{{- .SetBlockLabel "su_globalports"}}
+{{- .Block 32}}
+{{- .SetBlockLabel "su_voices"}}
+{{- .Block 131072}}
+{{- .Align}}
+{{- .SetBlockLabel "su_delaylines"}}
+{{- .Block (int (mul 262156 64))}}
+{{- .Align}}
+{{- .SetBlockLabel "su_outputbuffer"}}
+{{- .Block (int (mul $blockLength 8))}}
+{{- .Align}}
+{{- .SetBlockLabel "su_sample_table"}}
+{{- .Block 3440660}}
+
+
+;;------------------------------------------------------------------------------
+;; Import the difficult math functions from javascript
+;;------------------------------------------------------------------------------
+(func $pow (import "m" "pow") (param f32) (param f32) (result f32))
+(func $log2 (import "m" "log2") (param f32) (result f32))
+(func $sin (import "m" "sin") (param f32) (result f32))
+
+;;------------------------------------------------------------------------------
+;; Types. Only useful to define the jump table type, which is
+;; (int stereo) void
+;;------------------------------------------------------------------------------
+(type $opcode_func_signature (func (param i32)))
+
+;;------------------------------------------------------------------------------
+;; The one and only memory
+;;------------------------------------------------------------------------------
+(memory (export "m") {{.MemoryPages}})
+
+;;------------------------------------------------------------------------------
+;; Globals
+;;------------------------------------------------------------------------------
+(global $WRK (mut i32) (i32.const 0))
+(global $COM (mut i32) (i32.const 0))
+(global $VAL (mut i32) (i32.const 0))
+(global $COM_instr_start (mut i32) (i32.const 0))
+(global $VAL_instr_start (mut i32) (i32.const 0))
+(global $delayWRK (mut i32) (i32.const 0))
+(global $globaltick (mut i32) (i32.const 0))
+(global $sample (mut i32) (i32.const 0))
+(global $voice (mut i32) (i32.const 0))
+(global $voicesRemain (mut i32) (i32.const 0))
+(global $randseed (mut i32) (i32.const 1))
+(global $sp (mut i32) (i32.const {{index .Labels "su_stack"}}))
+(global $outputBufPtr (mut i32) (i32.const {{index .Labels "su_outputbuffer"}}))
+;; the addresses of the tables, so that javascript can fill them
+(global (export "su_patch_code") i32 (i32.const {{index .Labels "su_patch_code"}}))
+(global (export "su_patch_parameters") i32 (i32.const {{index .Labels "su_patch_parameters"}}))
+(global (export "su_delay_times") i32 (i32.const {{index .Labels "su_delay_times"}}))
+(global (export "su_sample_offsets") i32 (i32.const {{index .Labels "su_sample_offsets"}}))
+(global (export "su_polyphony") i32 (i32.const {{index .Labels "su_polyphony"}}))
+(global (export "su_numvoices") i32 (i32.const {{index .Labels "su_numvoices"}}))
+(global (export "su_voices") i32 (i32.const {{index .Labels "su_voices"}}))
+(global (export "su_outputbuffer") i32 (i32.const {{index .Labels "su_outputbuffer"}}))
+(global (export "su_max_block_length") i32 (i32.const {{$blockLength}}))
+(global (export "su_sample_table") i32 (i32.const {{index .Labels "su_sample_table"}}))
+(global (export "su_sample_table_size") i32 (i32.const 3440660))
+
+;;------------------------------------------------------------------------------
+;; Functions to emulate FPU stack in software
+;;------------------------------------------------------------------------------
+(func $peek (result f32)
+    (f32.load (global.get $sp))
+)
+
+(func $peek2 (result f32)
+    (f32.load offset=4 (global.get $sp))
+)
+
+(func $pop (result f32)
+    (call $peek)
+    (global.set $sp (i32.add (global.get $sp) (i32.const 4)))
+)
+
+(func $push (param $value f32)
+    (global.set $sp (i32.sub (global.get $sp) (i32.const 4)))
+    (f32.store (global.get $sp) (local.get $value))
+)
+
+;;------------------------------------------------------------------------------
+;; Helper functions
+;;------------------------------------------------------------------------------
+(func $swap (param f32 f32) (result f32 f32) ;; x,y -> y,x
+    local.get 1
+    local.get 0
+)
+
+(func $scanValueByte (result i32)        ;; scans positions $VAL for a byte, incrementing $VAL afterwards
+    (i32.load8_u (global.get $VAL))      ;; in other words: returns byte [$VAL++]
+    (global.set $VAL (i32.add (global.get $VAL) (i32.const 1))) ;; $VAL++
+)
+
+;;------------------------------------------------------------------------------
+;; su_render(samples): renders samples stereo float samples (L R L R ...) to
+;; su_outputbuffer, continuing from where the previous call left. samples
+;; should be at most su_max_block_length. Returns the number of time ticks
+;; advanced, which differs from samples only if the patch has speed units.
+;;------------------------------------------------------------------------------
+(func (export "su_render") (param $samples i32) (result i32) (local $i i32)
+    (global.set $outputBufPtr (i32.const {{index .Labels "su_outputbuffer"}}))
+    (global.set $sample (i32.const 0))
+    (local.set $i (i32.const 0))
+    block $sample_block
+    loop $sample_loop
+        (br_if $sample_block (i32.ge_s (local.get $i) (local.get $samples)))
+        (global.set $COM (i32.const {{index .Labels "su_patch_code"}}))
+        (global.set $VAL (i32.const {{index .Labels "su_patch_parameters"}}))
+        (global.set $COM_instr_start (global.get $COM))
+        (global.set $VAL_instr_start (global.get $VAL))
+        (global.set $WRK (i32.const {{index .Labels "su_voices"}}))
+        (global.set $voice (i32.const {{index .Labels "su_voices"}}))
+        (global.set $voicesRemain (i32.load (i32.const {{index .Labels "su_numvoices"}})))
+        (global.set $delayWRK (i32.const {{index .Labels "su_delaylines"}}))
+        (if (global.get $voicesRemain)(then ;; without a patch, just output silence
+            (call $su_run_vm)
+        ))
+        {{- template "output_sound.wat" .}}
+        (global.set $sample (i32.add (global.get $sample) (i32.const 1)))
+        (global.set $globaltick (i32.add (global.get $globaltick) (i32.const 1)))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        br $sample_loop
+    end
+    end
+    (global.get $sample)
+)
+
+;;------------------------------------------------------------------------------
+;; su_trigger(voice, note): clears the state of the voice and starts playing
+;; the note with it
+;;------------------------------------------------------------------------------
+(func (export "su_trigger") (param $voice i32) (param $note i32) (local $di i32)
+    (local.set $di (i32.add
+        (i32.mul (local.get $voice) (i32.const 4096))
+        (i32.const {{index .Labels "su_voices"}})
+    ))
+    (memory.fill (local.get $di) (i32.const 0) (i32.const 4096))
+    (i32.store (local.get $di) (local.get $note))
+)
+
+;;------------------------------------------------------------------------------
+;; su_release(voice): releases the note played by the voice
+;;------------------------------------------------------------------------------
+(func (export "su_release") (param $voice i32)
+    (i32.store offset={{add (index .Labels "su_voices") 4}}
+        (i32.mul (local.get $voice) (i32.const 4096))
+        (i32.const 1)
+    )
+)
+
+;;------------------------------------------------------------------------------
+;; su_reset(): silences all voices and clears the delay lines, e.g. after
+;; changing the patch
+;;------------------------------------------------------------------------------
+(func (export "su_reset")
+    (memory.fill (i32.const {{index .Labels "su_synth"}}) (i32.const 0) (i32.const {{sub (index .Labels "su_outputbuffer") (index .Labels "su_synth")}}))
+    (global.set $globaltick (i32.const 0))
+    (global.set $randseed (i32.const 1))
+)
+
+{{template "patch.wat" .}}
+
+
+;; All data is collected into a byte buffer and emitted at once
+(data (i32.const 0) "{{range .Data}}\{{. | printf "%02x"}}{{end}}")
+
+) ;; END MODULE
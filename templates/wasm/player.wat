@@ -46,6 +46,23 @@
 {{- $.DataB .}}
 {{- end}}
 
+{{- /*
+;-------------------------------------------------------------------------------
+;    Event tables of the tracks: the number of events (word), the rows (words)
+;    and the values (bytes) of the events
+;-------------------------------------------------------------------------------
+*/}}
+{{- range $i, $e := .Events}}
+{{- $.SetDataLabel (printf "su_events_%v" $i)}}
+{{- len $e.Rows | $.ToWord | $.DataW}}
+{{- range $e.Rows}}
+{{- $.ToWord . | $.DataW}}
+{{- end}}
+{{- range $e.Values}}
+{{- $.DataB .}}
+{{- end}}
+{{- end}}
+
 {{- /*
 ;-------------------------------------------------------------------------------
 ;    Delay times
@@ -56,6 +73,24 @@
 {{- $.DataW .}}
 {{- end}}
 
+{{- if gt (.SampleOffsets | len) 0}}
+{{- /*
+;-------------------------------------------------------------------------------
+;    Sample offsets and the samples embedded in the instruments
+;-------------------------------------------------------------------------------
+*/}}
+{{- .SetDataLabel "su_sample_offsets"}}
+{{- range .SampleOffsets}}
+{{- $.DataD .Start}}
+{{- $.DataW .LoopStart}}
+{{- $.DataW .LoopLength}}
+{{- end}}
+{{- .SetDataLabel "su_sample_table"}}
+{{- range .EncodedSamples}}
+{{- $.DataB .}}
+{{- end}}
+{{- end}}
+
 {{- /*
 ;-------------------------------------------------------------------------------
 ; The number of transformed parameters each opcode takes
@@ -109,9 +144,9 @@
 {{- .Align}}
 {{- .SetBlockLabel "su_outputbuffer"}}
 {{- if .Output16Bit}}
-{{- .Block (int (mul .PatternLength .SequenceLength .Song.SamplesPerRow 4))}}
+{{- .Block (int (mul .Song.Score.LengthInRows .Song.SamplesPerRow 4))}}
 {{- else}}
-{{- .Block (int (mul .PatternLength .SequenceLength .Song.SamplesPerRow 8))}}
+{{- .Block (int (mul .Song.Score.LengthInRows .Song.SamplesPerRow 8))}}
 {{- end}}
 {{- .SetBlockLabel "su_outputend"}}
 
@@ -160,8 +195,11 @@
 ;; TODO: only export start and length with certain compiler options; in demo use, they can be hard coded
 ;; in the intro
 (global $outputStart (export "s") i32 (i32.const {{index .Labels "su_outputbuffer"}}))
-(global $outputLength (export "l") i32 (i32.const {{if .Output16Bit}}{{mul .PatternLength .SequenceLength .Song.SamplesPerRow 4}}{{else}}{{mul .PatternLength .SequenceLength .Song.SamplesPerRow 8}}{{end}}))
+(global $outputLength (export "l") i32 (i32.const {{if .Output16Bit}}{{mul .Song.Score.LengthInRows .Song.SamplesPerRow 4}}{{else}}{{mul .Song.Score.LengthInRows .Song.SamplesPerRow 8}}{{end}}))
 (global $output16bit (export "t") i32 (i32.const {{if .Output16Bit}}1{{else}}0{{end}}))
+{{- range $i, $e := .Events}}
+(global (export "e{{$i}}") i32 (i32.const {{index $.Labels (printf "su_events_%v" $i)}}))
+{{- end}}
 
 
 ;;------------------------------------------------------------------------------
@@ -230,7 +268,18 @@
                 (br_if $sample_loop (i32.lt_s (global.get $sample) (i32.const {{.Song.SamplesPerRow}})))
             end
             (global.set $row (i32.add (global.get $row) (i32.const 1)))
+{{- if eq (mod .Song.Score.LengthInRows .PatternLength) 0}}
             (br_if $row_loop (i32.lt_s (global.get $row) (i32.const {{.PatternLength}})))
+{{- else}}
+            ;; the last pattern is only partially played, so also stop at the end of the song
+            (br_if $row_loop (i32.and
+                (i32.lt_s (global.get $row) (i32.const {{.PatternLength}}))
+                (i32.lt_s
+                    (i32.add (i32.mul (global.get $pattern) (i32.const {{.PatternLength}})) (global.get $row))
+                    (i32.const {{.Song.Score.LengthInRows}})
+                )
+            ))
+{{- end}}
         end
         (global.set $pattern (i32.add (global.get $pattern) (i32.const 1)))
         (br_if $pattern_loop (i32.lt_s (global.get $pattern) (i32.const {{.SequenceLength}})))
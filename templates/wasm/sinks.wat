@@ -196,3 +196,14 @@
     (global.set $sample (i32.add (global.get $sample) (local.get $w)))
 )
 {{end}}
+
+{{- if .HasOp "sync"}}
+;;-------------------------------------------------------------------------------
+;;   SYNC opcode: save the stack top to sync buffer
+;;-------------------------------------------------------------------------------
+;;   The wasm player & library do not output syncs, so this is a NOP, but it is
+;;   needed so that patches with sync units can be played.
+;;-------------------------------------------------------------------------------
+(func $su_op_sync (param $stereo i32)
+)
+{{end}}
@@ -106,10 +106,14 @@
 (func $su_op_oscillator (param $stereo i32) (local $flags i32) (local $detune f32) (local $phase f32) (local $color f32) (local $amplitude f32)
 {{- if .SupportsParamValueOtherThan "oscillator" "unison" 0}}
     (local $unison i32) (local $WRK_stash i32) (local $detune_stash f32)
+{{- end}}
+{{- if .Stereo "oscillator"}}
+    (local $WRK_stereo_stash i32)
 {{- end}}
     (local.set $flags (call $scanValueByte))
     (local.set $detune (call $inputSigned (i32.const {{.InputNumber "oscillator" "detune"}})))
 {{- if .Stereo "oscillator"}}
+    (local.set $WRK_stereo_stash (global.get $WRK))
     loop $stereoLoop
 {{- end}}
 {{- if .SupportsParamValueOtherThan "oscillator" "unison" 0}}
@@ -144,10 +148,25 @@
                     ))
                     (f32.add (f32.load (global.get $WRK))) ;; add the current phase of the oscillator
                 )
+{{- if .SupportsParamValue "oscillator" "type" .Sample}}
+                (select
+                    (f32.const 0) ;; for samples, we store the phase without mod(p,1)
+                    (f32.floor (local.get $phase))
+                    (i32.and (local.get $flags) (i32.const 0x80))
+                )
+{{- else}}
                 (f32.floor (local.get $phase))
+{{- end}}
             )
         )
     )
+{{- if .SupportsParamValue "oscillator" "type" .Sample}}
+    (if (i32.and (local.get $flags) (i32.const 0x80)) (then
+        (local.set $amplitude (call $oscillator_sample
+            (f32.add (local.get $phase) (call $input (i32.const {{.InputNumber "oscillator" "phase"}})))
+        ))
+    ))
+{{- end}}
     (f32.add (local.get $phase) (call $input (i32.const {{.InputNumber "oscillator" "phase"}})))
     (local.set $phase (f32.sub (local.tee $phase) (f32.floor (local.get $phase)))) ;; phase = phase mod 1.0
     (local.set $color (call $input (i32.const {{.InputNumber "oscillator" "color"}})))
@@ -202,9 +221,10 @@
 {{- end}}
 {{- if .Stereo "oscillator"}}
     (local.set $detune (f32.neg (local.get $detune))) ;; flip the detune for secon round
-    (global.set $WRK (i32.add (global.get $WRK) (i32.const 4))) ;; WARNING: this is a bug. WRK should be nonvolatile, but we are changing it. It does not cause immediate problems but modulations will be off.
+    (global.set $WRK (i32.add (global.get $WRK) (i32.const 4))) ;; the other channel uses the next phase
     (br_if $stereoLoop (i32.eqz (local.tee $stereo (i32.eqz (local.get $stereo)))))
     end
+    (global.set $WRK (local.get $WRK_stereo_stash)) ;; WRK should be nonvolatile, otherwise the modulations of the following units are off
 {{- end}}
 )
 
@@ -246,6 +266,38 @@
 )
 {{end}}
 
+{{- if .SupportsParamValue "oscillator" "type" .Sample}}
+(func $oscillator_sample (param $phase f32) (result f32) (local $offset i32) (local $index i32) (local $loopstart i32)
+    (local.set $offset (i32.add ;; sample offsets are 8 bytes each: start (dword), loopstart (word), looplength (word)
+        (i32.shl (i32.load8_u (i32.sub (global.get $VAL) (i32.const 4))) (i32.const 3)) ;; reuse "color" as the sample number
+        (i32.const {{index .Labels "su_sample_offsets"}})
+    ))
+    (local.set $index (i32.trunc_f32_s (f32.nearest (f32.mul (local.get $phase) (f32.const {{.SamplePhaseScale}})))))
+    (local.set $loopstart (i32.load16_u offset=4 (local.get $offset)))
+    (if (i32.ge_s (local.get $index) (local.get $loopstart)) (then
+        (local.set $index (i32.add
+            (i32.rem_u
+                (i32.sub (local.get $index) (local.get $loopstart))
+                (i32.load16_u offset=6 (local.get $offset))
+            )
+            (local.get $loopstart)
+        ))
+    ))
+    (local.set $index (i32.add (local.get $index) (i32.load (local.get $offset))))
+{{- if .SampleFormat.EightBit}}
+    (f32.div
+        (f32.convert_i32_s (i32.load8_s offset={{index .Labels "su_sample_table"}} (local.get $index)))
+        (f32.const 127.99609375) ;; 32767/256, so 8-bit samples have the same scale as 16-bit samples
+    )
+{{- else}}
+    (f32.div
+        (f32.convert_i32_s (i32.load16_s offset={{index .Labels "su_sample_table"}} (i32.shl (local.get $index) (i32.const 1))))
+        (f32.const 32767)
+    )
+{{- end}}
+)
+{{end}}
+
 {{- if .SupportsParamValue "oscillator" "type" .Gate}}
 (func $oscillator_gate (param $phase f32) (result f32) (local $x f32)
     (f32.store offset=16 (global.get $WRK)
@@ -42,7 +42,17 @@ int main(int argc, char* argv[]) {
     su_load_gmdls();
     #endif
 
+#ifdef TEST_CHUNK
+    // render a bit, seek back to the start and then render the whole song in
+    // chunks, which should give the same output as su_render_song
+    su_render_chunk(buf, 1000);
+    su_seek(0);
+    for (n = 0; n < SU_LENGTH_IN_SAMPLES; n += 1000) {
+        su_render_chunk(buf + n * 2, SU_LENGTH_IN_SAMPLES - n < 1000 ? SU_LENGTH_IN_SAMPLES - n : 1000);
+    }
+#else
     su_render_song(buf);
+#endif
 
 #if defined (_WIN32)
     CreateDirectory(actual_output_folder, NULL);
@@ -0,0 +1,67 @@
+#include <stdio.h>
+#include <stdlib.h>
+#include <math.h>
+
+#include TEST_HEADER
+
+// The test expects a player compiled from three songs; the expected outputs of
+// the songs are given as arguments, in the same order.
+static const int lengths[] = {
+    SU_BUFFER_LENGTH_0,
+    SU_BUFFER_LENGTH_1,
+    SU_BUFFER_LENGTH_2,
+};
+
+SUsample buf[SU_MAX_BUFFER_LENGTH];
+SUsample filebuf[SU_MAX_BUFFER_LENGTH];
+
+static int test_song(int i, const char *expected) {
+    FILE* f;
+    long fsize;
+    float diff;
+    int n;
+
+    su_render_song(buf, i);
+
+    f = fopen(expected, "rb");
+    if (f == NULL) {
+        fprintf(stderr, "No expected waveform found for song %d!\n", i);
+        return 1;
+    }
+    fseek(f, 0, SEEK_END);
+    fsize = ftell(f);
+    fseek(f, 0, SEEK_SET);
+    if (lengths[i] * sizeof(SUsample) != fsize) {
+        fprintf(stderr, "Sointu rendered song %d with different length than expected\n", i);
+        fclose(f);
+        return 1;
+    }
+    fread((void*)filebuf, fsize, 1, f);
+    fclose(f);
+
+    for (n = 0; n < lengths[i]; n++) {
+        diff = (float)fabs((float)(buf[n] - filebuf[n])/SU_SAMPLE_RANGE);
+        if (diff > 1e-3f || isnan(diff)) {
+            fprintf(stderr, "Sointu rendered song %d different than expected\n", i);
+            return 1;
+        }
+    }
+    return 0;
+}
+
+int main(int argc, char* argv[]) {
+    int i;
+
+    if (argc < 4) {
+        fprintf(stderr, "usage: [test] path/to/expected_wave0.raw path/to/expected_wave1.raw path/to/expected_wave2.raw");
+        return 1;
+    }
+    for (i = 0; i < 3; i++) {
+        if (test_song(i, argv[i + 1])) {
+            return 1;
+        }
+    }
+    // render the first song again, to check that the previous songs left
+    // nothing behind in the synth
+    return test_song(0, argv[1]);
+}
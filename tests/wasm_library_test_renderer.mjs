@@ -0,0 +1,87 @@
+// Renders a song with the wasm library of Sointu, triggering and releasing the
+// notes of the song the same way as the compiled players do, and compares the
+// result to the expected output.
+import fs from 'fs';
+import path from 'path';
+import { pathToFileURL } from 'url';
+import { exit } from 'process';
+
+if (process.argv.length <= 5) {
+  console.log("Usage: wasm_library_test_renderer.mjs path/to/sointu.wasm path/to/song.json path/to/song.patch.json path/to/expected_output.raw")
+  console.log("sointu.js should be in the same directory as sointu.wasm. Output the song and the patch with sointu-compile -j and sointu-compile -patch")
+  exit(2)
+}
+
+const [wasmFile, songFile, patchFile, expectedFile] = process.argv.slice(2);
+
+async function main() {
+  const { Sointu } = await import(pathToFileURL(path.join(path.dirname(wasmFile), 'sointu.js')));
+  const synth = new Sointu(await WebAssembly.compile(fs.readFileSync(wasmFile)));
+  const song = JSON.parse(fs.readFileSync(songFile));
+  synth.setPatch(JSON.parse(fs.readFileSync(patchFile)));
+
+  const samplesPerRow = Math.floor(44100 * 60 / (song.BPM * song.RowsPerBeat));
+  const rowsPerPattern = song.Score.RowsPerPattern;
+  const lengthInRows = song.Score.Length * rowsPerPattern;
+  let firstVoice = 0;
+  const tracks = song.Score.Tracks.map(track => {
+    const ret = {
+      firstVoice,
+      numVoices: track.NumVoices,
+      currentVoice: 0,
+      patterns: track.Patterns.map(p => Buffer.from(p || '', 'base64')),
+      order: track.Order || [],
+    };
+    firstVoice += track.NumVoices;
+    return ret;
+  });
+  const output = new Float32Array(lengthInRows * samplesPerRow * 2);
+  for (let row = 0; row < lengthInRows; row++) {
+    for (const track of tracks) {
+      const pattern = track.patterns[track.order[Math.floor(row / rowsPerPattern)]];
+      const note = pattern && row % rowsPerPattern < pattern.length ? pattern[row % rowsPerPattern] : 0;
+      if (note === 1) { // hold
+        continue;
+      }
+      synth.release(track.firstVoice + track.currentVoice);
+      if (note > 1) {
+        track.currentVoice = (track.currentVoice + 1) % track.numVoices;
+        synth.trigger(track.firstVoice + track.currentVoice, note);
+      }
+    }
+    for (let i = 0; i < samplesPerRow;) {
+      const block = synth.render(samplesPerRow - i);
+      output.set(block, (row * samplesPerRow + i) * 2);
+      i += block.length / 2;
+    }
+  }
+
+  const expectedBytes = fs.readFileSync(expectedFile);
+  const expected = new Float32Array(expectedBytes.buffer, expectedBytes.byteOffset, expectedBytes.byteLength / 4);
+  if (output.length !== expected.length) {
+    console.error("got " + output.length + " samples, expected " + expected.length);
+    return 1;
+  }
+  // same tolerances as in wasm_test_renderer.es6
+  const margin = 1e-2;
+  let errorCount = 0, firstErrorPos = 0;
+  for (let i = 2; i < output.length - 2; i++) {
+    if (Math.abs(output[i] - expected[i - 2]) > margin &&
+        Math.abs(output[i] - expected[i]) > margin &&
+        Math.abs(output[i] - expected[i + 2]) > margin) {
+      if (errorCount++ === 0) {
+        firstErrorPos = i;
+      }
+      if (errorCount > 200) {
+        console.error("got different buffer than expected. First error at: " + (firstErrorPos / 2 | 0));
+        return 1;
+      }
+    }
+  }
+  return 0;
+}
+
+main().then(retval => exit(retval), err => {
+  console.error(err);
+  exit(1);
+});
@@ -4,16 +4,23 @@
 package gioui
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"gioui.org/app"
 	"gopkg.in/yaml.v3"
 
 	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/fourklang"
+	"github.com/vsariola/sointu/smf"
+	"github.com/vsariola/sointu/xm"
 )
 
 func (t *Tracker) OpenSongFile(forced bool) {
@@ -58,6 +65,37 @@ func (t *Tracker) ExportWav() {
 	}
 }
 
+func (t *Tracker) ExportMidi() {
+	t.ExportMidiDialog.Visible = true
+	if p := t.FilePath(); p != "" {
+		d, _ := filepath.Split(p)
+		d = filepath.Clean(d)
+		t.ExportMidiDialog.Directory.SetText(d)
+	}
+}
+
+func (t *Tracker) ImportMidi() {
+	t.ImportMidiDialog.Visible = true
+	if p := t.FilePath(); p != "" {
+		d, _ := filepath.Split(p)
+		d = filepath.Clean(d)
+		t.ImportMidiDialog.Directory.SetText(d)
+	}
+}
+
+func (t *Tracker) ImportXM() {
+	t.ImportXMDialog.Visible = true
+	if p := t.FilePath(); p != "" {
+		d, _ := filepath.Split(p)
+		d = filepath.Clean(d)
+		t.ImportXMDialog.Directory.SetText(d)
+	}
+}
+
+func (t *Tracker) Import4klang() {
+	t.Import4klangDialog.Visible = true
+}
+
 func (t *Tracker) LoadInstrument() {
 	t.OpenInstrumentDialog.Visible = true
 }
@@ -66,17 +104,20 @@ func (t *Tracker) SaveInstrument() {
 	t.SaveInstrumentDialog.Visible = true
 }
 
+func (t *Tracker) LoadSample() {
+	t.OpenSampleDialog.Visible = true
+}
+
 func (t *Tracker) loadSong(filename string) {
-	bytes, err := ioutil.ReadFile(filename)
+	file, err := os.Open(filename)
 	if err != nil {
 		return
 	}
-	var song sointu.Song
-	if errJSON := json.Unmarshal(bytes, &song); errJSON != nil {
-		if errYaml := yaml.Unmarshal(bytes, &song); errYaml != nil {
-			t.Alert.Update(fmt.Sprintf("Error unmarshaling a song file: %v / %v", errYaml, errJSON), Error, time.Second*3)
-			return
-		}
+	defer file.Close()
+	song, err := sointu.LoadSong(file)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error loading the song file: %v", err), Error, time.Second*3)
+		return
 	}
 	if song.Score.Length <= 0 || len(song.Score.Tracks) == 0 || len(song.Patch) == 0 {
 		t.Alert.Update("The song file is malformed", Error, time.Second*3)
@@ -91,21 +132,19 @@ func (t *Tracker) loadSong(filename string) {
 
 func (t *Tracker) saveSong(filename string) bool {
 	var extension = filepath.Ext(filename)
-	var contents []byte
-	var err error
+	format := sointu.YAMLFormat
 	if extension == ".json" {
-		contents, err = json.Marshal(t.Song())
-	} else {
-		contents, err = yaml.Marshal(t.Song())
+		format = sointu.JSONFormat
 	}
-	if err != nil {
+	var contents bytes.Buffer
+	if err := sointu.SaveSong(&contents, t.Song(), format); err != nil {
 		t.Alert.Update(fmt.Sprintf("Error marshaling a song file: %v", err), Error, time.Second*3)
 		return false
 	}
 	if extension == "" {
 		filename = filename + ".yml"
 	}
-	ioutil.WriteFile(filename, contents, 0644)
+	ioutil.WriteFile(filename, contents.Bytes(), 0644)
 	t.SetFilePath(filename)
 	t.window.Option(app.Title(fmt.Sprintf("Sointu Tracker - %v", filename)))
 	t.SetChangedSinceSave(false)
@@ -117,12 +156,15 @@ func (t *Tracker) exportWav(filename string, pcm16 bool) {
 	if extension == "" {
 		filename = filename + ".wav"
 	}
+	if !t.alertDiagnostics() {
+		return
+	}
 	data, _, err := sointu.Play(t.synthService, t.Song(), true) // render the song to calculate its length
 	if err != nil {
 		t.Alert.Update(fmt.Sprintf("Error rendering the song during export: %v", err), Error, time.Second*3)
 		return
 	}
-	buffer, err := sointu.Wav(data, pcm16)
+	buffer, err := sointu.WavWithMetadata(data, pcm16, t.Song().Metadata)
 	if err != nil {
 		t.Alert.Update(fmt.Sprintf("Error converting to .wav: %v", err), Error, time.Second*3)
 		return
@@ -130,6 +172,105 @@ func (t *Tracker) exportWav(filename string, pcm16 bool) {
 	ioutil.WriteFile(filename, buffer, 0644)
 }
 
+func (t *Tracker) exportMidi(filename string) {
+	var extension = filepath.Ext(filename)
+	if extension == "" {
+		filename = filename + ".mid"
+	}
+	song := t.Song()
+	contents, err := smf.Export(&song)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error converting to .mid: %v", err), Error, time.Second*3)
+		return
+	}
+	ioutil.WriteFile(filename, contents, 0644)
+}
+
+func (t *Tracker) importMidi(filename string) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error reading the .mid: %v", err), Error, time.Second*3)
+		return
+	}
+	song := t.Song()
+	warnings, err := smf.Import(contents, &song, []int{smf.EffectController})
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error importing the .mid: %v", err), Error, time.Second*3)
+		return
+	}
+	t.SetSong(song)
+	if len(warnings) > 0 {
+		t.Alert.Update(fmt.Sprintf("%v notes or values of the .mid were dropped or shifted, e.g. %v", len(warnings), warnings[0]), Warning, time.Second*5)
+	}
+}
+
+func (t *Tracker) importXM(filename string) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error reading the .xm: %v", err), Error, time.Second*3)
+		return
+	}
+	numChannels, err := xm.NumChannels(contents)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error importing the .xm: %v", err), Error, time.Second*3)
+		return
+	}
+	// the volume columns of all the channels become effect tracks; the channels
+	// without volumes get no track
+	effects := make(map[int]xm.Column, numChannels)
+	for c := 0; c < numChannels; c++ {
+		effects[c] = xm.VolumeColumn
+	}
+	song := t.Song()
+	warnings, err := xm.Import(contents, &song, effects)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error importing the .xm: %v", err), Error, time.Second*3)
+		return
+	}
+	t.SetSong(song)
+	if len(warnings) > 0 {
+		t.Alert.Update(fmt.Sprintf("%v notes or effects of the .xm could not be imported, e.g. %v", len(warnings), warnings[0]), Warning, time.Second*5)
+	}
+}
+
+func (t *Tracker) import4klang(filename string) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error reading the 4klang file: %v", err), Error, time.Second*3)
+		return
+	}
+	var warnings []fourklang.Warning
+	if filepath.Ext(filename) == ".4kp" {
+		var patch sointu.Patch
+		patch, warnings, err = fourklang.ParsePatch(contents)
+		if err == nil && len(patch) == 0 {
+			err = errors.New("the patch has no units")
+		}
+		if err != nil {
+			t.Alert.Update(fmt.Sprintf("Error importing the 4klang patch: %v", err), Error, time.Second*3)
+			return
+		}
+		song := t.Song()
+		song.Patch = patch
+		t.SetSong(song)
+	} else {
+		var instrument sointu.Instrument
+		instrument, warnings, err = fourklang.ParseInstrument(contents)
+		if err == nil && len(instrument.Units) == 0 {
+			err = errors.New("the instrument has no units")
+		}
+		if err != nil {
+			t.Alert.Update(fmt.Sprintf("Error importing the 4klang instrument: %v", err), Error, time.Second*3)
+			return
+		}
+		instrument.NumVoices = t.Instrument().NumVoices
+		t.SetInstrument(instrument)
+	}
+	if len(warnings) > 0 {
+		t.Alert.Update(fmt.Sprintf("%v units of the 4klang file could not be imported exactly, e.g. %v", len(warnings), warnings[0]), Warning, time.Second*5)
+	}
+}
+
 func (t *Tracker) saveInstrument(filename string) bool {
 	var extension = filepath.Ext(filename)
 	var contents []byte
@@ -172,3 +313,21 @@ func (t *Tracker) loadInstrument(filename string) bool {
 	}
 	return true
 }
+
+func (t *Tracker) loadSample(filename string) bool {
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error opening the sample: %v", err), Error, time.Second*3)
+		return false
+	}
+	defer file.Close()
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	sample, err := sointu.ReadWavSample(file, name)
+	if err != nil {
+		t.Alert.Update(fmt.Sprintf("Error reading the sample: %v", err), Error, time.Second*3)
+		return false
+	}
+	offset := t.AddSample(sample)
+	t.Alert.Update(fmt.Sprintf("Sample %v embedded in the instrument, starting at samplestart %v", name, offset), Notify, time.Second*3)
+	return true
+}
@@ -19,6 +19,7 @@ import (
 	"gioui.org/widget"
 	"gioui.org/widget/material"
 	"gioui.org/x/eventx"
+	"github.com/vsariola/sointu"
 	"github.com/vsariola/sointu/tracker"
 	"golang.org/x/exp/shiny/materialdesign/icons"
 	"gopkg.in/yaml.v3"
@@ -30,6 +31,8 @@ type InstrumentEditor struct {
 	copyInstrumentBtn   *widget.Clickable
 	saveInstrumentBtn   *widget.Clickable
 	loadInstrumentBtn   *widget.Clickable
+	loadSampleBtn       *widget.Clickable
+	deleteSamplesBtn    *widget.Clickable
 	addUnitBtn          *widget.Clickable
 	commentExpandBtn    *widget.Clickable
 	commentEditor       *widget.Editor
@@ -41,7 +44,6 @@ type InstrumentEditor struct {
 	unitScrollBar       *ScrollBar
 	confirmInstrDelete  *Dialog
 	paramEditor         *ParamEditor
-	stackUse            []int
 	tag                 bool
 	wasFocused          bool
 	commentExpanded     bool
@@ -54,6 +56,8 @@ func NewInstrumentEditor() *InstrumentEditor {
 		copyInstrumentBtn:   new(widget.Clickable),
 		saveInstrumentBtn:   new(widget.Clickable),
 		loadInstrumentBtn:   new(widget.Clickable),
+		loadSampleBtn:       new(widget.Clickable),
+		deleteSamplesBtn:    new(widget.Clickable),
 		addUnitBtn:          new(widget.Clickable),
 		commentExpandBtn:    new(widget.Clickable),
 		commentEditor:       new(widget.Editor),
@@ -141,6 +145,8 @@ func (ie *InstrumentEditor) layoutInstrumentHeader(gtx C, t *Tracker) D {
 		saveInstrumentBtnStyle := IconButton(t.Theme, ie.saveInstrumentBtn, icons.ContentSave, true)
 		loadInstrumentBtnStyle := IconButton(t.Theme, ie.loadInstrumentBtn, icons.FileFolderOpen, true)
 		deleteInstrumentBtnStyle := IconButton(t.Theme, ie.deleteInstrumentBtn, icons.ActionDelete, t.CanDeleteInstrument())
+		loadSampleBtnStyle := IconButton(t.Theme, ie.loadSampleBtn, icons.AVLibraryMusic, true)
+		deleteSamplesBtnStyle := IconButton(t.Theme, ie.deleteSamplesBtn, icons.ContentClear, len(t.Instrument().Samples) > 0)
 
 		header := func(gtx C) D {
 			return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
@@ -157,6 +163,8 @@ func (ie *InstrumentEditor) layoutInstrumentHeader(gtx C, t *Tracker) D {
 				}),
 				layout.Flexed(1, func(gtx C) D { return layout.Dimensions{Size: gtx.Constraints.Min} }),
 				layout.Rigid(commentExpandBtnStyle.Layout),
+				layout.Rigid(loadSampleBtnStyle.Layout),
+				layout.Rigid(deleteSamplesBtnStyle.Layout),
 				layout.Rigid(saveInstrumentBtnStyle.Layout),
 				layout.Rigid(loadInstrumentBtnStyle.Layout),
 				layout.Rigid(copyInstrumentBtnStyle.Layout),
@@ -224,6 +232,12 @@ func (ie *InstrumentEditor) layoutInstrumentHeader(gtx C, t *Tracker) D {
 	for ie.loadInstrumentBtn.Clicked() {
 		t.LoadInstrument()
 	}
+	for ie.loadSampleBtn.Clicked() {
+		t.LoadSample()
+	}
+	for ie.deleteSamplesBtn.Clicked() {
+		t.DeleteSamples()
+	}
 	return Surface{Gray: 37, Focus: ie.wasFocused}.Layout(gtx, header)
 }
 
@@ -338,15 +352,12 @@ func (ie *InstrumentEditor) layoutInstrumentEditor(gtx C, t *Tracker) D {
 	addUnitBtnStyle.Background = t.Theme.Fg
 	addUnitBtnStyle.Inset = layout.UniformInset(unit.Dp(4))
 
-	units := t.Instrument().Units
-	for len(ie.stackUse) < len(units) {
-		ie.stackUse = append(ie.stackUse, 0)
-	}
-
-	stackHeight := 0
-	for i, u := range units {
-		stackHeight += u.StackChange()
-		ie.stackUse[i] = stackHeight
+	instr := t.Instrument()
+	units := instr.Units
+	stackUse := instr.StackUse()
+	underflows := map[int]bool{}
+	for _, u := range stackUse.Underflows {
+		underflows[u] = true
 	}
 
 	element := func(gtx C, i int) D {
@@ -355,22 +366,25 @@ func (ie *InstrumentEditor) layoutInstrumentEditor(gtx C, t *Tracker) D {
 		var color color.NRGBA = white
 
 		var stackText string
-		if i < len(ie.stackUse) {
-			stackText = strconv.FormatInt(int64(ie.stackUse[i]), 10)
+		if i < len(stackUse.Depths) {
+			stackText = strconv.FormatInt(int64(stackUse.Depths[i]), 10)
 			var prevStackUse int
 			if i > 0 {
-				prevStackUse = ie.stackUse[i-1]
+				prevStackUse = stackUse.Depths[i-1]
 			}
-			if stackNeed := u.StackNeed(); stackNeed > prevStackUse {
+			if underflows[i] {
 				color = errorColor
 				typeString := u.Type
 				if u.Parameters["stereo"] == 1 {
 					typeString += " (stereo)"
 				}
-				t.Alert.Update(fmt.Sprintf("%v needs at least %v input signals, got %v", typeString, stackNeed, prevStackUse), Error, 0)
-			} else if i == len(units)-1 && ie.stackUse[i] != 0 {
+				t.Alert.Update(fmt.Sprintf("%v needs at least %v input signals, got %v", typeString, u.StackNeed(), prevStackUse), Error, 0)
+			} else if stackUse.Depths[i] > sointu.X87StackSize {
+				color = warningColor
+				t.Alert.Update(fmt.Sprintf("Stack depth %v exceeds the %v-level x87 stack of the compiled VM", stackUse.Depths[i], sointu.X87StackSize), Warning, 0)
+			} else if i == len(units)-1 && stackUse.Leftover != 0 {
 				color = warningColor
-				t.Alert.Update(fmt.Sprintf("Instrument leaves %v signal(s) on the stack", ie.stackUse[i]), Warning, 0)
+				t.Alert.Update(fmt.Sprintf("Instrument leaves %v signal(s) on the stack", stackUse.Leftover), Warning, 0)
 			}
 		}
 
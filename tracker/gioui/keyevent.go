@@ -1,11 +1,12 @@
 package gioui
 
 import (
+	"strings"
 	"time"
 
 	"gioui.org/io/key"
+	"github.com/vsariola/sointu"
 	"github.com/vsariola/sointu/tracker"
-	"gopkg.in/yaml.v3"
 )
 
 var noteMap = map[string]int{
@@ -50,15 +51,20 @@ func (t *Tracker) KeyEvent(e key.Event) bool {
 			t.SaveSongDialog.Visible ||
 			t.SaveInstrumentDialog.Visible ||
 			t.OpenInstrumentDialog.Visible ||
-			t.ExportWavDialog.Visible {
+			t.OpenSampleDialog.Visible ||
+			t.ExportWavDialog.Visible ||
+			t.ImportMidiDialog.Visible ||
+			t.ExportMidiDialog.Visible ||
+			t.Import4klangDialog.Visible ||
+			t.ImportXMDialog.Visible {
 			return false
 		}
 		switch e.Name {
 		case "C":
 			if e.Modifiers.Contain(key.ModShortcut) {
-				contents, err := yaml.Marshal(t.Song())
-				if err == nil {
-					t.window.WriteClipboard(string(contents))
+				var contents strings.Builder
+				if err := sointu.SaveSong(&contents, t.Song(), sointu.YAMLFormat); err == nil {
+					t.window.WriteClipboard(contents.String())
 					t.Alert.Update("Song copied to clipboard", Notify, time.Second*3)
 				}
 				return true
@@ -111,6 +117,7 @@ func (t *Tracker) KeyEvent(e key.Event) bool {
 			if t.OrderEditor.Focused() {
 				startRow.Row = 0
 			}
+			t.alertDiagnostics()
 			t.player.Play(startRow)
 			return true
 		case "F6":
@@ -119,6 +126,7 @@ func (t *Tracker) KeyEvent(e key.Event) bool {
 			if t.OrderEditor.Focused() {
 				startRow.Row = 0
 			}
+			t.alertDiagnostics()
 			t.player.Play(startRow)
 			return true
 		case "F8":
@@ -129,6 +137,7 @@ func (t *Tracker) KeyEvent(e key.Event) bool {
 			if !playing {
 				t.SetNoteTracking(!e.Modifiers.Contain(key.ModShortcut))
 				startRow := t.Cursor().SongRow
+				t.alertDiagnostics()
 				t.player.Play(startRow)
 			} else {
 				t.player.Stop()
@@ -74,6 +74,38 @@ func (t *Tracker) Layout(gtx layout.Context) {
 	exportWavDialogStyle.ExtMain = ".wav"
 	exportWavDialogStyle.ExtAlt = ""
 	exportWavDialogStyle.Layout(gtx)
+	fstyle = OpenFileDialog(t.Theme, t.ImportMidiDialog)
+	fstyle.Title = "Import MIDI (replaces the score)"
+	for ok, file := t.ImportMidiDialog.FileSelected(); ok; ok, file = t.ImportMidiDialog.FileSelected() {
+		t.importMidi(file)
+	}
+	fstyle.ExtMain = ".mid"
+	fstyle.ExtAlt = ""
+	fstyle.Layout(gtx)
+	fstyle = SaveFileDialog(t.Theme, t.ExportMidiDialog)
+	fstyle.Title = "Export Song As MIDI"
+	for ok, file := t.ExportMidiDialog.FileSelected(); ok; ok, file = t.ExportMidiDialog.FileSelected() {
+		t.exportMidi(file)
+	}
+	fstyle.ExtMain = ".mid"
+	fstyle.ExtAlt = ""
+	fstyle.Layout(gtx)
+	fstyle = OpenFileDialog(t.Theme, t.Import4klangDialog)
+	fstyle.Title = "Import 4klang Instrument (replaces the instrument) or Patch (replaces the patch)"
+	for ok, file := t.Import4klangDialog.FileSelected(); ok; ok, file = t.Import4klangDialog.FileSelected() {
+		t.import4klang(file)
+	}
+	fstyle.ExtMain = ".4ki"
+	fstyle.ExtAlt = ".4kp"
+	fstyle.Layout(gtx)
+	fstyle = OpenFileDialog(t.Theme, t.ImportXMDialog)
+	fstyle.Title = "Import XM (replaces the score and the patch)"
+	for ok, file := t.ImportXMDialog.FileSelected(); ok; ok, file = t.ImportXMDialog.FileSelected() {
+		t.importXM(file)
+	}
+	fstyle.ExtMain = ".xm"
+	fstyle.ExtAlt = ""
+	fstyle.Layout(gtx)
 	fstyle = SaveFileDialog(t.Theme, t.SaveInstrumentDialog)
 	fstyle.Title = "Save Instrument As"
 	if t.SaveInstrumentDialog.Visible && t.Instrument().Name != "" {
@@ -90,6 +122,14 @@ func (t *Tracker) Layout(gtx layout.Context) {
 		t.loadInstrument(file)
 	}
 	fstyle.Layout(gtx)
+	fstyle = OpenFileDialog(t.Theme, t.OpenSampleDialog)
+	fstyle.Title = "Open Sample (.wav)"
+	fstyle.ExtMain = ".wav"
+	fstyle.ExtAlt = ""
+	for ok, file := t.OpenSampleDialog.FileSelected(); ok; ok, file = t.OpenSampleDialog.FileSelected() {
+		t.loadSample(file)
+	}
+	fstyle.Layout(gtx)
 	if t.ModalDialog != nil {
 		t.ModalDialog(gtx)
 	}
@@ -13,6 +13,7 @@ import (
 	"gioui.org/op"
 	"gioui.org/unit"
 	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/tracker"
 )
 
 func (t *Tracker) Run(w *app.Window) error {
@@ -64,13 +65,18 @@ func (t *Tracker) Run(w *app.Window) error {
 	}
 }
 
-func Main(audioContext sointu.AudioContext, synthService sointu.SynthService, syncChannel chan<- []float32) {
+// Main opens the tracker window. sampleEntries are the samples listed for the
+// sample-based oscillators; if nil, the samples of gm.dls are listed.
+func Main(audioContext sointu.AudioContext, synthService sointu.SynthService, sampleEntries []tracker.GmDlsEntry, syncChannel chan<- []float32) {
 	go func() {
 		w := app.NewWindow(
 			app.Size(unit.Dp(800), unit.Dp(600)),
 			app.Title("Sointu Tracker"),
 		)
 		t := New(audioContext, synthService, syncChannel, w)
+		if sampleEntries != nil {
+			t.SetSampleEntries(sampleEntries)
+		}
 		defer t.Close()
 		if err := t.Run(w); err != nil {
 			fmt.Println(err)
@@ -4,6 +4,7 @@ import (
 	"image"
 	"math"
 	"runtime"
+	"strings"
 	"time"
 
 	"gioui.org/f32"
@@ -15,8 +16,8 @@ import (
 	"gioui.org/unit"
 	"gioui.org/widget"
 	"gioui.org/widget/material"
+	"github.com/vsariola/sointu"
 	"golang.org/x/exp/shiny/materialdesign/icons"
-	"gopkg.in/yaml.v3"
 )
 
 func (t *Tracker) layoutSongPanel(gtx C) D {
@@ -63,6 +64,14 @@ func (t *Tracker) layoutMenuBar(gtx C) D {
 		case 4:
 			t.ExportWav()
 		case 5:
+			t.ImportMidi()
+		case 6:
+			t.ExportMidi()
+		case 7:
+			t.Import4klang()
+		case 8:
+			t.ImportXM()
+		case 9:
 			t.Quit(false)
 		}
 		clickedItem, hasClicked = t.Menus[0].Clicked()
@@ -75,8 +84,9 @@ func (t *Tracker) layoutMenuBar(gtx C) D {
 		case 1:
 			t.Redo()
 		case 2:
-			if contents, err := yaml.Marshal(t.Song()); err == nil {
-				clipboard.WriteOp{Text: string(contents)}.Add(gtx.Ops)
+			var contents strings.Builder
+			if err := sointu.SaveSong(&contents, t.Song(), sointu.YAMLFormat); err == nil {
+				clipboard.WriteOp{Text: contents.String()}.Add(gtx.Ops)
 				t.Alert.Update("Song copied to clipboard", Notify, time.Second*3)
 			}
 		case 3:
@@ -98,6 +108,10 @@ func (t *Tracker) layoutMenuBar(gtx C) D {
 			MenuItem{IconBytes: icons.ContentSave, Text: "Save Song", ShortcutText: shortcutKey + "S"},
 			MenuItem{IconBytes: icons.ContentSave, Text: "Save Song As..."},
 			MenuItem{IconBytes: icons.ImageAudiotrack, Text: "Export Wav..."},
+			MenuItem{IconBytes: icons.ImageMusicNote, Text: "Import MIDI..."},
+			MenuItem{IconBytes: icons.ImageMusicNote, Text: "Export MIDI..."},
+			MenuItem{IconBytes: icons.FileFolderOpen, Text: "Import 4klang..."},
+			MenuItem{IconBytes: icons.ImageMusicNote, Text: "Import XM..."},
 			MenuItem{IconBytes: icons.ActionExitToApp, Text: "Quit"},
 		)),
 		layout.Rigid(t.layoutMenu("Edit", &t.MenuBar[1], &t.Menus[1], unit.Dp(200),
@@ -196,6 +210,10 @@ func (t *Tracker) layoutSongOptions(gtx C) D {
 				}),
 			)
 		}),
+		layout.Rigid(t.layoutMetadataEditor(t.TitleEditor, "Title", func(m *sointu.Metadata) *string { return &m.Title })),
+		layout.Rigid(t.layoutMetadataEditor(t.AuthorEditor, "Author", func(m *sointu.Metadata) *string { return &m.Author })),
+		layout.Rigid(t.layoutMetadataEditor(t.LicenseEditor, "License", func(m *sointu.Metadata) *string { return &m.License })),
+		layout.Rigid(t.layoutMetadataEditor(t.CommentEditor, "Comment", func(m *sointu.Metadata) *string { return &m.Comment })),
 		layout.Rigid(func(gtx C) D {
 			gtx.Constraints.Min = image.Pt(0, 0)
 			return panicBtnStyle.Layout(gtx)
@@ -203,3 +221,27 @@ func (t *Tracker) layoutSongOptions(gtx C) D {
 		layout.Rigid(VuMeter{Volume: t.lastVolume, Range: 100}.Layout),
 	)
 }
+
+// layoutMetadataEditor returns a widget editing one field of the metadata of
+// the song, e.g. the title.
+func (t *Tracker) layoutMetadataEditor(editor *widget.Editor, hint string, field func(*sointu.Metadata) *string) layout.Widget {
+	return func(gtx C) D {
+		for _, ev := range editor.Events() {
+			if _, ok := ev.(widget.SubmitEvent); ok {
+				t.TrackEditor.Focus()
+			}
+		}
+		metadata := t.Song().Metadata
+		if value := field(&metadata); *value != editor.Text() {
+			editor.SetText(*value)
+		}
+		editorStyle := material.Editor(t.Theme, editor, hint)
+		editorStyle.Color = highEmphasisTextColor
+		editorStyle.HintColor = instrumentNameHintColor
+		editorStyle.TextSize = unit.Dp(12)
+		dims := layout.UniformInset(unit.Dp(2)).Layout(gtx, editorStyle.Layout)
+		*field(&metadata) = editor.Text()
+		t.SetMetadata(metadata)
+		return dims
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"gioui.org/app"
 	"gioui.org/font/gofont"
@@ -12,6 +14,7 @@ import (
 	"gioui.org/widget/material"
 	"github.com/vsariola/sointu"
 	"github.com/vsariola/sointu/tracker"
+	"github.com/vsariola/sointu/vm"
 	"gopkg.in/yaml.v3"
 )
 
@@ -32,6 +35,10 @@ type Tracker struct {
 	Step                  *NumberInput
 	InstrumentVoices      *NumberInput
 	SongLength            *NumberInput
+	TitleEditor           *widget.Editor
+	AuthorEditor          *widget.Editor
+	LicenseEditor         *widget.Editor
+	CommentEditor         *widget.Editor
 	PanicBtn              *widget.Clickable
 	AddUnitBtn            *widget.Clickable
 	TrackHexCheckBox      *widget.Bool
@@ -46,7 +53,12 @@ type Tracker struct {
 	SaveSongDialog        *FileDialog
 	OpenInstrumentDialog  *FileDialog
 	SaveInstrumentDialog  *FileDialog
+	OpenSampleDialog      *FileDialog
 	ExportWavDialog       *FileDialog
+	ImportMidiDialog      *FileDialog
+	ExportMidiDialog      *FileDialog
+	Import4klangDialog    *FileDialog
+	ImportXMDialog        *FileDialog
 	ConfirmSongActionType int
 	window                *app.Window
 	ModalDialog           layout.Widget
@@ -81,11 +93,9 @@ func (t *Tracker) UnmarshalContent(bytes []byte) error {
 			return nil
 		}
 	}
-	var song sointu.Song
-	if errJSON := json.Unmarshal(bytes, &song); errJSON != nil {
-		if errYaml := yaml.Unmarshal(bytes, &song); errYaml != nil {
-			return fmt.Errorf("the song could not be parsed as .json (%v) or .yml (%v)", errJSON, errYaml)
-		}
+	song, err := sointu.LoadSong(strings.NewReader(string(bytes)))
+	if err != nil {
+		return err
 	}
 	if song.BPM > 0 {
 		t.SetSong(song)
@@ -110,6 +120,10 @@ func New(audioContext sointu.AudioContext, synthService sointu.SynthService, syn
 		RowsPerBeat:       new(NumberInput),
 		Step:              &NumberInput{Value: 1},
 		InstrumentVoices:  new(NumberInput),
+		TitleEditor:       &widget.Editor{SingleLine: true, Submit: true},
+		AuthorEditor:      &widget.Editor{SingleLine: true, Submit: true},
+		LicenseEditor:     &widget.Editor{SingleLine: true, Submit: true},
+		CommentEditor:     new(widget.Editor),
 
 		PanicBtn:         new(widget.Clickable),
 		TrackHexCheckBox: new(widget.Bool),
@@ -130,14 +144,19 @@ func New(audioContext sointu.AudioContext, synthService sointu.SynthService, syn
 		SaveSongDialog:       NewFileDialog(),
 		OpenInstrumentDialog: NewFileDialog(),
 		SaveInstrumentDialog: NewFileDialog(),
+		OpenSampleDialog:     NewFileDialog(),
 		InstrumentEditor:     NewInstrumentEditor(),
 		OrderEditor:          NewOrderEditor(),
 		TrackEditor:          NewTrackEditor(),
 
-		ExportWavDialog: NewFileDialog(),
-		errorChannel:    make(chan error, 32),
-		window:          window,
-		synthService:    synthService,
+		ExportWavDialog:    NewFileDialog(),
+		ImportMidiDialog:   NewFileDialog(),
+		ExportMidiDialog:   NewFileDialog(),
+		Import4klangDialog: NewFileDialog(),
+		ImportXMDialog:     NewFileDialog(),
+		errorChannel:       make(chan error, 32),
+		window:             window,
+		synthService:       synthService,
 	}
 	t.Model = tracker.NewModel()
 	vuBufferObserver := make(chan []float32)
@@ -164,6 +183,32 @@ func New(audioContext sointu.AudioContext, synthService sointu.SynthService, syn
 	return t
 }
 
+// alertDiagnostics validates the song and shows the most severe problem found,
+// if any, as an alert. It returns false if the song has errors that prevent
+// rendering it.
+func (t *Tracker) alertDiagnostics() bool {
+	diagnostics := t.Diagnostics()
+	if len(diagnostics) == 0 {
+		return true
+	}
+	worst := diagnostics[0]
+	for _, d := range diagnostics {
+		if d.Severity > worst.Severity {
+			worst = d
+		}
+	}
+	message := worst.String()
+	if len(diagnostics) > 1 {
+		message = fmt.Sprintf("%v (and %v more problems)", message, len(diagnostics)-1)
+	}
+	if worst.Severity == vm.Error {
+		t.Alert.Update(message, Error, time.Second*5)
+		return false
+	}
+	t.Alert.Update(message, Warning, time.Second*5)
+	return true
+}
+
 func (t *Tracker) Quit(forced bool) bool {
 	if !forced && t.ChangedSinceSave() {
 		t.ConfirmSongActionType = ConfirmQuit
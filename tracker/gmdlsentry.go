@@ -1,6 +1,12 @@
 package tracker
 
-import "github.com/vsariola/sointu/vm"
+import (
+	"fmt"
+
+	"github.com/vsariola/sointu/dls"
+	"github.com/vsariola/sointu/sf2"
+	"github.com/vsariola/sointu/vm"
+)
 
 type GmDlsEntry struct {
 	Start              int
@@ -14,9 +20,54 @@ var GmDlsEntryMap = make(map[vm.SampleOffset]int)
 
 func init() {
 	for i, e := range GmDlsEntries {
-		key := vm.SampleOffset{Start: uint32(e.Start), LoopStart: uint16(e.LoopStart), LoopLength: uint16(e.LoopLength)}
-		GmDlsEntryMap[key] = i
+		GmDlsEntryMap[e.SampleOffset()] = i
 	}
 }
 
+// SampleOffset returns the SampleOffset of the sample-based oscillators
+// playing the entry.
+func (e GmDlsEntry) SampleOffset() vm.SampleOffset {
+	return vm.SampleOffset{Start: uint32(e.Start), LoopStart: uint16(e.LoopStart), LoopLength: uint16(e.LoopLength)}
+}
+
+// DlsEntries returns an entry for each wave in the wave pool of a DLS
+// collection, in the same format as GmDlsEntries. Start is the position of the
+// wave data in the DLS file in int16s, as the sample oscillators address the
+// DLS file as a flat int16 table.
+func DlsEntries(c *dls.Collection) []GmDlsEntry {
+	ret := make([]GmDlsEntry, len(c.Waves))
+	for i, w := range c.Waves {
+		loopStart, loopLength := w.Loop()
+		ret[i] = GmDlsEntry{
+			Start:              w.DataOffset / 2,
+			LoopStart:          loopStart,
+			LoopLength:         loopLength,
+			SuggestedTranspose: 60 - w.UnityNote(),
+			Name:               w.Name,
+		}
+		if ret[i].Name == "" {
+			ret[i].Name = fmt.Sprintf("#%v", i)
+		}
+	}
+	return ret
+}
+
 //go:generate go run generate/main.go
+
+// SoundFontEntries returns an entry for each sample of a SoundFont that can be
+// played by the sample-based oscillators, in the same format as GmDlsEntries;
+// see sf2.SoundFont.Entry.
+func SoundFontEntries(sf *sf2.SoundFont) []GmDlsEntry {
+	entries := sf.Entries()
+	ret := make([]GmDlsEntry, len(entries))
+	for i, e := range entries {
+		ret[i] = GmDlsEntry{
+			Start:              int(e.SampleOffset.Start),
+			LoopStart:          int(e.SampleOffset.LoopStart),
+			LoopLength:         int(e.SampleOffset.LoopLength),
+			SuggestedTranspose: e.SuggestedTranspose,
+			Name:               e.Name,
+		}
+	}
+	return ret
+}
@@ -31,6 +31,8 @@ type Model struct {
 	filePath         string
 	changedSinceSave bool
 	patternUseCount  [][]int
+	sampleEntries    []GmDlsEntry
+	sampleEntryMap   map[vm.SampleOffset]int
 
 	prevUndoType    string
 	undoSkipCounter int
@@ -66,9 +68,20 @@ const maxUndo = 256
 func NewModel() *Model {
 	ret := new(Model)
 	ret.setSongNoUndo(defaultSong.Copy())
+	ret.sampleEntries, ret.sampleEntryMap = GmDlsEntries, GmDlsEntryMap
 	return ret
 }
 
+// SetSampleEntries sets the samples listed by the sample parameter of the
+// sample-based oscillators, e.g. the entries of the SoundFont loaded as the
+// sample bank. By default, the entries of gm.dls are listed.
+func (m *Model) SetSampleEntries(entries []GmDlsEntry) {
+	m.sampleEntries, m.sampleEntryMap = entries, make(map[vm.SampleOffset]int)
+	for i, e := range entries {
+		m.sampleEntryMap[e.SampleOffset()] = i
+	}
+}
+
 func (m *Model) FilePath() string {
 	return m.filePath
 }
@@ -169,6 +182,30 @@ func (m *Model) SetInstrumentComment(comment string) {
 	m.song.Patch[m.instrIndex].Comment = comment
 }
 
+// AddSample embeds a sample in the current instrument and returns the offset of
+// the new sample within the concatenated samples of the instrument, i.e. the
+// samplestart of the Sample oscillators that should play it.
+func (m *Model) AddSample(sample sointu.InstrumentSample) int {
+	m.saveUndo("AddSample", 0)
+	offset := 0
+	for _, s := range m.Instrument().Samples {
+		offset += len(s.Data)
+	}
+	m.song.Patch[m.instrIndex].Samples = append(m.song.Patch[m.instrIndex].Samples, sample)
+	m.notifyPatchChange()
+	return offset
+}
+
+// DeleteSamples removes all the samples embedded in the current instrument.
+func (m *Model) DeleteSamples() {
+	if len(m.Instrument().Samples) == 0 {
+		return
+	}
+	m.saveUndo("DeleteSamples", 0)
+	m.song.Patch[m.instrIndex].Samples = nil
+	m.notifyPatchChange()
+}
+
 func (m *Model) SetBPM(value int) {
 	if value < 1 {
 		value = 1
@@ -199,6 +236,14 @@ func (m *Model) SetRowsPerBeat(value int) {
 	m.notifySamplesPerRowChange()
 }
 
+func (m *Model) SetMetadata(metadata sointu.Metadata) {
+	if m.song.Metadata == metadata {
+		return
+	}
+	m.saveUndo("SetMetadata", 10)
+	m.song.Metadata = metadata
+}
+
 func (m *Model) AddTrack(after bool) {
 	if !m.CanAddTrack() {
 		return
@@ -631,11 +676,11 @@ func (m *Model) SetParamIndex(value int) {
 	m.clampPositions()
 }
 
-func (m *Model) setGmDlsEntry(index int) {
-	if index < 0 || index >= len(GmDlsEntries) {
+func (m *Model) setSampleEntry(index int) {
+	if index < 0 || index >= len(m.sampleEntries) {
 		return
 	}
-	entry := GmDlsEntries[index]
+	entry := m.sampleEntries[index]
 	unit := m.Unit()
 	if unit.Type != "oscillator" || unit.Parameters["type"] != sointu.Sample {
 		return
@@ -643,7 +688,7 @@ func (m *Model) setGmDlsEntry(index int) {
 	if unit.Parameters["samplestart"] == entry.Start && unit.Parameters["loopstart"] == entry.LoopStart && unit.Parameters["looplength"] == entry.LoopLength {
 		return
 	}
-	m.saveUndo("SetGmDlsEntry", 20)
+	m.saveUndo("SetSampleEntry", 20)
 	unit.Parameters["samplestart"] = entry.Start
 	unit.Parameters["loopstart"] = entry.LoopStart
 	unit.Parameters["looplength"] = entry.LoopLength
@@ -822,6 +867,12 @@ func (m *Model) Song() sointu.Song {
 	return m.song
 }
 
+// Diagnostics validates the current song, returning all the problems found in
+// it; see vm.Validate.
+func (m *Model) Diagnostics() []vm.Diagnostic {
+	return vm.Validate(&m.song)
+}
+
 func (m *Model) SelectionCorner() SongPoint {
 	return m.selectionCorner
 }
@@ -953,11 +1004,11 @@ func (m *Model) Param(index int) (Parameter, error) {
 		key := vm.SampleOffset{Start: uint32(unit.Parameters["samplestart"]), LoopStart: uint16(unit.Parameters["loopstart"]), LoopLength: uint16(unit.Parameters["looplength"])}
 		val := 0
 		hint := "0 / custom"
-		if v, ok := GmDlsEntryMap[key]; ok {
+		if v, ok := m.sampleEntryMap[key]; ok {
 			val = v + 1
-			hint = fmt.Sprintf("%v / %v", val, GmDlsEntries[v].Name)
+			hint = fmt.Sprintf("%v / %v", val, m.sampleEntries[v].Name)
 		}
-		return Parameter{Type: IntegerParameter, Min: 0, Max: len(GmDlsEntries), Name: "sample", Hint: hint, Value: val}, nil
+		return Parameter{Type: IntegerParameter, Min: 0, Max: len(m.sampleEntries), Name: "sample", Hint: hint, Value: val}, nil
 	}
 	if unit.Type == "delay" {
 		if index == 0 {
@@ -1053,7 +1104,7 @@ func (m *Model) SetParam(value int) {
 		value = p.Max
 	}
 	if p.Name == "sample" {
-		m.setGmDlsEntry(value - 1)
+		m.setSampleEntry(value - 1)
 		return
 	}
 	unit := m.Unit()
@@ -1162,9 +1213,13 @@ func (m *Model) freeUnitIDs(units []sointu.Unit) {
 }
 
 func (m *Model) assignUnitIDs(units []sointu.Unit) {
+	renumbered := map[int]int{}
 	for i := range units {
 		if units[i].ID == 0 || m.usedIDs[units[i].ID] {
 			m.maxID++
+			if units[i].ID != 0 {
+				renumbered[units[i].ID] = m.maxID
+			}
 			units[i].ID = m.maxID
 		}
 		m.usedIDs[units[i].ID] = true
@@ -1172,6 +1227,16 @@ func (m *Model) assignUnitIDs(units []sointu.Unit) {
 			m.maxID = units[i].ID
 		}
 	}
+	// the sends targeting the renumbered units would otherwise modulate the
+	// units that had the IDs already
+	for i := range units {
+		if units[i].Type != "send" {
+			continue
+		}
+		if id, ok := renumbered[units[i].Parameters["target"]]; ok {
+			units[i].Parameters["target"] = id
+		}
+	}
 }
 
 func (m *Model) computePatternUseCounts() {
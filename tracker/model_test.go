@@ -0,0 +1,80 @@
+package tracker_test
+
+import (
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/fourklang"
+	"github.com/vsariola/sointu/tracker"
+)
+
+func TestImport4klangInstrumentIntoSong(t *testing.T) {
+	// a 4klang instrument with an envelope and a store modulating its gain
+	data := append([]byte("4k14"), make([]byte, 64+64*16)...)
+	units := data[4+64:]
+	copy(units[0:], []byte{1, 64, 64, 64, 64, 128}) // ENV
+	copy(units[16:], []byte{7, 96, 0, 0xFF, 0, 5})  // FST to the gain of the local unit 0
+	instrument, _, err := fourklang.ParseInstrument(data)
+	if err != nil {
+		t.Fatalf("could not parse the instrument: %v", err)
+	}
+	model := tracker.NewModel()
+	song := model.Song()
+	if len(song.Patch) < 2 {
+		t.Fatalf("the default song should have several instruments")
+	}
+	// the units of the first instrument have the same IDs as the converted ones
+	last := len(song.Patch) - 1
+	model.SetInstrIndex(last)
+	model.SetInstrument(instrument)
+	song = model.Song()
+	ids := map[int]int{}
+	for i, instr := range song.Patch {
+		for _, u := range instr.Units {
+			if _, ok := ids[u.ID]; ok {
+				t.Errorf("unit ID %v is used twice", u.ID)
+			}
+			ids[u.ID] = i
+		}
+	}
+	imported := song.Patch[last].Units
+	if len(imported) != 2 || imported[1].Type != "send" {
+		t.Fatalf("expected an envelope and a send, got %v", imported)
+	}
+	if target := imported[1].Parameters["target"]; target != imported[0].ID {
+		t.Errorf("the send should target the envelope of the imported instrument (ID %v), got ID %v of instrument %v", imported[0].ID, target, ids[target])
+	}
+}
+
+func TestSetSampleEntries(t *testing.T) {
+	model := tracker.NewModel()
+	model.SetInstrument(sointu.Instrument{NumVoices: 1, Units: []sointu.Unit{
+		{Type: "oscillator", Parameters: map[string]int{"stereo": 0, "transpose": 64, "detune": 64, "phase": 0, "color": 128, "shape": 64, "gain": 128, "type": sointu.Sample}},
+	}})
+	model.SetUnitIndex(0)
+	model.SetSampleEntries([]tracker.GmDlsEntry{
+		{Start: 100, LoopStart: 10, LoopLength: 20, SuggestedTranspose: -12, Name: "First"},
+		{Start: 200, LoopStart: 30, LoopLength: 40, SuggestedTranspose: 5, Name: "Second"},
+	})
+	index := -1
+	for i := 0; i < model.NumParams(); i++ {
+		if p, err := model.Param(i); err == nil && p.Name == "sample" {
+			index = i
+		}
+	}
+	if index < 0 {
+		t.Fatalf("the sample oscillator should have a sample parameter")
+	}
+	if p, _ := model.Param(index); p.Max != 2 || p.Value != 0 {
+		t.Errorf("expected the sample parameter to list the 2 entries, got %+v", p)
+	}
+	model.SetParamIndex(index)
+	model.SetParam(2)
+	u := model.Unit()
+	if u.Parameters["samplestart"] != 200 || u.Parameters["loopstart"] != 30 || u.Parameters["looplength"] != 40 || u.Parameters["transpose"] != 69 {
+		t.Errorf("the second entry was not set to the oscillator: %v", u.Parameters)
+	}
+	if p, _ := model.Param(index); p.Value != 2 || p.Hint != "2 / Second" {
+		t.Errorf("expected the sample parameter to show the second entry, got %+v", p)
+	}
+}
@@ -1,8 +1,10 @@
 package vm
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 
 	"github.com/vsariola/sointu"
 )
@@ -18,6 +20,10 @@ import (
 // and third instrument four voices, the PolyphonyBitmask is:
 //
 // (MSB) 110101110 (LSB)
+//
+// SampleData contains the samples embedded in the instruments, concatenated,
+// or is empty if the Sample oscillators use gm.dls. SampleFormat tells how the
+// SampleData has been converted for storing in the compiled song.
 type BytePatch struct {
 	Commands         []byte
 	Values           []byte
@@ -25,6 +31,8 @@ type BytePatch struct {
 	SampleOffsets    []SampleOffset
 	PolyphonyBitmask uint32
 	NumVoices        uint32
+	SampleData       []int16
+	SampleFormat     SampleFormat
 }
 
 type SampleOffset struct {
@@ -33,6 +41,15 @@ type SampleOffset struct {
 	LoopLength uint16
 }
 
+// SampleFormat tells how the embedded samples are stored in a compiled song.
+// Downsample is the integer factor by which the samples are decimated (0 and 1
+// both mean no decimation) and EightBit tells if the samples are quantized to 8
+// bits.
+type SampleFormat struct {
+	Downsample int
+	EightBit   bool
+}
+
 func Encode(patch sointu.Patch, featureSet FeatureSet) (*BytePatch, error) {
 	c := BytePatch{PolyphonyBitmask: polyphonyBitmask(patch), NumVoices: uint32(patch.NumVoices())}
 	if c.NumVoices > 32 {
@@ -47,6 +64,12 @@ func Encode(patch sointu.Patch, featureSet FeatureSet) (*BytePatch, error) {
 	for i := range delayTable {
 		c.DelayTimes[i] = uint16(delayTable[i])
 	}
+	embeddedSamples := false
+	for _, instr := range patch {
+		if len(instr.Samples) > 0 {
+			embeddedSamples = true
+		}
+	}
 	for instrIndex, instr := range patch {
 		if len(instr.Units) > 63 {
 			return nil, errors.New("An instrument can have a maximum of 63 units")
@@ -54,6 +77,11 @@ func Encode(patch sointu.Patch, featureSet FeatureSet) (*BytePatch, error) {
 		if instr.NumVoices < 1 {
 			return nil, errors.New("Each instrument must have at least 1 voice")
 		}
+		sampleBase := len(c.SampleData)
+		for _, s := range instr.Samples {
+			c.SampleData = append(c.SampleData, s.Data...)
+		}
+		instrSampleLength := len(c.SampleData) - sampleBase
 		localAddrs := map[int]uint16{}
 		localFixups := map[int]([]int){}
 		localUnitNo := 0
@@ -67,6 +95,15 @@ func Encode(patch sointu.Patch, featureSet FeatureSet) (*BytePatch, error) {
 					// hacky quick fix: looplength 0 causes div by zero so avoid crashing
 					s.LoopLength = 1
 				}
+				if embeddedSamples {
+					if instrSampleLength == 0 {
+						return nil, fmt.Errorf("instrument %v has sample oscillators but no embedded samples; samples from gm.dls and embedded samples cannot be mixed", instrIndex)
+					}
+					if end := int(s.Start) + int(s.LoopStart) + int(s.LoopLength); end > instrSampleLength {
+						return nil, fmt.Errorf("a sample oscillator of instrument %v reaches sample %v, but the instrument has only %v samples embedded", instrIndex, end, instrSampleLength)
+					}
+					s.Start += uint32(sampleBase)
+				}
 				index, ok := sampleOffsetMap[s]
 				if !ok {
 					index = len(c.SampleOffsets)
@@ -194,6 +231,407 @@ func Encode(patch sointu.Patch, featureSet FeatureSet) (*BytePatch, error) {
 	return &c, nil
 }
 
+// EncodePatches encodes several patches for one virtual machine, e.g. to
+// compile several songs into one player. The returned BytePatches share the
+// same DelayTimes, SampleOffsets and SampleData, which are the tables of all the
+// patches merged, and the delay indices and the sample numbers in the Values
+// are relocated accordingly. The featureSet should support all the patches,
+// see NecessaryFeaturesForAll. Samples from gm.dls and embedded samples cannot
+// be mixed, not even in different patches.
+func EncodePatches(patches []sointu.Patch, featureSet FeatureSet) ([]*BytePatch, error) {
+	ret := make([]*BytePatch, len(patches))
+	delayTables := make([][]int, len(patches))
+	for i, patch := range patches {
+		b, err := Encode(patch, featureSet)
+		if err != nil {
+			return nil, fmt.Errorf("patch %v: %v", i, err)
+		}
+		ret[i] = b
+		for _, t := range b.DelayTimes {
+			delayTables[i] = append(delayTables[i], int(t))
+		}
+	}
+	delayTable, delayBases := findSuperIntArray(delayTables)
+	delayTimes := make([]uint16, len(delayTable))
+	for i := range delayTable {
+		delayTimes[i] = uint16(delayTable[i])
+	}
+	var sampleOffsets []SampleOffset
+	var sampleData []int16
+	for i, b := range ret {
+		if len(b.SampleOffsets) > 0 && len(sampleOffsets) > 0 && (len(b.SampleData) > 0) != (len(sampleData) > 0) {
+			return nil, fmt.Errorf("patch %v: samples from gm.dls and embedded samples cannot be mixed", i)
+		}
+		if err := b.relocate(featureSet, delayBases[i], len(sampleOffsets)); err != nil {
+			return nil, fmt.Errorf("patch %v: %v", i, err)
+		}
+		for _, s := range b.SampleOffsets {
+			s.Start += uint32(len(sampleData))
+			sampleOffsets = append(sampleOffsets, s)
+		}
+		sampleData = append(sampleData, b.SampleData...)
+	}
+	for _, b := range ret {
+		b.DelayTimes = delayTimes
+		b.SampleOffsets = sampleOffsets
+		b.SampleData = sampleData
+	}
+	return ret, nil
+}
+
+// relocate adds delayBase to the delay indices and sampleBase to the sample
+// numbers in the Values, when the delay times and sample offsets are moved
+// into bigger tables.
+func (b *BytePatch) relocate(featureSet FeatureSet, delayBase, sampleBase int) error {
+	if delayBase == 0 && sampleBase == 0 {
+		return nil
+	}
+	instructions := featureSet.Instructions()
+	colorIndex := 0
+	for _, p := range sointu.UnitTypes["oscillator"] {
+		if p.Name == "color" {
+			break
+		}
+		if p.CanModulate && p.CanSet {
+			colorIndex++
+		}
+	}
+	pos := 0
+	for _, op := range b.Commands {
+		if op>>1 == 0 {
+			continue
+		}
+		index := int(op>>1) - 1
+		if index >= len(instructions) {
+			return fmt.Errorf("unknown opcode %v", op)
+		}
+		unitType := instructions[index]
+		transformCount := featureSet.TransformCount(unitType)
+		next := pos + ValueCount(featureSet, unitType)
+		if next > len(b.Values) {
+			return errors.New("value stream ended prematurely")
+		}
+		switch unitType {
+		case "oscillator":
+			if b.Values[pos+transformCount]&0x80 != 0 {
+				s := int(b.Values[pos+colorIndex]) + sampleBase
+				if s > 255 {
+					return errors.New("the songs have more than 256 different samples together")
+				}
+				b.Values[pos+colorIndex] = byte(s)
+			}
+		case "delay":
+			d := int(b.Values[pos+transformCount]) + delayBase
+			if d > 255 {
+				return errors.New("the merged delay time table is too long, delay indices do not fit in a byte")
+			}
+			b.Values[pos+transformCount] = byte(d)
+		}
+		pos = next
+	}
+	return nil
+}
+
+// ValueCount returns the number of bytes a command of the given unit type
+// takes from the Values of a BytePatch encoded with the given FeatureSet: the
+// transformed parameters, followed by the extra values of aux, in, oscillator,
+// filter, send and delay units.
+func ValueCount(featureSet FeatureSet, unitType string) int {
+	return featureSet.TransformCount(unitType) + extraValueCount(unitType)
+}
+
+func extraValueCount(unitType string) int {
+	switch unitType {
+	case "aux", "in", "oscillator", "filter":
+		return 1 // channel or flags
+	case "send", "delay":
+		return 2 // address, or delay index and count
+	}
+	return 0
+}
+
+// Decode rebuilds a sointu.Patch from a BytePatch encoded with the given
+// FeatureSet, so that Decode(Encode(p)) is equivalent to p. The NumVoices of
+// the instruments are derived from the PolyphonyBitmask and the delay times
+// from the DelayTimes. Units targeted by sends are given new IDs, numbered from
+// 1 in the order the units appear in the patch; other units get no IDs.
+//
+// Some information is lost in encoding and cannot be recovered: the names and
+// comments of the instruments, empty units, delays without any delay lines,
+// the port of sends without a target and whether a global send to another
+// instrument targets voice "auto" (0) or 1, which are the same thing. The color
+// of a Sample oscillator is the index to the SampleOffsets, as in the encoded
+// patch. If the BytePatch has embedded samples, each instrument gets the range
+// of the samples its oscillators use; the samples are not converted back if
+// they have been downsampled or quantized with ConvertSamples.
+func Decode(b *BytePatch, featureSet FeatureSet) (sointu.Patch, error) {
+	instructions := featureSet.Instructions()
+	voiceRanges := instrumentVoices(b)
+	type sendTarget struct{ instr, unit int }
+	var patch sointu.Patch
+	var units []sointu.Unit
+	var sendParams []map[string]int
+	var targets []sendTarget
+	values := b.Values
+	take := func(n int) ([]byte, error) {
+		if len(values) < n {
+			return nil, errors.New("value stream ended prematurely")
+		}
+		ret := values[:n]
+		values = values[n:]
+		return ret, nil
+	}
+	for _, op := range b.Commands {
+		instrIndex := len(patch)
+		if op>>1 == 0 {
+			if instrIndex >= len(voiceRanges) {
+				return nil, fmt.Errorf("the PolyphonyBitmask and NumVoices define only %v instruments, but there are more instruments in the command stream", len(voiceRanges))
+			}
+			r := voiceRanges[instrIndex]
+			patch = append(patch, sointu.Instrument{NumVoices: r[1] - r[0] + 1, Units: units})
+			units = nil
+			continue
+		}
+		index := int(op>>1) - 1
+		if index >= len(instructions) {
+			return nil, fmt.Errorf("instrument %v: unknown opcode %v", instrIndex, op)
+		}
+		unit := sointu.Unit{Type: instructions[index], Parameters: map[string]int{}}
+		for _, p := range sointu.UnitTypes[unit.Type] {
+			if p.CanSet {
+				unit.Parameters[p.Name] = 0
+			}
+		}
+		if _, ok := unit.Parameters["stereo"]; ok {
+			unit.Parameters["stereo"] = int(op & 1)
+		}
+		transformed, err := take(featureSet.TransformCount(unit.Type))
+		if err != nil {
+			return nil, fmt.Errorf("instrument %v, unit %v: %v", instrIndex, len(units), err)
+		}
+		i := 0
+		for _, p := range sointu.UnitTypes[unit.Type] {
+			if p.CanModulate && p.CanSet && i < len(transformed) {
+				unit.Parameters[p.Name] = int(transformed[i])
+				i++
+			}
+		}
+		v, err := take(extraValueCount(unit.Type))
+		if err != nil {
+			return nil, fmt.Errorf("instrument %v, unit %v: %v", instrIndex, len(units), err)
+		}
+		switch unit.Type {
+		case "aux", "in":
+			unit.Parameters["channel"] = int(v[0])
+		case "oscillator":
+			switch {
+			case v[0]&0x80 != 0:
+				unit.Parameters["type"] = sointu.Sample
+				s := unit.Parameters["color"]
+				if s >= len(b.SampleOffsets) {
+					return nil, fmt.Errorf("instrument %v, unit %v: sample offset %v out of range", instrIndex, len(units), s)
+				}
+				unit.Parameters["samplestart"] = int(b.SampleOffsets[s].Start)
+				unit.Parameters["loopstart"] = int(b.SampleOffsets[s].LoopStart)
+				unit.Parameters["looplength"] = int(b.SampleOffsets[s].LoopLength)
+			case v[0]&0x40 != 0:
+				unit.Parameters["type"] = sointu.Sine
+			case v[0]&0x20 != 0:
+				unit.Parameters["type"] = sointu.Trisaw
+			case v[0]&0x10 != 0:
+				unit.Parameters["type"] = sointu.Pulse
+			case v[0]&0x04 != 0:
+				unit.Parameters["type"] = sointu.Gate
+			}
+			unit.Parameters["lfo"] = int(v[0]>>3) & 1
+			unit.Parameters["unison"] = int(v[0] & 3)
+		case "filter":
+			unit.Parameters["lowpass"] = int(v[0]>>6) & 1
+			unit.Parameters["bandpass"] = int(v[0]>>5) & 1
+			unit.Parameters["highpass"] = int(v[0]>>4) & 1
+			unit.Parameters["negbandpass"] = int(v[0]>>3) & 1
+			unit.Parameters["neghighpass"] = int(v[0]>>2) & 1
+		case "send":
+			addr := uint16(v[0]) + uint16(v[1])<<8
+			unit.Parameters["sendpop"] = int(addr>>3) & 1
+			if addr|0x8 == 0xFFFF {
+				break // no target
+			}
+			unit.Parameters["port"] = int(addr & 7)
+			slot := int(addr&0x7FF0) >> 4
+			target := sendTarget{instr: instrIndex, unit: slot - 1}
+			if addr&0x8000 != 0 {
+				slot -= 2
+				voice := slot / 64
+				target = sendTarget{instr: -1, unit: slot % 64}
+				for i, r := range voiceRanges {
+					if voice >= r[0] && voice <= r[1] {
+						target.instr = i
+						// voice 0 ("auto") to another instrument is the same
+						// as voice 1, but to the same instrument it would have
+						// been a local send
+						if voice > r[0] || i == instrIndex {
+							unit.Parameters["voice"] = voice - r[0] + 1
+						}
+					}
+				}
+				if target.instr == -1 {
+					return nil, fmt.Errorf("instrument %v, unit %v: send targets voice %v, which does not exist", instrIndex, len(units), voice)
+				}
+			}
+			sendParams = append(sendParams, unit.Parameters)
+			targets = append(targets, target)
+		case "delay":
+			count := (int(v[1]) + 1) / 2
+			if unit.Parameters["stereo"] == 1 {
+				count *= 2
+			}
+			if int(v[0])+count > len(b.DelayTimes) {
+				return nil, fmt.Errorf("instrument %v, unit %v: delay lines %v-%v out of range", instrIndex, len(units), v[0], int(v[0])+count-1)
+			}
+			for _, t := range b.DelayTimes[v[0] : int(v[0])+count] {
+				unit.VarArgs = append(unit.VarArgs, int(t))
+			}
+			unit.Parameters["notetracking"] = 1 - int(v[1]&1)
+		}
+		units = append(units, unit)
+	}
+	if len(units) > 0 {
+		return nil, errors.New("the last instrument is missing the advance command (opcode 0)")
+	}
+	if len(values) > 0 {
+		return nil, fmt.Errorf("%v unused bytes left in the value stream", len(values))
+	}
+	ids := map[sendTarget]int{}
+	for _, t := range targets {
+		if t.instr >= len(patch) || t.unit < 0 || t.unit >= len(patch[t.instr].Units) {
+			return nil, fmt.Errorf("a send targets unit %v of instrument %v, which does not exist", t.unit, t.instr)
+		}
+		ids[t] = 0
+	}
+	id := 1
+	for i, instr := range patch {
+		for u := range instr.Units {
+			if _, ok := ids[sendTarget{i, u}]; ok {
+				ids[sendTarget{i, u}] = id
+				patch[i].Units[u].ID = id
+				id++
+			}
+		}
+	}
+	for i, t := range targets {
+		sendParams[i]["target"] = ids[t]
+	}
+	if len(b.SampleData) > 0 {
+		for i, instr := range patch {
+			start, end := len(b.SampleData), 0
+			for _, u := range instr.Units {
+				if u.Type == "oscillator" && u.Parameters["type"] == sointu.Sample {
+					if s := u.Parameters["samplestart"]; s < start {
+						start = s
+					}
+					if e := u.Parameters["samplestart"] + u.Parameters["loopstart"] + u.Parameters["looplength"]; e > end {
+						end = e
+					}
+				}
+			}
+			if end == 0 {
+				continue
+			}
+			if end > len(b.SampleData) {
+				return nil, fmt.Errorf("instrument %v uses samples up to %v, but there are only %v samples", i, end, len(b.SampleData))
+			}
+			data := make([]int16, end-start)
+			copy(data, b.SampleData[start:end])
+			patch[i].Samples = []sointu.InstrumentSample{{Data: data}}
+			for _, u := range instr.Units {
+				if u.Type == "oscillator" && u.Parameters["type"] == sointu.Sample {
+					u.Parameters["samplestart"] -= start
+				}
+			}
+		}
+	}
+	return patch, nil
+}
+
+// ConvertSamples converts the embedded samples and the sample offsets to the
+// given format: the samples are decimated by averaging and/or quantized to 8
+// bits. The quantized samples are still stored as int16s, with the low byte
+// zero. If the patch has no embedded samples, ConvertSamples does nothing, as
+// gm.dls cannot be converted.
+func (b *BytePatch) ConvertSamples(format SampleFormat) {
+	if len(b.SampleData) == 0 {
+		return
+	}
+	if k := format.Downsample; k > 1 {
+		data := make([]int16, (len(b.SampleData)+k-1)/k)
+		for i := range data {
+			sum, n := 0, 0
+			for j := i * k; j < (i+1)*k && j < len(b.SampleData); j++ {
+				sum += int(b.SampleData[j])
+				n++
+			}
+			data[i] = int16(sum / n)
+		}
+		b.SampleData = data
+		for i, s := range b.SampleOffsets {
+			s.Start /= uint32(k)
+			s.LoopStart /= uint16(k)
+			s.LoopLength /= uint16(k)
+			if s.LoopLength == 0 {
+				s.LoopLength = 1
+			}
+			b.SampleOffsets[i] = s
+		}
+	}
+	if format.EightBit {
+		for i, v := range b.SampleData {
+			q := math.Round(float64(v) / 256)
+			b.SampleData[i] = int16(math.Max(math.Min(q, 127), -128)) * 256
+		}
+	}
+	b.SampleFormat = format
+}
+
+// SamplePhaseScale returns the number of samples a Sample oscillator advances
+// in the sample data per unit of phase, taking the downsampling into account.
+func (b *BytePatch) SamplePhaseScale() float32 {
+	if k := b.SampleFormat.Downsample; k > 1 {
+		return float32(84.28074964676522 / float64(k))
+	}
+	return 84.28074964676522
+}
+
+// EncodedSamples returns the embedded samples as they are stored in a compiled
+// song: one byte per sample if the samples are 8-bit, otherwise little-endian
+// int16s.
+func (b *BytePatch) EncodedSamples() []byte {
+	if b.SampleFormat.EightBit {
+		ret := make([]byte, len(b.SampleData))
+		for i, v := range b.SampleData {
+			ret[i] = byte(v >> 8)
+		}
+		return ret
+	}
+	ret := make([]byte, len(b.SampleData)*2)
+	for i, v := range b.SampleData {
+		binary.LittleEndian.PutUint16(ret[i*2:], uint16(v))
+	}
+	return ret
+}
+
+// SampleTableLength returns the minimum number of int16s the sample table needs
+// to have, so that none of the SampleOffsets reach outside the table.
+func (b *BytePatch) SampleTableLength() int {
+	ret := 0
+	for _, s := range b.SampleOffsets {
+		if end := int(s.Start) + int(s.LoopStart) + int(s.LoopLength); end > ret {
+			ret = end
+		}
+	}
+	return ret
+}
+
 func polyphonyBitmask(patch sointu.Patch) uint32 {
 	var ret uint32 = 0
 	for _, instr := range patch {
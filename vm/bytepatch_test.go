@@ -0,0 +1,219 @@
+package vm_test
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
+)
+
+func TestConvertSamples(t *testing.T) {
+	b := vm.BytePatch{
+		SampleData:    []int16{0, 1000, 2000, 3000, 4000, 5000, -3000, -2000, 7},
+		SampleOffsets: []vm.SampleOffset{{Start: 1, LoopStart: 4, LoopLength: 1}},
+	}
+	b.ConvertSamples(vm.SampleFormat{Downsample: 2, EightBit: true})
+	if expected := []int16{2 * 256, 10 * 256, 18 * 256, -10 * 256, 0}; !reflect.DeepEqual(b.SampleData, expected) {
+		t.Fatalf("expected converted samples %v, got %v", expected, b.SampleData)
+	}
+	if expected := (vm.SampleOffset{Start: 0, LoopStart: 2, LoopLength: 1}); b.SampleOffsets[0] != expected {
+		t.Fatalf("expected converted sample offset %+v, got %+v", expected, b.SampleOffsets[0])
+	}
+	if expected := []byte{2, 10, 18, 246, 0}; !reflect.DeepEqual(b.EncodedSamples(), expected) {
+		t.Fatalf("expected 8-bit encoded samples %v, got %v", expected, b.EncodedSamples())
+	}
+	if scale := b.SamplePhaseScale(); scale != float32(84.28074964676522/2) {
+		t.Fatalf("expected the phase scale to be halved when downsampling by 2, got %v", scale)
+	}
+}
+
+func TestDecodeRegressionTests(t *testing.T) {
+	forEachTestSong(t, func(t *testing.T, song sointu.Song) {
+		features := vm.NecessaryFeaturesFor(song.Patch)
+		encoded, err := vm.Encode(song.Patch, features)
+		if err != nil {
+			t.Fatalf("encoding failed: %v", err)
+		}
+		decoded, err := vm.Decode(encoded, features)
+		if err != nil {
+			t.Fatalf("decoding failed: %v", err)
+		}
+		reencoded, err := vm.Encode(decoded, features)
+		if err != nil {
+			t.Fatalf("encoding the decoded patch failed: %v", err)
+		}
+		if !reflect.DeepEqual(encoded, reencoded) {
+			t.Fatalf("encoding the decoded patch gave a different BytePatch:\n%+v\nvs.\n%+v", encoded, reencoded)
+		}
+	})
+}
+
+func TestDecodeRandomPatches(t *testing.T) {
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 500; i++ {
+		patch := randomPatch(r)
+		encoded, err := vm.Encode(patch, vm.AllFeatures{})
+		if err != nil {
+			t.Fatalf("patch %v: encoding failed: %v", i, err)
+		}
+		decoded, err := vm.Decode(encoded, vm.AllFeatures{})
+		if err != nil {
+			t.Fatalf("patch %v: decoding failed: %v", i, err)
+		}
+		// Encode sets the color of the Sample oscillators of the original patch
+		// to the index of the sample offset, so it's comparable to the decoded
+		expected, actual := normalizeSends(patch), normalizeSends(decoded)
+		if !reflect.DeepEqual(expected, actual) {
+			t.Fatalf("patch %v: decoded patch differs from the original:\n%+v\nvs.\n%+v", i, expected, actual)
+		}
+	}
+}
+
+func TestEncodePatches(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		patches := make([]sointu.Patch, 1+r.Intn(3))
+		for j := range patches {
+			patches[j] = randomPatch(r)
+		}
+		encoded, err := vm.EncodePatches(patches, vm.AllFeatures{})
+		if err != nil {
+			t.Fatalf("case %v: encoding failed: %v", i, err)
+		}
+		for j, b := range encoded {
+			if !reflect.DeepEqual(b.DelayTimes, encoded[0].DelayTimes) || !reflect.DeepEqual(b.SampleOffsets, encoded[0].SampleOffsets) {
+				t.Fatalf("case %v: patch %v does not share the delay times and sample offsets of patch 0", i, j)
+			}
+			decoded, err := vm.Decode(b, vm.AllFeatures{})
+			if err != nil {
+				t.Fatalf("case %v: decoding patch %v failed: %v", i, j, err)
+			}
+			// the colors of the Sample oscillators are indices to the shared
+			// sample offsets, so only the decoded sample offsets are compared
+			expected, actual := clearSampleColors(normalizeSends(patches[j])), clearSampleColors(normalizeSends(decoded))
+			if !reflect.DeepEqual(expected, actual) {
+				t.Fatalf("case %v: decoded patch %v differs from the original:\n%+v\nvs.\n%+v", i, j, expected, actual)
+			}
+		}
+	}
+}
+
+func clearSampleColors(patch sointu.Patch) sointu.Patch {
+	for _, instr := range patch {
+		for _, unit := range instr.Units {
+			if unit.Type == "oscillator" && unit.Parameters["type"] == sointu.Sample {
+				unit.Parameters["color"] = 0
+			}
+		}
+	}
+	return patch
+}
+
+func randomPatch(r *rand.Rand) sointu.Patch {
+	var types []string
+	for t := range sointu.UnitTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	patch := make(sointu.Patch, 1+r.Intn(4))
+	for i := range patch {
+		patch[i].NumVoices = 1 + r.Intn(3)
+		patch[i].Units = make([]sointu.Unit, 1+r.Intn(10))
+		for u := range patch[i].Units {
+			unit := sointu.Unit{Type: types[r.Intn(len(types))], ID: i*100 + u + 1, Parameters: map[string]int{}}
+			for _, p := range sointu.UnitTypes[unit.Type] {
+				if p.CanSet {
+					unit.Parameters[p.Name] = p.MinValue + r.Intn(p.MaxValue-p.MinValue+1)
+				}
+			}
+			switch unit.Type {
+			case "oscillator":
+				if unit.Parameters["type"] != sointu.Sample {
+					// only the Sample oscillators encode the sample offsets
+					unit.Parameters["samplestart"], unit.Parameters["loopstart"], unit.Parameters["looplength"] = 0, 0, 0
+				} else if unit.Parameters["looplength"] == 0 {
+					unit.Parameters["looplength"] = 1
+				}
+			case "delay":
+				n := 1 + r.Intn(3)
+				if unit.Parameters["stereo"] == 1 {
+					n *= 2
+				}
+				for j := 0; j < n; j++ {
+					unit.VarArgs = append(unit.VarArgs, r.Intn(65536))
+				}
+			}
+			patch[i].Units[u] = unit
+		}
+	}
+	for i, instr := range patch {
+		for _, unit := range instr.Units {
+			if unit.Type == "send" {
+				ti := r.Intn(len(patch))
+				unit.Parameters["target"] = patch[ti].Units[r.Intn(len(patch[ti].Units))].ID
+				unit.Parameters["voice"] = r.Intn(patch[ti].NumVoices + 1)
+				if ti != i && unit.Parameters["voice"] == 1 {
+					unit.Parameters["voice"] = 0 // voice 0 to another instrument is the same as voice 1
+				}
+			}
+		}
+	}
+	return patch
+}
+
+// normalizeSends replaces the target IDs of the sends with the indices of the
+// targeted units and removes the IDs, so that patches with differently
+// numbered IDs can be compared
+func normalizeSends(patch sointu.Patch) sointu.Patch {
+	ret := patch.Copy()
+	for i, instr := range ret {
+		for u, unit := range instr.Units {
+			if unit.Type == "send" {
+				if ti, tu, err := patch.FindSendTarget(unit.Parameters["target"]); err == nil {
+					unit.Parameters["target"] = ti*64 + tu + 1
+				}
+			}
+			ret[i].Units[u].ID = 0
+		}
+	}
+	return ret
+}
+
+func TestDecodeEmbeddedSamples(t *testing.T) {
+	osc := func(start, loopStart, loopLength int) sointu.Unit {
+		return sointu.Unit{Type: "oscillator", Parameters: map[string]int{"type": sointu.Sample, "samplestart": start, "loopstart": loopStart, "looplength": loopLength}}
+	}
+	patch := sointu.Patch{
+		{NumVoices: 1, Units: []sointu.Unit{osc(2, 1, 2)}, Samples: []sointu.InstrumentSample{{Data: []int16{1, 2, 3, 4, 5, 6}}}},
+		{NumVoices: 1, Units: []sointu.Unit{osc(0, 0, 3), osc(1, 1, 1)}, Samples: []sointu.InstrumentSample{{Data: []int16{7, 8, 9}}}},
+	}
+	encoded, err := vm.Encode(patch, vm.AllFeatures{})
+	if err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	decoded, err := vm.Decode(encoded, vm.AllFeatures{})
+	if err != nil {
+		t.Fatalf("decoding failed: %v", err)
+	}
+	if expected := []int16{3, 4, 5}; !reflect.DeepEqual(decoded[0].Samples[0].Data, expected) {
+		t.Errorf("expected instrument 0 to get the samples %v it uses, got %v", expected, decoded[0].Samples[0].Data)
+	}
+	if s := decoded[0].Units[0].Parameters["samplestart"]; s != 0 {
+		t.Errorf("expected samplestart to be relative to the samples of the instrument, got %v", s)
+	}
+	reencoded, err := vm.Encode(decoded, vm.AllFeatures{})
+	if err != nil {
+		t.Fatalf("encoding the decoded patch failed: %v", err)
+	}
+	for i, s := range encoded.SampleOffsets {
+		a := encoded.SampleData[s.Start : s.Start+uint32(s.LoopStart)+uint32(s.LoopLength)]
+		r := reencoded.SampleOffsets[i]
+		b := reencoded.SampleData[r.Start : r.Start+uint32(r.LoopStart)+uint32(r.LoopLength)]
+		if !reflect.DeepEqual(a, b) || s.LoopStart != r.LoopStart || s.LoopLength != r.LoopLength {
+			t.Errorf("sample offset %v plays different samples after the round-trip: %v vs. %v", i, a, b)
+		}
+	}
+}
@@ -5,6 +5,7 @@ package bridge
 // #include <sointu.h>
 import "C"
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -16,6 +17,16 @@ import (
 type BridgeService struct {
 }
 
+// Features are the features the library was compiled with, read from
+// SU_FEATURES of sointu.h. Patches using other features cannot be played.
+var Features vm.FeatureList
+
+func init() {
+	if err := json.Unmarshal([]byte(C.SU_FEATURES), &Features); err != nil {
+		panic(fmt.Errorf("invalid SU_FEATURES in sointu.h: %v", err))
+	}
+}
+
 func (s BridgeService) Compile(patch sointu.Patch) (sointu.Synth, error) {
 	synth, err := Synth(patch)
 	return synth, err
@@ -26,7 +37,10 @@ func Synth(patch sointu.Patch) (*C.Synth, error) {
 	if n := patch.NumDelayLines(); n > 64 {
 		return nil, fmt.Errorf("native bridge has currently a hard limit of 64 delaylines; patch uses %v", n)
 	}
-	comPatch, err := vm.Encode(patch, vm.AllFeatures{})
+	if err := vm.CheckPatch(patch, Features); err != nil {
+		return nil, fmt.Errorf("the library cannot play the patch: %v", err)
+	}
+	comPatch, err := vm.Encode(patch, Features)
 	if err != nil {
 		return nil, fmt.Errorf("error compiling patch: %v", err)
 	}
@@ -50,12 +64,33 @@ func Synth(patch sointu.Patch) (*C.Synth, error) {
 		s.SampleOffsets[i].LoopStart = (C.ushort)(v.LoopStart)
 		s.SampleOffsets[i].LoopLength = (C.ushort)(v.LoopLength)
 	}
+	if len(comPatch.SampleData) > 0 {
+		// the library has only one sample table, so the embedded samples replace
+		// whatever sample bank was loaded before
+		if err := LoadSampleTable(vm.SampleTable(comPatch.SampleData)); err != nil {
+			return nil, err
+		}
+	}
 	s.NumVoices = C.uint(comPatch.NumVoices)
 	s.Polyphony = C.uint(comPatch.PolyphonyBitmask)
 	s.RandSeed = 1
 	return s, nil
 }
 
+// LoadSampleTable copies a sample bank (e.g. gm.dls or a .sf2 file loaded with
+// vm.LoadSampleTable) into the sample table of the library, used by all
+// Synths. Returns an error, leaving the table untouched, if the samples do not
+// fit in the table.
+func LoadSampleTable(table vm.SampleTable) error {
+	if len(table) > len(C.su_sample_table) {
+		return fmt.Errorf("the sample table of the library has room for %v samples; the sample bank has %v", len(C.su_sample_table), len(table))
+	}
+	for i, v := range table {
+		C.su_sample_table[i] = C.short(v)
+	}
+	return nil
+}
+
 // Render renders until the buffer is full or the modulated time is reached, whichever
 // happens first.
 // Parameters:
@@ -109,7 +144,10 @@ func (s *C.Synth) Update(patch sointu.Patch) error {
 	if n := patch.NumDelayLines(); n > 64 {
 		return fmt.Errorf("native bridge has currently a hard limit of 64 delaylines; patch uses %v", n)
 	}
-	comPatch, err := vm.Encode(patch, vm.AllFeatures{})
+	if err := vm.CheckPatch(patch, Features); err != nil {
+		return fmt.Errorf("the library cannot play the patch: %v", err)
+	}
+	comPatch, err := vm.Encode(patch, Features)
 	if err != nil {
 		return fmt.Errorf("error compiling patch: %v", err)
 	}
@@ -137,6 +175,13 @@ func (s *C.Synth) Update(patch sointu.Patch) error {
 		s.SampleOffsets[i].LoopStart = (C.ushort)(v.LoopStart)
 		s.SampleOffsets[i].LoopLength = (C.ushort)(v.LoopLength)
 	}
+	if len(comPatch.SampleData) > 0 {
+		// the library has only one sample table, so the embedded samples replace
+		// whatever sample bank was loaded before
+		if err := LoadSampleTable(vm.SampleTable(comPatch.SampleData)); err != nil {
+			return err
+		}
+	}
 	s.NumVoices = C.uint(comPatch.NumVoices)
 	s.Polyphony = C.uint(comPatch.PolyphonyBitmask)
 	if needsRefresh {
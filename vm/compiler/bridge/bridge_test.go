@@ -14,6 +14,7 @@ import (
 	"testing"
 
 	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
 	"github.com/vsariola/sointu/vm/compiler/bridge"
 	"gopkg.in/yaml.v2"
 	// TODO: test the song using a mocks instead
@@ -72,6 +73,15 @@ func TestRenderSamples(t *testing.T) {
 	compareToRawFloat32(t, buffer, "test_render_samples.raw")
 }
 
+func TestLoadSampleTableTooLarge(t *testing.T) {
+	// the sample table of the library is 3440660 bytes, the size of gm.dls; a
+	// table that fits is not loaded here, as it would replace gm.dls loaded
+	// for the regression tests
+	if err := bridge.LoadSampleTable(make(vm.SampleTable, 3440660/2+1)); err == nil {
+		t.Errorf("a sample table larger than the one of the library should not load")
+	}
+}
+
 func TestAllRegressionTests(t *testing.T) {
 	_, myname, _, _ := runtime.Caller(0)
 	files, err := filepath.Glob(path.Join(path.Dir(myname), "..", "..", "..", "tests", "*.yml"))
@@ -2,10 +2,13 @@ package compiler
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"go/format"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig"
@@ -14,14 +17,21 @@ import (
 )
 
 type Compiler struct {
-	Template    *template.Template
-	OS          string
-	Arch        string
-	Output16Bit bool
-	RowSync     bool
+	Template     *template.Template
+	OS           string
+	Arch         string
+	Output16Bit  bool
+	RowSync      bool
+	SampleFormat vm.SampleFormat // format of the samples embedded in the instruments
+	GoPackage    string          // package name of the .go player; "player" if empty
+	Chunked      bool            // the 386 and amd64 players also have su_render_chunk and su_seek, for rendering the song in pieces
+	Features     vm.FeatureSet   // the features of the library; nil means all the features
+	Events       bool            // the players also have the event tables of the tracks (vm.Events), e.g. for syncing visuals
 }
 
-// New returns a new compiler using the default .asm templates
+// New returns a new compiler using the default templates of the architecture:
+// .asm for 386 and amd64, .wat for wasm, portable C source for c and a Go
+// package for go.
 func New(os string, arch string, output16Bit bool, rowsync bool) (*Compiler, error) {
 	_, myname, _, _ := runtime.Caller(0)
 	var subdir string
@@ -29,8 +39,12 @@ func New(os string, arch string, output16Bit bool, rowsync bool) (*Compiler, err
 		subdir = "amd64-386"
 	} else if arch == "wasm" {
 		subdir = "wasm"
+	} else if arch == "c" {
+		subdir = "c"
+	} else if arch == "go" {
+		subdir = "go"
 	} else {
-		return nil, fmt.Errorf("compiler.New failed, because only amd64, 386 and wasm archs are supported (targeted architecture was %v)", arch)
+		return nil, fmt.Errorf("compiler.New failed, because only amd64, 386, wasm, c and go archs are supported (targeted architecture was %v)", arch)
 	}
 	templateDir := filepath.Join(path.Dir(myname), "..", "..", "templates", subdir)
 	compiler, err := NewFromTemplates(os, arch, output16Bit, rowsync, templateDir)
@@ -46,24 +60,57 @@ func NewFromTemplates(os string, arch string, output16Bit bool, rowsync bool, te
 	return &Compiler{Template: tmpl, OS: os, Arch: arch, RowSync: rowsync, Output16Bit: output16Bit}, nil
 }
 
+// Library compiles Sointu into a library, which loads the patch at runtime:
+// .asm and .h for 386 and amd64; .wat, .js (the javascript glue and the
+// AudioWorklet processor) and .html (a minimal page to play the patch) for
+// wasm. The library has the Features of the compiler, or all the features of
+// the VM if Features is nil; the patches loaded should be encoded with the same
+// features.
 func (com *Compiler) Library() (map[string]string, error) {
-	if com.Arch != "386" && com.Arch != "amd64" {
-		return nil, fmt.Errorf(`compiling as a library is supported only on 386 and amd64 architectures (targeted architecture was %v)`, com.Arch)
+	if com.Arch != "386" && com.Arch != "amd64" && com.Arch != "wasm" {
+		return nil, fmt.Errorf(`compiling as a library is supported only on 386, amd64 and wasm architectures (targeted architecture was %v)`, com.Arch)
+	}
+	if com.Arch == "wasm" && (com.Output16Bit || com.RowSync) {
+		return nil, errors.New(`the wasm library outputs only float samples and no syncs`)
 	}
 	templates := []string{"library.asm", "library.h"}
-	features := vm.AllFeatures{}
+	if com.Arch == "wasm" {
+		templates = []string{"library.wat", "library.js", "library.html"}
+	}
+	var features vm.FeatureSet = vm.AllFeatures{}
+	if com.Features != nil {
+		features = com.Features
+	}
+	featureList := vm.FeatureListFor(features)
 	retmap := map[string]string{}
 	for _, templateName := range templates {
 		compilerMacros := *NewCompilerMacros(*com)
 		compilerMacros.Library = true
 		featureSetMacros := FeatureSetMacros{features}
-		x86Macros := *NewX86Macros(com.OS, com.Arch == "amd64", features, false)
-		data := struct {
-			CompilerMacros
-			FeatureSetMacros
-			X86Macros
-		}{compilerMacros, featureSetMacros, x86Macros}
-		populatedTemplate, extension, err := com.compile(templateName, &data)
+		var populatedTemplate, extension string
+		var err error
+		if com.Arch == "wasm" {
+			wasmMacros := *NewWasmMacros()
+			// the patch is loaded at runtime, so the BytePatch only tells
+			// that the sample table has 16-bit samples that are not downsampled
+			data := struct {
+				CompilerMacros
+				FeatureSetMacros
+				WasmMacros
+				*vm.BytePatch
+				FeatureList vm.FeatureList
+			}{compilerMacros, featureSetMacros, wasmMacros, &vm.BytePatch{}, featureList}
+			populatedTemplate, extension, err = com.compile(templateName, &data)
+		} else {
+			x86Macros := *NewX86Macros(com.OS, com.Arch == "amd64", features, false)
+			data := struct {
+				CompilerMacros
+				FeatureSetMacros
+				X86Macros
+				FeatureList vm.FeatureList
+			}{compilerMacros, featureSetMacros, x86Macros, featureList}
+			populatedTemplate, extension, err = com.compile(templateName, &data)
+		}
 		if err != nil {
 			return nil, fmt.Errorf(`could not execute template "%v": %v`, templateName, err)
 		}
@@ -73,14 +120,24 @@ func (com *Compiler) Library() (map[string]string, error) {
 }
 
 func (com *Compiler) Song(song *sointu.Song) (map[string]string, error) {
-	if com.Arch != "386" && com.Arch != "amd64" && com.Arch != "wasm" {
-		return nil, fmt.Errorf(`compiling a song player is supported only on 386, amd64 and wasm architectures (targeted architecture was %v)`, com.Arch)
+	if com.Arch != "386" && com.Arch != "amd64" && com.Arch != "wasm" && com.Arch != "c" && com.Arch != "go" {
+		return nil, fmt.Errorf(`compiling a song player is supported only on 386, amd64, wasm, c and go architectures (targeted architecture was %v)`, com.Arch)
+	}
+	if com.Arch == "go" && (com.Output16Bit || com.RowSync) {
+		return nil, errors.New(`the go player outputs only float samples and no syncs`)
+	}
+	if com.Chunked && com.Arch != "386" && com.Arch != "amd64" {
+		return nil, fmt.Errorf(`rendering in chunks is supported only on 386 and amd64 architectures (targeted architecture was %v)`, com.Arch)
 	}
 	var templates []string
 	if com.Arch == "386" || com.Arch == "amd64" {
 		templates = []string{"player.asm", "player.h"}
 	} else if com.Arch == "wasm" {
 		templates = []string{"player.wat"}
+	} else if com.Arch == "c" {
+		templates = []string{"player.c", "player.h"}
+	} else if com.Arch == "go" {
+		templates = []string{"player.go.tmpl"}
 	}
 	features := vm.NecessaryFeaturesFor(song.Patch)
 	retmap := map[string]string{}
@@ -88,10 +145,22 @@ func (com *Compiler) Song(song *sointu.Song) (map[string]string, error) {
 	if err != nil {
 		return nil, fmt.Errorf(`could not encode patch: %v`, err)
 	}
-	patterns, sequences, err := vm.ConstructPatterns(song)
+	if len(encodedPatch.SampleOffsets) > 0 && len(encodedPatch.SampleData) == 0 && (com.Arch == "wasm" || com.Arch == "go") {
+		return nil, fmt.Errorf(`the %v player cannot load gm.dls; embed the samples in the instruments instead`, com.Arch)
+	}
+	encodedPatch.ConvertSamples(com.SampleFormat)
+	patternLength, err := vm.OptimalPatternLength(song)
+	if err != nil {
+		return nil, fmt.Errorf(`could not encode song: %v`, err)
+	}
+	patterns, sequences, err := vm.ConstructPatternsWithLength(song, patternLength)
 	if err != nil {
 		return nil, fmt.Errorf(`could not encode song: %v`, err)
 	}
+	events, err := com.events(song)
+	if err != nil {
+		return nil, err
+	}
 	for _, templateName := range templates {
 		compilerMacros := *NewCompilerMacros(*com)
 		featureSetMacros := FeatureSetMacros{features}
@@ -111,7 +180,8 @@ func (com *Compiler) Song(song *sointu.Song) (map[string]string, error) {
 				PatternLength  int
 				SequenceLength int
 				Hold           int
-			}{compilerMacros, featureSetMacros, x86Macros, songMacros, encodedPatch, patterns, sequences, len(patterns[0]), len(sequences[0]), 1}
+				Events         []vm.TrackEvents
+			}{compilerMacros, featureSetMacros, x86Macros, songMacros, encodedPatch, patterns, sequences, len(patterns[0]), len(sequences[0]), 1, events}
 			populatedTemplate, extension, err = com.compile(templateName, &data)
 		} else if com.Arch == "wasm" {
 			wasmMacros := *NewWasmMacros()
@@ -126,7 +196,22 @@ func (com *Compiler) Song(song *sointu.Song) (map[string]string, error) {
 				PatternLength  int
 				SequenceLength int
 				Hold           int
-			}{compilerMacros, featureSetMacros, wasmMacros, songMacros, encodedPatch, patterns, sequences, len(patterns[0]), len(sequences[0]), 1}
+				Events         []vm.TrackEvents
+			}{compilerMacros, featureSetMacros, wasmMacros, songMacros, encodedPatch, patterns, sequences, len(patterns[0]), len(sequences[0]), 1, events}
+			populatedTemplate, extension, err = com.compile(templateName, &data)
+		} else if com.Arch == "c" || com.Arch == "go" {
+			data := struct {
+				CompilerMacros
+				FeatureSetMacros
+				SongMacros
+				*vm.BytePatch
+				Patterns       [][]byte
+				Sequences      [][]byte
+				PatternLength  int
+				SequenceLength int
+				Hold           int
+				Events         []vm.TrackEvents
+			}{compilerMacros, featureSetMacros, songMacros, encodedPatch, patterns, sequences, len(patterns[0]), len(sequences[0]), 1, events}
 			populatedTemplate, extension, err = com.compile(templateName, &data)
 		}
 		if err != nil {
@@ -137,9 +222,128 @@ func (com *Compiler) Song(song *sointu.Song) (map[string]string, error) {
 	return retmap, nil
 }
 
+// songData is the song specific part of a player compiled with Songs.
+type songData struct {
+	SongMacros
+	*vm.BytePatch
+	Patterns       [][]byte
+	Sequences      [][]byte
+	PatternLength  int
+	SequenceLength int
+	Events         []vm.TrackEvents
+}
+
+// events returns the event tables of the song if the compiler should output
+// them, nil otherwise. The rows are stored as 16-bit words in the players.
+func (com *Compiler) events(song *sointu.Song) ([]vm.TrackEvents, error) {
+	if !com.Events {
+		return nil, nil
+	}
+	if l := song.Score.LengthInRows(); l > 65536 {
+		return nil, fmt.Errorf(`the event tables support songs of at most 65536 rows; the song has %v rows`, l)
+	}
+	return vm.Events(song.Score), nil
+}
+
+// Songs compiles several songs into one 386 or amd64 player, which has one
+// synth with the union of the features of the songs. Each song has its own
+// patch, pattern and sequence tables, found by su_render_song(buffer, song)
+// from a table indexed by the song; the delay times and sample offsets are
+// shared.
+func (com *Compiler) Songs(songs []*sointu.Song) (map[string]string, error) {
+	if com.Arch != "386" && com.Arch != "amd64" {
+		return nil, fmt.Errorf(`compiling several songs into one player is supported only on 386 and amd64 architectures (targeted architecture was %v)`, com.Arch)
+	}
+	if com.Chunked {
+		return nil, errors.New(`rendering in chunks is not supported when compiling several songs into one player`)
+	}
+	if len(songs) == 0 {
+		return nil, errors.New(`no songs to compile`)
+	}
+	patches := make([]sointu.Patch, len(songs))
+	for i, song := range songs {
+		if com.RowSync && song.SamplesPerRow() != songs[0].SamplesPerRow() {
+			return nil, fmt.Errorf(`song %v has different number of samples per row than song 0; the row sync needs the same for all songs`, i)
+		}
+		patches[i] = song.Patch
+	}
+	features := vm.NecessaryFeaturesForAll(patches)
+	encodedPatches, err := vm.EncodePatches(patches, features)
+	if err != nil {
+		return nil, fmt.Errorf(`could not encode patches: %v`, err)
+	}
+	// the delay times and the samples are shared by all the songs, so the
+	// samples are converted once and the converted tables given to every patch
+	encodedPatches[0].ConvertSamples(com.SampleFormat)
+	for _, b := range encodedPatches[1:] {
+		b.SampleOffsets, b.SampleData, b.SampleFormat = encodedPatches[0].SampleOffsets, encodedPatches[0].SampleData, encodedPatches[0].SampleFormat
+	}
+	songDatas := make([]songData, len(songs))
+	voiceTracks := false
+	maxSamples, numDelayLines := 0, 0
+	for i, song := range songs {
+		patternLength, err := vm.OptimalPatternLength(song)
+		if err != nil {
+			return nil, fmt.Errorf(`could not encode song %v: %v`, i, err)
+		}
+		patterns, sequences, err := vm.ConstructPatternsWithLength(song, patternLength)
+		if err != nil {
+			return nil, fmt.Errorf(`could not encode song %v: %v`, i, err)
+		}
+		events, err := com.events(song)
+		if err != nil {
+			return nil, fmt.Errorf(`song %v: %v`, i, err)
+		}
+		songDatas[i] = songData{*NewSongMacros(song), encodedPatches[i], patterns, sequences, len(patterns[0]), len(sequences[0]), events}
+		if songDatas[i].VoiceTrackBitmask != 0 {
+			voiceTracks = true
+		}
+		if songDatas[i].MaxSamples > maxSamples {
+			maxSamples = songDatas[i].MaxSamples
+		}
+		if n := song.Patch.NumDelayLines(); n > numDelayLines {
+			numDelayLines = n
+		}
+	}
+	retmap := map[string]string{}
+	for _, templateName := range []string{"songs.asm", "songs.h"} {
+		compilerMacros := *NewCompilerMacros(*com)
+		featureSetMacros := FeatureSetMacros{features}
+		x86Macros := *NewX86Macros(com.OS, com.Arch == "amd64", features, false)
+		data := struct {
+			CompilerMacros
+			FeatureSetMacros
+			X86Macros
+			*vm.BytePatch
+			Song          *sointu.Song
+			Songs         []songData
+			VoiceTracks   bool
+			MaxSamples    int
+			NumDelayLines int
+			Hold          int
+		}{compilerMacros, featureSetMacros, x86Macros, encodedPatches[0], songs[0], songDatas, voiceTracks, maxSamples, numDelayLines, 1}
+		populatedTemplate, extension, err := com.compile(templateName, &data)
+		if err != nil {
+			return nil, fmt.Errorf(`could not execute template "%v": %v`, templateName, err)
+		}
+		retmap[extension] = populatedTemplate
+	}
+	return retmap, nil
+}
+
+// compile executes the template. Templates of Go source are named .go.tmpl,
+// so that the go tool does not mistake them for source; their output is
+// gofmt'ed.
 func (com *Compiler) compile(templateName string, data interface{}) (string, string, error) {
 	result := bytes.NewBufferString("")
 	err := com.Template.ExecuteTemplate(result, templateName, data)
-	extension := filepath.Ext(templateName)
-	return result.String(), extension, err
+	extension := filepath.Ext(strings.TrimSuffix(templateName, ".tmpl"))
+	if err != nil || extension != ".go" {
+		return result.String(), extension, err
+	}
+	formatted, err := format.Source(result.Bytes())
+	if err != nil {
+		return result.String(), extension, fmt.Errorf(`the generated Go source is invalid: %v`, err)
+	}
+	return string(formatted), extension, nil
 }
@@ -0,0 +1,514 @@
+package compiler_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
+	"github.com/vsariola/sointu/vm/compiler"
+	"gopkg.in/yaml.v2"
+)
+
+// TestCPlayerRegressionTests compiles the regression tests with the c arch and
+// runs them with tests/test_renderer.c, if gcc is found.
+func TestCPlayerRegressionTests(t *testing.T) {
+	gcc, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("gcc not found, skipping the tests of the C player")
+	}
+	_, myname, _, _ := runtime.Caller(0)
+	testDir := path.Join(path.Dir(myname), "..", "..", "tests")
+	files, err := filepath.Glob(path.Join(testDir, "*.yml"))
+	if err != nil {
+		t.Fatalf("cannot glob files in the test directory: %v", err)
+	}
+	type testCase struct {
+		name, filename string
+		output16Bit    bool
+	}
+	var cases []testCase
+	for _, filename := range files {
+		basename := filepath.Base(filename)
+		testname := strings.TrimSuffix(basename, path.Ext(basename))
+		if strings.Contains(testname, "sample") && runtime.GOOS != "windows" {
+			continue // the samples are gm.dls based, and thus require Windows
+		}
+		cases = append(cases, testCase{testname, filename, false})
+	}
+	cases = append(cases, testCase{"test_envelope_16bit", path.Join(testDir, "test_envelope.yml"), true})
+	for _, c := range cases {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			bytes, err := ioutil.ReadFile(c.filename)
+			if err != nil {
+				t.Fatalf("cannot read the .yml file: %v", c.filename)
+			}
+			var song sointu.Song
+			if err := yaml.Unmarshal(bytes, &song); err != nil {
+				t.Fatalf("could not parse the .yml file: %v", err)
+			}
+			rowSync := strings.Contains(c.name, "sync")
+			comp, err := compiler.New(runtime.GOOS, "c", c.output16Bit, rowSync)
+			if err != nil {
+				t.Fatalf("could not create the compiler: %v", err)
+			}
+			player, err := comp.Song(&song)
+			if err != nil {
+				t.Fatalf("compiling the player failed: %v", err)
+			}
+			dir, err := ioutil.TempDir("", "sointu-c-")
+			if err != nil {
+				t.Fatalf("could not create a temporary directory: %v", err)
+			}
+			defer os.RemoveAll(dir)
+			for ext, code := range player {
+				if err := ioutil.WriteFile(filepath.Join(dir, c.name+ext), []byte(code), 0644); err != nil {
+					t.Fatalf("could not write the player: %v", err)
+				}
+			}
+			object := filepath.Join(dir, c.name+".o")
+			out, err := exec.Command(gcc, "-std=c99", "-Wall", "-O2", "-c", "-o", object, filepath.Join(dir, c.name+".c")).CombinedOutput()
+			if err != nil {
+				t.Fatalf("gcc failed to compile the player: %v\n%s", err, out)
+			}
+			if len(out) > 0 {
+				t.Errorf("gcc gave warnings when compiling the player:\n%s", out)
+			}
+			executable := filepath.Join(dir, c.name)
+			out, err = exec.Command(gcc, `-DTEST_HEADER="`+c.name+`.h"`, `-DTEST_NAME="`+c.name+`"`, "-I", dir,
+				"-o", executable, path.Join(testDir, "test_renderer.c"), object, "-lm").CombinedOutput()
+			if err != nil {
+				t.Fatalf("gcc failed to compile the test renderer: %v\n%s", err, out)
+			}
+			args := []string{path.Join(testDir, "expected_output", c.name+".raw")}
+			if rowSync {
+				args = append(args, path.Join(testDir, "expected_output", c.name+"_syncbuf.raw"))
+			}
+			cmd := exec.Command(executable, args...)
+			cmd.Dir = dir
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("the rendered song differs from the expected output: %v\n%s", err, out)
+			}
+		})
+	}
+}
+
+// TestWasmLibrary checks that the wasm library compiles and exports its API.
+// The library is tested against the expected outputs in tests/CMakeLists.txt,
+// if wat2wasm and node are found.
+func TestWasmLibrary(t *testing.T) {
+	comp, err := compiler.New(runtime.GOOS, "wasm", false, false)
+	if err != nil {
+		t.Fatalf("could not create the compiler: %v", err)
+	}
+	library, err := comp.Library()
+	if err != nil {
+		t.Fatalf("compiling the library failed: %v", err)
+	}
+	for _, ext := range []string{".wat", ".js", ".html"} {
+		if len(library[ext]) == 0 {
+			t.Errorf("the library has no %v file", ext)
+		}
+	}
+	for _, export := range []string{`(export "su_render")`, `(export "su_trigger")`, `(export "su_release")`, `(import "m" "pow")`} {
+		if !strings.Contains(library[".wat"], export) {
+			t.Errorf("the .wat of the library does not contain %v", export)
+		}
+	}
+	comp.Output16Bit = true
+	if _, err := comp.Library(); err == nil {
+		t.Error("compiling the wasm library with 16-bit output should fail")
+	}
+}
+
+// TestLibraryFeatures checks that a library compiled with a subset of the
+// features has only the opcodes of the subset and documents them in the .h.
+func TestLibraryFeatures(t *testing.T) {
+	for _, arch := range []string{"386", "amd64"} {
+		comp, err := compiler.New("linux", arch, false, false)
+		if err != nil {
+			t.Fatalf("could not create the compiler: %v", err)
+		}
+		comp.Features = vm.FeatureList{Units: []string{"envelope", "oscillator", "out"}, Modulations: []string{"oscillator.transpose"}}
+		library, err := comp.Library()
+		if err != nil {
+			t.Fatalf("compiling the library for %v failed: %v", arch, err)
+		}
+		for _, op := range []string{"su_op_envelope", "su_op_oscillator", "su_op_out"} {
+			if !strings.Contains(library[".asm"], op) {
+				t.Errorf("the .asm for %v does not contain %v", arch, op)
+			}
+		}
+		if strings.Contains(library[".asm"], "su_op_delay") {
+			t.Errorf("the .asm for %v contains su_op_delay, although delay is not in the features", arch)
+		}
+		for _, define := range []string{"#define SU_OSCILLATOR_ID    4", "#define SU_FEATURES"} {
+			if !strings.Contains(library[".h"], define) {
+				t.Errorf("the .h for %v does not contain %v", arch, define)
+			}
+		}
+		if strings.Contains(library[".h"], "SU_DELAY_ID") {
+			t.Errorf("the .h for %v defines SU_DELAY_ID, although delay is not in the features", arch)
+		}
+	}
+}
+
+// TestEventTables checks that the players of all the architectures have the
+// event tables of the tracks when Events is set.
+func TestEventTables(t *testing.T) {
+	_, myname, _, _ := runtime.Caller(0)
+	songBytes, err := ioutil.ReadFile(path.Join(path.Dir(myname), "..", "..", "tests", "test_chords.yml"))
+	if err != nil {
+		t.Fatalf("cannot read the .yml file: %v", err)
+	}
+	var song sointu.Song
+	if err := yaml.Unmarshal(songBytes, &song); err != nil {
+		t.Fatalf("could not parse the .yml file: %v", err)
+	}
+	expected := map[string][]string{
+		"386":   {"su_event_rows_0:", "su_event_values_0:", "#define SU_EVENTS_0 "},
+		"amd64": {"su_event_rows_0:", "su_event_values_0:", "#define SU_EVENTS_0 "},
+		"c":     {"su_event_rows_0[] = {", "su_event_values_0[] = {", "#define SU_EVENTS_0 "},
+		"go":    {"var Events = "},
+		"wasm":  {`(export "e0")`},
+	}
+	for arch, contents := range expected {
+		comp, err := compiler.New("linux", arch, false, false)
+		if err != nil {
+			t.Fatalf("could not create the compiler: %v", err)
+		}
+		player, err := comp.Song(&song)
+		if err != nil {
+			t.Fatalf("compiling for %v failed: %v", arch, err)
+		}
+		for _, code := range player {
+			if strings.Contains(code, contents[0]) {
+				t.Errorf("the player for %v has event tables although Events is not set", arch)
+			}
+		}
+		comp.Events = true
+		player, err = comp.Song(&song)
+		if err != nil {
+			t.Fatalf("compiling with the event tables for %v failed: %v", arch, err)
+		}
+		for _, c := range contents {
+			found := false
+			for _, code := range player {
+				found = found || strings.Contains(code, c)
+			}
+			if !found {
+				t.Errorf("the player for %v does not contain %v", arch, c)
+			}
+		}
+	}
+}
+
+// TestSongMetadata checks that the metadata of the song is in the comments of
+// the players and in the defines of the headers.
+func TestSongMetadata(t *testing.T) {
+	_, myname, _, _ := runtime.Caller(0)
+	songBytes, err := ioutil.ReadFile(path.Join(path.Dir(myname), "..", "..", "tests", "test_chords.yml"))
+	if err != nil {
+		t.Fatalf("cannot read the .yml file: %v", err)
+	}
+	var song sointu.Song
+	if err := yaml.Unmarshal(songBytes, &song); err != nil {
+		t.Fatalf("could not parse the .yml file: %v", err)
+	}
+	song.Metadata = sointu.Metadata{Title: `Chords "1"`, Author: "Someone", Comment: "First line\nSecond line\t\\", License: "CC0"}
+	expected := map[string][]string{
+		".asm": {"; Title: Chords \"1\"\n", "; Author: Someone\n", "; Comment: First line\n;     Second line\t\n", "; License: CC0\n"},
+		".h": {
+			"// Title: Chords \"1\"\n",
+			`#define SU_TITLE                "Chords \"1\""`,
+			`#define SU_AUTHOR               "Someone"`,
+			`#define SU_COMMENT              "First line\nSecond line\011\\"`,
+			`#define SU_LICENSE              "CC0"`,
+		},
+	}
+	for _, arch := range []string{"386", "c"} {
+		comp, err := compiler.New("linux", arch, false, false)
+		if err != nil {
+			t.Fatalf("could not create the compiler: %v", err)
+		}
+		player, err := comp.Song(&song)
+		if err != nil {
+			t.Fatalf("compiling for %v failed: %v", arch, err)
+		}
+		for extension, contents := range expected {
+			if _, ok := player[extension]; !ok && extension == ".asm" {
+				continue // the c player has no .asm
+			}
+			for _, c := range contents {
+				if !strings.Contains(player[extension], c) {
+					t.Errorf("the %v file for %v does not contain %q", extension, arch, c)
+				}
+			}
+		}
+	}
+}
+
+// TestChunkedPlayer checks that the x86 players export su_render_chunk and
+// su_seek when Chunked is set. The chunked players are tested against the
+// expected outputs in tests/CMakeLists.txt.
+func TestChunkedPlayer(t *testing.T) {
+	_, myname, _, _ := runtime.Caller(0)
+	for _, testname := range []string{"test_chords", "test_polyphony", "test_sync"} {
+		songBytes, err := ioutil.ReadFile(path.Join(path.Dir(myname), "..", "..", "tests", testname+".yml"))
+		if err != nil {
+			t.Fatalf("cannot read the .yml file: %v", testname)
+		}
+		var song sointu.Song
+		if err := yaml.Unmarshal(songBytes, &song); err != nil {
+			t.Fatalf("could not parse the .yml file: %v", err)
+		}
+		for _, arch := range []string{"386", "amd64"} {
+			comp, err := compiler.New("linux", arch, false, testname == "test_sync")
+			if err != nil {
+				t.Fatalf("could not create the compiler: %v", err)
+			}
+			comp.Chunked = true
+			player, err := comp.Song(&song)
+			if err != nil {
+				t.Fatalf("compiling %v for %v failed: %v", testname, arch, err)
+			}
+			for _, label := range []string{"su_render_song:", "su_render_chunk:", "su_seek:", "su_player:"} {
+				if !strings.Contains(player[".asm"], label) {
+					t.Errorf("the .asm of %v for %v does not contain %v", testname, arch, label)
+				}
+			}
+			if !strings.Contains(player[".h"], "su_render_chunk(SUsample *buffer, int samples)") {
+				t.Errorf("the .h of %v for %v does not declare su_render_chunk", testname, arch)
+			}
+		}
+		comp, err := compiler.New("linux", "wasm", false, false)
+		if err != nil {
+			t.Fatalf("could not create the compiler: %v", err)
+		}
+		comp.Chunked = true
+		if _, err := comp.Song(&song); err == nil {
+			t.Error("compiling a chunked wasm player should fail")
+		}
+	}
+}
+
+// TestSongsPlayer checks that compiling several songs into one x86 player
+// gives the tables of each song and one entry point for all of them. The player
+// is tested against the expected outputs in tests/CMakeLists.txt.
+func TestSongsPlayer(t *testing.T) {
+	_, myname, _, _ := runtime.Caller(0)
+	var songs []*sointu.Song
+	for _, testname := range []string{"test_chords", "test_delay", "test_polyphony"} {
+		songBytes, err := ioutil.ReadFile(path.Join(path.Dir(myname), "..", "..", "tests", testname+".yml"))
+		if err != nil {
+			t.Fatalf("cannot read the .yml file: %v", testname)
+		}
+		var song sointu.Song
+		if err := yaml.Unmarshal(songBytes, &song); err != nil {
+			t.Fatalf("could not parse the .yml file: %v", err)
+		}
+		songs = append(songs, &song)
+	}
+	for _, arch := range []string{"386", "amd64"} {
+		comp, err := compiler.New("linux", arch, false, false)
+		if err != nil {
+			t.Fatalf("could not create the compiler: %v", err)
+		}
+		player, err := comp.Songs(songs)
+		if err != nil {
+			t.Fatalf("compiling the songs for %v failed: %v", arch, err)
+		}
+		for i := range songs {
+			for _, label := range []string{"su_patch_code_%v:", "su_patch_parameters_%v:", "su_patterns_%v:", "su_tracks_%v:"} {
+				if l := fmt.Sprintf(label, i); !strings.Contains(player[".asm"], l) {
+					t.Errorf("the .asm for %v does not contain %v", arch, l)
+				}
+			}
+			if l := fmt.Sprintf("su_patch_code_%v, su_patch_parameters_%v, su_tracks_%v, su_patterns_%v", i, i, i, i); !strings.Contains(player[".asm"], l) {
+				t.Errorf("the su_songs of %v does not list the tables of song %v", arch, i)
+			}
+		}
+		for _, label := range []string{"su_render_song:", "su_update_voices:", "su_songs:"} {
+			if n := strings.Count(player[".asm"], label); n != 1 {
+				t.Errorf("the .asm for %v should have one %v for all the songs, got %v", arch, label, n)
+			}
+		}
+		if !strings.Contains(player[".h"], "su_render_song(SUsample *buffer, int song)") {
+			t.Errorf("the .h for %v does not declare su_render_song", arch)
+		}
+		if n := strings.Count(player[".asm"], "su_run_vm:"); n != 1 {
+			t.Errorf("the .asm for %v should have one su_run_vm for all the songs, got %v", arch, n)
+		}
+	}
+	comp, err := compiler.New("linux", "wasm", false, false)
+	if err != nil {
+		t.Fatalf("could not create the compiler: %v", err)
+	}
+	if _, err := comp.Songs(songs); err == nil {
+		t.Error("compiling several songs into a wasm player should fail")
+	}
+}
+
+// TestGoPlayerRegressionTests compiles each regression test with the go arch
+// into a package of its own and renders them all with one program, if the go
+// tool is found.
+func TestGoPlayerRegressionTests(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found, skipping the tests of the Go player")
+	}
+	_, myname, _, _ := runtime.Caller(0)
+	testDir := path.Join(path.Dir(myname), "..", "..", "tests")
+	files, err := filepath.Glob(path.Join(testDir, "*.yml"))
+	if err != nil {
+		t.Fatalf("cannot glob files in the test directory: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "sointu-go-")
+	if err != nil {
+		t.Fatalf("could not create a temporary directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module sointutest\n\ngo 1.15\n"), 0644); err != nil {
+		t.Fatalf("could not write go.mod: %v", err)
+	}
+	var names []string
+	var imports, renders strings.Builder
+	for _, filename := range files {
+		basename := filepath.Base(filename)
+		testname := strings.TrimSuffix(basename, path.Ext(basename))
+		if strings.Contains(testname, "sample") {
+			continue // the samples are gm.dls based, which the Go player cannot load
+		}
+		bytes, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("cannot read the .yml file: %v", filename)
+		}
+		var song sointu.Song
+		if err := yaml.Unmarshal(bytes, &song); err != nil {
+			t.Fatalf("could not parse the .yml file: %v", err)
+		}
+		comp, err := compiler.New(runtime.GOOS, "go", false, false)
+		if err != nil {
+			t.Fatalf("could not create the compiler: %v", err)
+		}
+		comp.GoPackage = testname
+		player, err := comp.Song(&song)
+		if err != nil {
+			t.Fatalf("compiling %v failed: %v", testname, err)
+		}
+		if err := os.Mkdir(filepath.Join(dir, testname), 0755); err != nil {
+			t.Fatalf("could not create a directory for the player: %v", err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, testname, testname+".go"), []byte(player[".go"]), 0644); err != nil {
+			t.Fatalf("could not write the player: %v", err)
+		}
+		names = append(names, testname)
+		fmt.Fprintf(&imports, "\t%q\n", "sointutest/"+testname)
+		fmt.Fprintf(&renders, "\twrite(%q, %v.BufferLength, %v.NewPlayer())\n", testname, testname, testname)
+	}
+	program := fmt.Sprintf(goPlayerTestProgram, imports.String(), renders.String())
+	if err := ioutil.WriteFile(filepath.Join(dir, "main.go"), []byte(program), 0644); err != nil {
+		t.Fatalf("could not write the test program: %v", err)
+	}
+	cmd := exec.Command(goTool, "run", ".")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("running the Go players failed: %v\n%s", err, out)
+	}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			actual, err := ioutil.ReadFile(filepath.Join(dir, name+".raw"))
+			if err != nil {
+				t.Fatalf("cannot read the rendered song: %v", err)
+			}
+			expected, err := ioutil.ReadFile(path.Join(testDir, "expected_output", name+".raw"))
+			if err != nil {
+				t.Fatalf("cannot read the expected output: %v", err)
+			}
+			if len(actual) != len(expected) {
+				t.Fatalf("the length of the rendered song differs, got %v bytes, expected %v", len(actual), len(expected))
+			}
+			for i := 0; i < len(actual); i += 4 {
+				a := math.Float32frombits(binary.LittleEndian.Uint32(actual[i:]))
+				e := math.Float32frombits(binary.LittleEndian.Uint32(expected[i:]))
+				if diff := math.Abs(float64(a - e)); diff > 1e-3 || math.IsNaN(diff) {
+					t.Fatalf("the rendered song differs from the expected output at sample %v: got %v, expected %v", i/4, a, e)
+				}
+			}
+		})
+	}
+}
+
+// goPlayerTestProgram renders the songs with the Go players in chunks, like
+// when streaming, and writes them to .raw files. The parameters are the
+// imports of the players and the calls to write.
+// forEachTestSong runs f as a subtest for each of the songs in the tests
+// directory, loaded with sointu.LoadSong.
+func forEachTestSong(t *testing.T, f func(t *testing.T, song sointu.Song)) {
+	_, myname, _, _ := runtime.Caller(0)
+	files, err := filepath.Glob(path.Join(path.Dir(myname), "..", "..", "tests", "*.yml"))
+	if err != nil {
+		t.Fatalf("cannot glob files in the test directory: %v", err)
+	}
+	for _, filename := range files {
+		basename := filepath.Base(filename)
+		testname := strings.TrimSuffix(basename, path.Ext(basename))
+		t.Run(testname, func(t *testing.T) {
+			file, err := os.Open(filename)
+			if err != nil {
+				t.Fatalf("cannot open the .yml file: %v", err)
+			}
+			defer file.Close()
+			song, err := sointu.LoadSong(file)
+			if err != nil {
+				t.Fatalf("could not load the .yml file: %v", err)
+			}
+			f(t, song)
+		})
+	}
+}
+
+const goPlayerTestProgram = `package main
+
+import (
+	"encoding/binary"
+	"os"
+
+%v)
+
+func write(name string, length int, player interface{ Render([]float32) int }) {
+	buffer := make([]float32, length)
+	for b := buffer; len(b) > 0; {
+		n := len(b)
+		if n > 1000 {
+			n = 1000
+		}
+		samples := player.Render(b[:n])
+		if samples == 0 { // the speed unit can make the song shorter
+			break
+		}
+		b = b[samples*2:]
+	}
+	f, err := os.Create(name + ".raw")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if err := binary.Write(f, binary.LittleEndian, buffer); err != nil {
+		panic(err)
+	}
+}
+
+func main() {
+%v}
+`
@@ -0,0 +1,352 @@
+package compiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
+)
+
+// SizeItem is one entry of a SizeReport: Bytes is the size of the data (or
+// code) uncompressed and Compressed the estimated size of it compressed, both
+// in bytes.
+type SizeItem struct {
+	Name       string
+	Bytes      int
+	Compressed float64
+}
+
+// SizeReport is an estimate of how many bytes the data of a compiled song adds
+// to an executable compressed with a context modelling compressor, such as
+// Crinkler. The estimate is broken down per data table (su_patterns,
+// su_tracks, su_patch_code etc.), per instrument (its commands, parameters and
+// embedded samples) and per opcode (the commands and parameters of all the
+// units of that type). Instruments and Opcodes are sorted, most expensive
+// first.
+//
+// Code is a much rougher estimate of the code of the VM the song needs: the
+// core of the VM, named "vm", followed by the implementation of each opcode
+// used, in the order of Opcodes. It is not included in Total; see
+// opcodeCodeSizes.
+type SizeReport struct {
+	Total       SizeItem
+	Tables      []SizeItem
+	Instruments []SizeItem
+	Opcodes     []SizeItem
+	Code        []SizeItem
+}
+
+// opcodeCodeSizes are rough estimates of the sizes of the implementations of
+// the opcodes in the 32-bit x86 VM, in bytes, from the number of instructions
+// in templates/amd64-386 at about 3 bytes per instruction. The features of an
+// opcode change its size: the estimates assume a typical unit, e.g. an
+// oscillator with one waveform, so a stereo or unison unit costs a bit more.
+var opcodeCodeSizes = map[string]int{
+	"add":        25,
+	"addp":       20,
+	"aux":        30,
+	"clip":       15,
+	"compressor": 95,
+	"crush":      15,
+	"delay":      200,
+	"distort":    45,
+	"envelope":   120,
+	"filter":     95,
+	"gain":       20,
+	"hold":       50,
+	"in":         30,
+	"invgain":    20,
+	"loadnote":   25,
+	"loadval":    20,
+	"mul":        25,
+	"mulp":       20,
+	"noise":      40,
+	"oscillator": 250,
+	"out":        25,
+	"outaux":     35,
+	"pan":        40,
+	"pop":        10,
+	"push":       20,
+	"receive":    30,
+	"send":       85,
+	"speed":      35,
+	"sync":       25,
+	"xch":        20,
+}
+
+// vmCodeSize is a rough estimate of the size of the code every song needs: the
+// render loop, the voice updates and the opcode dispatch of the VM.
+const vmCodeSize = 400
+
+// codeCompressionRatio is a typical ratio of the compressed and the
+// uncompressed size of the x87 code of a 4k intro.
+const codeCompressionRatio = 0.7
+
+// EstimateSize estimates the compressed size of the data of the song, as it
+// would be compiled by the compiler.
+func (com *Compiler) EstimateSize(song *sointu.Song) (*SizeReport, error) {
+	features := vm.NecessaryFeaturesFor(song.Patch)
+	encodedPatch, err := vm.Encode(song.Patch, features)
+	if err != nil {
+		return nil, fmt.Errorf(`could not encode patch: %v`, err)
+	}
+	encodedPatch.ConvertSamples(com.SampleFormat)
+	patternLength, err := vm.OptimalPatternLength(song)
+	if err != nil {
+		return nil, fmt.Errorf(`could not encode song: %v`, err)
+	}
+	patterns, sequences, err := vm.ConstructPatternsWithLength(song, patternLength)
+	if err != nil {
+		return nil, fmt.Errorf(`could not encode song: %v`, err)
+	}
+	ret := &SizeReport{Total: SizeItem{Name: "total"}}
+	for i, instr := range song.Patch {
+		name := instr.Name
+		if name == "" {
+			name = fmt.Sprintf("instrument %v", i)
+		}
+		ret.Instruments = append(ret.Instruments, SizeItem{Name: name})
+	}
+	instructions := features.Instructions()
+	for _, name := range instructions {
+		ret.Opcodes = append(ret.Opcodes, SizeItem{Name: name})
+	}
+	// the data is laid out in the same order as in player.asm; for each byte,
+	// remember which table, instrument and opcode it belongs to (-1 = none)
+	var data []byte
+	var tables, instrs, ops []int
+	add := func(instr, op int, b ...byte) {
+		for range b {
+			tables = append(tables, len(ret.Tables)-1)
+			instrs = append(instrs, instr)
+			ops = append(ops, op)
+		}
+		data = append(data, b...)
+	}
+	ret.Tables = append(ret.Tables, SizeItem{Name: "su_patterns"})
+	for _, p := range patterns {
+		add(-1, -1, p...)
+	}
+	ret.Tables = append(ret.Tables, SizeItem{Name: "su_tracks"})
+	for _, s := range sequences {
+		add(-1, -1, s...)
+	}
+	if len(encodedPatch.SampleOffsets) > 0 {
+		ret.Tables = append(ret.Tables, SizeItem{Name: "su_sample_offsets"})
+		for _, s := range encodedPatch.SampleOffsets {
+			var b [8]byte
+			binary.LittleEndian.PutUint32(b[0:4], s.Start)
+			binary.LittleEndian.PutUint16(b[4:6], s.LoopStart)
+			binary.LittleEndian.PutUint16(b[6:8], s.LoopLength)
+			add(-1, -1, b[:]...)
+		}
+	}
+	if len(encodedPatch.DelayTimes) > 0 {
+		ret.Tables = append(ret.Tables, SizeItem{Name: "su_delay_times"})
+		for _, t := range encodedPatch.DelayTimes {
+			add(-1, -1, byte(t), byte(t>>8))
+		}
+	}
+	// find out the unit type of each command, to attribute the parameters
+	cmdInstrs := make([]int, len(encodedPatch.Commands))
+	cmdOps := make([]int, len(encodedPatch.Commands))
+	instr := 0
+	for i, c := range encodedPatch.Commands {
+		cmdInstrs[i], cmdOps[i] = instr, int(c>>1)-1
+		if c>>1 == 0 {
+			instr++
+		}
+	}
+	ret.Tables = append(ret.Tables, SizeItem{Name: "su_patch_code"})
+	for i, c := range encodedPatch.Commands {
+		add(cmdInstrs[i], cmdOps[i], c)
+	}
+	ret.Tables = append(ret.Tables, SizeItem{Name: "su_patch_parameters"})
+	values := encodedPatch.Values
+	for i := range encodedPatch.Commands {
+		if cmdOps[i] < 0 || cmdOps[i] >= len(instructions) {
+			continue
+		}
+		n := vm.ValueCount(features, instructions[cmdOps[i]])
+		if n > len(values) {
+			return nil, fmt.Errorf("value stream ended prematurely")
+		}
+		add(cmdInstrs[i], cmdOps[i], values[:n]...)
+		values = values[n:]
+	}
+	if samples := encodedPatch.EncodedSamples(); len(samples) > 0 {
+		ret.Tables = append(ret.Tables, SizeItem{Name: "su_sample_table"})
+		bytesPerSample := 2
+		if encodedPatch.SampleFormat.EightBit {
+			bytesPerSample = 1
+		}
+		downsample := encodedPatch.SampleFormat.Downsample
+		if downsample < 1 {
+			downsample = 1
+		}
+		// the embedded samples are concatenated in the order of the
+		// instruments, so find out which instrument each sample came from
+		var ends []int
+		end := 0
+		for _, instr := range song.Patch {
+			for _, s := range instr.Samples {
+				end += len(s.Data)
+			}
+			ends = append(ends, end)
+		}
+		instr := 0
+		for i, b := range samples {
+			original := i / bytesPerSample * downsample
+			for instr < len(ends)-1 && original >= ends[instr] {
+				instr++
+			}
+			add(instr, -1, b)
+		}
+	}
+	costs := estimateBitCosts(data)
+	for i, c := range costs {
+		bytes := c / 8
+		ret.Total.Bytes++
+		ret.Total.Compressed += bytes
+		ret.Tables[tables[i]].Bytes++
+		ret.Tables[tables[i]].Compressed += bytes
+		if instrs[i] >= 0 && instrs[i] < len(ret.Instruments) {
+			ret.Instruments[instrs[i]].Bytes++
+			ret.Instruments[instrs[i]].Compressed += bytes
+		}
+		if ops[i] >= 0 && ops[i] < len(ret.Opcodes) {
+			ret.Opcodes[ops[i]].Bytes++
+			ret.Opcodes[ops[i]].Compressed += bytes
+		}
+	}
+	mostExpensiveFirst := func(items []SizeItem) {
+		sort.SliceStable(items, func(i, j int) bool { return items[i].Compressed > items[j].Compressed })
+	}
+	mostExpensiveFirst(ret.Instruments)
+	mostExpensiveFirst(ret.Opcodes)
+	ret.Code = append(ret.Code, SizeItem{Name: "vm", Bytes: vmCodeSize, Compressed: vmCodeSize * codeCompressionRatio})
+	for _, op := range ret.Opcodes {
+		size := opcodeCodeSizes[op.Name]
+		ret.Code = append(ret.Code, SizeItem{Name: op.Name, Bytes: size, Compressed: float64(size) * codeCompressionRatio})
+	}
+	return ret, nil
+}
+
+// Write writes the report in a human readable form. The opcodes are listed
+// with the rough estimates of their code, answering what using an opcode
+// costs, and the report ends with the total including the code of the VM.
+func (r *SizeReport) Write(w io.Writer) error {
+	code := map[string]SizeItem{}
+	codeTotal := 0.0
+	for _, item := range r.Code {
+		code[item.Name] = item
+		codeTotal += item.Compressed
+	}
+	section := func(title string, items []SizeItem) error {
+		if _, err := fmt.Fprintf(w, "%v:\n", title); err != nil {
+			return err
+		}
+		for _, item := range items {
+			if _, err := fmt.Fprintf(w, "  %-24v %6d bytes -> %8.1f bytes\n", item.Name, item.Bytes, item.Compressed); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "estimated compressed size of the data: %.1f bytes (%v bytes uncompressed)\n", r.Total.Compressed, r.Total.Bytes); err != nil {
+		return err
+	}
+	if err := section("tables", r.Tables); err != nil {
+		return err
+	}
+	if err := section("instruments", r.Instruments); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(w, "opcodes (commands and parameters + code, roughly):"); err != nil {
+		return err
+	}
+	for _, item := range r.Opcodes {
+		c := code[item.Name]
+		if _, err := fmt.Fprintf(w, "  %-24v %6d bytes -> %8.1f bytes + ~%.0f bytes of code = ~%.0f bytes\n", item.Name, item.Bytes, item.Compressed, c.Compressed, item.Compressed+c.Compressed); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "roughly estimated compressed size of the code of the VM: %.0f bytes (%.0f bytes for the core); total with the data: %.0f bytes\n", codeTotal, code["vm"].Compressed, r.Total.Compressed+codeTotal)
+	return err
+}
+
+// estimateBitCosts estimates how many bits each byte of the data costs when
+// compressed with a context mixing compressor, like the ones used for 4k
+// intros. Each bit is predicted by mixing the predictions of the order 0-4
+// contexts (the previous 0-4 bytes) in the logistic domain and the cost of a
+// bit is its information content -log2(p).
+func estimateBitCosts(data []byte) []float64 {
+	const orders = 5
+	const rate = 0.02
+	type counter struct {
+		p float64
+		n int
+	}
+	var models [orders]map[uint64]counter
+	var weights [orders]float64
+	for o := range models {
+		models[o] = map[uint64]counter{}
+		weights[o] = 0.3
+	}
+	ret := make([]float64, len(data))
+	for i, c := range data {
+		var hashes [orders]uint64
+		var h uint64
+		for o := 1; o < orders; o++ {
+			var prev uint64
+			if i-o >= 0 {
+				prev = uint64(data[i-o]) + 1
+			}
+			h = (h + prev) * 0x9E3779B97F4A7C15
+			hashes[o] = h
+		}
+		partial := uint64(1) // the bits of the current byte seen so far, with a leading 1
+		for bit := 7; bit >= 0; bit-- {
+			y := int(c>>uint(bit)) & 1
+			var stretched [orders]float64
+			var keys [orders]uint64
+			dot := 0.0
+			for o := range models {
+				keys[o] = hashes[o]<<8 ^ partial
+				cnt, ok := models[o][keys[o]]
+				if !ok {
+					cnt.p = 0.5
+				}
+				stretched[o] = math.Log(cnt.p / (1 - cnt.p))
+				dot += weights[o] * stretched[o]
+			}
+			p := 1 / (1 + math.Exp(-dot))
+			p = math.Min(math.Max(p, 1.0/4096), 1-1.0/4096)
+			if y == 1 {
+				ret[i] -= math.Log2(p)
+			} else {
+				ret[i] -= math.Log2(1 - p)
+			}
+			e := float64(y) - p
+			for o := range models {
+				weights[o] += rate * e * stretched[o]
+				cnt, ok := models[o][keys[o]]
+				if !ok {
+					cnt.p = 0.5
+				}
+				if cnt.n < 30 {
+					cnt.n++
+				}
+				cnt.p += (float64(y) - cnt.p) / (float64(cnt.n) + 0.5)
+				cnt.p = math.Min(math.Max(cnt.p, 1.0/4096), 1-1.0/4096)
+				models[o][keys[o]] = cnt
+			}
+			partial = partial<<1 | uint64(y)
+		}
+	}
+	return ret
+}
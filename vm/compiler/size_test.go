@@ -0,0 +1,62 @@
+package compiler_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm/compiler"
+)
+
+func TestEstimateSize(t *testing.T) {
+	sum := func(items []compiler.SizeItem) (int, float64) {
+		bytes, compressed := 0, 0.0
+		for _, item := range items {
+			bytes += item.Bytes
+			compressed += item.Compressed
+		}
+		return bytes, compressed
+	}
+	forEachTestSong(t, func(t *testing.T, song sointu.Song) {
+		report, err := (&compiler.Compiler{}).EstimateSize(&song)
+		if err != nil {
+			t.Fatalf("estimating size failed: %v", err)
+		}
+		if report.Total.Compressed <= 0 || report.Total.Compressed > float64(report.Total.Bytes)+1 {
+			t.Errorf("implausible estimate %v bytes for %v bytes of data", report.Total.Compressed, report.Total.Bytes)
+		}
+		if b, c := sum(report.Tables); b != report.Total.Bytes || math.Abs(c-report.Total.Compressed) > 1e-6 {
+			t.Errorf("the tables should add up to the total %+v, got %v bytes -> %v bytes", report.Total, b, c)
+		}
+		patchBytes := 0
+		for _, table := range report.Tables {
+			if table.Name == "su_patch_code" || table.Name == "su_patch_parameters" || table.Name == "su_sample_table" {
+				patchBytes += table.Bytes
+			}
+		}
+		if b, _ := sum(report.Instruments); b != patchBytes {
+			t.Errorf("the instruments should add up to the %v bytes of patch code, parameters and samples, got %v", patchBytes, b)
+		}
+		var out strings.Builder
+		if err := report.Write(&out); err != nil {
+			t.Fatalf("writing the report failed: %v", err)
+		}
+		if !strings.Contains(out.String(), "code of the VM") {
+			t.Errorf("the report should estimate the code of the VM")
+		}
+		if len(report.Code) != len(report.Opcodes)+1 {
+			t.Fatalf("expected the code of the VM and of each of the %v opcodes, got %v", len(report.Opcodes), report.Code)
+		}
+		for i, item := range report.Code {
+			if item.Compressed <= 0 || (i > 0 && item.Name != report.Opcodes[i-1].Name) {
+				t.Errorf("wrong code estimate %+v", item)
+			}
+		}
+		for i := 1; i < len(report.Instruments); i++ {
+			if report.Instruments[i].Compressed > report.Instruments[i-1].Compressed {
+				t.Errorf("the instruments should be sorted most expensive first")
+			}
+		}
+	})
+}
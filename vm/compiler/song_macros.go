@@ -1,6 +1,9 @@
 package compiler
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/vsariola/sointu"
 )
 
@@ -23,3 +26,37 @@ func NewSongMacros(s *sointu.Song) *SongMacros {
 	}
 	return &p
 }
+
+// MetadataComments returns the metadata of the song as lines of comments
+// starting with prefix, e.g. "; ". The trailing backslashes are removed, as in
+// C and nasm they would continue the comment to the next line.
+func (p *SongMacros) MetadataComments(prefix string) string {
+	var b strings.Builder
+	for _, line := range p.Song.Metadata.Lines() {
+		b.WriteString(prefix + strings.TrimRight(line, `\`) + "\n")
+	}
+	return b.String()
+}
+
+// CString quotes a string as a C string literal, e.g. for the metadata of the
+// song in the player headers. The control characters are escaped as octal, as
+// hexadecimal escapes would swallow the hex digits following them.
+func (p *SongMacros) CString(str string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c < 0x20 || c == 0x7F:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -76,6 +76,10 @@ func (wm *WasmMacros) ToByte(value int) byte {
 	return byte(value)
 }
 
+func (wm *WasmMacros) ToWord(value int) uint16 {
+	return uint16(value)
+}
+
 func (wm *WasmMacros) Data() []byte {
 	return wm.data.Bytes()
 }
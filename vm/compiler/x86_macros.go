@@ -380,6 +380,16 @@ func (p *X86Macros) ExportFunc(name string, params ...string) string {
 	return fmt.Sprintf("%[1]v\nglobal %[2]v\n%[2]v:", p.SectText(name), name)
 }
 
+// ExportData returns a label for data exported to C, decorated with an
+// underscore on the platforms where C symbols are. The undecorated label is
+// always defined too, so the rest of the code can refer to it.
+func (p *X86Macros) ExportData(name string) string {
+	if (!p.Amd64 && p.OS == "windows") || p.OS == "darwin" {
+		return fmt.Sprintf("global _%[1]v\n_%[1]v:\n%[1]v:", name)
+	}
+	return fmt.Sprintf("global %[1]v\n%[1]v:", name)
+}
+
 func (p *X86Macros) Input(unit string, port string) (string, error) {
 	i := p.features.InputNumber(unit, port)
 	if i != 0 {
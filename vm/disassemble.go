@@ -0,0 +1,240 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vsariola/sointu"
+)
+
+// Disassemble returns a human readable listing of the bytecode of a BytePatch
+// encoded with AllFeatures, one line per command. See DisassembleFeatures.
+func Disassemble(b *BytePatch) (string, error) {
+	return DisassembleFeatures(b, AllFeatures{})
+}
+
+// DisassembleFeatures returns a human readable listing of the bytecode of a
+// BytePatch, which was encoded using the given FeatureSet. Each instrument
+// starts with a header line telling which voices use it, as implied by the
+// PolyphonyBitmask. Each command is listed on its own line, with its opcode,
+// stereo flag, transformed parameters and the extra values of the command:
+// aux/in channels, oscillator and filter flags, send addresses and delay table
+// indices with the delay times. The units are numbered as in the workspace of
+// the VM, i.e. empty units and delays without delay lines are not counted.
+func DisassembleFeatures(b *BytePatch, featureSet FeatureSet) (string, error) {
+	instructions := featureSet.Instructions()
+	// first pass: find out the unit types of all instruments, so that the
+	// send targets can be named
+	var instrTypes [][]string
+	var types []string
+	for i, op := range b.Commands {
+		if op>>1 == 0 {
+			instrTypes = append(instrTypes, types)
+			types = nil
+			continue
+		}
+		index := int(op>>1) - 1
+		if index >= len(instructions) {
+			return "", fmt.Errorf("command %v: unknown opcode %v", i, op)
+		}
+		types = append(types, instructions[index])
+	}
+	if len(types) > 0 {
+		return "", fmt.Errorf("the last instrument is missing the advance command (opcode 0)")
+	}
+	voiceRanges := instrumentVoices(b)
+	var sb strings.Builder
+	values := b.Values
+	take := func(n int) ([]byte, error) {
+		if len(values) < n {
+			return nil, fmt.Errorf("value stream ended prematurely")
+		}
+		ret := values[:n]
+		values = values[n:]
+		return ret, nil
+	}
+	commands := b.Commands
+	for instrIndex, types := range instrTypes {
+		if instrIndex < len(voiceRanges) {
+			r := voiceRanges[instrIndex]
+			if r[0] == r[1] {
+				fmt.Fprintf(&sb, "instrument %v (voice %v)\n", instrIndex, r[0])
+			} else {
+				fmt.Fprintf(&sb, "instrument %v (voices %v-%v)\n", instrIndex, r[0], r[1])
+			}
+		} else {
+			fmt.Fprintf(&sb, "instrument %v (no voices)\n", instrIndex)
+		}
+		for unitNo, unitType := range types {
+			op := commands[0]
+			commands = commands[1:]
+			stereo := op&1 == 1
+			name := unitType
+			if stereo {
+				name += " stereo"
+			}
+			var fields []string
+			transformed, err := take(featureSet.TransformCount(unitType))
+			if err != nil {
+				return "", fmt.Errorf("instrument %v, unit %v: %v", instrIndex, unitNo, err)
+			}
+			i := 0
+			for _, p := range sointu.UnitTypes[unitType] {
+				if p.CanModulate && p.CanSet && i < len(transformed) {
+					fields = append(fields, fmt.Sprintf("%v=%v", p.Name, transformed[i]))
+					i++
+				}
+			}
+			v, err := take(extraValueCount(unitType))
+			if err != nil {
+				return "", fmt.Errorf("instrument %v, unit %v: %v", instrIndex, unitNo, err)
+			}
+			switch unitType {
+			case "aux", "in":
+				fields = append(fields, fmt.Sprintf("channel=%v", v[0]))
+			case "oscillator":
+				fields = append(fields, fmt.Sprintf("flags=0x%02x (%v)", v[0], oscillatorFlags(v[0])))
+			case "filter":
+				fields = append(fields, fmt.Sprintf("flags=0x%02x (%v)", v[0], filterFlags(v[0])))
+			case "send":
+				addr := uint16(v[0]) + uint16(v[1])<<8
+				fields = append(fields, fmt.Sprintf("addr=0x%04x (%v)", addr, sendTarget(addr, instrIndex, instrTypes, voiceRanges)))
+			case "delay":
+				fields = append(fields, delayLines(b, v[0], v[1], stereo))
+			}
+			line := fmt.Sprintf("  %3d %-18v %v", unitNo, name, strings.Join(fields, " "))
+			fmt.Fprintln(&sb, strings.TrimRight(line, " "))
+		}
+		commands = commands[1:] // advance
+		fmt.Fprintf(&sb, "      advance\n")
+	}
+	if len(values) > 0 {
+		return "", fmt.Errorf("%v unused bytes left in the value stream", len(values))
+	}
+	return sb.String(), nil
+}
+
+// instrumentVoices returns the first and the last voice of each instrument,
+// based on the PolyphonyBitmask and NumVoices of the BytePatch.
+func instrumentVoices(b *BytePatch) [][2]int {
+	var ret [][2]int
+	first := 0
+	for voice := 0; voice < int(b.NumVoices); voice++ {
+		// after voice, the VM checks the bit corresponding to the number of
+		// remaining voices: if it is set, the next voice uses the same instrument
+		remaining := int(b.NumVoices) - voice - 1
+		if remaining == 0 || b.PolyphonyBitmask&(1<<uint(remaining)) == 0 {
+			ret = append(ret, [2]int{first, voice})
+			first = voice + 1
+		}
+	}
+	return ret
+}
+
+func oscillatorFlags(flags byte) string {
+	var ret []string
+	switch {
+	case flags&0x80 != 0:
+		ret = append(ret, "sample")
+	case flags&0x40 != 0:
+		ret = append(ret, "sine")
+	case flags&0x20 != 0:
+		ret = append(ret, "trisaw")
+	case flags&0x10 != 0:
+		ret = append(ret, "pulse")
+	case flags&0x04 != 0:
+		ret = append(ret, "gate")
+	default:
+		ret = append(ret, "none")
+	}
+	if flags&0x08 != 0 {
+		ret = append(ret, "lfo")
+	}
+	if u := flags & 3; u > 0 {
+		ret = append(ret, fmt.Sprintf("unison=%v", u))
+	}
+	return strings.Join(ret, ",")
+}
+
+func filterFlags(flags byte) string {
+	var ret []string
+	for _, f := range []struct {
+		bit  byte
+		name string
+	}{{0x40, "lowpass"}, {0x20, "bandpass"}, {0x10, "highpass"}, {0x08, "negbandpass"}, {0x04, "neghighpass"}} {
+		if flags&f.bit != 0 {
+			ret = append(ret, f.name)
+		}
+	}
+	if len(ret) == 0 {
+		return "none"
+	}
+	return strings.Join(ret, ",")
+}
+
+// sendTarget decodes a send address, as encoded by Encode, into a human
+// readable form.
+func sendTarget(addr uint16, instrIndex int, instrTypes [][]string, voiceRanges [][2]int) string {
+	pop := ""
+	if addr&0x8 != 0 {
+		pop = ", pop"
+	}
+	if addr|0x8 == 0xFFFF {
+		return "no target" + pop
+	}
+	port := int(addr & 7)
+	slot := int(addr&0x7FF0) >> 4 // the workspace of each voice is 64 slots of 16 bytes
+	var ret string
+	targetInstr := instrIndex
+	unit := slot - 1 // the first slot of a voice is not used by units
+	if addr&0x8000 != 0 {
+		slot -= 2 // global addresses are offset by one extra slot
+		voice := slot / 64
+		unit = slot % 64
+		targetInstr = -1
+		for i, r := range voiceRanges {
+			if voice >= r[0] && voice <= r[1] {
+				targetInstr = i
+			}
+		}
+		ret = fmt.Sprintf("global, voice %v", voice)
+		if targetInstr >= 0 {
+			ret += fmt.Sprintf(" (instrument %v, voice %v)", targetInstr, voice-voiceRanges[targetInstr][0])
+		}
+		ret += ", "
+	} else {
+		ret = "local, "
+	}
+	ret += fmt.Sprintf("unit %v", unit)
+	if targetInstr >= 0 && targetInstr < len(instrTypes) && unit >= 0 && unit < len(instrTypes[targetInstr]) {
+		unitType := instrTypes[targetInstr][unit]
+		ret += fmt.Sprintf(" (%v)", unitType)
+		if ports := sointu.Ports[unitType]; port < len(ports) {
+			return ret + fmt.Sprintf(", port %v (%v)", port, ports[port]) + pop
+		}
+	}
+	return ret + fmt.Sprintf(", port %v", port) + pop
+}
+
+// delayLines decodes the delay table index and the count/notetracking byte of
+// a delay command.
+func delayLines(b *BytePatch, index, countTrack byte, stereo bool) string {
+	count := (int(countTrack) + 1) / 2
+	lines := count
+	if stereo {
+		lines *= 2
+	}
+	var times []string
+	for i := int(index); i < int(index)+lines; i++ {
+		if i < len(b.DelayTimes) {
+			times = append(times, fmt.Sprint(b.DelayTimes[i]))
+		} else {
+			times = append(times, "?")
+		}
+	}
+	ret := fmt.Sprintf("delaytimes[%v:%v]=[%v]", index, int(index)+lines, strings.Join(times, " "))
+	if countTrack&1 == 0 {
+		ret += " notetracking"
+	}
+	return ret
+}
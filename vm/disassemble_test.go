@@ -0,0 +1,67 @@
+package vm_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
+)
+
+func TestDisassembleRegressionTests(t *testing.T) {
+	forEachTestSong(t, func(t *testing.T, song sointu.Song) {
+		features := vm.NecessaryFeaturesFor(song.Patch)
+		patch, err := vm.Encode(song.Patch, features)
+		if err != nil {
+			t.Fatalf("encoding failed: %v", err)
+		}
+		listing, err := vm.DisassembleFeatures(patch, features)
+		if err != nil {
+			t.Fatalf("disassembling failed: %v", err)
+		}
+		if n, expected := strings.Count(listing, "\n"), len(patch.Commands)+len(song.Patch); n != expected {
+			t.Fatalf("expected %v lines (one per command and instrument), got %v:\n%v", expected, n, listing)
+		}
+	})
+}
+
+func TestDisassemble(t *testing.T) {
+	patch := sointu.Patch{
+		{NumVoices: 2, Units: []sointu.Unit{
+			{Type: "envelope", ID: 1, Parameters: map[string]int{"stereo": 0, "attack": 32, "decay": 64, "sustain": 64, "release": 64, "gain": 128}},
+			{Type: "oscillator", Parameters: map[string]int{"stereo": 1, "transpose": 64, "detune": 64, "phase": 0, "color": 128, "shape": 64, "gain": 128, "type": sointu.Trisaw, "lfo": 1, "unison": 2}},
+			{Type: "filter", Parameters: map[string]int{"stereo": 1, "frequency": 32, "resonance": 64, "lowpass": 1, "negbandpass": 1}},
+			{Type: "delay", Parameters: map[string]int{"stereo": 1, "pregain": 40, "dry": 128, "feedback": 125, "damp": 64, "notetracking": 1}, VarArgs: []int{1000, 2000}},
+			{Type: "send", Parameters: map[string]int{"stereo": 0, "amount": 96, "port": 1, "target": 1}},
+			{Type: "out", Parameters: map[string]int{"stereo": 1, "gain": 128}},
+		}},
+		{NumVoices: 1, Units: []sointu.Unit{
+			{Type: "loadnote", Parameters: map[string]int{"stereo": 0}},
+			{Type: "send", Parameters: map[string]int{"stereo": 0, "amount": 64, "port": 4, "target": 1, "voice": 2, "sendpop": 1}},
+			{Type: "send", Parameters: map[string]int{"stereo": 0, "amount": 64, "target": 42}},
+		}},
+	}
+	bytePatch, err := vm.Encode(patch, vm.AllFeatures{})
+	if err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	listing, err := vm.Disassemble(bytePatch)
+	if err != nil {
+		t.Fatalf("disassembling failed: %v", err)
+	}
+	for _, expected := range []string{
+		"instrument 0 (voices 0-1)",
+		"envelope           attack=32 decay=64 sustain=64 release=64 gain=128",
+		"oscillator stereo  transpose=64 detune=64 phase=0 color=128 shape=64 gain=128 flags=0x2a (trisaw,lfo,unison=2)",
+		"flags=0x48 (lowpass,negbandpass)",
+		"delaytimes[0:2]=[1000 2000] notetracking",
+		"amount=96 addr=0x0011 (local, unit 0 (envelope), port 1 (decay))",
+		"instrument 1 (voice 2)",
+		"addr=0x842c (global, voice 1 (instrument 0, voice 1), unit 0 (envelope), port 4 (gain), pop)",
+		"addr=0xfff7 (no target)",
+	} {
+		if !strings.Contains(listing, expected) {
+			t.Errorf("expected the listing to contain %q, got:\n%v", expected, listing)
+		}
+	}
+}
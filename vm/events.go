@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"github.com/vsariola/sointu"
+)
+
+// TrackEvents is the event table of one track of a Score, precomputed so that
+// e.g. visuals can react to the music without running sync units. For a note
+// track, the events are the note-ons and the values are the notes. For an
+// effect track, the events are the steps of the value: the value of the track
+// is Values[i] from Rows[i] until Rows[i+1]; before the first event, the value
+// is 0.
+type TrackEvents struct {
+	Effect bool
+	Rows   []int  // the rows of the events, in increasing order
+	Values []byte // the notes of the note-ons or the new values of the effect track
+}
+
+// Events computes the event tables of all the tracks of a Score, covering the
+// whole length of the song. Holds (1) are not events; in note tracks, neither
+// are releases (0). In effect tracks, a row is an event only if it changes the
+// value.
+func Events(score sointu.Score) []TrackEvents {
+	ret := make([]TrackEvents, len(score.Tracks))
+	for i, t := range score.Tracks {
+		ret[i].Effect = t.Effect
+		value := 0
+		for row, n := range flattenSequence(t, score.Length, score.RowsPerPattern, false) {
+			if n == 1 || (!t.Effect && n == 0) || (t.Effect && n == value) {
+				continue
+			}
+			ret[i].Rows = append(ret[i].Rows, row)
+			ret[i].Values = append(ret[i].Values, byte(n))
+			value = n
+		}
+	}
+	return ret
+}
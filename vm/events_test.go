@@ -0,0 +1,35 @@
+package vm_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
+)
+
+func TestEvents(t *testing.T) {
+	score := sointu.Score{
+		Length:         2,
+		RowsPerPattern: 8,
+		Tracks: []sointu.Track{{
+			Patterns: []sointu.Pattern{{64, 1, 0, 64, 1, 1, 66, 0}, {1, 1, 68, 0}},
+			Order:    sointu.Order{0, 1},
+		}, {
+			Effect:   true,
+			Patterns: []sointu.Pattern{{1, 5, 5, 1, 7, 0, 0, 9}},
+			Order:    sointu.Order{0, 0},
+		}, {
+			Patterns: []sointu.Pattern{{64}},
+			Order:    sointu.Order{0},
+		}},
+	}
+	expected := []vm.TrackEvents{
+		{Rows: []int{0, 3, 6, 10}, Values: []byte{64, 64, 66, 68}},
+		{Effect: true, Rows: []int{1, 4, 5, 7, 9, 12, 13, 15}, Values: []byte{5, 7, 0, 9, 5, 7, 0, 9}},
+		{Rows: []int{0}, Values: []byte{64}},
+	}
+	if events := vm.Events(score); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("got different events than expected. got: %v expected: %v", events, expected)
+	}
+}
@@ -1,7 +1,10 @@
 package vm
 
 import (
+	"errors"
+	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/vsariola/sointu"
 )
@@ -124,23 +127,36 @@ type NecessaryFeatures struct {
 }
 
 func NecessaryFeaturesFor(patch sointu.Patch) NecessaryFeatures {
+	return NecessaryFeaturesForAll([]sointu.Patch{patch})
+}
+
+// NecessaryFeaturesForAll returns the union of the features the patches need,
+// for compiling several songs to use one virtual machine.
+func NecessaryFeaturesForAll(patches []sointu.Patch) NecessaryFeatures {
 	features := NecessaryFeatures{opcodes: map[string]int{}, supportsParamValue: map[paramKey](map[int]bool){}, supportsModulation: map[paramKey]bool{}}
+	for _, patch := range patches {
+		features.add(patch)
+	}
+	return features
+}
+
+func (n *NecessaryFeatures) add(patch sointu.Patch) {
 	for instrIndex, instrument := range patch {
 		for _, unit := range instrument.Units {
 			if unit.Type == "" {
 				continue
 			}
-			if _, ok := features.opcodes[unit.Type]; !ok {
-				features.instructions = append(features.instructions, unit.Type)
-				features.opcodes[unit.Type] = len(features.instructions) * 2 // note that the first opcode gets value 1, as 0 is always reserved for advance
+			if _, ok := n.opcodes[unit.Type]; !ok {
+				n.instructions = append(n.instructions, unit.Type)
+				n.opcodes[unit.Type] = len(n.instructions) * 2 // note that the first opcode gets value 1, as 0 is always reserved for advance
 			}
 			for _, paramType := range sointu.UnitTypes[unit.Type] {
 				v := unit.Parameters[paramType.Name]
 				key := paramKey{unit.Type, paramType.Name}
-				if features.supportsParamValue[key] == nil {
-					features.supportsParamValue[key] = map[int]bool{}
+				if n.supportsParamValue[key] == nil {
+					n.supportsParamValue[key] = map[int]bool{}
 				}
-				features.supportsParamValue[key][v] = true
+				n.supportsParamValue[key][v] = true
 			}
 			if unit.Type == "send" {
 				targetInstrIndex, targetUnitIndex, err := patch.FindSendTarget(unit.Parameters["target"])
@@ -154,16 +170,15 @@ func NecessaryFeaturesFor(patch sointu.Patch) NecessaryFeatures {
 					continue
 				}
 				if targetInstrIndex != instrIndex || unit.Parameters["voice"] > 0 {
-					features.globalSend = true
+					n.globalSend = true
 				}
-				features.supportsModulation[paramKey{targetUnit.Type, portList[portIndex]}] = true
+				n.supportsModulation[paramKey{targetUnit.Type, portList[portIndex]}] = true
 			}
 		}
 		if instrument.NumVoices > 1 {
-			features.polyphony = true
+			n.polyphony = true
 		}
 	}
-	return features
 }
 
 func (n NecessaryFeatures) SupportsParamValue(unit string, paramName string, value int) bool {
@@ -211,3 +226,237 @@ func (_ NecessaryFeatures) TransformCount(unitType string) int {
 func (n NecessaryFeatures) SupportsGlobalSend() bool {
 	return n.globalSend
 }
+
+// FeatureList is a FeatureSet given as lists, e.g. in a .yml file, for
+// compiling the library with only the features the user needs. Units are the
+// supported unit types, in the order of their opcodes. ParamValues lists the
+// supported values of parameters, keyed by "unit.param", e.g. "oscillator.type";
+// parameters that are not listed support all values. Modulations lists the
+// "unit.port"s that sends can modulate.
+type FeatureList struct {
+	Units       []string         `yaml:",flow"`
+	ParamValues map[string][]int `yaml:",omitempty"`
+	Modulations []string         `yaml:",flow"`
+	Polyphony   bool
+	GlobalSend  bool
+}
+
+// flagParams are the parameters that are not transformed, but change the code
+// the VM needs; these are the parameters FeatureListFor lists the values of.
+var flagParams = map[string][]string{
+	"oscillator": {"type", "lfo", "unison"},
+	"filter":     {"lowpass", "bandpass", "highpass", "negbandpass", "neghighpass"},
+	"delay":      {"notetracking"},
+}
+
+// FeatureListFor lists the features of a FeatureSet: its instructions, the
+// modulations it supports and the values of the stereo parameters and the
+// flags of the oscillators, filters and delays, which change the code of the
+// VM. Other parameters are not listed, so they support all values. For
+// example, FeatureListFor(NecessaryFeaturesForAll(patches)) gives the features
+// needed to play the patches.
+func FeatureListFor(featureSet FeatureSet) FeatureList {
+	ret := FeatureList{
+		Units:       featureSet.Instructions(),
+		ParamValues: map[string][]int{},
+		Polyphony:   featureSet.SupportsPolyphony(),
+		GlobalSend:  featureSet.SupportsGlobalSend(),
+	}
+	for _, unitType := range ret.Units {
+		params := flagParams[unitType]
+		for _, p := range sointu.UnitTypes[unitType] {
+			if p.Name == "stereo" {
+				params = append([]string{"stereo"}, params...)
+			}
+		}
+		for _, name := range params {
+			var values []int
+			all := true
+			for _, p := range sointu.UnitTypes[unitType] {
+				if p.Name != name {
+					continue
+				}
+				for v := p.MinValue; v <= p.MaxValue; v++ {
+					if featureSet.SupportsParamValue(unitType, name, v) {
+						values = append(values, v)
+					} else {
+						all = false
+					}
+				}
+			}
+			if !all {
+				ret.ParamValues[unitType+"."+name] = values
+			}
+		}
+		for _, port := range sointu.Ports[unitType] {
+			if featureSet.SupportsModulation(unitType, port) {
+				ret.Modulations = append(ret.Modulations, unitType+"."+port)
+			}
+		}
+	}
+	return ret
+}
+
+func (f FeatureList) Opcode(unitType string) (int, bool) {
+	for i, u := range f.Units {
+		if u == unitType {
+			return (i + 1) * 2, true
+		}
+	}
+	return 0, false
+}
+
+func (f FeatureList) TransformCount(unitType string) int {
+	return allTransformCounts[unitType]
+}
+
+func (f FeatureList) Instructions() []string {
+	return f.Units
+}
+
+func (f FeatureList) InputNumber(unitType string, paramName string) int {
+	return allInputs[paramKey{unitType, paramName}]
+}
+
+func (f FeatureList) SupportsParamValue(unitType string, paramName string, value int) bool {
+	if _, ok := f.Opcode(unitType); !ok {
+		return false
+	}
+	values, ok := f.ParamValues[unitType+"."+paramName]
+	if !ok {
+		return true
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FeatureList) SupportsParamValueOtherThan(unitType string, paramName string, value int) bool {
+	if _, ok := f.Opcode(unitType); !ok {
+		return false
+	}
+	values, ok := f.ParamValues[unitType+"."+paramName]
+	if !ok {
+		return true
+	}
+	for _, v := range values {
+		if v != value {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FeatureList) SupportsModulation(unitType string, port string) bool {
+	for _, m := range f.Modulations {
+		if m == unitType+"."+port {
+			return true
+		}
+	}
+	return false
+}
+
+func (f FeatureList) SupportsPolyphony() bool {
+	return f.Polyphony
+}
+
+func (f FeatureList) SupportsGlobalSend() bool {
+	return f.GlobalSend
+}
+
+// Check returns an error if the FeatureList has unknown unit types, parameters
+// or ports, e.g. because of a typo in the file it was loaded from.
+func (f FeatureList) Check() error {
+	seen := map[string]bool{}
+	for _, u := range f.Units {
+		if _, ok := sointu.UnitTypes[u]; !ok {
+			return fmt.Errorf(`unknown unit type "%v"`, u)
+		}
+		if seen[u] {
+			return fmt.Errorf(`unit type "%v" is listed twice`, u)
+		}
+		seen[u] = true
+	}
+	for key := range f.ParamValues {
+		unitType, name := splitKey(key)
+		found := false
+		for _, p := range sointu.UnitTypes[unitType] {
+			if p.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf(`unknown parameter "%v"`, key)
+		}
+	}
+	for _, key := range f.Modulations {
+		unitType, name := splitKey(key)
+		found := false
+		for _, p := range sointu.Ports[unitType] {
+			if p == name {
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf(`unknown port "%v"`, key)
+		}
+	}
+	return nil
+}
+
+func splitKey(key string) (string, string) {
+	if i := strings.IndexByte(key, '.'); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return key, ""
+}
+
+// CheckPatch returns an error if the patch needs a feature that the FeatureSet
+// does not support, telling which instrument and unit needs it. For example, a
+// library compiled with only some of the features cannot play all patches.
+func CheckPatch(patch sointu.Patch, featureSet FeatureSet) error {
+	needed := NecessaryFeaturesFor(patch)
+	if needed.SupportsPolyphony() && !featureSet.SupportsPolyphony() {
+		return errors.New("the patch has instruments with several voices, but the virtual machine does not support polyphony")
+	}
+	if needed.SupportsGlobalSend() && !featureSet.SupportsGlobalSend() {
+		return errors.New("the patch has sends to other instruments or voices, but the virtual machine does not support global sends")
+	}
+	for instrIndex, instr := range patch {
+		for unitIndex, unit := range instr.Units {
+			if unit.Type == "" {
+				continue
+			}
+			if _, ok := featureSet.Opcode(unit.Type); !ok {
+				return fmt.Errorf(`instrument %v (%v), unit %v: the virtual machine does not support unit type "%v"`, instrIndex, instr.Name, unitIndex, unit.Type)
+			}
+			for _, p := range sointu.UnitTypes[unit.Type] {
+				if !p.CanSet {
+					continue
+				}
+				if v := unit.Parameters[p.Name]; !featureSet.SupportsParamValue(unit.Type, p.Name, v) {
+					return fmt.Errorf(`instrument %v (%v), unit %v: the virtual machine does not support value %v for parameter "%v" of unit type "%v"`, instrIndex, instr.Name, unitIndex, v, p.Name, unit.Type)
+				}
+			}
+			if unit.Type == "send" {
+				targetInstrIndex, targetUnitIndex, err := patch.FindSendTarget(unit.Parameters["target"])
+				if err != nil {
+					continue
+				}
+				targetUnit := patch[targetInstrIndex].Units[targetUnitIndex]
+				portList := sointu.Ports[targetUnit.Type]
+				portIndex := unit.Parameters["port"]
+				if portIndex < 0 || portIndex >= len(portList) {
+					continue
+				}
+				if !featureSet.SupportsModulation(targetUnit.Type, portList[portIndex]) {
+					return fmt.Errorf(`instrument %v (%v), unit %v: the virtual machine does not support modulating port "%v" of unit type "%v"`, instrIndex, instr.Name, unitIndex, portList[portIndex], targetUnit.Type)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package vm_test
+
+import (
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
+	"gopkg.in/yaml.v2"
+)
+
+// TestFeatureListRegressionTests checks that a FeatureList listing the
+// necessary features of a song encodes the song exactly like the
+// NecessaryFeatures it was made from, and accepts the patch.
+func TestFeatureListRegressionTests(t *testing.T) {
+	_, myname, _, _ := runtime.Caller(0)
+	files, err := filepath.Glob(path.Join(path.Dir(myname), "..", "tests", "*.yml"))
+	if err != nil {
+		t.Fatalf("cannot glob files in the test directory: %v", err)
+	}
+	for _, filename := range files {
+		basename := filepath.Base(filename)
+		testname := strings.TrimSuffix(basename, path.Ext(basename))
+		t.Run(testname, func(t *testing.T) {
+			bytes, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatalf("cannot read the .yml file: %v", filename)
+			}
+			var song sointu.Song
+			if err := yaml.Unmarshal(bytes, &song); err != nil {
+				t.Fatalf("could not parse the .yml file: %v", err)
+			}
+			features := vm.NecessaryFeaturesFor(song.Patch)
+			list := vm.FeatureListFor(features)
+			if err := list.Check(); err != nil {
+				t.Fatalf("the feature list is invalid: %v", err)
+			}
+			if err := vm.CheckPatch(song.Patch, list); err != nil {
+				t.Fatalf("the feature list does not accept the patch: %v", err)
+			}
+			expected, err := vm.Encode(song.Patch, features)
+			if err != nil {
+				t.Fatalf("could not encode the patch: %v", err)
+			}
+			got, err := vm.Encode(song.Patch, list)
+			if err != nil {
+				t.Fatalf("could not encode the patch with the feature list: %v", err)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Fatal("the patch encoded with the feature list differs from the patch encoded with the necessary features")
+			}
+		})
+	}
+}
+
+func TestCheckPatch(t *testing.T) {
+	list := vm.FeatureList{
+		Units:       []string{"envelope", "oscillator", "send", "out"},
+		ParamValues: map[string][]int{"oscillator.type": {sointu.Sine}, "out.stereo": {1}},
+		Modulations: []string{"oscillator.transpose"},
+	}
+	if err := list.Check(); err != nil {
+		t.Fatalf("the feature list should be valid, got %v", err)
+	}
+	instr := func(units ...sointu.Unit) sointu.Patch {
+		return sointu.Patch{sointu.Instrument{NumVoices: 1, Units: units}}
+	}
+	cases := []struct {
+		name  string
+		patch sointu.Patch
+		err   string
+	}{
+		{"ok", instr(
+			sointu.Unit{Type: "envelope", ID: 1},
+			sointu.Unit{Type: "send", Parameters: map[string]int{"target": 2, "port": 0}},
+			sointu.Unit{Type: "oscillator", ID: 2, Parameters: map[string]int{"type": sointu.Sine}},
+			sointu.Unit{Type: "out", Parameters: map[string]int{"stereo": 1}},
+		), ""},
+		{"unit", instr(sointu.Unit{Type: "delay"}), `unit type "delay"`},
+		{"value", instr(sointu.Unit{Type: "oscillator", Parameters: map[string]int{"type": sointu.Trisaw}}), `parameter "type"`},
+		{"stereo", instr(sointu.Unit{Type: "out", Parameters: map[string]int{"stereo": 0}}), `parameter "stereo"`},
+		{"modulation", instr(
+			sointu.Unit{Type: "send", Parameters: map[string]int{"target": 2, "port": 1}},
+			sointu.Unit{Type: "oscillator", ID: 2, Parameters: map[string]int{"type": sointu.Sine}},
+		), `port "detune"`},
+		{"polyphony", sointu.Patch{sointu.Instrument{NumVoices: 2, Units: []sointu.Unit{{Type: "envelope"}}}}, "polyphony"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := vm.CheckPatch(c.patch, list)
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("expected an error mentioning %v, got %v", c.err, err)
+			}
+		})
+	}
+	if err := (vm.FeatureList{Units: []string{"foo"}}).Check(); err == nil {
+		t.Error("a feature list with an unknown unit type should be invalid")
+	}
+	if err := (vm.FeatureList{Modulations: []string{"envelope.foo"}}).Check(); err == nil {
+		t.Error("a feature list with an unknown port should be invalid")
+	}
+}
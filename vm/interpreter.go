@@ -18,14 +18,27 @@ import (
 // Internally, it uses software stack with practically no limitations in the
 // number of signals, so be warned that if you compose patches for it, they
 // might not work with the x87 implementation, as it has only 8-level stack.
+// Instrument.StackUse (or Validate) can be used to check the patches.
 type Interpreter struct {
-	bytePatch  BytePatch
-	stack      []float32
-	synth      synth
-	delaylines []delayline
+	bytePatch    BytePatch
+	stack        []float32
+	synth        synth
+	delaylines   []delayline
+	sampleBank   SampleBank
+	sampleFormat SampleFormat
+	// bank is the bank the Sample oscillators play: the samples embedded in
+	// the patch, or sampleBank if there are none
+	bank SampleBank
 }
 
+// SynthService compiles patches into Interpreters. SampleBank is the source of
+// the sample data for the Sample oscillators; if it is nil, the Sample
+// oscillators output silence. Patches with samples embedded in the instruments
+// do not need the SampleBank; their samples are converted to SampleFormat, so
+// that the Interpreter sounds the same as the compiled song.
 type SynthService struct {
+	SampleBank   SampleBank
+	SampleFormat SampleFormat
 }
 
 const MAX_VOICES = 32
@@ -63,19 +76,31 @@ const (
 	envStateRelease
 )
 
-func Synth(patch sointu.Patch) (sointu.Synth, error) {
-	bytePatch, err := Encode(patch, AllFeatures{})
+// Synth compiles a patch into an Interpreter. The sample bank provides the
+// data for the Sample oscillators and can be nil if the patch does not use
+// samples.
+func Synth(patch sointu.Patch, sampleBank SampleBank) (sointu.Synth, error) {
+	return SynthService{SampleBank: sampleBank}.Compile(patch)
+}
+
+func (s SynthService) Compile(patch sointu.Patch) (sointu.Synth, error) {
+	bytePatch, err := encode(patch, s.SampleFormat)
 	if err != nil {
-		return nil, fmt.Errorf("error compiling %v", err)
+		return nil, err
 	}
-	ret := &Interpreter{bytePatch: *bytePatch, stack: make([]float32, 0, 4), delaylines: make([]delayline, patch.NumDelayLines())}
+	ret := &Interpreter{bytePatch: *bytePatch, stack: make([]float32, 0, 4), delaylines: make([]delayline, patch.NumDelayLines()), sampleBank: s.SampleBank, sampleFormat: s.SampleFormat}
+	ret.updateBank()
 	ret.synth.randSeed = 1
 	return ret, nil
 }
 
-func (s SynthService) Compile(patch sointu.Patch) (sointu.Synth, error) {
-	synth, err := Synth(patch)
-	return synth, err
+func encode(patch sointu.Patch, sampleFormat SampleFormat) (*BytePatch, error) {
+	bytePatch, err := Encode(patch, AllFeatures{})
+	if err != nil {
+		return nil, fmt.Errorf("error compiling %v", err)
+	}
+	bytePatch.ConvertSamples(sampleFormat)
+	return bytePatch, nil
 }
 
 func (s *Interpreter) Trigger(voiceIndex int, note byte) {
@@ -88,9 +113,9 @@ func (s *Interpreter) Release(voiceIndex int) {
 }
 
 func (s *Interpreter) Update(patch sointu.Patch) error {
-	bytePatch, err := Encode(patch, AllFeatures{})
+	bytePatch, err := encode(patch, s.sampleFormat)
 	if err != nil {
-		return fmt.Errorf("error compiling %v", err)
+		return err
 	}
 	needsRefresh := len(bytePatch.Commands) != len(s.bytePatch.Commands)
 	if !needsRefresh {
@@ -102,6 +127,7 @@ func (s *Interpreter) Update(patch sointu.Patch) error {
 		}
 	}
 	s.bytePatch = *bytePatch
+	s.updateBank()
 	for len(s.delaylines) < patch.NumDelayLines() {
 		s.delaylines = append(s.delaylines, delayline{})
 	}
@@ -432,36 +458,40 @@ func (s *Interpreter) Render(buffer []float32, syncBuf []float32, maxtime int) (
 							omega *= 0.000038 //  pretty random scaling constant to get LFOs into reasonable range. Historical reasons, goes all the way back to 4klang
 						}
 						*statevar += float32(omega)
-						*statevar -= float32(int(*statevar+1) - 1)
-						phase := *statevar
-						phase += params[2]
-						phase -= float32(int(phase))
-						color := params[3]
 						var amplitude float32
-						switch {
-						case flags&0x40 == 0x40: // Sine
-							if phase < color {
-								amplitude = float32(math.Sin(2 * math.Pi * float64(phase/color)))
-							}
-						case flags&0x20 == 0x20: // Trisaw
-							if phase >= color {
-								phase = 1 - phase
-								color = 1 - color
-							}
-							amplitude = phase/color*2 - 1
-						case flags&0x10 == 0x10: // Pulse
-							if phase >= color {
-								amplitude = -1
-							} else {
-								amplitude = 1
+						if flags&0x80 == 0x80 { // Sample: the phase is not wrapped, as it is the position in the sample
+							amplitude = s.sample(valuesAtTransform[3], *statevar+params[2])
+						} else {
+							*statevar -= float32(int(*statevar+1) - 1)
+							phase := *statevar
+							phase += params[2]
+							phase -= float32(int(phase))
+							color := params[3]
+							switch {
+							case flags&0x40 == 0x40: // Sine
+								if phase < color {
+									amplitude = float32(math.Sin(2 * math.Pi * float64(phase/color)))
+								}
+							case flags&0x20 == 0x20: // Trisaw
+								if phase >= color {
+									phase = 1 - phase
+									color = 1 - color
+								}
+								amplitude = phase/color*2 - 1
+							case flags&0x10 == 0x10: // Pulse
+								if phase >= color {
+									amplitude = -1
+								} else {
+									amplitude = 1
+								}
+							case flags&0x4 == 0x4: // Gate
+								maskLow, maskHigh := valuesAtTransform[3], valuesAtTransform[4]
+								gateBits := (int(maskHigh) << 8) + int(maskLow)
+								amplitude = float32((gateBits >> (int(phase*16+.5) & 15)) & 1)
+								g := unit.state[4+i] // warning: still fucks up with unison = 3
+								amplitude += 0.99609375 * (g - amplitude)
+								unit.state[4+i] = amplitude
 							}
-						case flags&0x4 == 0x4: // Gate
-							maskLow, maskHigh := valuesAtTransform[3], valuesAtTransform[4]
-							gateBits := (int(maskHigh) << 8) + int(maskLow)
-							amplitude = float32((gateBits >> (int(phase*16+.5) & 15)) & 1)
-							g := unit.state[4+i] // warning: still fucks up with unison = 3
-							amplitude += 0.99609375 * (g - amplitude)
-							unit.state[4+i] = amplitude
 						}
 						if flags&0x4 == 0 {
 							output += waveshape(amplitude, params[4]) * params[5]
@@ -554,6 +584,25 @@ func (s *Interpreter) Render(buffer []float32, syncBuf []float32, maxtime int) (
 	return samples, syncs, time, nil
 }
 
+func (s *Interpreter) updateBank() {
+	s.bank = s.sampleBank
+	if len(s.bytePatch.SampleData) > 0 {
+		s.bank = SampleTable(s.bytePatch.SampleData)
+	}
+}
+
+func (s *Interpreter) sample(sampleNo byte, phase float32) float32 {
+	if s.bank == nil || int(sampleNo) >= len(s.bytePatch.SampleOffsets) {
+		return 0
+	}
+	offset := s.bytePatch.SampleOffsets[sampleNo]
+	index := int(math.RoundToEven(float64(phase) * float64(s.bytePatch.SamplePhaseScale())))
+	if loopStart := int(offset.LoopStart); index >= loopStart && offset.LoopLength > 0 {
+		index = (index-loopStart)%int(offset.LoopLength) + loopStart
+	}
+	return float32(s.bank.Sample(int(offset.Start)+index)) / 32767
+}
+
 func (s *synth) rand() float32 {
 	s.randSeed *= 16007
 	return float32(int32(s.randSeed)) / -2147483648.0
@@ -3,6 +3,7 @@ package vm_test
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io/ioutil"
 	"log"
 	"math"
@@ -24,12 +25,13 @@ func TestAllRegressionTests(t *testing.T) {
 	if err != nil {
 		t.Fatalf("cannot glob files in the test directory: %v", err)
 	}
+	sampleBank, sampleErr := loadGmDls()
 	for _, filename := range files {
 		basename := filepath.Base(filename)
 		testname := strings.TrimSuffix(basename, path.Ext(basename))
 		t.Run(testname, func(t *testing.T) {
-			if strings.Contains(testname, "sample") {
-				t.Skip("Samples (gm.dls) not available in the interpreter VM at the moment")
+			if strings.Contains(testname, "sample") && sampleErr != nil {
+				t.Skipf("Samples (gm.dls) not available: %v", sampleErr)
 				return
 			}
 			asmcode, err := ioutil.ReadFile(filename)
@@ -41,7 +43,7 @@ func TestAllRegressionTests(t *testing.T) {
 			if err != nil {
 				t.Fatalf("could not parse the .yml file: %v", err)
 			}
-			buffer, syncBuffer, err := sointu.Play(vm.SynthService{}, song, false)
+			buffer, syncBuffer, err := sointu.Play(vm.SynthService{SampleBank: sampleBank}, song, false)
 			buffer = buffer[:song.Score.LengthInRows()*song.SamplesPerRow()*2] // extend to the nominal length always.
 			if err != nil {
 				t.Fatalf("Play failed: %v", err)
@@ -79,7 +81,7 @@ func TestStackUnderflow(t *testing.T) {
 	patch := sointu.Patch{sointu.Instrument{NumVoices: 1, Units: []sointu.Unit{
 		sointu.Unit{Type: "pop", Parameters: map[string]int{}},
 	}}}
-	synth, err := vm.Synth(patch)
+	synth, err := vm.Synth(patch, nil)
 	if err != nil {
 		t.Fatalf("bridge compile error: %v", err)
 	}
@@ -95,7 +97,7 @@ func TestStackBalancing(t *testing.T) {
 		sointu.Instrument{NumVoices: 1, Units: []sointu.Unit{
 			sointu.Unit{Type: "push", Parameters: map[string]int{}},
 		}}}
-	synth, err := vm.Synth(patch)
+	synth, err := vm.Synth(patch, nil)
 	if err != nil {
 		t.Fatalf("bridge compile error: %v", err)
 	}
@@ -106,6 +108,111 @@ func TestStackBalancing(t *testing.T) {
 	}
 }
 
+func TestEmbeddedSamples(t *testing.T) {
+	data := make([]int16, 100)
+	for i := range data {
+		data[i] = int16(i * 300)
+	}
+	units := []sointu.Unit{
+		sointu.Unit{Type: "oscillator", Parameters: map[string]int{"transpose": 64, "detune": 64, "phase": 0, "color": 0, "shape": 64, "gain": 128, "type": sointu.Sample, "samplestart": 10, "loopstart": 20, "looplength": 30}},
+		sointu.Unit{Type: "pan", Parameters: map[string]int{"panning": 64}},
+		sointu.Unit{Type: "out", Parameters: map[string]int{"gain": 128, "stereo": 1}},
+	}
+	embedded := sointu.Patch{sointu.Instrument{NumVoices: 1, Units: units, Samples: []sointu.InstrumentSample{{Data: data}}}}
+	render := func(patch sointu.Patch, sampleBank vm.SampleBank) []float32 {
+		synth, err := vm.Synth(patch.Copy(), sampleBank)
+		if err != nil {
+			t.Fatalf("compile error: %v", err)
+		}
+		synth.Trigger(0, 64)
+		buffer := make([]float32, 2000)
+		if _, _, _, err := synth.Render(buffer, make([]float32, 10), len(buffer)/2); err != nil {
+			t.Fatalf("render error: %v", err)
+		}
+		return buffer
+	}
+	expected := render(sointu.Patch{sointu.Instrument{NumVoices: 1, Units: units}}, vm.SampleTable(data))
+	actual := render(embedded, nil)
+	silent := true
+	for i, v := range expected {
+		if v != actual[i] {
+			t.Fatalf("embedded samples should sound the same as the samples in a sample bank, first difference at %v", i)
+		}
+		if v != 0 {
+			silent = false
+		}
+	}
+	if silent {
+		t.Fatalf("sample oscillator produced only silence")
+	}
+	// the samples embedded by Update replace the silent sample bank
+	synth, err := vm.Synth(sointu.Patch{sointu.Instrument{NumVoices: 1, Units: units}}, make(vm.SampleTable, 100))
+	if err != nil {
+		t.Fatalf("compile error: %v", err)
+	}
+	if err := synth.Update(embedded.Copy()); err != nil {
+		t.Fatalf("update error: %v", err)
+	}
+	synth.Trigger(0, 64)
+	updated := make([]float32, len(expected))
+	if _, _, _, err := synth.Render(updated, make([]float32, 10), len(updated)/2); err != nil {
+		t.Fatalf("render error: %v", err)
+	}
+	for i, v := range expected {
+		if v != updated[i] {
+			t.Fatalf("the samples embedded by Update should be played, first difference at %v", i)
+		}
+	}
+	embedded[0].Samples[0].Data = data[:50]
+	if _, err := vm.Synth(embedded, nil); err == nil {
+		t.Fatalf("a sample oscillator reaching beyond the embedded samples should not compile")
+	}
+}
+
+// forEachTestSong runs f as a subtest for each of the songs in the tests
+// directory, loaded with sointu.LoadSong.
+func forEachTestSong(t *testing.T, f func(t *testing.T, song sointu.Song)) {
+	_, myname, _, _ := runtime.Caller(0)
+	files, err := filepath.Glob(path.Join(path.Dir(myname), "..", "tests", "*.yml"))
+	if err != nil {
+		t.Fatalf("cannot glob files in the test directory: %v", err)
+	}
+	for _, filename := range files {
+		basename := filepath.Base(filename)
+		testname := strings.TrimSuffix(basename, path.Ext(basename))
+		t.Run(testname, func(t *testing.T) {
+			file, err := os.Open(filename)
+			if err != nil {
+				t.Fatalf("cannot open the .yml file: %v", err)
+			}
+			defer file.Close()
+			song, err := sointu.LoadSong(file)
+			if err != nil {
+				t.Fatalf("could not load the .yml file: %v", err)
+			}
+			f(t, song)
+		})
+	}
+}
+
+// loadGmDls loads the gm.dls for the sample tests, from the path given in the
+// SOINTU_GMDLS environment variable or from the default location on Windows.
+func loadGmDls() (vm.SampleBank, error) {
+	dlsPath := os.Getenv("SOINTU_GMDLS")
+	if dlsPath == "" {
+		if runtime.GOOS != "windows" {
+			return nil, errors.New("set SOINTU_GMDLS to the path of gm.dls to run the sample tests")
+		}
+		dlsPath = filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "gm.dls")
+	}
+	file, err := os.Open(dlsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return vm.LoadSampleTable(file)
+}
+
 func compareToRawFloat32(t *testing.T, buffer []float32, rawname string) {
 	_, filename, _, _ := runtime.Caller(0)
 	expectedb, err := ioutil.ReadFile(path.Join(path.Dir(filename), "..", "tests", "expected_output", rawname))
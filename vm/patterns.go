@@ -3,6 +3,7 @@ package vm
 import (
 	"errors"
 	"fmt"
+	"math"
 
 	"github.com/vsariola/sointu"
 )
@@ -146,20 +147,34 @@ func intsToBytes(array []int) ([]byte, error) {
 	return ret, nil
 }
 
+// ConstructPatterns constructs the pattern table and the sequences of the
+// tracks for the song, using the RowsPerPattern of the song as the length of
+// the encoded patterns. See ConstructPatternsWithLength.
 func ConstructPatterns(song *sointu.Song) ([][]byte, [][]byte, error) {
+	return ConstructPatternsWithLength(song, song.Score.RowsPerPattern)
+}
+
+// ConstructPatternsWithLength constructs the pattern table and the sequences
+// of the tracks for the song, splitting the tracks into patterns of the given
+// length, which does not have to be the RowsPerPattern used when composing. If
+// the length does not divide the length of the song in rows, the last
+// patterns are padded with don't cares. Patterns are reused whenever possible,
+// taking the don't cares into account. The returned sequences have one byte
+// per pattern, indexing the pattern table.
+func ConstructPatternsWithLength(song *sointu.Song, patternLength int) ([][]byte, [][]byte, error) {
+	if patternLength < 1 {
+		return nil, nil, fmt.Errorf("pattern length should be > 0, was %v", patternLength)
+	}
 	sequences := make([][]byte, len(song.Score.Tracks))
-	var patterns [][]int
-	for i, t := range song.Score.Tracks {
-		flat := flattenSequence(t, song.Score.Length, song.Score.RowsPerPattern, true)
-		dontCares := markDontCares(flat)
-		// TODO: we could give the user the possibility to use another length during encoding that during composing
-		chunks := splitSequence(dontCares, song.Score.RowsPerPattern)
-		var sequence []int
-		sequence, patterns = addPatternsToTable(chunks, patterns)
+	patterns, intSequences, ok := encodePatterns(flattenTracks(song), patternLength, 256)
+	if !ok {
+		return nil, nil, errors.New("the constructed pattern table would result in > 256 unique patterns; only 256 unique patterns are supported")
+	}
+	for i, sequence := range intSequences {
 		var err error
 		sequences[i], err = intsToBytes(sequence)
 		if err != nil {
-			return nil, nil, errors.New("the constructed pattern table would result in > 256 unique patterns; only 256 unique patterns are supported")
+			return nil, nil, err
 		}
 	}
 	bytePatterns := make([][]byte, len(patterns))
@@ -201,3 +216,71 @@ func ConstructPatterns(song *sointu.Song) ([][]byte, [][]byte, error) {
 	}
 	return bytePatterns, sequences, nil
 }
+
+// OptimalPatternLength searches the length of the encoded patterns that
+// minimizes the total size of the pattern table and the sequences, in bytes,
+// when the song is encoded with ConstructPatternsWithLength. All the lengths
+// up to 255 rows are tried, also the ones that do not divide the length of the
+// song; if several lengths give the same size, RowsPerPattern is preferred,
+// and then the shortest length. An error is returned if every length results
+// in more than 256 unique patterns.
+func OptimalPatternLength(song *sointu.Song) (int, error) {
+	tracks := flattenTracks(song)
+	rows := song.Score.LengthInRows()
+	bestLength, bestSize := -1, math.MaxInt32
+	try := func(length int) {
+		sequenceSize := len(tracks) * ((rows + length - 1) / length)
+		if sequenceSize >= bestSize {
+			return
+		}
+		// give up as soon as the pattern table cannot beat the best size
+		maxPatterns := (bestSize - sequenceSize - 1) / length
+		if maxPatterns > 256 {
+			maxPatterns = 256
+		}
+		if patterns, _, ok := encodePatterns(tracks, length, maxPatterns); ok {
+			bestLength, bestSize = length, sequenceSize+len(patterns)*length
+		}
+	}
+	if song.Score.RowsPerPattern > 0 {
+		try(song.Score.RowsPerPattern)
+	}
+	for length := 1; length < 256 && length <= rows; length++ {
+		try(length)
+	}
+	if bestLength == -1 {
+		return 0, errors.New("the constructed pattern table would result in > 256 unique patterns with any pattern length; only 256 unique patterns are supported")
+	}
+	return bestLength, nil
+}
+
+// flattenTracks returns the notes of each track of the song as a linear array,
+// with the don't cares marked.
+func flattenTracks(song *sointu.Song) [][]int {
+	ret := make([][]int, len(song.Score.Tracks))
+	for i, t := range song.Score.Tracks {
+		flat := flattenSequence(t, song.Score.Length, song.Score.RowsPerPattern, true)
+		ret[i] = markDontCares(flat)
+	}
+	return ret
+}
+
+// encodePatterns splits the tracks into patterns of the given length and adds
+// them to a pattern table, returning the table and the sequence of pattern
+// indices of each track. It gives up and returns false as soon as the table
+// would have more than maxPatterns patterns.
+func encodePatterns(tracks [][]int, patternLength int, maxPatterns int) ([][]int, [][]int, bool) {
+	var patterns [][]int
+	sequences := make([][]int, len(tracks))
+	for i, track := range tracks {
+		for _, chunk := range splitSequence(track, patternLength) {
+			var sequence []int
+			sequence, patterns = addPatternsToTable([][]int{chunk}, patterns)
+			if len(patterns) > maxPatterns {
+				return nil, nil, false
+			}
+			sequences[i] = append(sequences[i], sequence[0])
+		}
+	}
+	return patterns, sequences, true
+}
@@ -90,3 +90,40 @@ func TestDontCares(t *testing.T) {
 		t.Fatalf("got different patterns than expected. got: %v expected: %v", patterns, expectedPatterns)
 	}
 }
+
+func TestOptimalPatternLength(t *testing.T) {
+	var pattern sointu.Pattern
+	for i := 0; i < 23; i++ {
+		pattern = append(pattern, []byte{64, 1, 1, 0}[i%4])
+	}
+	song := sointu.Song{
+		Score: sointu.Score{
+			Length:         1,
+			RowsPerPattern: 23,
+			Tracks: []sointu.Track{{
+				Patterns: []sointu.Pattern{pattern},
+				Order:    sointu.Order{0},
+			}},
+		},
+	}
+	length, err := vm.OptimalPatternLength(&song)
+	if err != nil {
+		t.Fatalf("error finding the optimal pattern length: %v", err)
+	}
+	if length != 4 {
+		t.Fatalf("expected the optimal pattern length to be 4, got %v", length)
+	}
+	patterns, sequences, err := vm.ConstructPatternsWithLength(&song, length)
+	if err != nil {
+		t.Fatalf("erorr constructing patterns: %v", err)
+	}
+	// the last pattern is only 3 rows long, so its padding is a don't care
+	expectedSequences := [][]byte{{0, 0, 0, 0, 0, 0}}
+	expectedPatterns := [][]byte{{64, 1, 1, 0}}
+	if !reflect.DeepEqual(patterns, expectedPatterns) {
+		t.Fatalf("got different patterns than expected. got: %v expected: %v", patterns, expectedPatterns)
+	}
+	if !reflect.DeepEqual(sequences, expectedSequences) {
+		t.Fatalf("got different sequences than expected. got: %v expected: %v", sequences, expectedSequences)
+	}
+}
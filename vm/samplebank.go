@@ -0,0 +1,50 @@
+package vm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// SampleBank is the source of the 16-bit sample data used by the Sample
+// oscillators. The SampleOffsets of a BytePatch index directly into it: Start,
+// LoopStart and LoopLength are all measured in samples i.e. int16s, just as in
+// the su_sample_table of the x86 players.
+type SampleBank interface {
+	// Sample returns the i:th sample of the bank. Indices outside the bank
+	// should return 0, so that a missing or truncated bank only causes silence.
+	Sample(i int) int16
+}
+
+// SampleTable is a SampleBank backed by a slice of int16s.
+type SampleTable []int16
+
+// Sample returns the i:th sample of the table, or 0 if i is out of range.
+func (t SampleTable) Sample(i int) int16 {
+	if i < 0 || i >= len(t) {
+		return 0
+	}
+	return t[i]
+}
+
+// LoadSampleTable reads a sample bank file, either a DLS file (for example
+// gm.dls of Windows) or a SoundFont 2 file, into a SampleTable. Like
+// su_load_gmdls of the x86 players, the whole file is treated as a flat table
+// of little-endian int16s, so the sample offsets are half of the file
+// positions of the sample data.
+func LoadSampleTable(r io.Reader) (SampleTable, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read sample bank: %v", err)
+	}
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || (string(data[8:12]) != "DLS " && string(data[8:12]) != "sfbk") {
+		return nil, errors.New("not a sample bank: expected a DLS or a SoundFont 2 file")
+	}
+	ret := make(SampleTable, len(data)/2)
+	for i := range ret {
+		ret[i] = int16(binary.LittleEndian.Uint16(data[i*2:]))
+	}
+	return ret, nil
+}
@@ -0,0 +1,175 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/vsariola/sointu"
+)
+
+// Severity tells how serious a Diagnostic is.
+type Severity int
+
+const (
+	// Warning means that the song can be compiled and rendered, but probably
+	// does not sound as intended or does not work with all synths.
+	Warning Severity = iota
+	// Error means that the song cannot be compiled or rendered.
+	Error
+)
+
+// Diagnostic is a problem found in a song by Validate. Instrument, Unit and
+// Track locate the problem and are -1 when not applicable; Unit is an index to
+// the units of the instrument.
+type Diagnostic struct {
+	Severity   Severity
+	Instrument int
+	Unit       int
+	Track      int
+	Message    string
+}
+
+// maxBridgeDelayLines is the number of delay lines compiled into the native
+// bridge
+const maxBridgeDelayLines = 64
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// String returns the diagnostic in a human readable form, e.g. "error:
+// instrument 2, unit 3: unknown unit type "foo"".
+func (d Diagnostic) String() string {
+	var loc []string
+	if d.Instrument > -1 {
+		loc = append(loc, fmt.Sprintf("instrument %v", d.Instrument))
+	}
+	if d.Unit > -1 {
+		loc = append(loc, fmt.Sprintf("unit %v", d.Unit))
+	}
+	if d.Track > -1 {
+		loc = append(loc, fmt.Sprintf("track %v", d.Track))
+	}
+	if len(loc) == 0 {
+		return fmt.Sprintf("%v: %v", d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%v: %v: %v", d.Severity, strings.Join(loc, ", "), d.Message)
+}
+
+// HasErrors returns true if any of the diagnostics is an Error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the song for all the problems that would prevent compiling or
+// rendering it (Errors) or that would make it sound different than intended
+// (Warnings): unknown unit types, parameters outside their range, sends without
+// a target, stack underflows, signals left on the stack or stack depth beyond
+// the x87 stack, too many voices, units or patterns, and more delay lines than
+// the native bridge supports. An empty result means that no problems were found.
+func Validate(song *sointu.Song) []Diagnostic {
+	var ret []Diagnostic
+	add := func(severity Severity, instr, unit, track int, format string, args ...interface{}) {
+		ret = append(ret, Diagnostic{Severity: severity, Instrument: instr, Unit: unit, Track: track, Message: fmt.Sprintf(format, args...)})
+	}
+	if song.BPM < 1 {
+		add(Error, -1, -1, -1, "BPM should be > 0, was %v", song.BPM)
+	}
+	if song.RowsPerBeat < 1 {
+		add(Error, -1, -1, -1, "rows per beat should be > 0, was %v", song.RowsPerBeat)
+	}
+	if len(song.Score.Tracks) == 0 {
+		add(Error, -1, -1, -1, "song contains no tracks")
+	}
+	for i, track := range song.Score.Tracks {
+		if track.NumVoices < 1 {
+			add(Warning, -1, -1, i, "track has no voices, so its notes are never played")
+		}
+	}
+	if song.Score.NumVoices() > song.Patch.NumVoices() {
+		add(Error, -1, -1, -1, "tracks use %v voices, but the patch has only %v", song.Score.NumVoices(), song.Patch.NumVoices())
+	}
+	if n := song.Patch.NumVoices(); n > MAX_VOICES {
+		add(Error, -1, -1, -1, "Sointu does not support more than %v concurrent voices; patch uses %v", MAX_VOICES, n)
+	}
+	if n := song.Patch.NumDelayLines(); n > maxBridgeDelayLines {
+		add(Warning, -1, -1, -1, "the native bridge supports at most %v delay lines; patch uses %v", maxBridgeDelayLines, n)
+	}
+	for i, instr := range song.Patch {
+		if instr.NumVoices < 1 {
+			add(Error, i, -1, -1, "each instrument must have at least 1 voice")
+		}
+		if len(instr.Units) > MAX_UNITS {
+			add(Error, i, -1, -1, "an instrument can have a maximum of %v units; instrument has %v", MAX_UNITS, len(instr.Units))
+		}
+		stackUse := instr.StackUse()
+		for _, u := range stackUse.Underflows {
+			depth := 0
+			if u > 0 {
+				depth = stackUse.Depths[u-1]
+			}
+			add(Error, i, u, -1, "%v needs at least %v input signals, got %v", instr.Units[u].Type, instr.Units[u].StackNeed(), depth)
+		}
+		if stackUse.Leftover > 0 {
+			add(Error, i, -1, -1, "instrument leaves %v signal(s) on the stack", stackUse.Leftover)
+		}
+		if stackUse.Overflows() {
+			add(Warning, i, -1, -1, "stack depth reaches %v, but the x87 stack of the native and compiled VM holds only %v signals", stackUse.Peak, sointu.X87StackSize)
+		}
+		for u, unit := range instr.Units {
+			if unit.Type == "" {
+				continue // empty units, e.g. just added in the tracker, are skipped when encoding
+			}
+			params, ok := sointu.UnitTypes[unit.Type]
+			if !ok {
+				add(Error, i, u, -1, "unknown unit type %q", unit.Type)
+				continue
+			}
+			for _, p := range params {
+				if !p.CanSet {
+					continue
+				}
+				min, max := p.MinValue, p.MaxValue
+				if unit.Type == "oscillator" && unit.Parameters["type"] == sointu.Gate && (p.Name == "color" || p.Name == "shape") {
+					max = 255 // the gate bits are stored in color and shape
+				}
+				if v := unit.Parameters[p.Name]; v < min || v > max {
+					add(Warning, i, u, -1, "parameter %v = %v is outside the range %v .. %v", p.Name, v, min, max)
+				}
+			}
+			if unit.Type == "send" {
+				targetInstr, targetUnit, err := song.Patch.FindSendTarget(unit.Parameters["target"])
+				if err != nil {
+					add(Warning, i, u, -1, "%v", err)
+					continue
+				}
+				ports := sointu.Ports[song.Patch[targetInstr].Units[targetUnit].Type]
+				if port := unit.Parameters["port"]; port < 0 || port >= len(ports) {
+					add(Warning, i, u, -1, "send targets port %v, but the targeted %v unit has only %v ports", port, song.Patch[targetInstr].Units[targetUnit].Type, len(ports))
+				}
+			}
+		}
+	}
+	if song.Score.RowsPerPattern > 0 && len(song.Score.Tracks) > 0 {
+		// usually the song can be encoded with its own RowsPerPattern; the
+		// search for the optimal length is slow, so it is the fallback
+		if _, _, err := ConstructPatternsWithLength(song, song.Score.RowsPerPattern); err != nil {
+			length, err := OptimalPatternLength(song)
+			if err == nil {
+				_, _, err = ConstructPatternsWithLength(song, length)
+			}
+			if err != nil {
+				add(Error, -1, -1, -1, "%v", err)
+			}
+		}
+	}
+	return ret
+}
@@ -0,0 +1,59 @@
+package vm_test
+
+import (
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/vm"
+)
+
+func TestValidateRegressionTests(t *testing.T) {
+	forEachTestSong(t, func(t *testing.T, song sointu.Song) {
+		if diagnostics := vm.Validate(&song); len(diagnostics) > 0 {
+			t.Fatalf("expected no diagnostics for a regression test song, got %v", diagnostics)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	loads := make([]sointu.Unit, 9)
+	for i := range loads {
+		loads[i] = sointu.Unit{Type: "loadnote"}
+	}
+	song := sointu.Song{BPM: 100, RowsPerBeat: 4, Score: sointu.Score{RowsPerPattern: 1, Length: 1, Tracks: []sointu.Track{
+		{NumVoices: 4, Order: sointu.Order{0}, Patterns: []sointu.Pattern{{64}}},
+	}}, Patch: sointu.Patch{
+		sointu.Instrument{NumVoices: 1, Units: []sointu.Unit{
+			{Type: "envelope"},
+			{Type: "foo"},
+			{Type: "gain", Parameters: map[string]int{"gain": 129}},
+			{Type: "send", Parameters: map[string]int{"target": 42}},
+			{Type: "pop"},
+			{}, // empty units are skipped
+		}},
+		sointu.Instrument{NumVoices: 1, Units: []sointu.Unit{{Type: "addp"}}},
+		sointu.Instrument{NumVoices: 1, Units: loads},
+	}}
+	diagnostics := vm.Validate(&song)
+	expected := []vm.Diagnostic{
+		{Severity: vm.Error, Instrument: -1, Unit: -1, Track: -1},  // tracks use more voices than the patch has
+		{Severity: vm.Error, Instrument: 0, Unit: 1, Track: -1},    // unknown unit type
+		{Severity: vm.Warning, Instrument: 0, Unit: 2, Track: -1},  // parameter out of range
+		{Severity: vm.Warning, Instrument: 0, Unit: 3, Track: -1},  // send target not found
+		{Severity: vm.Error, Instrument: 1, Unit: 0, Track: -1},    // stack underflow
+		{Severity: vm.Error, Instrument: 2, Unit: -1, Track: -1},   // signals left on the stack
+		{Severity: vm.Warning, Instrument: 2, Unit: -1, Track: -1}, // x87 stack overflow
+	}
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("expected %v diagnostics, got %v", len(expected), diagnostics)
+	}
+	for i, d := range diagnostics {
+		d.Message = ""
+		if d != expected[i] {
+			t.Errorf("diagnostic %v: expected %+v, got %+v", i, expected[i], diagnostics[i])
+		}
+	}
+	if !vm.HasErrors(diagnostics) {
+		t.Errorf("HasErrors should be true when there are errors")
+	}
+}
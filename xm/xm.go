@@ -0,0 +1,492 @@
+// Package xm imports the arrangements of FastTracker II modules (.xm) into
+// Sointu songs, for resynthesizing old modules with Sointu instruments. Only
+// the notes, the order table and optionally one column per channel are
+// imported; the samples and the rest of the effects are ignored.
+package xm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/vsariola/sointu"
+)
+
+// Column selects the column of an XM channel that is imported as an effect
+// track.
+type Column int
+
+const (
+	// VolumeColumn imports the set volume commands (0x10-0x50) of the volume
+	// column as values 0-128.
+	VolumeColumn Column = iota + 1
+	// EffectColumn imports the parameters of the effect column, whatever the
+	// effect.
+	EffectColumn
+)
+
+// Warning tells about something in an XM module that could not be imported
+// exactly, e.g. a note that was dropped.
+type Warning struct {
+	Order   int // the position in the order table
+	Row     int // the row in the pattern
+	Channel int
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("order %v, row %v, channel %v: %v", w.Order, w.Row, w.Channel, w.Message)
+}
+
+// the notes of XM: 1 is C-0 and 96 B-7, 97 is key off
+const (
+	keyOff = 97
+	// noteOffset converts XM notes to Sointu notes, keeping their names
+	// (24 is C-0 in Sointu)
+	noteOffset = 23
+)
+
+type cell struct {
+	note, instrument, volume, effect, param byte
+}
+
+type module struct {
+	numChannels int
+	order       []int
+	patterns    [][][]cell // pattern, row, channel
+	instruments []string
+	speed, bpm  int
+}
+
+// row is a row of the module in the order it is played, with its location for
+// the warnings.
+type row struct {
+	order, row int
+	cells      []cell
+}
+
+// note is a note of a channel, from row start to row end, in the rows of the
+// whole song.
+type note struct {
+	start, end int
+	pitch      byte
+}
+
+// Import reads an XM module into the song, replacing its score and patch. The
+// rows of the song are the rows of the module in the order of the order table,
+// with the pattern breaks (Dxx) applied. If all the patterns played have the
+// same number of rows, it becomes the RowsPerPattern of the score; otherwise
+// the RowsPerPattern of the song is used (16 if not set). The BPM is set so
+// that the rows have the speed of the default tempo and BPM of the module.
+//
+// The patch gets a placeholder instrument for each XM instrument. The notes of
+// each instrument are played by as many tracks, with one voice each, as the
+// instrument plays notes at the same time, and the instrument gets that many
+// voices. A note lasts until the next note or key off on its channel. For the
+// channels given in effects, the column is imported as an effect track, with
+// its own placeholder instrument; the channels without values in the column
+// get no track. The placeholders of the effect tracks come after the
+// instruments playing notes, followed by the unused XM instruments, so that the
+// voices of the tracks match the voices of the instruments.
+func Import(data []byte, song *sointu.Song, effects map[int]Column) ([]Warning, error) {
+	if song.RowsPerBeat <= 0 {
+		return nil, fmt.Errorf("the song should have positive RowsPerBeat, got %v", song.RowsPerBeat)
+	}
+	m, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []Warning
+	warn := func(r row, channel int, format string, args ...interface{}) {
+		warnings = append(warnings, Warning{r.order, r.row, channel, fmt.Sprintf(format, args...)})
+	}
+	rows, rowsPerPattern := m.flatten(warn)
+	if rowsPerPattern <= 0 {
+		rowsPerPattern = song.Score.RowsPerPattern
+		if rowsPerPattern <= 0 {
+			rowsPerPattern = 16
+		}
+	}
+	numRows := (len(rows) + rowsPerPattern - 1) / rowsPerPattern * rowsPerPattern
+	if numRows == 0 {
+		numRows = rowsPerPattern
+	}
+	notes := make([][]note, len(m.instruments)) // the notes of each instrument
+	for c := 0; c < m.numChannels; c++ {
+		instr, playing := 0, -1 // the last instrument of the channel, the index of the note playing in notes[instr]
+		stop := func(r int) {
+			if playing >= 0 {
+				notes[instr-1][playing].end = r
+				playing = -1
+			}
+		}
+		for i, r := range rows {
+			n := r.cells[c]
+			if n.note == 0 {
+				continue
+			}
+			stop(i)
+			if n.note == keyOff {
+				continue
+			}
+			if n.instrument > 0 {
+				instr = int(n.instrument)
+			}
+			if instr == 0 || instr > len(m.instruments) {
+				warn(r, c, "note %v has no valid instrument; dropped", n.note)
+				instr = 0
+				continue
+			}
+			if n.note > keyOff {
+				warn(r, c, "invalid note %v; dropped", n.note)
+				continue
+			}
+			playing = len(notes[instr-1])
+			notes[instr-1] = append(notes[instr-1], note{start: i, end: numRows, pitch: n.note + noteOffset})
+		}
+	}
+	var tracks []sointu.Track
+	var patch, unused sointu.Patch
+	for i, name := range m.instruments {
+		if name == "" {
+			name = fmt.Sprintf("Instr %v", i+1)
+		}
+		lanes := noteLanes(notes[i], numRows)
+		if len(lanes) == 0 {
+			unused = append(unused, placeholder(name, 1))
+			continue
+		}
+		patch = append(patch, placeholder(name, len(lanes)))
+		for _, lane := range lanes {
+			tracks = append(tracks, splitPatterns(lane, rowsPerPattern, false))
+		}
+	}
+	var channels []int
+	for c := range effects {
+		channels = append(channels, c)
+	}
+	sort.Ints(channels)
+	for _, c := range channels {
+		if c < 0 || c >= m.numChannels {
+			return nil, fmt.Errorf("the module has no channel %v", c)
+		}
+		lane := effectLane(rows, c, effects[c], numRows, warn)
+		if lane == nil {
+			continue
+		}
+		name := fmt.Sprintf("Channel %v", c)
+		patch = append(patch, sointu.Instrument{Name: name, NumVoices: 1, Units: []sointu.Unit{
+			{Type: "loadnote", Parameters: map[string]int{"stereo": 0}},
+			{Type: "pop", Parameters: map[string]int{"stereo": 0}},
+		}})
+		tracks = append(tracks, splitPatterns(lane, rowsPerPattern, true))
+	}
+	if len(tracks) == 0 {
+		return nil, errors.New("the module has no notes to import")
+	}
+	song.Score = sointu.Score{RowsPerPattern: rowsPerPattern, Length: numRows / rowsPerPattern, Tracks: tracks}
+	song.Patch = append(patch, unused...)
+	if m.speed > 0 && m.bpm > 0 {
+		// a row lasts 2.5 * speed / bpm seconds in XM
+		song.BPM = (24*m.bpm + m.speed*song.RowsPerBeat/2) / (m.speed * song.RowsPerBeat)
+	}
+	return warnings, nil
+}
+
+// NumChannels returns the number of channels of an XM module, e.g. for
+// choosing the channels to import as effect tracks.
+func NumChannels(data []byte) (int, error) {
+	m, err := parse(data)
+	if err != nil {
+		return 0, err
+	}
+	return m.numChannels, nil
+}
+
+// flatten returns the rows of the module in the order they are played and the
+// number of rows in the patterns played, or 0 if the patterns have different
+// numbers of rows.
+func (m *module) flatten(warn func(row, int, string, ...interface{})) ([]row, int) {
+	var rows []row
+	rowsPerPattern, startRow := -1, 0
+	tempoWarned, jumpWarned := false, false
+	for o, p := range m.order {
+		var pattern [][]cell
+		if p < len(m.patterns) {
+			pattern = m.patterns[p]
+		} else {
+			// the patterns not in the file are 64 empty rows
+			pattern = make([][]cell, 64)
+			for i := range pattern {
+				pattern[i] = make([]cell, m.numChannels)
+			}
+		}
+		if rowsPerPattern == -1 {
+			rowsPerPattern = len(pattern)
+		} else if rowsPerPattern != len(pattern) || startRow > 0 {
+			rowsPerPattern = 0
+		}
+		next := 0
+		for r := startRow; r < len(pattern); r++ {
+			rows = append(rows, row{order: o, row: r, cells: pattern[r]})
+			brk := false
+			for c, n := range pattern[r] {
+				switch n.effect {
+				case 0x0D: // pattern break, to the row in decimal
+					brk, next = true, int(n.param>>4)*10+int(n.param&15)
+				case 0x0B:
+					if !jumpWarned {
+						warn(rows[len(rows)-1], c, "position jumps (Bxx) are ignored")
+						jumpWarned = true
+					}
+				case 0x0F:
+					if !tempoWarned {
+						warn(rows[len(rows)-1], c, "speed and tempo changes (Fxx) are ignored")
+						tempoWarned = true
+					}
+				}
+			}
+			if brk {
+				if r+1 < len(pattern) {
+					rowsPerPattern = 0
+				}
+				break
+			}
+		}
+		startRow = next
+	}
+	if rowsPerPattern > 255 {
+		rowsPerPattern = 0
+	}
+	return rows, rowsPerPattern
+}
+
+// noteLanes divides the notes of an instrument into lanes of non-overlapping
+// notes, returned as the notes of each row.
+func noteLanes(notes []note, numRows int) [][]byte {
+	sort.SliceStable(notes, func(i, j int) bool { return notes[i].start < notes[j].start })
+	var lanes [][]byte
+	var free []int // the row after the last note of each lane
+	for _, n := range notes {
+		l := 0
+		for l < len(lanes) && free[l] > n.start {
+			l++
+		}
+		if l == len(lanes) {
+			lane := make([]byte, numRows)
+			for r := range lane {
+				lane[r] = 1
+			}
+			lanes = append(lanes, lane)
+			free = append(free, 0)
+		}
+		lanes[l][n.start] = n.pitch
+		if n.end < numRows {
+			lanes[l][n.end] = 0
+		}
+		free[l] = n.end
+	}
+	return lanes
+}
+
+// effectLane returns the values of a column of a channel as an effect track,
+// or nil if the column has no values.
+func effectLane(rows []row, channel int, column Column, numRows int, warn func(row, int, string, ...interface{})) []byte {
+	lane := make([]byte, numRows)
+	for r := range lane {
+		lane[r] = 1
+	}
+	found := false
+	value := byte(0)
+	for i, r := range rows {
+		n := r.cells[channel]
+		var v byte
+		switch column {
+		case VolumeColumn:
+			if n.volume < 0x10 || n.volume > 0x50 {
+				continue
+			}
+			v = (n.volume - 0x10) * 2
+		case EffectColumn:
+			if n.effect == 0 && n.param == 0 {
+				continue
+			}
+			v = n.param
+			if v == 1 {
+				warn(r, channel, "value 1 means hold in an effect track; changed to 0")
+				v = 0
+			}
+		default:
+			return nil
+		}
+		found = true
+		if v != value {
+			lane[i] = v
+			value = v
+		}
+	}
+	if !found {
+		return nil
+	}
+	return lane
+}
+
+// placeholder returns a simple instrument to be replaced with the real one.
+func placeholder(name string, numVoices int) sointu.Instrument {
+	return sointu.Instrument{Name: name, NumVoices: numVoices, Units: []sointu.Unit{
+		{Type: "envelope", Parameters: map[string]int{"stereo": 0, "attack": 32, "decay": 64, "sustain": 64, "release": 64, "gain": 64}},
+		{Type: "oscillator", Parameters: map[string]int{"stereo": 0, "transpose": 64, "detune": 64, "phase": 0, "color": 64, "shape": 64, "gain": 64, "type": sointu.Trisaw}},
+		{Type: "mulp", Parameters: map[string]int{"stereo": 0}},
+		{Type: "pan", Parameters: map[string]int{"stereo": 0, "panning": 64}},
+		{Type: "outaux", Parameters: map[string]int{"stereo": 1, "outgain": 64, "auxgain": 0}},
+	}}
+}
+
+// splitPatterns splits the notes of a track into patterns, reusing identical
+// patterns.
+func splitPatterns(lane []byte, rowsPerPattern int, effect bool) sointu.Track {
+	track := sointu.Track{NumVoices: 1, Effect: effect}
+	for i := 0; i < len(lane); i += rowsPerPattern {
+		pat := sointu.Pattern(lane[i : i+rowsPerPattern])
+		index := -1
+		for j, p := range track.Patterns {
+			if string(p) == string(pat) {
+				index = j
+				break
+			}
+		}
+		if index == -1 {
+			index = len(track.Patterns)
+			track.Patterns = append(track.Patterns, append(sointu.Pattern{}, pat...))
+		}
+		track.Order = append(track.Order, index)
+	}
+	return track
+}
+
+// parse reads the header, the patterns and the instrument names of an XM
+// module.
+func parse(data []byte) (*module, error) {
+	if len(data) < 80 || string(data[:17]) != "Extended Module: " {
+		return nil, errors.New("not an XM module")
+	}
+	u16 := func(pos int) int { return int(binary.LittleEndian.Uint16(data[pos:])) }
+	u32 := func(pos int) int { return int(binary.LittleEndian.Uint32(data[pos:])) }
+	if version := u16(58); version != 0x0104 {
+		return nil, fmt.Errorf("XM version %x.%02x is not supported, only 1.04", version>>8, version&0xFF)
+	}
+	headerSize := u32(60)
+	if headerSize < 20+256 || 60+headerSize > len(data) {
+		return nil, errors.New("invalid XM header")
+	}
+	m := &module{
+		numChannels: u16(68),
+		speed:       u16(76),
+		bpm:         u16(78),
+	}
+	songLength, numPatterns, numInstruments := u16(64), u16(70), u16(72)
+	if songLength > 256 || m.numChannels == 0 || m.numChannels > 32 || numPatterns > 256 || numInstruments > 128 {
+		return nil, errors.New("invalid XM header")
+	}
+	for _, p := range data[80 : 80+songLength] {
+		m.order = append(m.order, int(p))
+	}
+	pos := 60 + headerSize
+	for i := 0; i < numPatterns; i++ {
+		if pos+9 > len(data) {
+			return nil, fmt.Errorf("pattern %v: truncated header", i)
+		}
+		numRows, packedSize := u16(pos+5), u16(pos+7)
+		if numRows == 0 || numRows > 256 {
+			return nil, fmt.Errorf("pattern %v: invalid number of rows %v", i, numRows)
+		}
+		pos += u32(pos)
+		if pos+packedSize > len(data) {
+			return nil, fmt.Errorf("pattern %v: truncated data", i)
+		}
+		pattern, err := unpack(data[pos:pos+packedSize], numRows, m.numChannels)
+		if err != nil {
+			return nil, fmt.Errorf("pattern %v: %v", i, err)
+		}
+		m.patterns = append(m.patterns, pattern)
+		pos += packedSize
+	}
+	for i := 0; i < numInstruments; i++ {
+		if pos+29 > len(data) {
+			return nil, fmt.Errorf("instrument %v: truncated header", i+1)
+		}
+		size, numSamples := u32(pos), u16(pos+27)
+		m.instruments = append(m.instruments, zeroTerminated(data[pos+4:pos+26]))
+		if numSamples == 0 {
+			pos += size
+			continue
+		}
+		if pos+33 > len(data) {
+			return nil, fmt.Errorf("instrument %v: truncated header", i+1)
+		}
+		sampleHeaderSize := u32(pos + 29)
+		pos += size
+		sampleData := 0
+		for j := 0; j < numSamples; j++ {
+			if pos+4 > len(data) {
+				return nil, fmt.Errorf("instrument %v: truncated sample header", i+1)
+			}
+			sampleData += u32(pos)
+			pos += sampleHeaderSize
+		}
+		pos += sampleData
+	}
+	return m, nil
+}
+
+// unpack unpacks the pattern data: each cell is either the five bytes note,
+// instrument, volume, effect and parameter, or a byte with the high bit set,
+// telling which of them follow.
+func unpack(data []byte, numRows, numChannels int) ([][]cell, error) {
+	ret := make([][]cell, numRows)
+	pos := 0
+	next := func() (byte, error) {
+		if pos >= len(data) {
+			return 0, errors.New("truncated pattern data")
+		}
+		pos++
+		return data[pos-1], nil
+	}
+	for r := range ret {
+		ret[r] = make([]cell, numChannels)
+		if len(data) == 0 {
+			continue // empty pattern
+		}
+		for c := range ret[r] {
+			b, err := next()
+			if err != nil {
+				return nil, err
+			}
+			flags := byte(0x1F)
+			if b&0x80 != 0 {
+				flags = b
+			} else {
+				pos-- // the byte was the note
+			}
+			fields := []*byte{&ret[r][c].note, &ret[r][c].instrument, &ret[r][c].volume, &ret[r][c].effect, &ret[r][c].param}
+			for i, f := range fields {
+				if flags&(1<<i) != 0 {
+					if *f, err = next(); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+func zeroTerminated(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
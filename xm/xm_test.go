@@ -0,0 +1,179 @@
+package xm_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vsariola/sointu"
+	"github.com/vsariola/sointu/xm"
+)
+
+type cell [5]byte // note, instrument, volume, effect, parameter
+
+// module builds an XM module; the patterns are given as rows of cells and the
+// instruments by their names, the last one with a sample.
+func module(numChannels int, order []byte, patterns [][][]cell, instruments []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("Extended Module: ")
+	b.Write(make([]byte, 20))
+	b.WriteByte(0x1A)
+	b.Write(make([]byte, 20))
+	binary.Write(&b, binary.LittleEndian, uint16(0x0104))
+	binary.Write(&b, binary.LittleEndian, uint32(276))
+	binary.Write(&b, binary.LittleEndian, []uint16{uint16(len(order)), 0, uint16(numChannels), uint16(len(patterns)), uint16(len(instruments)), 1, 6, 125})
+	var orderTable [256]byte
+	copy(orderTable[:], order)
+	b.Write(orderTable[:])
+	for _, p := range patterns {
+		var packed bytes.Buffer
+		for _, r := range p {
+			for c := 0; c < numChannels; c++ {
+				var n cell
+				if c < len(r) {
+					n = r[c]
+				}
+				if n[0] != 0 && n[1] != 0 && n[2] != 0 && n[3] != 0 && n[4] != 0 {
+					packed.Write(n[:])
+					continue
+				}
+				flags := byte(0x80)
+				for i, v := range n {
+					if v != 0 {
+						flags |= 1 << i
+					}
+				}
+				packed.WriteByte(flags)
+				for _, v := range n {
+					if v != 0 {
+						packed.WriteByte(v)
+					}
+				}
+			}
+		}
+		binary.Write(&b, binary.LittleEndian, uint32(9))
+		b.WriteByte(0)
+		binary.Write(&b, binary.LittleEndian, []uint16{uint16(len(p)), uint16(packed.Len())})
+		b.Write(packed.Bytes())
+	}
+	for i, name := range instruments {
+		var n [22]byte
+		copy(n[:], name)
+		if i < len(instruments)-1 {
+			binary.Write(&b, binary.LittleEndian, uint32(29))
+			b.Write(n[:])
+			b.Write([]byte{0, 0, 0})
+			continue
+		}
+		binary.Write(&b, binary.LittleEndian, uint32(263))
+		b.Write(n[:])
+		b.Write([]byte{0, 1, 0})
+		binary.Write(&b, binary.LittleEndian, uint32(40))
+		b.Write(make([]byte, 263-33))
+		binary.Write(&b, binary.LittleEndian, uint32(10)) // sample length
+		b.Write(make([]byte, 36+10))
+	}
+	return b.Bytes()
+}
+
+func TestImport(t *testing.T) {
+	const c4, d4, e4, g4, a4, off = 49, 51, 53, 56, 58, 97
+	data := module(2, []byte{0, 1, 0}, [][][]cell{
+		{
+			{{c4, 1, 0x50, 0, 0}, {e4, 1, 0, 0, 0}},
+			{{}, {g4, 2, 0, 0, 0}},
+			{{off, 0, 0, 0, 0}},
+			{},
+		},
+		{
+			{{d4, 0, 0, 0, 0}},
+			{},
+			{},
+			{{}, {a4, 2, 0x30, 0x0C, 0x20}},
+		},
+	}, []string{"Bass", "Lead", "Unused"})
+	song := sointu.Song{BPM: 100, RowsPerBeat: 4}
+	warnings, err := xm.Import(data, &song, map[int]xm.Column{0: xm.VolumeColumn, 1: xm.EffectColumn})
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if len(warnings) > 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+	expected := sointu.Score{RowsPerPattern: 4, Length: 3, Tracks: []sointu.Track{
+		{NumVoices: 1, Order: sointu.Order{0, 1, 0}, Patterns: []sointu.Pattern{{72, 1, 0, 1}, {74, 1, 1, 1}}},
+		{NumVoices: 1, Order: sointu.Order{0, 1, 0}, Patterns: []sointu.Pattern{{76, 0, 1, 1}, {1, 1, 1, 1}}},
+		{NumVoices: 1, Order: sointu.Order{0, 1, 2}, Patterns: []sointu.Pattern{{1, 79, 1, 1}, {1, 1, 1, 81}, {0, 79, 1, 1}}},
+		{NumVoices: 1, Effect: true, Order: sointu.Order{0, 1, 1}, Patterns: []sointu.Pattern{{128, 1, 1, 1}, {1, 1, 1, 1}}},
+		{NumVoices: 1, Effect: true, Order: sointu.Order{0, 1, 0}, Patterns: []sointu.Pattern{{1, 1, 1, 1}, {1, 1, 1, 32}}},
+	}}
+	if !reflect.DeepEqual(song.Score, expected) {
+		t.Fatalf("wrong score\nexpected: %v\ngot: %v", expected, song.Score)
+	}
+	var names []string
+	var voices []int
+	for _, instr := range song.Patch {
+		names = append(names, instr.Name)
+		voices = append(voices, instr.NumVoices)
+	}
+	if !reflect.DeepEqual(names, []string{"Bass", "Lead", "Channel 0", "Channel 1", "Unused"}) || !reflect.DeepEqual(voices, []int{2, 1, 1, 1, 1}) {
+		t.Fatalf("wrong instruments: %v, voices %v", names, voices)
+	}
+	if n, err := xm.NumChannels(data); err != nil || n != 2 {
+		t.Errorf("expected 2 channels, got %v (%v)", n, err)
+	}
+	if song.BPM != 125 {
+		t.Errorf("expected BPM 125, got %v", song.BPM)
+	}
+	if err := song.Validate(); err != nil {
+		t.Errorf("the imported song is not valid: %v", err)
+	}
+}
+
+func TestImportPatternBreak(t *testing.T) {
+	data := module(1, []byte{0, 0}, [][][]cell{
+		{
+			{{49, 0, 0, 0, 0}}, // no instrument
+			{{0, 0, 0, 0x0D, 0x02}},
+			{{51, 1, 0, 0, 0}},
+			{},
+		},
+	}, []string{"Instr"})
+	song := sointu.Song{BPM: 100, RowsPerBeat: 4, Score: sointu.Score{RowsPerPattern: 8}}
+	warnings, err := xm.Import(data, &song, nil)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	// rows 0 and 1 of the first order, rows 2 and 3 of the second
+	expected := sointu.Score{RowsPerPattern: 8, Length: 1, Tracks: []sointu.Track{
+		{NumVoices: 1, Order: sointu.Order{0}, Patterns: []sointu.Pattern{{1, 1, 74, 1, 1, 1, 1, 1}}},
+	}}
+	if !reflect.DeepEqual(song.Score, expected) {
+		t.Fatalf("wrong score\nexpected: %v\ngot: %v", expected, song.Score)
+	}
+	if len(warnings) != 1 || warnings[0].Order != 0 || warnings[0].Row != 0 || !strings.Contains(warnings[0].Message, "instrument") {
+		t.Errorf("expected a warning about the note without an instrument, got %v", warnings)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	song := sointu.Song{BPM: 100, RowsPerBeat: 4}
+	if _, err := xm.Import([]byte("MThd"), &song, nil); err == nil {
+		t.Error("expected an error for a file that is not an XM module")
+	}
+	if _, err := xm.NumChannels([]byte("MThd")); err == nil {
+		t.Error("expected an error for the channels of a file that is not an XM module")
+	}
+	data := module(1, []byte{0}, [][][]cell{{{{49, 1, 0, 0, 0}}}}, []string{"Instr"})
+	if _, err := xm.Import(data, &song, map[int]xm.Column{3: xm.VolumeColumn}); err == nil {
+		t.Error("expected an error for a channel that does not exist")
+	}
+	if _, err := xm.Import(data[:len(data)-300], &song, nil); err == nil {
+		t.Error("expected an error for a truncated module")
+	}
+	if _, err := xm.Import(module(1, []byte{0}, [][][]cell{{{}}}, []string{"Instr"}), &song, nil); err == nil {
+		t.Error("expected an error for a module without notes")
+	}
+}